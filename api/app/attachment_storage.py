@@ -0,0 +1,92 @@
+"""Reads attachment bytes kept outside the database (storage.backend)
+
+The MX server writes attachments to a filesystem directory or an
+S3-compatible bucket and stores only the key in attachments.storage_key.
+"""
+
+import datetime
+import hashlib
+import hmac
+import os
+import urllib.parse
+import urllib.request
+
+from app.config import settings
+
+
+class AttachmentStorageError(Exception):
+    """Raised when stored attachment bytes cannot be read"""
+
+
+def _valid_key(key: str) -> bool:
+    """Reject keys that could escape the storage root"""
+    if not key or key.startswith('/') or '\\' in key:
+        return False
+    return all(part not in ('', '.', '..') for part in key.split('/'))
+
+
+def _read_file(key: str) -> bytes:
+    path = os.path.join(settings.STORAGE_PATH, *key.split('/'))
+    try:
+        with open(path, 'rb') as f:
+            return f.read()
+    except OSError as e:
+        raise AttachmentStorageError(f"failed to read attachment: {e}") from e
+
+
+def _sign(key: bytes, msg: str) -> bytes:
+    return hmac.new(key, msg.encode(), hashlib.sha256).digest()
+
+
+def _read_s3(key: str) -> bytes:
+    """GET an object with AWS Signature Version 4 (path-style URL)"""
+    endpoint = settings.S3_ENDPOINT.rstrip('/')
+    path = urllib.parse.quote(f"/{settings.S3_BUCKET}/{settings.S3_PREFIX}{key}")
+    host = urllib.parse.urlsplit(endpoint).netloc
+
+    now = datetime.datetime.now(datetime.timezone.utc)
+    amz_date = now.strftime('%Y%m%dT%H%M%SZ')
+    day = now.strftime('%Y%m%d')
+    payload_hash = hashlib.sha256(b'').hexdigest()
+
+    signed_headers = 'host;x-amz-content-sha256;x-amz-date'
+    canonical_request = '\n'.join([
+        'GET', path, '',
+        f"host:{host}\nx-amz-content-sha256:{payload_hash}\nx-amz-date:{amz_date}\n",
+        signed_headers, payload_hash,
+    ])
+    scope = f"{day}/{settings.S3_REGION}/s3/aws4_request"
+    string_to_sign = '\n'.join([
+        'AWS4-HMAC-SHA256', amz_date, scope,
+        hashlib.sha256(canonical_request.encode()).hexdigest(),
+    ])
+
+    signing_key = _sign(('AWS4' + settings.S3_SECRET_KEY).encode(), day)
+    for part in (settings.S3_REGION, 's3', 'aws4_request'):
+        signing_key = _sign(signing_key, part)
+    signature = hmac.new(signing_key, string_to_sign.encode(), hashlib.sha256).hexdigest()
+
+    request = urllib.request.Request(endpoint + path, headers={
+        'X-Amz-Date': amz_date,
+        'X-Amz-Content-Sha256': payload_hash,
+        'Authorization': (
+            f"AWS4-HMAC-SHA256 Credential={settings.S3_ACCESS_KEY}/{scope}, "
+            f"SignedHeaders={signed_headers}, Signature={signature}"
+        ),
+    })
+    try:
+        with urllib.request.urlopen(request, timeout=30) as response:
+            return response.read()
+    except OSError as e:
+        raise AttachmentStorageError(f"failed to download attachment: {e}") from e
+
+
+def read_attachment(key: str) -> bytes:
+    """Return the bytes stored under key in the configured attachment store"""
+    if not _valid_key(key):
+        raise AttachmentStorageError(f"invalid storage key {key!r}")
+    if settings.STORAGE_BACKEND == 'filesystem':
+        return _read_file(key)
+    if settings.STORAGE_BACKEND == 's3':
+        return _read_s3(key)
+    raise AttachmentStorageError(f"attachment {key} is not in the database and storage.backend is {settings.STORAGE_BACKEND}")
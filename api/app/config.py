@@ -1,7 +1,7 @@
 """Configuration management using YAML file"""
 
 import os
-from typing import List
+from typing import Dict, List
 import yaml
 
 
@@ -15,6 +15,14 @@ class Config:
         # Domains
         self.DOMAINS: List[str] = config.get('domains', [])
 
+        # Per-domain address caps (domains_config.<domain>.max_addresses, 0 = unlimited)
+        domains_config = config.get('domains_config') or {}
+        self.DOMAIN_MAX_ADDRESSES: Dict[str, int] = {
+            domain.lower(): int(domain_settings.get('max_addresses') or 0)
+            for domain, domain_settings in domains_config.items()
+            if domain_settings and domain_settings.get('max_addresses')
+        }
+
         # Database
         db_config = config.get('database', {})
         self.DATABASE_URL: str = db_config.get('url', '')
@@ -29,6 +37,7 @@ class Config:
         self.MAX_MESSAGE_SIZE_MB: int = server_config.get('max_message_size_mb', 10)
         self.HOSTNAME: str = server_config.get('hostname', 'mail.tempmail.local')
         self.DOCS_ENABLED: bool = server_config.get('docs_enabled', True)
+        self.ADMIN_TOKEN: str = server_config.get('admin_token', '') or ''  # Empty disables admin endpoints
 
         # Tempmail settings
         tempmail_config = config.get('tempmail', {})
@@ -45,6 +54,18 @@ class Config:
             'info', 'support', 'security', 'sales', 'contact'
         ])
 
+        # Attachment storage (storage.backend; filesystem and s3 keep only a key in the table)
+        storage_config = config.get('storage') or {}
+        s3_config = storage_config.get('s3') or {}
+        self.STORAGE_BACKEND: str = storage_config.get('backend', 'postgres')
+        self.STORAGE_PATH: str = storage_config.get('path', '')
+        self.S3_ENDPOINT: str = s3_config.get('endpoint', '')
+        self.S3_REGION: str = s3_config.get('region', 'us-east-1')
+        self.S3_BUCKET: str = s3_config.get('bucket', '')
+        self.S3_PREFIX: str = s3_config.get('prefix', '')
+        self.S3_ACCESS_KEY: str = s3_config.get('access_key', '')
+        self.S3_SECRET_KEY: str = s3_config.get('secret_key', '')
+
         # Validation
         validation_config = config.get('validation', {})
         self.CHECK_DKIM: bool = validation_config.get('check_dkim', True)
@@ -90,6 +111,7 @@ def create_test_config() -> Config:
 
     # Set minimal test values
     config.DOMAINS = ['tempmail.example.com']
+    config.DOMAIN_MAX_ADDRESSES = {}
     config.DATABASE_URL = 'sqlite:///:memory:'
     config.DB_POOL_SIZE = 5
     config.DB_MAX_OVERFLOW = 10
@@ -99,6 +121,7 @@ def create_test_config() -> Config:
     config.MAX_MESSAGE_SIZE_MB = 10
     config.HOSTNAME = 'mail.test.local'
     config.DOCS_ENABLED = True
+    config.ADMIN_TOKEN = 'test-admin-token'
     config.ADDRESS_LIFETIME_HOURS = 24
     config.MAX_EMAILS_PER_ADDRESS = 100
     config.CLEANUP_INTERVAL_HOURS = 1
@@ -111,6 +134,14 @@ def create_test_config() -> Config:
         'root', 'webmaster', 'hostmaster', 'mailer-daemon',
         'info', 'support', 'security', 'sales', 'contact'
     ]
+    config.STORAGE_BACKEND = 'postgres'
+    config.STORAGE_PATH = ''
+    config.S3_ENDPOINT = ''
+    config.S3_REGION = 'us-east-1'
+    config.S3_BUCKET = ''
+    config.S3_PREFIX = ''
+    config.S3_ACCESS_KEY = ''
+    config.S3_SECRET_KEY = ''
     config.CHECK_DKIM = False
     config.CHECK_SPF = False
     config.CHECK_DMARC = False
@@ -0,0 +1,80 @@
+"""Inbox export - streams stored messages as an mbox file or a zip of .eml files"""
+
+import re
+import zipfile
+from typing import Iterable, Iterator
+
+from sqlalchemy import asc
+from sqlalchemy.orm import Session
+
+from app.models import Email, EmailRecipient
+
+# Rows fetched per query round trip while exporting
+EXPORT_BATCH_SIZE = 50
+
+# mboxrd escaping: quote any line that already looks like a (quoted) separator
+_FROM_LINE = re.compile(rb"^(>*From )", re.MULTILINE)
+
+
+def iter_inbox_emails(db: Session, address_id) -> Iterator[Email]:
+    """Yield an address's emails oldest first without loading the whole inbox"""
+    query = db.query(Email).join(
+        EmailRecipient, Email.id == EmailRecipient.email_id
+    ).filter(
+        EmailRecipient.address_id == address_id
+    ).order_by(asc(Email.received_at), asc(Email.id))
+    return query.yield_per(EXPORT_BATCH_SIZE)
+
+
+def mbox_entry(email: Email) -> bytes:
+    """Render one email as an mboxrd entry (separator line, escaped message, blank line)"""
+    sender = email.return_path or email.from_address or "MAILER-DAEMON"
+    sender = sender.split()[0] if sender.strip() else "MAILER-DAEMON"
+    separator = f"From {sender} {email.received_at.strftime('%a %b %d %H:%M:%S %Y')}\n".encode()
+
+    body = email.raw_message.replace(b"\r\n", b"\n")
+    body = _FROM_LINE.sub(rb">\1", body)
+    if not body.endswith(b"\n"):
+        body += b"\n"
+    return separator + body + b"\n"
+
+
+def stream_mbox(emails: Iterable[Email]) -> Iterator[bytes]:
+    """Stream emails as an mbox file, one entry per chunk"""
+    for email in emails:
+        yield mbox_entry(email)
+
+
+class _ChunkWriter:
+    """Write-only file object collecting zip output so it can be yielded in chunks"""
+
+    def __init__(self):
+        self.chunks = []
+        self.offset = 0
+
+    def write(self, data):
+        self.chunks.append(bytes(data))
+        self.offset += len(data)
+        return len(data)
+
+    def tell(self):
+        return self.offset
+
+    def flush(self):
+        pass
+
+    def drain(self) -> bytes:
+        data = b"".join(self.chunks)
+        self.chunks = []
+        return data
+
+
+def stream_eml_zip(emails: Iterable[Email]) -> Iterator[bytes]:
+    """Stream emails as a zip archive of .eml files, one member at a time"""
+    writer = _ChunkWriter()
+    with zipfile.ZipFile(writer, mode="w", compression=zipfile.ZIP_DEFLATED) as archive:
+        for email in emails:
+            name = f"{email.received_at.strftime('%Y%m%dT%H%M%S')}_{email.id}.eml"
+            archive.writestr(name, email.raw_message)
+            yield writer.drain()
+    yield writer.drain()
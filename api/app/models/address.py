@@ -1,6 +1,6 @@
 """Address model - temporary email addresses"""
 
-from sqlalchemy import Column, String, DateTime
+from sqlalchemy import Column, String, DateTime, Boolean, Integer
 from sqlalchemy.orm import relationship
 import uuid
 from datetime import datetime
@@ -17,6 +17,9 @@ class Address(Base):
     token = Column(String(64), unique=True, nullable=False, index=True)
     created_at = Column(DateTime, nullable=False, default=datetime.utcnow)
     expires_at = Column(DateTime, nullable=False, index=True)
+    blackhole = Column(Boolean, nullable=False, default=False)  # Accept and discard mail
+    max_emails = Column(Integer, nullable=True)  # Retention override, NULL uses max_emails_per_address
+    first_email_at = Column(DateTime, nullable=True)  # Set once by the MX server's first delivery
 
     # Relationships
     email_recipients = relationship("EmailRecipient", back_populates="address", cascade="all, delete-orphan")
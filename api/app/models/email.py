@@ -1,6 +1,6 @@
 """Email models - emails, recipients, and attachments"""
 
-from sqlalchemy import Column, String, Text, DateTime, BigInteger, Boolean, ForeignKey, LargeBinary
+from sqlalchemy import Column, String, Text, DateTime, BigInteger, Boolean, Float, ForeignKey, LargeBinary, JSON
 from sqlalchemy.orm import relationship
 import uuid
 from datetime import datetime
@@ -15,20 +15,46 @@ class Email(Base):
     id = Column(GUID, primary_key=True, default=uuid.uuid4)
     message_id = Column(String(255), index=True)
     subject = Column(Text)
+    raw_subject = Column(Text, nullable=True)  # Original subject if whitespace was normalized
     from_address = Column(String(255), nullable=False, index=True)
+    return_path = Column(String(255), nullable=True)  # Envelope sender for bounce correlation, '' for <>
     to_address = Column(String(255), nullable=False, index=True)
+    delivered_to = Column(String(255), nullable=True)  # Original RCPT TO when stored under a catch-all address
     raw_headers = Column(Text, nullable=False)
     body_plain = Column(Text)
     body_html = Column(Text)
     raw_message = Column(LargeBinary, nullable=False)
+    raw_message_sha256 = Column(String(64), nullable=True, index=True)  # Hash of the message as received
+    envelope = Column(JSON, nullable=True)  # SMTP envelope (MAIL FROM, RCPT TO, params) recorded by the MX
+    session_id = Column(String(32), nullable=True, index=True)  # MX session ID, matches session_id in MX logs
     size_bytes = Column(BigInteger, nullable=False, default=0)
 
     # Validation results
     dkim_valid = Column(Boolean, nullable=True)  # nullable - true/false if checked, NULL if not
+    dkim_details = Column(JSON, nullable=True)  # Per-signature results (domain, selector, algorithm, valid, error)
     spf_result = Column(String(20))  # pass, fail, softfail, neutral, none, temperror, permerror
+    spf_identity = Column(String(10))  # mailfrom, or helo for the null sender
     dmarc_result = Column(String(20))  # pass, fail, none
+    arc_result = Column(String(10), nullable=True)  # ARC chain status: none, pass, fail
+    unauthenticated = Column(Boolean, nullable=False, default=False)  # Failed SPF, DKIM and DMARC together
+    dkim_misaligned = Column(Boolean, nullable=False, default=False)  # Signed by another domain, sender has no DMARC
+
+    # Spam scoring, independent of the validation results
+    spam_score = Column(Float, nullable=True)  # NULL if not scored
+    spam_rules = Column(JSON, nullable=True)  # Names of the matched rules
+    spam_disposition = Column(String(16), nullable=True)  # accept, flag
+
+    # Sending client GeoIP, NULL when unknown
+    client_country = Column(String(2), nullable=True)  # ISO 3166-1 alpha-2
+    client_asn = Column(BigInteger, nullable=True)
 
     has_attachments = Column(Boolean, default=False)
+    image_spam_candidate = Column(Boolean, nullable=False, default=False)
+    bcc_only = Column(Boolean, nullable=False, default=False)  # No To/Cc header, recipients were BCC'd
+    recipient_mismatch = Column(Boolean, nullable=False, default=False)  # RCPT TO missing from To/Cc
+    date_missing = Column(Boolean, nullable=False, default=False)  # No usable Date header
+    is_bounce = Column(Boolean, nullable=False, default=False)  # Null sender MAIL FROM:<> (bounce/DSN)
+    quarantined = Column(Boolean, nullable=False, default=False)  # Failed DMARC with p=quarantine
     received_at = Column(DateTime, nullable=False, default=datetime.utcnow, index=True)
 
     # Relationships
@@ -65,7 +91,9 @@ class Attachment(Base):
     filename = Column(String(255), nullable=False, index=True)
     content_type = Column(String(127), nullable=False)
     size_bytes = Column(BigInteger, nullable=False)
-    data = Column(LargeBinary, nullable=False)  # Binary data stored in database
+    data = Column(LargeBinary, nullable=True)  # Binary data stored in database, NULL when offloaded
+    suspicious = Column(Boolean, nullable=False, default=False)
+    storage_key = Column(Text, nullable=True)  # Key in the attachment store (storage.backend)
     created_at = Column(DateTime, nullable=False, default=datetime.utcnow)
 
     # Relationships
@@ -1,12 +1,16 @@
 """Address management endpoints"""
 
-from fastapi import APIRouter, Depends, HTTPException
+from fastapi import APIRouter, Depends, Header, HTTPException
 from sqlalchemy.orm import Session
 from datetime import datetime, timedelta
+from uuid import UUID
+import hmac
 
 from app.database import get_db
-from app.models import Address
-from app.schemas import AddressResponse, AddressCreate, DomainListResponse
+from app.models import Address, Email, EmailRecipient
+from app.schemas import (
+    AddressResponse, AddressCreate, AddressLimitUpdate, AddressLimitResponse, DomainListResponse
+)
 from app.config import settings
 from app.utils import (
     generate_random_email,
@@ -45,6 +49,7 @@ def create_address(request: AddressCreate = AddressCreate(), db: Session = Depen
     Args:
         username: Optional custom username (3-64 chars, alphanumeric + . _ -)
         domain: Optional domain selection (must be in configured domains)
+        blackhole: Accept mail for the address but discard it instead of storing
 
     Returns:
         - email: The generated email address
@@ -114,6 +119,19 @@ def create_address(request: AddressCreate = AddressCreate(), db: Session = Depen
                     detail="Failed to generate unique email address"
                 )
 
+    # Enforce domains_config.<domain>.max_addresses
+    max_addresses = settings.DOMAIN_MAX_ADDRESSES.get(domain.lower(), 0)
+    if max_addresses > 0:
+        active = db.query(Address).filter(
+            Address.email.ilike(f"%@{domain}"),
+            Address.expires_at > datetime.utcnow()
+        ).count()
+        if active >= max_addresses:
+            raise HTTPException(
+                status_code=403,
+                detail=f"Address limit reached for domain '{domain}'"
+            )
+
     # Generate token
     token = generate_token()
 
@@ -126,7 +144,8 @@ def create_address(request: AddressCreate = AddressCreate(), db: Session = Depen
         email=email,
         token=token,
         created_at=now,
-        expires_at=expires_at
+        expires_at=expires_at,
+        blackhole=request.blackhole
     )
 
     db.add(address)
@@ -134,3 +153,58 @@ def create_address(request: AddressCreate = AddressCreate(), db: Session = Depen
     db.refresh(address)
 
     return address
+
+
+def require_admin(x_admin_token: str = Header(default="")):
+    """Allow the request only with the configured server.admin_token"""
+    if not settings.ADMIN_TOKEN:
+        # Admin endpoints don't exist unless a token is configured
+        raise HTTPException(status_code=404, detail="Not found")
+    if not hmac.compare_digest(x_admin_token.encode(), settings.ADMIN_TOKEN.encode()):
+        raise HTTPException(status_code=401, detail="Invalid admin token")
+
+
+@router.put(
+    "/admin/addresses/{address_id}/limit",
+    response_model=AddressLimitResponse,
+    dependencies=[Depends(require_admin)]
+)
+def set_address_limit(address_id: UUID, request: AddressLimitUpdate, db: Session = Depends(get_db)):
+    """
+    Set how many emails an address keeps, overriding max_emails_per_address.
+
+    Requires the X-Admin-Token header. Oldest emails beyond a lowered limit
+    are deleted right away; the MX enforces the limit on every delivery.
+
+    Example:
+        PUT /api/v1/admin/addresses/{id}/limit
+        Body: {"max_emails": 1000}   (null clears the override)
+    """
+    address = db.query(Address).filter(Address.id == address_id).first()
+    if not address:
+        raise HTTPException(status_code=404, detail="Address not found")
+
+    address.max_emails = request.max_emails
+    effective = address.max_emails or settings.MAX_EMAILS_PER_ADDRESS
+
+    # Delete the oldest emails past the new limit
+    excess = (
+        db.query(Email)
+        .join(EmailRecipient, EmailRecipient.email_id == Email.id)
+        .filter(EmailRecipient.address_id == address.id)
+        .order_by(Email.received_at.desc())
+        .offset(effective)
+        .all()
+    )
+    for email in excess:
+        db.delete(email)
+
+    db.commit()
+    db.refresh(address)
+
+    return AddressLimitResponse(
+        id=address.id,
+        email=address.email,
+        max_emails=address.max_emails,
+        effective_max_emails=effective
+    )
@@ -1,6 +1,7 @@
 """Email retrieval endpoints - token-based authentication"""
 
 from fastapi import APIRouter, Depends, HTTPException, Query, Response
+from fastapi.responses import StreamingResponse
 from sqlalchemy.orm import Session
 from sqlalchemy import desc, or_
 from typing import Optional
@@ -11,6 +12,8 @@ from app.database import get_db
 from app.models import Email, EmailRecipient, Attachment
 from app.schemas import EmailSummary, EmailDetail, EmailListResponse, AttachmentInfo
 from app.utils import get_address_by_token
+from app.export import iter_inbox_emails, stream_mbox, stream_eml_zip
+from app.attachment_storage import read_attachment, AttachmentStorageError
 
 router = APIRouter(prefix="/api/v1/{token}", tags=["emails"])
 
@@ -166,7 +169,8 @@ def get_email(
             id=att.id,
             filename=att.filename,
             content_type=att.content_type,
-            size_bytes=att.size_bytes
+            size_bytes=att.size_bytes,
+            suspicious=bool(att.suspicious)
         )
         for att in attachments
     ]
@@ -175,16 +179,36 @@ def get_email(
         id=email.id,
         message_id=email.message_id,
         subject=email.subject,
+        raw_subject=email.raw_subject,
         from_address=email.from_address,
+        return_path=email.return_path,
         to_address=email.to_address,
+        delivered_to=email.delivered_to,
         raw_headers=email.raw_headers,
         body_plain=email.body_plain,
         body_html=email.body_html,
         size_bytes=email.size_bytes,
+        raw_message_sha256=email.raw_message_sha256,
+        envelope=email.envelope,
+        session_id=email.session_id,
         dkim_valid=email.dkim_valid,
+        dkim_details=email.dkim_details,
         spf_result=email.spf_result,
         dmarc_result=email.dmarc_result,
+        arc_result=email.arc_result,
+        unauthenticated=bool(email.unauthenticated),
+        dkim_misaligned=bool(email.dkim_misaligned),
+        spam_score=email.spam_score,
+        spam_rules=email.spam_rules or [],
+        spam_disposition=email.spam_disposition,
+        client_country=email.client_country,
+        client_asn=email.client_asn,
         has_attachments=email.has_attachments,
+        bcc_only=bool(email.bcc_only),
+        recipient_mismatch=bool(email.recipient_mismatch),
+        date_missing=bool(email.date_missing),
+        is_bounce=bool(email.is_bounce),
+        quarantined=bool(email.quarantined),
         received_at=email.received_at,
         is_read=recipient.is_read,
         attachments=attachment_list
@@ -268,12 +292,20 @@ def download_attachment(
     if not attachment:
         raise HTTPException(status_code=404, detail="Attachment not found")
 
+    # Bytes live in the attachment store when the MX offloaded them
+    content = attachment.data
+    if content is None and attachment.storage_key:
+        try:
+            content = read_attachment(attachment.storage_key)
+        except AttachmentStorageError:
+            raise HTTPException(status_code=502, detail="Attachment content unavailable")
+
     # Return file
     # Sanitize filename to prevent path traversal
     safe_filename = attachment.filename.replace('/', '_').replace('\\', '_')
 
     return Response(
-        content=attachment.data,
+        content=content,
         media_type=attachment.content_type,
         headers={"Content-Disposition": f'attachment; filename="{safe_filename}"'}
     )
@@ -310,3 +342,52 @@ def delete_email(
     db.commit()
 
     return Response(status_code=204)
+
+
+@router.get("/export")
+def export_inbox(
+    token: str,
+    format: str = Query("mbox", pattern="^(mbox|zip)$", description="Archive format: mbox or zip"),
+    db: Session = Depends(get_db)
+):
+    """
+    Download the whole inbox as an archive.
+
+    Messages are exported oldest first exactly as received, attachments included,
+    and streamed so large inboxes are never held in memory.
+
+    **Query Parameters:**
+    - `format`: `mbox` (single mboxrd file, default) or `zip` (one .eml per message)
+
+    **Example:**
+    ```bash
+    curl http://localhost:8000/api/v1/{token}/export -o inbox.mbox
+    curl http://localhost:8000/api/v1/{token}/export?format=zip -o inbox.zip
+    ```
+    """
+    # Verify token
+    address = get_address_by_token(token, db)
+    address_id = address.id
+
+    def content():
+        # The response outlives the request dependency, so release the session here
+        try:
+            emails = iter_inbox_emails(db, address_id)
+            if format == "zip":
+                yield from stream_eml_zip(emails)
+            else:
+                yield from stream_mbox(emails)
+        finally:
+            db.close()
+
+    local_part = address.email.split("@")[0]
+    if format == "zip":
+        media_type, filename = "application/zip", f"{local_part}.zip"
+    else:
+        media_type, filename = "application/mbox", f"{local_part}.mbox"
+
+    return StreamingResponse(
+        content(),
+        media_type=media_type,
+        headers={"Content-Disposition": f'attachment; filename="{filename}"'}
+    )
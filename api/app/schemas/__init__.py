@@ -1,12 +1,16 @@
 """Schemas package"""
 
-from app.schemas.address import AddressCreate, AddressResponse, AddressInfo, DomainListResponse
+from app.schemas.address import (
+    AddressCreate, AddressResponse, AddressInfo, AddressLimitUpdate, AddressLimitResponse, DomainListResponse
+)
 from app.schemas.email import EmailSummary, EmailDetail, EmailListResponse, AttachmentInfo
 
 __all__ = [
     "AddressCreate",
     "AddressResponse",
     "AddressInfo",
+    "AddressLimitUpdate",
+    "AddressLimitResponse",
     "DomainListResponse",
     "EmailSummary",
     "EmailDetail",
@@ -1,6 +1,6 @@
 """Pydantic schemas for addresses"""
 
-from pydantic import BaseModel, EmailStr, field_validator, field_serializer
+from pydantic import BaseModel, EmailStr, Field, field_validator, field_serializer
 from typing import Optional, List
 from datetime import datetime
 from uuid import UUID
@@ -11,6 +11,7 @@ class AddressCreate(BaseModel):
     """Schema for creating a new address (API request)"""
     username: Optional[str] = None  # If None, generates random username
     domain: Optional[str] = None    # If None, uses first configured domain
+    blackhole: bool = False         # Accept mail but discard it (sinks, load tests)
 
     @field_validator('username')
     @classmethod
@@ -60,6 +61,7 @@ class AddressResponse(BaseModel):
     token: str
     created_at: datetime
     expires_at: datetime
+    blackhole: bool = False
 
     @field_serializer('created_at', 'expires_at')
     def serialize_dt(self, dt: datetime, _info):
@@ -86,6 +88,22 @@ class AddressInfo(BaseModel):
         from_attributes = True
 
 
+class AddressLimitUpdate(BaseModel):
+    """Schema for setting an address's retention override"""
+    max_emails: Optional[int] = Field(default=None, ge=1)  # None clears the override
+
+
+class AddressLimitResponse(BaseModel):
+    """Retention settings of an address"""
+    id: UUID
+    email: EmailStr
+    max_emails: Optional[int] = None  # None means max_emails_per_address applies
+    effective_max_emails: int
+
+    class Config:
+        from_attributes = True
+
+
 class DomainListResponse(BaseModel):
     """Response schema for listing available domains"""
     domains: List[str]
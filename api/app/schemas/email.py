@@ -3,7 +3,7 @@
 from pydantic import BaseModel, field_serializer
 from datetime import datetime
 from uuid import UUID
-from typing import Optional, List
+from typing import Optional, List, Any, Dict
 
 
 class AttachmentInfo(BaseModel):
@@ -12,11 +12,21 @@ class AttachmentInfo(BaseModel):
     filename: str
     content_type: str
     size_bytes: int
+    suspicious: bool = False
 
     class Config:
         from_attributes = True
 
 
+class DKIMSignatureInfo(BaseModel):
+    """Result of checking one DKIM-Signature header"""
+    domain: str
+    selector: str
+    algorithm: str
+    valid: bool
+    error: Optional[str] = None
+
+
 class EmailSummary(BaseModel):
     """Email summary for list view"""
     id: UUID
@@ -41,19 +51,45 @@ class EmailDetail(BaseModel):
     id: UUID
     message_id: Optional[str]
     subject: Optional[str]
+    raw_subject: Optional[str] = None  # Original subject if whitespace was normalized
     from_address: str
+    return_path: Optional[str] = None
     to_address: str
+    delivered_to: Optional[str] = None  # Original RCPT TO, differs from to_address for catch-all domains
     raw_headers: str
     body_plain: Optional[str]
     body_html: Optional[str]
     size_bytes: int
+    raw_message_sha256: Optional[str] = None  # SHA-256 of the message as received, before header additions
+
+    # SMTP envelope as recorded by the MX (sender, recipients, parameters)
+    envelope: Optional[Dict[str, Any]] = None
+    session_id: Optional[str] = None  # MX session that delivered it, for finding its log lines
 
     # Validation results
     dkim_valid: Optional[bool]
+    dkim_details: Optional[List[DKIMSignatureInfo]] = None  # None if DKIM was not checked by the MX
     spf_result: Optional[str]
     dmarc_result: Optional[str]
+    arc_result: Optional[str] = None  # ARC chain status (None if not checked)
+    unauthenticated: bool = False  # Failed SPF, DKIM and DMARC together
+    dkim_misaligned: bool = False  # Signed by another domain, sender has no DMARC
+
+    # Spam scoring, independent of the validation results (None if not scored)
+    spam_score: Optional[float] = None
+    spam_rules: List[str] = []
+    spam_disposition: Optional[str] = None
+
+    # Sending client GeoIP (None if unknown or GeoIP is off)
+    client_country: Optional[str] = None
+    client_asn: Optional[int] = None
 
     has_attachments: bool
+    bcc_only: bool = False  # No To/Cc header, every recipient was BCC'd
+    recipient_mismatch: bool = False  # Envelope recipient not listed in To/Cc
+    date_missing: bool = False  # No usable Date header (missing or unparseable)
+    is_bounce: bool = False  # Received with the null sender MAIL FROM:<> (a bounce or other DSN)
+    quarantined: bool = False  # Failed DMARC and the sender publishes p=quarantine
     received_at: datetime
     is_read: bool
 
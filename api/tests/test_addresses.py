@@ -2,6 +2,7 @@
 
 import pytest
 from datetime import datetime, timedelta
+from uuid import uuid4
 from app.models import Address
 
 
@@ -99,6 +100,25 @@ class TestAddressCreation:
         assert address.email == data["email"]
         assert address.token == data["token"]
 
+    def test_create_blackhole_address(self, client, db_session):
+        """Test blackhole flag is stored and returned"""
+        response = client.post("/api/v1/addresses", json={"blackhole": True})
+
+        assert response.status_code == 200
+        data = response.json()
+        assert data["blackhole"] is True
+
+        address = db_session.query(Address).filter(
+            Address.email == data["email"]
+        ).first()
+        assert address.blackhole is True
+
+    def test_create_address_not_blackhole_by_default(self, client):
+        """Test addresses store mail unless blackhole is requested"""
+        response = client.post("/api/v1/addresses")
+
+        assert response.json()["blackhole"] is False
+
 
 class TestAddressValidation:
     """Test address validation and constraints"""
@@ -466,3 +486,117 @@ class TestBackwardCompatibility:
         assert "token" in data
         assert "created_at" in data
         assert "expires_at" in data
+
+
+class TestAddressLimit:
+    """Test the admin retention override endpoint"""
+
+    ADMIN = {"X-Admin-Token": "test-admin-token"}
+
+    def _address_with_emails(self, client, db_session, count):
+        from app.models import Email, EmailRecipient
+
+        data = client.post("/api/v1/addresses").json()
+        address = db_session.query(Address).filter(Address.email == data["email"]).first()
+        base = datetime.utcnow()
+        for i in range(count):
+            email = Email(
+                subject=f"Message {i}",
+                from_address="sender@example.com",
+                to_address=address.email,
+                raw_headers="From: sender@example.com",
+                raw_message=b"raw",
+                size_bytes=3,
+                received_at=base + timedelta(minutes=i)
+            )
+            db_session.add(email)
+            db_session.flush()
+            db_session.add(EmailRecipient(email_id=email.id, address_id=address.id))
+        db_session.commit()
+        return data
+
+    def test_set_custom_limit_enforces_retention(self, client, db_session):
+        """Test a lowered limit keeps only the newest emails"""
+        data = self._address_with_emails(client, db_session, 5)
+
+        response = client.put(
+            f"/api/v1/admin/addresses/{data['id']}/limit", json={"max_emails": 2}, headers=self.ADMIN
+        )
+
+        assert response.status_code == 200
+        assert response.json()["max_emails"] == 2
+        assert response.json()["effective_max_emails"] == 2
+
+        inbox = client.get(f"/api/v1/{data['token']}/emails").json()
+        subjects = sorted(e["subject"] for e in inbox["emails"])
+        assert subjects == ["Message 3", "Message 4"]
+
+    def test_clear_limit_uses_global_default(self, client, db_session):
+        """Test null clears the override"""
+        data = self._address_with_emails(client, db_session, 0)
+
+        client.put(f"/api/v1/admin/addresses/{data['id']}/limit", json={"max_emails": 5}, headers=self.ADMIN)
+        response = client.put(
+            f"/api/v1/admin/addresses/{data['id']}/limit", json={"max_emails": None}, headers=self.ADMIN
+        )
+
+        assert response.status_code == 200
+        assert response.json()["max_emails"] is None
+        assert response.json()["effective_max_emails"] == 100
+
+    def test_requires_admin_token(self, client, db_session):
+        """Test the endpoint rejects missing or wrong tokens"""
+        data = self._address_with_emails(client, db_session, 0)
+        url = f"/api/v1/admin/addresses/{data['id']}/limit"
+
+        assert client.put(url, json={"max_emails": 5}).status_code == 401
+        assert client.put(url, json={"max_emails": 5}, headers={"X-Admin-Token": "wrong"}).status_code == 401
+
+    def test_invalid_limit_rejected(self, client, db_session):
+        """Test limits below 1 are refused"""
+        data = self._address_with_emails(client, db_session, 0)
+
+        response = client.put(
+            f"/api/v1/admin/addresses/{data['id']}/limit", json={"max_emails": 0}, headers=self.ADMIN
+        )
+        assert response.status_code == 422
+
+    def test_unknown_address(self, client):
+        """Test 404 for an unknown address id"""
+        response = client.put(
+            f"/api/v1/admin/addresses/{uuid4()}/limit", json={"max_emails": 5}, headers=self.ADMIN
+        )
+        assert response.status_code == 404
+
+
+class TestDomainAddressCap:
+    """Test domains_config.<domain>.max_addresses"""
+
+    def test_create_up_to_cap(self, client, monkeypatch):
+        """Test addresses can be created up to the cap, then are refused"""
+        from app.config import settings
+        monkeypatch.setattr(settings, "DOMAIN_MAX_ADDRESSES", {"tempmail.example.com": 2})
+
+        assert client.post("/api/v1/addresses").status_code == 200
+        assert client.post("/api/v1/addresses", json={"username": "second"}).status_code == 200
+
+        response = client.post("/api/v1/addresses")
+        assert response.status_code == 403
+        assert "limit" in response.json()["detail"].lower()
+
+    def test_expired_addresses_do_not_count(self, client, db_session, monkeypatch):
+        """Test expired addresses free up room under the cap"""
+        from app.config import settings
+        monkeypatch.setattr(settings, "DOMAIN_MAX_ADDRESSES", {"tempmail.example.com": 1})
+
+        data = client.post("/api/v1/addresses").json()
+        address = db_session.query(Address).filter(Address.email == data["email"]).first()
+        address.expires_at = datetime.utcnow() - timedelta(hours=1)
+        db_session.commit()
+
+        assert client.post("/api/v1/addresses").status_code == 200
+
+    def test_uncapped_by_default(self, client):
+        """Test no cap applies without max_addresses"""
+        for _ in range(5):
+            assert client.post("/api/v1/addresses").status_code == 200
@@ -394,6 +394,22 @@ class TestEmailDetail:
 
         assert response.status_code == 404
 
+    def test_get_email_includes_envelope(self, client, db_session):
+        """Test email details expose the SMTP envelope separately from headers"""
+        address = create_test_address(db_session)
+        email = create_test_email(db_session, address)
+        email.envelope = {
+            "mail_from": "bounce@example.com",
+            "recipients": [{"address": address.email}],
+        }
+        db_session.commit()
+
+        response = client.get(f"/api/v1/{address.token}/emails/{email.id}")
+        data = response.json()
+
+        assert data["envelope"]["mail_from"] == "bounce@example.com"
+        assert data["envelope"]["recipients"][0]["address"] == address.email
+
 
 class TestEmailAttachments:
     """Test email attachment handling"""
@@ -448,6 +464,35 @@ class TestEmailAttachments:
         assert response.content == attachment_data
         assert "attachment" in response.headers["content-disposition"]
 
+    def test_download_offloaded_attachment(self, client, db_session, monkeypatch, tmp_path):
+        """Test downloading an attachment the MX kept in the filesystem store"""
+        from app.config import settings
+        monkeypatch.setattr(settings, "STORAGE_BACKEND", "filesystem")
+        monkeypatch.setattr(settings, "STORAGE_PATH", str(tmp_path))
+
+        attachment_data = b"offloaded file content"
+        (tmp_path / "ab").mkdir()
+        (tmp_path / "ab" / "abcdef").write_bytes(attachment_data)
+
+        address = create_test_address(db_session)
+        email = create_test_email(db_session, address, has_attachments=True)
+        attachment = Attachment(
+            email_id=email.id,
+            filename="offloaded.txt",
+            content_type="text/plain",
+            size_bytes=len(attachment_data),
+            storage_key="ab/abcdef"
+        )
+        db_session.add(attachment)
+        db_session.commit()
+
+        response = client.get(
+            f"/api/v1/{address.token}/emails/{email.id}/attachments/{attachment.id}"
+        )
+
+        assert response.status_code == 200
+        assert response.content == attachment_data
+
     def test_download_attachment_wrong_email(self, client, db_session):
         """Test downloading attachment from wrong email fails"""
         address = create_test_address(db_session)
@@ -568,3 +613,109 @@ class TestEmailValidation:
         assert data["dkim_valid"] is True
         assert data["spf_result"] == "pass"
         assert data["dmarc_result"] == "pass"
+        assert data["spam_score"] is None
+        assert data["spam_disposition"] is None
+
+    def test_spam_verdict_separate_from_validation(self, client, db_session):
+        """Test spam verdict is returned alongside, not instead of, validation results"""
+        address = create_test_address(db_session)
+        email = create_test_email(db_session, address)
+        email.spf_result = "fail"
+        email.spam_score = 6.5
+        email.spam_rules = ["IMAGE_ONLY", "SUSPICIOUS_ATTACHMENT"]
+        email.spam_disposition = "flag"
+        db_session.commit()
+
+        response = client.get(f"/api/v1/{address.token}/emails/{email.id}")
+        data = response.json()
+
+        assert data["spf_result"] == "fail"
+        assert data["spam_score"] == 6.5
+        assert data["spam_rules"] == ["IMAGE_ONLY", "SUSPICIOUS_ATTACHMENT"]
+        assert data["spam_disposition"] == "flag"
+
+
+class TestInboxExport:
+    """Test whole-inbox mbox/zip export"""
+
+    def _create_raw_email(self, db_session, address, raw, received_at):
+        email = create_test_email(db_session, address)
+        email.raw_message = raw
+        email.received_at = received_at
+        db_session.commit()
+        return email
+
+    def test_export_mbox(self, client, db_session):
+        """Test exporting an inbox as mbox with one separator per message"""
+        address = create_test_address(db_session)
+        now = datetime.utcnow()
+        self._create_raw_email(
+            db_session, address,
+            b"From: a@example.com\r\nSubject: First\r\n\r\nHello\r\nFrom the start of a line\r\n",
+            now - timedelta(minutes=5)
+        )
+        self._create_raw_email(
+            db_session, address,
+            b"From: b@example.com\r\nSubject: Second\r\n\r\nBye\r\n",
+            now
+        )
+
+        response = client.get(f"/api/v1/{address.token}/export")
+
+        assert response.status_code == 200
+        assert response.headers["content-type"].startswith("application/mbox")
+        assert "test.mbox" in response.headers["content-disposition"]
+
+        body = response.content
+        separators = [line for line in body.split(b"\n") if line.startswith(b"From ")]
+        assert len(separators) == 2
+        assert separators[0].startswith(b"From sender@example.com ")
+        # Body lines that look like separators are quoted
+        assert b"\n>From the start of a line\n" in body
+        # Oldest message first
+        assert body.index(b"Subject: First") < body.index(b"Subject: Second")
+
+    def test_export_zip(self, client, db_session):
+        """Test exporting an inbox as a zip of .eml files"""
+        import io
+        import zipfile
+
+        address = create_test_address(db_session)
+        first = self._create_raw_email(db_session, address, b"Subject: One\r\n\r\n1\r\n", datetime.utcnow())
+        second = self._create_raw_email(db_session, address, b"Subject: Two\r\n\r\n2\r\n", datetime.utcnow())
+
+        response = client.get(f"/api/v1/{address.token}/export?format=zip")
+
+        assert response.status_code == 200
+        assert response.headers["content-type"] == "application/zip"
+
+        archive = zipfile.ZipFile(io.BytesIO(response.content))
+        names = archive.namelist()
+        assert len(names) == 2
+        contents = {archive.read(name) for name in names}
+        assert contents == {first.raw_message, second.raw_message}
+
+    def test_export_only_own_emails(self, client, db_session):
+        """Test export excludes other addresses' mail"""
+        address = create_test_address(db_session)
+        other = create_test_address(db_session, email="other@tempmail.example.com", token="other_token")
+        self._create_raw_email(db_session, other, b"Subject: Private\r\n\r\nx\r\n", datetime.utcnow())
+
+        response = client.get(f"/api/v1/{address.token}/export")
+
+        assert response.status_code == 200
+        assert response.content == b""
+
+    def test_export_invalid_format(self, client, db_session):
+        """Test unsupported export formats are rejected"""
+        address = create_test_address(db_session)
+
+        response = client.get(f"/api/v1/{address.token}/export?format=tar")
+
+        assert response.status_code == 422
+
+    def test_export_invalid_token(self, client, db_session):
+        """Test export with an unknown token"""
+        response = client.get("/api/v1/invalid_token/export")
+
+        assert response.status_code == 404
@@ -15,7 +15,11 @@ CREATE TABLE addresses (
     token VARCHAR(64) NOT NULL UNIQUE,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     expires_at TIMESTAMP NOT NULL,
-    CONSTRAINT addresses_email_check CHECK (email ~ '^[^@]+@[^@]+$')
+    blackhole BOOLEAN NOT NULL DEFAULT FALSE,
+    max_emails INTEGER,  -- retention override, NULL uses max_emails_per_address
+    first_email_at TIMESTAMP,  -- set once by the first delivery
+    CONSTRAINT addresses_email_check CHECK (email ~ '^[^@]+@[^@]+$'),
+    CONSTRAINT addresses_max_emails_check CHECK (max_emails IS NULL OR max_emails > 0)
 );
 
 CREATE INDEX idx_addresses_token ON addresses(token);
@@ -25,6 +29,9 @@ CREATE INDEX idx_addresses_email ON addresses(email);
 COMMENT ON TABLE addresses IS 'Temporary email addresses with auto-expiration';
 COMMENT ON COLUMN addresses.token IS 'Access token for API authentication';
 COMMENT ON COLUMN addresses.expires_at IS 'When this address will be automatically deleted';
+COMMENT ON COLUMN addresses.blackhole IS 'Accept mail with 250 but discard it instead of storing';
+COMMENT ON COLUMN addresses.max_emails IS 'Per-address retention override; NULL uses max_emails_per_address';
+COMMENT ON COLUMN addresses.first_email_at IS 'When the first email was stored; set once, NULL until then';
 
 -- ============================================================================
 -- Table: emails
@@ -34,20 +41,48 @@ CREATE TABLE emails (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     message_id VARCHAR(255),
     subject TEXT,
+    raw_subject TEXT,
     from_address VARCHAR(255) NOT NULL,
+    return_path VARCHAR(255),  -- envelope sender, '' for the null sender <>
     to_address VARCHAR(255) NOT NULL,
+    delivered_to VARCHAR(255),  -- original RCPT TO, differs from to_address for catch-all domains
     raw_headers TEXT NOT NULL,
     body_plain TEXT,
     body_html TEXT,
+    body_language VARCHAR(8),  -- ISO 639-1 code, NULL/empty if not detected
     raw_message BYTEA NOT NULL,
+    raw_message_sha256 CHAR(64),  -- hex SHA-256 of the message as received
+    envelope JSONB,  -- SMTP envelope: MAIL FROM, RCPT TO, parameters, timestamps
+    session_id VARCHAR(32),  -- MX session that delivered the message, matches session_id in its logs
     size_bytes BIGINT NOT NULL DEFAULT 0,
 
     -- Validation results
     dkim_valid BOOLEAN DEFAULT NULL,
+    dkim_algorithm VARCHAR(20),  -- a= tag of the accepted signature, e.g. rsa-sha256
+    dkim_details JSONB,  -- one object per DKIM-Signature: domain, selector, algorithm, valid, error
     spf_result VARCHAR(20),  -- pass, fail, softfail, neutral, none, temperror, permerror
+    spf_identity VARCHAR(10),  -- mailfrom, or helo for the null sender
     dmarc_result VARCHAR(20), -- pass, fail, none
+    arc_result VARCHAR(10),  -- ARC chain status: none, pass, fail
+    unauthenticated BOOLEAN NOT NULL DEFAULT FALSE,  -- failed SPF, DKIM and DMARC together
+    dkim_misaligned BOOLEAN NOT NULL DEFAULT FALSE,  -- signed by another domain, sender has no DMARC
+
+    -- Spam scoring (independent of the validation results above)
+    spam_score REAL,  -- NULL if the message was not scored
+    spam_rules JSONB,  -- names of the matched rules
+    spam_disposition VARCHAR(16),  -- accept, flag
+
+    -- Sending client, from geoip.db_path (NULL when GeoIP is off or the IP is unknown)
+    client_country VARCHAR(2),
+    client_asn BIGINT,
 
     has_attachments BOOLEAN DEFAULT FALSE,
+    image_spam_candidate BOOLEAN NOT NULL DEFAULT FALSE,
+    bcc_only BOOLEAN NOT NULL DEFAULT FALSE,
+    recipient_mismatch BOOLEAN NOT NULL DEFAULT FALSE,
+    date_missing BOOLEAN NOT NULL DEFAULT FALSE,
+    is_bounce BOOLEAN NOT NULL DEFAULT FALSE,  -- null sender MAIL FROM:<>
+    quarantined BOOLEAN NOT NULL DEFAULT FALSE,
     received_at TIMESTAMP NOT NULL DEFAULT NOW(),
 
     CONSTRAINT emails_size_check CHECK (size_bytes >= 0)
@@ -57,13 +92,40 @@ CREATE INDEX idx_emails_message_id ON emails(message_id);
 CREATE INDEX idx_emails_from ON emails(from_address);
 CREATE INDEX idx_emails_to ON emails(to_address);
 CREATE INDEX idx_emails_received_at ON emails(received_at DESC);
+CREATE INDEX idx_emails_received_at_id ON emails(received_at DESC, id DESC);  -- keyset pagination
+CREATE INDEX idx_emails_raw_message_sha256 ON emails(raw_message_sha256);
+CREATE INDEX idx_emails_session_id ON emails(session_id);
 CREATE INDEX idx_emails_subject_trgm ON emails USING gin (subject gin_trgm_ops);
 
 COMMENT ON TABLE emails IS 'Received email messages with full content and validation';
 COMMENT ON COLUMN emails.raw_message IS 'Complete RFC 5322 message as received';
+COMMENT ON COLUMN emails.raw_message_sha256 IS 'SHA-256 of the message as received, before the MX added any headers';
+COMMENT ON COLUMN emails.return_path IS 'Return-Path (envelope sender) for bounce correlation';
+COMMENT ON COLUMN emails.delivered_to IS 'Original RCPT TO address; catch-all domains store under one address';
+COMMENT ON COLUMN emails.envelope IS 'SMTP transaction envelope recorded separately from the DATA message';
+COMMENT ON COLUMN emails.session_id IS 'ID of the SMTP session that delivered the message, as logged by the MX';
+COMMENT ON COLUMN emails.body_language IS 'Detected primary language of the plain text body';
 COMMENT ON COLUMN emails.dkim_valid IS 'DKIM signature validation result';
+COMMENT ON COLUMN emails.dkim_algorithm IS 'Signing algorithm of the accepted DKIM signature';
+COMMENT ON COLUMN emails.arc_result IS 'ARC chain validation status: none, pass, fail; NULL if ARC was not checked';
+COMMENT ON COLUMN emails.dkim_details IS 'Per-signature DKIM results: domain, selector, algorithm, valid, error; NULL if DKIM was not checked locally';
 COMMENT ON COLUMN emails.spf_result IS 'SPF validation result';
+COMMENT ON COLUMN emails.spf_identity IS 'Identity SPF was evaluated against (mailfrom or helo)';
 COMMENT ON COLUMN emails.dmarc_result IS 'DMARC policy check result';
+COMMENT ON COLUMN emails.unauthenticated IS 'Failed SPF, DKIM and DMARC together; flagged instead of rejected';
+COMMENT ON COLUMN emails.dkim_misaligned IS 'Valid DKIM signature from another domain while the sender has no DMARC; possible third-party signing abuse';
+COMMENT ON COLUMN emails.image_spam_candidate IS 'Image attachment with negligible text, weighted by spam scoring';
+COMMENT ON COLUMN emails.recipient_mismatch IS 'Single envelope recipient absent from To/Cc; weak spam signal';
+COMMENT ON COLUMN emails.raw_subject IS 'Subject before whitespace normalization; NULL when normalization is off or changed nothing';
+COMMENT ON COLUMN emails.date_missing IS 'No usable Date header (missing or unparseable); malformed message signal';
+COMMENT ON COLUMN emails.is_bounce IS 'Received with the null sender MAIL FROM:<>; a bounce or other delivery status notification';
+COMMENT ON COLUMN emails.quarantined IS 'Failed DMARC and the sender publishes p=quarantine; set only with validation.enforce_dmarc';
+COMMENT ON COLUMN emails.bcc_only IS 'No To/Cc header, all recipients were BCC''d; weighted by spam scoring';
+COMMENT ON COLUMN emails.spam_score IS 'Content filter score, NULL when the message was not scored';
+COMMENT ON COLUMN emails.spam_rules IS 'Names of the spam rules that matched';
+COMMENT ON COLUMN emails.spam_disposition IS 'Spam decision taken from the score: accept or flag';
+COMMENT ON COLUMN emails.client_country IS 'GeoIP country (ISO 3166-1 alpha-2) of the sending client, NULL if unknown';
+COMMENT ON COLUMN emails.client_asn IS 'GeoIP autonomous system number of the sending client, NULL if unknown';
 
 -- ============================================================================
 -- Table: email_recipients
@@ -88,7 +150,8 @@ COMMENT ON TABLE email_recipients IS 'Links emails to recipient addresses with r
 
 -- ============================================================================
 -- Table: attachments
--- Stores email attachments in database as BYTEA
+-- Stores email attachments in database as BYTEA, or a key into the
+-- attachment store (storage.backend: filesystem or s3)
 -- ============================================================================
 CREATE TABLE attachments (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -96,17 +159,43 @@ CREATE TABLE attachments (
     filename VARCHAR(255) NOT NULL,
     content_type VARCHAR(127) NOT NULL,
     size_bytes BIGINT NOT NULL,
-    data BYTEA NOT NULL,
+    data BYTEA,
+    suspicious BOOLEAN NOT NULL DEFAULT FALSE,
+    storage_key TEXT,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
 
-    CONSTRAINT attachments_size_check CHECK (size_bytes >= 0)
+    CONSTRAINT attachments_size_check CHECK (size_bytes >= 0),
+    CONSTRAINT attachments_content_check CHECK (data IS NOT NULL OR storage_key IS NOT NULL)
 );
 
 CREATE INDEX idx_attachments_email_id ON attachments(email_id);
 CREATE INDEX idx_attachments_filename ON attachments(filename);
+CREATE INDEX idx_attachments_storage_key ON attachments(storage_key) WHERE storage_key IS NOT NULL;
 
 COMMENT ON TABLE attachments IS 'Email attachments stored as binary data';
-COMMENT ON COLUMN attachments.data IS 'File content stored in database';
+COMMENT ON COLUMN attachments.data IS 'File content stored in database; NULL when kept in the attachment store';
+COMMENT ON COLUMN attachments.storage_key IS 'Key in the attachment store (filesystem or S3); NULL when data holds the content';
+COMMENT ON COLUMN attachments.suspicious IS 'Flagged by the attachment policy (e.g. invoice.pdf.exe double extension)';
+
+-- ============================================================================
+-- Table: greylist
+-- Delivery attempts seen by the MX greylist
+-- ============================================================================
+CREATE TABLE greylist (
+    client_ip VARCHAR(45) NOT NULL,
+    sender TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    first_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (client_ip, sender, recipient)
+);
+
+CREATE INDEX idx_greylist_last_seen ON greylist(last_seen);
+
+COMMENT ON TABLE greylist IS 'Greylisting triples; a retry is accepted once first_seen is older than greylist.delay_minutes';
+COMMENT ON COLUMN greylist.sender IS 'Lowercased MAIL FROM, empty for the null sender';
+COMMENT ON COLUMN greylist.last_seen IS 'Last attempt; triples idle longer than greylist.expiry_hours are purged';
 
 -- ============================================================================
 -- Triggers for automatic cleanup
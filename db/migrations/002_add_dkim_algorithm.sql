@@ -0,0 +1,7 @@
+-- Migration: Add DKIM algorithm column
+-- Date: 2026-10-17
+-- Description: Records the signing algorithm of the accepted DKIM signature
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS dkim_algorithm VARCHAR(20);
+
+COMMENT ON COLUMN emails.dkim_algorithm IS 'Signing algorithm of the accepted DKIM signature';
@@ -0,0 +1,7 @@
+-- Migration: Add detected body language column
+-- Date: 2026-10-17
+-- Description: Stores the detected primary language of the plain text body
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS body_language VARCHAR(8);
+
+COMMENT ON COLUMN emails.body_language IS 'Detected primary language of the plain text body';
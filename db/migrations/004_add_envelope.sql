@@ -0,0 +1,7 @@
+-- Migration: Add SMTP envelope column
+-- Date: 2026-10-17
+-- Description: Stores the SMTP envelope (MAIL FROM, RCPT TO, parameters) as JSON
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS envelope JSONB;
+
+COMMENT ON COLUMN emails.envelope IS 'SMTP transaction envelope recorded separately from the DATA message';
@@ -0,0 +1,7 @@
+-- Migration: Add suspicious flag to attachments
+-- Date: 2026-10-17
+-- Description: Marks attachments flagged by the attachment policy (double extensions like invoice.pdf.exe)
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS suspicious BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN attachments.suspicious IS 'Flagged by the attachment policy (e.g. invoice.pdf.exe double extension)';
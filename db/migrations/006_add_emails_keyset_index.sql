@@ -0,0 +1,5 @@
+-- Migration: Add keyset pagination index on emails
+-- Date: 2026-10-17
+-- Description: Supports inbox listing ordered by (received_at, id) without OFFSET scans
+
+CREATE INDEX IF NOT EXISTS idx_emails_received_at_id ON emails(received_at DESC, id DESC);
@@ -0,0 +1,7 @@
+-- Migration: Add Return-Path column
+-- Date: 2026-10-17
+-- Description: Stores the Return-Path (envelope sender) for bounce correlation
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS return_path VARCHAR(255);
+
+COMMENT ON COLUMN emails.return_path IS 'Return-Path (envelope sender) for bounce correlation';
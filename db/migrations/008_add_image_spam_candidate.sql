@@ -0,0 +1,7 @@
+-- Migration: Add image spam flag
+-- Date: 2026-10-17
+-- Description: Flags messages that consist of an image attachment with negligible text
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS image_spam_candidate BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN emails.image_spam_candidate IS 'Image attachment with negligible text, weighted by spam scoring';
@@ -0,0 +1,7 @@
+-- Migration: Add SPF identity column
+-- Date: 2026-10-17
+-- Description: Records whether SPF was checked against MAIL FROM or the HELO name (null sender)
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS spf_identity VARCHAR(10);
+
+COMMENT ON COLUMN emails.spf_identity IS 'Identity SPF was evaluated against (mailfrom or helo)';
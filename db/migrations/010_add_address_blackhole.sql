@@ -0,0 +1,7 @@
+-- Migration: Add blackhole flag to addresses
+-- Date: 2026-10-17
+-- Description: Blackhole addresses accept mail with 250 but discard it instead of storing
+
+ALTER TABLE addresses ADD COLUMN IF NOT EXISTS blackhole BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN addresses.blackhole IS 'Accept mail with 250 but discard it instead of storing';
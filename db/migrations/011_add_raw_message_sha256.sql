@@ -0,0 +1,9 @@
+-- Migration: Add raw message hash column
+-- Date: 2026-10-17
+-- Description: Records a SHA-256 of the message as received for integrity audits and dedup
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS raw_message_sha256 CHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_emails_raw_message_sha256 ON emails(raw_message_sha256);
+
+COMMENT ON COLUMN emails.raw_message_sha256 IS 'SHA-256 of the message as received, before the MX added any headers';
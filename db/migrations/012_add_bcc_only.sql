@@ -0,0 +1,7 @@
+-- Migration: Add BCC-only flag
+-- Date: 2026-10-17
+-- Description: Flags messages with no To/Cc header where every recipient was BCC'd
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS bcc_only BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN emails.bcc_only IS 'No To/Cc header, all recipients were BCC''d; weighted by spam scoring';
@@ -0,0 +1,7 @@
+-- Migration: Add delivered_to column
+-- Date: 2026-10-17
+-- Description: Keeps the original RCPT TO when a catch-all domain stores mail under one address
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS delivered_to VARCHAR(255);
+
+COMMENT ON COLUMN emails.delivered_to IS 'Original RCPT TO address; catch-all domains store under one address';
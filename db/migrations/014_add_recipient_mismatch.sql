@@ -0,0 +1,7 @@
+-- Migration: Add recipient mismatch flag
+-- Date: 2026-10-17
+-- Description: Flags single-recipient mail whose RCPT TO is not listed in the To/Cc headers
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS recipient_mismatch BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN emails.recipient_mismatch IS 'Single envelope recipient absent from To/Cc; weak spam signal';
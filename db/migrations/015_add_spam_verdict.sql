@@ -0,0 +1,11 @@
+-- Migration: Add spam verdict columns
+-- Date: 2026-10-17
+-- Description: Stores the content filter's score, matched rules and disposition apart from SPF/DKIM/DMARC results
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS spam_score REAL;
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS spam_rules JSONB;
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS spam_disposition VARCHAR(16);
+
+COMMENT ON COLUMN emails.spam_score IS 'Content filter score, NULL when the message was not scored';
+COMMENT ON COLUMN emails.spam_rules IS 'Names of the spam rules that matched';
+COMMENT ON COLUMN emails.spam_disposition IS 'Spam decision taken from the score: accept or flag';
@@ -0,0 +1,10 @@
+-- Migration: Add per-address retention override
+-- Date: 2026-10-17
+-- Description: Lets operators keep more (or fewer) emails for specific addresses than max_emails_per_address
+
+ALTER TABLE addresses ADD COLUMN IF NOT EXISTS max_emails INTEGER;
+
+ALTER TABLE addresses DROP CONSTRAINT IF EXISTS addresses_max_emails_check;
+ALTER TABLE addresses ADD CONSTRAINT addresses_max_emails_check CHECK (max_emails IS NULL OR max_emails > 0);
+
+COMMENT ON COLUMN addresses.max_emails IS 'Per-address retention override; NULL uses max_emails_per_address';
@@ -0,0 +1,7 @@
+-- Migration: Add unauthenticated flag
+-- Date: 2026-10-17
+-- Description: Flags mail that failed SPF, DKIM and DMARC together (validation.reject_unauthenticated: flag)
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS unauthenticated BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN emails.unauthenticated IS 'Failed SPF, DKIM and DMARC together; flagged instead of rejected';
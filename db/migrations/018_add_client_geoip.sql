@@ -0,0 +1,9 @@
+-- Migration: Add client GeoIP columns
+-- Date: 2026-10-17
+-- Description: Stores the country and ASN of the sending client (geoip.db_path) for abuse analysis
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS client_country VARCHAR(2);
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS client_asn BIGINT;
+
+COMMENT ON COLUMN emails.client_country IS 'GeoIP country (ISO 3166-1 alpha-2) of the sending client, NULL if unknown';
+COMMENT ON COLUMN emails.client_asn IS 'GeoIP autonomous system number of the sending client, NULL if unknown';
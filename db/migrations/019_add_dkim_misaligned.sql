@@ -0,0 +1,7 @@
+-- Migration: Add DKIM misalignment flag
+-- Date: 2026-10-17
+-- Description: Flags mail DKIM-signed by a domain other than the sender's when the sender publishes no DMARC
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS dkim_misaligned BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN emails.dkim_misaligned IS 'Valid DKIM signature from another domain while the sender has no DMARC; possible third-party signing abuse';
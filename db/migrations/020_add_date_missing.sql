@@ -0,0 +1,7 @@
+-- Migration: Add missing Date flag
+-- Date: 2026-10-17
+-- Description: Flags messages that arrived without a usable Date header
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS date_missing BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN emails.date_missing IS 'No usable Date header (missing or unparseable); malformed message signal';
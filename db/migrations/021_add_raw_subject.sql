@@ -0,0 +1,7 @@
+-- Migration: Add raw subject
+-- Date: 2026-10-17
+-- Description: Keeps the original subject when tempmail.normalize_subjects collapsed its whitespace
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS raw_subject TEXT;
+
+COMMENT ON COLUMN emails.raw_subject IS 'Subject before whitespace normalization; NULL when normalization is off or changed nothing';
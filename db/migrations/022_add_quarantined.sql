@@ -0,0 +1,7 @@
+-- Migration: Add DMARC quarantine flag
+-- Date: 2026-10-17
+-- Description: Flags mail that failed DMARC for a sender publishing p=quarantine (validation.enforce_dmarc)
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS quarantined BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN emails.quarantined IS 'Failed DMARC and the sender publishes p=quarantine; set only with validation.enforce_dmarc';
@@ -0,0 +1,7 @@
+-- Migration: Add DKIM signature details
+-- Date: 2026-10-17
+-- Description: Stores the per-signature DKIM results (domain, selector, algorithm, error) for abuse investigations
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS dkim_details JSONB;
+
+COMMENT ON COLUMN emails.dkim_details IS 'Per-signature DKIM results: domain, selector, algorithm, valid, error; NULL if DKIM was not checked locally';
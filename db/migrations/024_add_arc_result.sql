@@ -0,0 +1,7 @@
+-- Migration: Add ARC result
+-- Date: 2026-10-17
+-- Description: Stores the ARC chain validation status (validation.check_arc)
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS arc_result VARCHAR(10);
+
+COMMENT ON COLUMN emails.arc_result IS 'ARC chain validation status: none, pass, fail; NULL if ARC was not checked';
@@ -0,0 +1,19 @@
+-- Migration: Add greylist table
+-- Date: 2026-10-17
+-- Description: Records (client IP, MAIL FROM, RCPT TO) triples for greylisting (greylist.enabled)
+
+CREATE TABLE IF NOT EXISTS greylist (
+    client_ip VARCHAR(45) NOT NULL,
+    sender TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    first_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (client_ip, sender, recipient)
+);
+
+CREATE INDEX IF NOT EXISTS idx_greylist_last_seen ON greylist(last_seen);
+
+COMMENT ON TABLE greylist IS 'Greylisting triples; a retry is accepted once first_seen is older than greylist.delay_minutes';
+COMMENT ON COLUMN greylist.sender IS 'Lowercased MAIL FROM, empty for the null sender';
+COMMENT ON COLUMN greylist.last_seen IS 'Last attempt; triples idle longer than greylist.expiry_hours are purged';
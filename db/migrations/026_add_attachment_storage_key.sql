@@ -0,0 +1,13 @@
+-- Migration: Add attachment storage key
+-- Date: 2026-10-17
+-- Description: Lets attachment bytes live outside the database (storage.backend: filesystem or s3)
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS storage_key TEXT;
+ALTER TABLE attachments ALTER COLUMN data DROP NOT NULL;
+
+ALTER TABLE attachments DROP CONSTRAINT IF EXISTS attachments_content_check;
+ALTER TABLE attachments ADD CONSTRAINT attachments_content_check
+    CHECK (data IS NOT NULL OR storage_key IS NOT NULL);
+
+COMMENT ON COLUMN attachments.data IS 'File content stored in database; NULL when kept in the attachment store';
+COMMENT ON COLUMN attachments.storage_key IS 'Key in the attachment store (filesystem or S3); NULL when data holds the content';
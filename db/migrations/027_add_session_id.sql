@@ -0,0 +1,9 @@
+-- Migration: Add SMTP session ID
+-- Date: 2026-10-17
+-- Description: Stores the MX session ID so a stored email can be matched to its session_id log lines
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS session_id VARCHAR(32);
+
+CREATE INDEX IF NOT EXISTS idx_emails_session_id ON emails(session_id);
+
+COMMENT ON COLUMN emails.session_id IS 'ID of the SMTP session that delivered the message, as logged by the MX';
@@ -0,0 +1,7 @@
+-- Migration: Add bounce flag
+-- Date: 2026-10-17
+-- Description: Flags messages received with the null sender (MAIL FROM:<>), i.e. bounces and other DSNs
+
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS is_bounce BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN emails.is_bounce IS 'Received with the null sender MAIL FROM:<>; a bounce or other delivery status notification';
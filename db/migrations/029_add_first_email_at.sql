@@ -0,0 +1,18 @@
+-- Migration: Add first delivery timestamp
+-- Date: 2026-10-17
+-- Description: Records when an address received its first email, so address.first_email fires once even after retention or cleanup empties the address
+
+ALTER TABLE addresses ADD COLUMN IF NOT EXISTS first_email_at TIMESTAMP;
+
+-- Addresses that already hold mail have had their first delivery
+UPDATE addresses a
+SET first_email_at = (
+    SELECT MIN(e.received_at)
+    FROM emails e
+    JOIN email_recipients er ON er.email_id = e.id
+    WHERE er.address_id = a.id
+)
+WHERE a.first_email_at IS NULL
+  AND EXISTS (SELECT 1 FROM email_recipients er WHERE er.address_id = a.id);
+
+COMMENT ON COLUMN addresses.first_email_at IS 'When the first email was stored; set once, NULL until then';
@@ -0,0 +1,5 @@
+-- Migration: Index attachment storage keys
+-- Date: 2026-10-17
+-- Description: Lets the attachment store sweep check which keys are still referenced without scanning the table
+
+CREATE INDEX IF NOT EXISTS idx_attachments_storage_key ON attachments(storage_key) WHERE storage_key IS NOT NULL;
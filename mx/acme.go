@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME defaults (tls.acme.cache_dir, tls.acme.challenge_addr)
+const (
+	defaultACMECacheDir      = "/config/certs/acme"
+	defaultACMEChallengeAddr = ":443"
+)
+
+// acmeHandshakeTimeout bounds a TLS-ALPN challenge handshake from the CA
+const acmeHandshakeTimeout = 10 * time.Second
+
+// newACMEManager builds the autocert manager for tls.acme; certificates are
+// obtained on first use, renewed before expiry and kept in cache_dir
+func newACMEManager(cfg *Config) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.TLS.ACME.CacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.TLS.ACME.Domains...),
+		Email:      cfg.TLS.ACME.Email,
+	}
+	if cfg.TLS.ACME.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.TLS.ACME.DirectoryURL}
+	}
+	return m
+}
+
+// acmeGetCertificate serves certificates from m for STARTTLS
+// Many sending MTAs omit SNI, so those handshakes get the first ACME domain
+func acmeGetCertificate(m *autocert.Manager, defaultName string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == "" {
+			h := *hello
+			h.ServerName = defaultName
+			hello = &h
+		}
+		return m.GetCertificate(hello)
+	}
+}
+
+// listenACMEChallenges opens the TLS-ALPN-01 challenge listener; the CA
+// validates on port 443, so addr must be reachable there
+func listenACMEChallenges(addr string, m *autocert.Manager) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{acme.ALPNProto},
+		MinVersion:     tls.VersionTLS12,
+	}
+	go serveACMEChallenges(tls.NewListener(ln, tlsConfig))
+	return ln, nil
+}
+
+// serveACMEChallenges answers challenge handshakes until ln is closed
+// The handshake itself is the response, so each connection is closed after it
+func serveACMEChallenges(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("ACME challenge listener stopped", "error", err)
+			}
+			return
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(acmeHandshakeTimeout))
+			if err := c.(*tls.Conn).Handshake(); err != nil {
+				slog.Debug("ACME challenge handshake failed", "peer_addr", c.RemoteAddr().String(), "error", err)
+			}
+		}(c)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestNewSMTPServerACME(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.test"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.TLS.Enabled = true
+	cfg.TLS.CertFile = "/nonexistent/cert.pem"
+	cfg.TLS.KeyFile = "/nonexistent/key.pem"
+	cfg.TLS.ACME.Enabled = true
+	cfg.TLS.ACME.Domains = []string{"mx.tempmail.test"}
+	cfg.TLS.ACME.CacheDir = t.TempDir()
+
+	// Static files are not read in ACME mode
+	server, err := NewSMTPServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewSMTPServer() error = %v", err)
+	}
+	defer server.Close()
+	if server.acme == nil || server.certs != nil || server.server.TLSConfig.GetCertificate == nil {
+		t.Error("NewSMTPServer() should serve certificates from the ACME manager")
+	}
+
+	// And still required without it
+	cfg.TLS.ACME.Enabled = false
+	if _, err := NewSMTPServer(cfg, nil); err == nil {
+		t.Error("NewSMTPServer() without ACME succeeded with missing cert files")
+	}
+}
+
+func TestACMEGetCertificateServerName(t *testing.T) {
+	errPolicy := errors.New("host policy")
+	tests := []struct {
+		name       string
+		serverName string
+		want       string
+	}{
+		{"SNI sent", "mx2.tempmail.test", "mx2.tempmail.test"},
+		{"no SNI", "", "mx.tempmail.test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var asked string
+			m := &autocert.Manager{
+				Prompt: autocert.AcceptTOS,
+				HostPolicy: func(_ context.Context, host string) error {
+					asked = host
+					return errPolicy
+				},
+			}
+			getCert := acmeGetCertificate(m, "mx.tempmail.test")
+			if _, err := getCert(&tls.ClientHelloInfo{ServerName: tt.serverName}); !errors.Is(err, errPolicy) {
+				t.Fatalf("GetCertificate() error = %v, want host policy error", err)
+			}
+			if asked != tt.want {
+				t.Errorf("certificate requested for %q, want %q", asked, tt.want)
+			}
+		})
+	}
+}
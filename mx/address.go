@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// MAIL FROM syntax modes (validation.mail_from_syntax)
+const (
+	MailFromSyntaxOff    = "off"
+	MailFromSyntaxBasic  = "basic"
+	MailFromSyntaxStrict = "strict"
+)
+
+// RFC 5321 section 4.5.3.1 size limits
+const (
+	maxLocalPartLen = 64
+	maxDomainLen    = 255
+	maxPathLen      = 256
+)
+
+// validateMailFrom checks the MAIL FROM reverse-path for the given mode
+// The empty null sender (<>) used by bounces is always accepted
+func validateMailFrom(from, mode string, utf8 bool) error {
+	if from == "" {
+		return nil
+	}
+
+	switch mode {
+	case MailFromSyntaxOff:
+		return nil
+	case MailFromSyntaxStrict:
+		return parseMailboxStrict(from, utf8)
+	default:
+		addr, err := mail.ParseAddress(from)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(addr.Address, "@") {
+			return fmt.Errorf("missing domain")
+		}
+		return nil
+	}
+}
+
+// normalizeMailFrom returns the reverse-path as kept for SPF, DMARC and the
+// Return-Path: a bare lowercase mailbox, or "" for the null sender. A sender
+// the parser cannot read (only accepted with mail_from_syntax off) is just
+// lowercased
+func normalizeMailFrom(from string) string {
+	from = strings.TrimSpace(from)
+	if from == "" {
+		return ""
+	}
+	if addr, err := mail.ParseAddress(from); err == nil {
+		// String keeps the quotes a local-part like "john doe" needs
+		from = strings.TrimSuffix(strings.TrimPrefix(addr.String(), "<"), ">")
+	}
+	return strings.ToLower(from)
+}
+
+// isPostmaster reports whether addr is a domain's postmaster, which RFC 5321
+// section 4.5.1 requires to accept mail whether or not it was ever created
+func isPostmaster(addr string) bool {
+	at := strings.LastIndex(addr, "@")
+	return at > 0 && strings.EqualFold(addr[:at], "postmaster")
+}
+
+// parseMailboxStrict validates a bare RFC 5321 mailbox (no display name or comments)
+func parseMailboxStrict(addr string, utf8 bool) error {
+	if len(addr)+2 > maxPathLen {
+		return fmt.Errorf("path too long")
+	}
+
+	at := strings.LastIndex(addr, "@")
+	if at <= 0 || at == len(addr)-1 {
+		return fmt.Errorf("mailbox must be local-part@domain")
+	}
+	local, domain := addr[:at], addr[at+1:]
+
+	if len(local) > maxLocalPartLen {
+		return fmt.Errorf("local-part too long")
+	}
+	if strings.HasPrefix(local, `"`) {
+		if err := checkQuotedString(local, utf8); err != nil {
+			return err
+		}
+	} else if err := checkDotString(local, utf8); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(domain, "[") {
+		return checkAddressLiteral(domain)
+	}
+	return checkDomain(domain, utf8)
+}
+
+// isAtext reports whether r is allowed in an unquoted local-part atom
+func isAtext(r rune, utf8 bool) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r):
+		return true
+	case r >= 0x80:
+		return utf8
+	}
+	return false
+}
+
+// checkDotString validates a dot-string local-part: atoms separated by single dots
+func checkDotString(local string, utf8 bool) error {
+	for _, atom := range strings.Split(local, ".") {
+		if atom == "" {
+			return fmt.Errorf("empty atom in local-part")
+		}
+		for _, r := range atom {
+			if !isAtext(r, utf8) {
+				return fmt.Errorf("invalid character %q in local-part", r)
+			}
+		}
+	}
+	return nil
+}
+
+// checkQuotedString validates a quoted-string local-part
+func checkQuotedString(local string, utf8 bool) error {
+	if len(local) < 2 || !strings.HasSuffix(local, `"`) {
+		return fmt.Errorf("unterminated quoted local-part")
+	}
+
+	escaped := false
+	for _, r := range local[1 : len(local)-1] {
+		switch {
+		case escaped:
+			if r < 32 || r > 126 {
+				return fmt.Errorf("invalid quoted-pair in local-part")
+			}
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			return fmt.Errorf("unescaped quote in local-part")
+		case r >= 32 && r <= 126:
+		case r >= 0x80 && utf8:
+		default:
+			return fmt.Errorf("invalid character %q in quoted local-part", r)
+		}
+	}
+	if escaped {
+		return fmt.Errorf("unterminated quoted-pair in local-part")
+	}
+	return nil
+}
+
+// checkDomain validates a dotted hostname with LDH labels
+func checkDomain(domain string, utf8 bool) error {
+	if len(domain) > maxDomainLen {
+		return fmt.Errorf("domain too long")
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid domain label")
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("domain label must not start or end with a hyphen")
+		}
+		for _, r := range label {
+			ok := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || (r >= 0x80 && utf8)
+			if !ok {
+				return fmt.Errorf("invalid character %q in domain", r)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAddressLiteral validates [IPv4] and [IPv6:...] domain literals
+func checkAddressLiteral(literal string) error {
+	if !strings.HasSuffix(literal, "]") {
+		return fmt.Errorf("unterminated address literal")
+	}
+	inner := literal[1 : len(literal)-1]
+
+	if v6, ok := strings.CutPrefix(inner, "IPv6:"); ok {
+		if ip := net.ParseIP(v6); ip != nil && ip.To4() == nil {
+			return nil
+		}
+		return fmt.Errorf("invalid IPv6 address literal")
+	}
+	if ip := net.ParseIP(inner); ip != nil && ip.To4() != nil && !strings.Contains(inner, ":") {
+		return nil
+	}
+	return fmt.Errorf("invalid address literal")
+}
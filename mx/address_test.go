@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestValidateMailFrom(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		mode    string
+		utf8    bool
+		wantErr bool
+	}{
+		{"null sender basic", "", MailFromSyntaxBasic, false, false},
+		{"null sender strict", "", MailFromSyntaxStrict, false, false},
+		{"simple basic", "sender@example.com", MailFromSyntaxBasic, false, false},
+		{"simple strict", "sender@example.com", MailFromSyntaxStrict, false, false},
+		{"plus tag strict", "user+tag@mail.example.co.uk", MailFromSyntaxStrict, false, false},
+		{"quoted local strict", `"john doe"@example.com`, MailFromSyntaxStrict, false, false},
+		{"ipv4 literal strict", "user@[192.0.2.1]", MailFromSyntaxStrict, false, false},
+		{"ipv6 literal strict", "user@[IPv6:2001:db8::1]", MailFromSyntaxStrict, false, false},
+		{"utf8 with SMTPUTF8", "josé@example.com", MailFromSyntaxStrict, true, false},
+		{"utf8 without SMTPUTF8", "josé@example.com", MailFromSyntaxStrict, false, true},
+		{"no at basic", "sender", MailFromSyntaxBasic, false, true},
+		{"no at strict", "sender", MailFromSyntaxStrict, false, true},
+		{"garbage basic", "not an address", MailFromSyntaxBasic, false, true},
+		{"garbage off", "not an address", MailFromSyntaxOff, false, false},
+		{"double dot strict", "john..doe@example.com", MailFromSyntaxStrict, false, true},
+		{"leading dot strict", ".john@example.com", MailFromSyntaxStrict, false, true},
+		{"display name strict", "John <john@example.com>", MailFromSyntaxStrict, false, true},
+		{"empty domain strict", "john@", MailFromSyntaxStrict, false, true},
+		{"hyphen label strict", "john@-example.com", MailFromSyntaxStrict, false, true},
+		{"bad literal strict", "john@[999.1.1.1]", MailFromSyntaxStrict, false, true},
+		{"long local strict", strings.Repeat("a", 65) + "@example.com", MailFromSyntaxStrict, false, true},
+		{"long path strict", "a@" + strings.Repeat("b", 250) + ".com", MailFromSyntaxStrict, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMailFrom(tt.from, tt.mode, tt.utf8)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMailFrom(%q, %s) error = %v, wantErr %v", tt.from, tt.mode, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSessionMailRejectsMalformed(t *testing.T) {
+	cfg := &Config{}
+	cfg.Validation.MailFromSyntax = MailFromSyntaxStrict
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, nil, nil, nil)
+
+	err := s.Mail("john..doe@example.com", nil)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 501 {
+		t.Fatalf("Mail() error = %v, want 501", err)
+	}
+	if s.smtpEnvelope != nil {
+		t.Error("rejected MAIL FROM should not start a transaction")
+	}
+
+	// Bounces use the null sender
+	if err := s.Mail("", nil); err != nil {
+		t.Errorf("Mail() with null sender error = %v", err)
+	}
+}
+
+func TestNormalizeMailFrom(t *testing.T) {
+	tests := []struct {
+		from string
+		want string
+	}{
+		{"", ""},
+		{"Sender@Example.COM", "sender@example.com"},
+		{" sender@example.com ", "sender@example.com"},
+		{`"John Doe"@Example.com`, `"john doe"@example.com`},
+		{"user@[192.0.2.1]", "user@[192.0.2.1]"},
+		{"José@Example.com", "josé@example.com"},
+		{"Not An Address", "not an address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			if got := normalizeMailFrom(tt.from); got != tt.want {
+				t.Errorf("normalizeMailFrom(%q) = %q, want %q", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionMailNormalizesSender(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		wantFrom string
+		wantCode int // 0 = accepted
+	}{
+		{"null sender", "", "", 0},
+		{"mixed case", "Sender@Example.COM", "sender@example.com", 0},
+		{"missing domain", "sender", "", 501},
+		{"garbage", "not an address", "", 501},
+		{"missing local part", "@example.com", "", 501},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Validation.MailFromSyntax = MailFromSyntaxBasic
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, nil, nil, nil)
+
+			err := s.Mail(tt.from, nil)
+			if tt.wantCode != 0 {
+				var smtpErr *smtp.SMTPError
+				if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+					t.Fatalf("Mail(%q) error = %v, want %d", tt.from, err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Mail(%q) error = %v", tt.from, err)
+			}
+			if s.from != tt.wantFrom {
+				t.Errorf("session from = %q, want %q", s.from, tt.wantFrom)
+			}
+			// SPF and DMARC take the sender domain from s.from
+			if got, want := extractDomain(s.from), addressDomain(tt.wantFrom); got != want {
+				t.Errorf("extractDomain(%q) = %q, want %q", s.from, got, want)
+			}
+		})
+	}
+}
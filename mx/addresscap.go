@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// addressMetricsInterval is how often the per-domain address counts are refreshed
+const addressMetricsInterval = time.Minute
+
+// AddressCounter counts active (unexpired) addresses; implemented by *DB
+type AddressCounter interface {
+	CountAddresses(domain string) (int64, error)
+	CountAddressesByDomain() (map[string]int64, error)
+}
+
+// checkAddressCap refuses a new address once domain has reached
+// domains_config.<domain>.max_addresses (0 or unset = unlimited)
+func checkAddressCap(cfg *Config, counter AddressCounter, domain string) error {
+	domain = strings.ToLower(domain)
+	max := cfg.DomainsConfig[domain].MaxAddresses
+	if max <= 0 {
+		return nil
+	}
+
+	count, err := counter.CountAddresses(domain)
+	if err != nil {
+		return err
+	}
+	if count >= int64(max) {
+		return fmt.Errorf("%w: %s has %d of %d addresses", ErrDomainAddressLimit, domain, count, max)
+	}
+	return nil
+}
+
+// hasAddressCaps reports whether any domain sets max_addresses
+func (c *Config) hasAddressCaps() bool {
+	for _, domainCfg := range c.DomainsConfig {
+		if domainCfg.MaxAddresses > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshAddressMetrics publishes the current address count of each domain
+func refreshAddressMetrics(counter AddressCounter) error {
+	counts, err := counter.CountAddressesByDomain()
+	if err != nil {
+		return err
+	}
+	// Domains whose last address expired drop out of the query; report them as 0
+	metricAddressesByDomain.Do(func(kv expvar.KeyValue) {
+		if _, ok := counts[kv.Key]; !ok {
+			kv.Value.(*expvar.Int).Set(0)
+		}
+	})
+	for domain, count := range counts {
+		v := new(expvar.Int)
+		v.Set(count)
+		metricAddressesByDomain.Set(domain, v)
+	}
+	return nil
+}
+
+// startAddressMetrics refreshes the address counts now and then periodically until ctx is done
+func startAddressMetrics(ctx context.Context, counter AddressCounter) {
+	if err := refreshAddressMetrics(counter); err != nil {
+		slog.Warn("Failed to count addresses", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(addressMetricsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := refreshAddressMetrics(counter); err != nil {
+					slog.Warn("Failed to count addresses", "error", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+)
+
+// fakeAddressCounter serves address counts from a map
+type fakeAddressCounter map[string]int64
+
+func (f fakeAddressCounter) CountAddresses(domain string) (int64, error) {
+	return f[domain], nil
+}
+
+func (f fakeAddressCounter) CountAddressesByDomain() (map[string]int64, error) {
+	return f, nil
+}
+
+func TestCheckAddressCap(t *testing.T) {
+	cfg := &Config{Domains: []string{"capped.example", "open.example"}}
+	cfg.DomainsConfig = map[string]DomainConfig{"capped.example": {MaxAddresses: 3}}
+
+	tests := []struct {
+		name    string
+		domain  string
+		count   int64
+		wantErr bool
+	}{
+		{"first address", "capped.example", 0, false},
+		{"last address under the cap", "capped.example", 2, false},
+		{"cap reached", "capped.example", 3, true},
+		{"beyond the cap", "capped.example", 10, true},
+		{"domain case ignored", "CAPPED.example", 3, true},
+		{"uncapped domain", "open.example", 1000000, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := fakeAddressCounter{"capped.example": tt.count, "open.example": tt.count}
+			err := checkAddressCap(cfg, counter, tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkAddressCap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrDomainAddressLimit) {
+				t.Errorf("checkAddressCap() error = %v, want ErrDomainAddressLimit", err)
+			}
+		})
+	}
+}
+
+func TestRefreshAddressMetrics(t *testing.T) {
+	if err := refreshAddressMetrics(fakeAddressCounter{"a.example": 2, "b.example": 5}); err != nil {
+		t.Fatalf("refreshAddressMetrics() error = %v", err)
+	}
+	// b.example's last address expired
+	if err := refreshAddressMetrics(fakeAddressCounter{"a.example": 3}); err != nil {
+		t.Fatalf("refreshAddressMetrics() error = %v", err)
+	}
+
+	for domain, want := range map[string]int64{"a.example": 3, "b.example": 0} {
+		got, ok := metricAddressesByDomain.Get(domain).(*expvar.Int)
+		if !ok || got.Value() != want {
+			t.Errorf("addresses_by_domain[%s] = %v, want %d", domain, metricAddressesByDomain.Get(domain), want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-msgauth/authres"
+)
+
+// ARC chain validation statuses (RFC 8617 section 4.4, the cv= values)
+const (
+	ARCNone = "none"
+	ARCPass = "pass"
+	ARCFail = "fail"
+)
+
+// arcMaxInstances is the highest ARC instance number allowed (RFC 8617 section 4.2.1)
+const arcMaxInstances = 50
+
+// headerField is one header field exactly as received, continuation lines included
+type headerField struct {
+	name string // as written, e.g. "ARC-Seal"
+	raw  string // "Name: value\r\n"
+}
+
+// value returns the field body after the colon
+func (f *headerField) value() string {
+	_, value, _ := strings.Cut(f.raw, ":")
+	return value
+}
+
+// arcSet is one ARC instance: the seal, message signature and authentication
+// results an intermediary added
+type arcSet struct {
+	seal             *headerField
+	messageSignature *headerField
+	authResults      *headerField
+}
+
+// arcAuthResults are the SPF and DKIM results an ARC sealer recorded when it
+// received the message, which DMARC may use when the chain passes
+type arcAuthResults struct {
+	Sealer      string   // d= of the ARC-Seal that recorded them
+	SPFResult   string   // pass, fail, ... for smtp.mailfrom
+	SPFDomain   string   // smtp.mailfrom domain
+	DKIMDomains []string // header.d of passing DKIM signatures
+}
+
+// validateARC verifies the message's ARC chain (RFC 8617 section 5.2): the
+// structure of the sets, the latest ARC-Message-Signature and every ARC-Seal
+// Returns the chain status and, when it passes, the latest sealer's recorded results
+func (v *Validator) validateARC(rawMessage []byte) (string, *arcAuthResults) {
+	fields, body := splitHeaderFields(rawMessage)
+	sets, err := collectARCSets(fields)
+	if err != nil {
+		v.logger().Info("ARC: fail", "error", err)
+		return ARCFail, nil
+	}
+	if len(sets) == 0 {
+		return ARCNone, nil
+	}
+
+	latest := sets[len(sets)-1]
+	if cv := parseTagList(latest.seal.value())["cv"]; cv == ARCFail {
+		v.logger().Info("ARC: fail - chain already marked failed", "arc_sets", len(sets))
+		return ARCFail, nil
+	}
+	for i, set := range sets {
+		want := ARCPass
+		if i == 0 {
+			want = ARCNone
+		}
+		if cv := parseTagList(set.seal.value())["cv"]; cv != want {
+			v.logger().Info("ARC: fail - unexpected cv", "arc_instance", i+1, "cv", cv, "want", want)
+			return ARCFail, nil
+		}
+	}
+
+	if err := v.verifyMessageSignature(fields, body, latest.messageSignature); err != nil {
+		v.logger().Info("ARC: fail - ARC-Message-Signature", "arc_instance", len(sets), "error", err)
+		return ARCFail, nil
+	}
+	for i := len(sets); i >= 1; i-- {
+		if err := v.verifyARCSeal(sets[:i]); err != nil {
+			v.logger().Info("ARC: fail - ARC-Seal", "arc_instance", i, "error", err)
+			return ARCFail, nil
+		}
+	}
+
+	preserved := parseARCAuthResults(latest)
+	v.logger().Info("ARC: pass", "arc_sets", len(sets), "arc_sealer", preserved.Sealer)
+	return ARCPass, preserved
+}
+
+// collectARCSets groups the ARC header fields by instance, checking that
+// instances 1..N each have exactly one field of every kind
+func collectARCSets(fields []headerField) ([]arcSet, error) {
+	byInstance := make(map[int]*arcSet)
+	highest := 0
+	for i := range fields {
+		field := &fields[i]
+		name := strings.ToLower(field.name)
+		if name != "arc-seal" && name != "arc-message-signature" && name != "arc-authentication-results" {
+			continue
+		}
+
+		instance, err := strconv.Atoi(parseTagList(field.value())["i"])
+		if err != nil || instance < 1 || instance > arcMaxInstances {
+			return nil, fmt.Errorf("%s has an invalid instance", field.name)
+		}
+		set := byInstance[instance]
+		if set == nil {
+			set = &arcSet{}
+			byInstance[instance] = set
+		}
+
+		var slot **headerField
+		switch name {
+		case "arc-seal":
+			slot = &set.seal
+		case "arc-message-signature":
+			slot = &set.messageSignature
+		default:
+			slot = &set.authResults
+		}
+		if *slot != nil {
+			return nil, fmt.Errorf("duplicate %s for i=%d", field.name, instance)
+		}
+		*slot = field
+		highest = max(highest, instance)
+	}
+
+	sets := make([]arcSet, 0, highest)
+	for i := 1; i <= highest; i++ {
+		set := byInstance[i]
+		if set == nil || set.seal == nil || set.messageSignature == nil || set.authResults == nil {
+			return nil, fmt.Errorf("incomplete ARC set i=%d", i)
+		}
+		sets = append(sets, *set)
+	}
+	return sets, nil
+}
+
+// verifyMessageSignature checks a DKIM-style signature (an ARC-Message-Signature
+// or DKIM-Signature field) over the message; only rsa-sha256 is accepted, as ARC requires
+func (v *Validator) verifyMessageSignature(fields []headerField, body []byte, sig *headerField) error {
+	tags := parseTagList(sig.value())
+	if tags["a"] != "rsa-sha256" {
+		return fmt.Errorf("unsupported algorithm %q", tags["a"])
+	}
+
+	headerCanon, bodyCanon, _ := strings.Cut(tags["c"], "/")
+	relaxedHeader, relaxedBody := headerCanon == "relaxed", bodyCanon == "relaxed"
+
+	canonicalBody := canonicalizeBody(body, relaxedBody)
+	if l := tags["l"]; l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 || n > len(canonicalBody) {
+			return fmt.Errorf("invalid body length l=%s", l)
+		}
+		canonicalBody = canonicalBody[:n]
+	}
+	bodyHash := sha256.Sum256(canonicalBody)
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("body hash mismatch")
+	}
+
+	h := sha256.New()
+	for _, field := range selectSignedHeaders(fields, strings.Split(tags["h"], ":")) {
+		h.Write([]byte(canonicalizeHeader(field.raw, relaxedHeader)))
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalizeHeader(stripSignatureValue(sig.raw), relaxedHeader), "\r\n")))
+
+	return v.verifyARCSignature(tags, h)
+}
+
+// verifyARCSeal checks the ARC-Seal of the last set in sets, which signs every
+// ARC field up to its instance in relaxed canonicalization
+func (v *Validator) verifyARCSeal(sets []arcSet) error {
+	seal := sets[len(sets)-1].seal
+	tags := parseTagList(seal.value())
+	if tags["a"] != "rsa-sha256" {
+		return fmt.Errorf("unsupported algorithm %q", tags["a"])
+	}
+
+	h := sha256.New()
+	for _, set := range sets {
+		h.Write([]byte(canonicalizeHeader(set.authResults.raw, true)))
+		h.Write([]byte(canonicalizeHeader(set.messageSignature.raw, true)))
+		if set.seal != seal {
+			h.Write([]byte(canonicalizeHeader(set.seal.raw, true)))
+		}
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalizeHeader(stripSignatureValue(seal.raw), true), "\r\n")))
+
+	return v.verifyARCSignature(tags, h)
+}
+
+// verifyARCSignature checks the b= signature of tags against the hashed data
+// using the key published at s=._domainkey.d=
+func (v *Validator) verifyARCSignature(tags map[string]string, h hash.Hash) error {
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil || len(signature) == 0 {
+		return errors.New("invalid signature encoding")
+	}
+	if tags["d"] == "" || tags["s"] == "" {
+		return errors.New("missing d= or s=")
+	}
+
+	name := tags["s"] + "._domainkey." + tags["d"]
+	records, err := v.lookupDKIMKey(name)
+	if err != nil || len(records) == 0 {
+		return fmt.Errorf("key %s not found", name)
+	}
+	key, err := parseDKIMPublicKey(records[0])
+	if err != nil {
+		return fmt.Errorf("key %s: %w", name, err)
+	}
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h.Sum(nil), signature); err != nil {
+		return errors.New("signature did not verify")
+	}
+	return nil
+}
+
+// parseARCAuthResults extracts the SPF and DKIM results from a set's
+// ARC-Authentication-Results (an Authentication-Results value after "i=N;")
+func parseARCAuthResults(set arcSet) *arcAuthResults {
+	preserved := &arcAuthResults{Sealer: strings.ToLower(parseTagList(set.seal.value())["d"])}
+
+	_, value, _ := strings.Cut(set.authResults.value(), ";")
+	_, results, err := authres.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return preserved
+	}
+	for _, result := range results {
+		switch r := result.(type) {
+		case *authres.SPFResult:
+			preserved.SPFResult = string(r.Value)
+			preserved.SPFDomain = strings.ToLower(r.From)
+			if domain := extractDomain(r.From); domain != "" {
+				preserved.SPFDomain = domain
+			}
+		case *authres.DKIMResult:
+			if r.Value == authres.ResultPass && r.Domain != "" {
+				preserved.DKIMDomains = append(preserved.DKIMDomains, strings.ToLower(r.Domain))
+			}
+		}
+	}
+	return preserved
+}
+
+// trustedARCResults returns the preserved results when the chain passed and the
+// latest sealer is listed in validation.arc_trusted_sealers, nil otherwise
+func (v *Validator) trustedARCResults(status string, preserved *arcAuthResults) *arcAuthResults {
+	if status != ARCPass || preserved == nil {
+		return nil
+	}
+	for _, sealer := range v.cfg.Validation.ARCTrustedSealers {
+		if strings.EqualFold(strings.TrimSuffix(sealer, "."), preserved.Sealer) {
+			return preserved
+		}
+	}
+	return nil
+}
+
+// splitHeaderFields splits a message into its header fields and body
+// Bare LF line endings are treated as CRLF, as they are for DKIM
+func splitHeaderFields(rawMessage []byte) ([]headerField, []byte) {
+	var fields []headerField
+	rest := rawMessage
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := string(bytes.TrimRight(rest[:end], "\r\n"))
+		rest = rest[end:]
+		if line == "" {
+			break // the blank line ends the header
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line + "\r\n"
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line + "\r\n"})
+	}
+	return fields, bytes.ReplaceAll(bytes.ReplaceAll(rest, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// selectSignedHeaders picks the fields named in an h= list: each name takes the
+// bottom-most instance not already used, and names with no instance left are skipped
+func selectSignedHeaders(fields []headerField, names []string) []*headerField {
+	used := make([]bool, len(fields))
+	var selected []*headerField
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				selected = append(selected, &fields[i])
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// canonicalizeHeader applies DKIM header canonicalization (RFC 6376 section 3.4)
+// Relaxed lowercases the name, unfolds the value and collapses whitespace
+func canonicalizeHeader(raw string, relaxed bool) string {
+	if !relaxed {
+		return raw
+	}
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value + "\r\n"
+}
+
+// canonicalizeBody applies DKIM body canonicalization (RFC 6376 section 3.4)
+// to a CRLF body; relaxed also collapses whitespace within lines
+func canonicalizeBody(body []byte, relaxed bool) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if relaxed {
+		for i, line := range lines {
+			var b strings.Builder
+			space := false
+			for j := 0; j < len(line); j++ {
+				if isWSP(rune(line[j])) {
+					space = true
+					continue
+				}
+				// Trailing whitespace is never written out
+				if space {
+					b.WriteByte(' ')
+					space = false
+				}
+				b.WriteByte(line[j])
+			}
+			lines[i] = b.String()
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if relaxed {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// isWSP reports whether r is whitespace in the DKIM sense (space or tab)
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// stripSignatureValue empties the b= tag of a signature field, which is how the
+// field is hashed when it signs itself
+func stripSignatureValue(raw string) string {
+	name, value, _ := strings.Cut(strings.TrimSuffix(raw, "\r\n"), ":")
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		key, _, ok := strings.Cut(part, "=")
+		if ok && strings.TrimSpace(key) == "b" {
+			parts[i] = key + "="
+		}
+	}
+	return name + ":" + strings.Join(parts, ";") + "\r\n"
+}
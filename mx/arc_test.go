@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+const arcTestMessage = "From: user@sender.example\r\n" +
+	"To: list@lists.example\r\n" +
+	"Subject: Forwarded  through\r\n" +
+	"\tthe list\r\n" +
+	"\r\n" +
+	"Hello list,   \r\n" +
+	"\tthis line has  odd spacing.\r\n" +
+	"\r\n" +
+	"\r\n"
+
+// newARCTestKey returns a signing key and its DKIM key record
+func newARCTestKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	return key, "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)
+}
+
+// arcSignTestMessage adds an ARC set sealed by lists.example (selector arc)
+func arcSignTestMessage(t *testing.T, key *rsa.PrivateKey, message []byte, instance int, cv, authResults string) []byte {
+	t.Helper()
+	sign := func(data string) string {
+		digest := sha256.Sum256([]byte(data))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	fields, body := splitHeaderFields(message)
+	bodyHash := sha256.Sum256(canonicalizeBody(body, true))
+	ams := fmt.Sprintf("ARC-Message-Signature: i=%d; a=rsa-sha256; c=relaxed/relaxed;\r\n\td=lists.example; s=arc; h=from:to:subject;\r\n\tbh=%s; b=\r\n",
+		instance, base64.StdEncoding.EncodeToString(bodyHash[:]))
+	var signed strings.Builder
+	for _, field := range selectSignedHeaders(fields, []string{"from", "to", "subject"}) {
+		signed.WriteString(canonicalizeHeader(field.raw, true))
+	}
+	signed.WriteString(strings.TrimSuffix(canonicalizeHeader(ams, true), "\r\n"))
+	ams = strings.TrimSuffix(ams, "\r\n") + sign(signed.String()) + "\r\n"
+
+	aar := fmt.Sprintf("ARC-Authentication-Results: i=%d; %s\r\n", instance, authResults)
+	seal := fmt.Sprintf("ARC-Seal: i=%d; a=rsa-sha256; cv=%s; d=lists.example; s=arc; b=\r\n", instance, cv)
+
+	sets, err := collectARCSets(fields)
+	if err != nil {
+		t.Fatalf("Existing ARC sets are invalid: %v", err)
+	}
+	signed.Reset()
+	for _, set := range sets {
+		signed.WriteString(canonicalizeHeader(set.authResults.raw, true))
+		signed.WriteString(canonicalizeHeader(set.messageSignature.raw, true))
+		signed.WriteString(canonicalizeHeader(set.seal.raw, true))
+	}
+	signed.WriteString(canonicalizeHeader(aar, true))
+	signed.WriteString(canonicalizeHeader(ams, true))
+	signed.WriteString(strings.TrimSuffix(canonicalizeHeader(seal, true), "\r\n"))
+	seal = strings.TrimSuffix(seal, "\r\n") + sign(signed.String()) + "\r\n"
+
+	return append([]byte(seal+ams+aar), message...)
+}
+
+func TestVerifyMessageSignatureMatchesDKIM(t *testing.T) {
+	key, record := newARCTestKey(t)
+	validator := NewValidator(&Config{})
+	validator.resolver = &fakeResolver{txt: map[string][]string{"sel._domainkey.sender.example": {record}}}
+
+	canonicalizations := []dkim.Canonicalization{dkim.CanonicalizationSimple, dkim.CanonicalizationRelaxed}
+	for _, headerCanon := range canonicalizations {
+		for _, bodyCanon := range canonicalizations {
+			t.Run(string(headerCanon)+"/"+string(bodyCanon), func(t *testing.T) {
+				var signed bytes.Buffer
+				options := &dkim.SignOptions{
+					Domain:                 "sender.example",
+					Selector:               "sel",
+					Signer:                 key,
+					HeaderCanonicalization: headerCanon,
+					BodyCanonicalization:   bodyCanon,
+				}
+				if err := dkim.Sign(&signed, strings.NewReader(arcTestMessage), options); err != nil {
+					t.Fatalf("Failed to sign message: %v", err)
+				}
+
+				fields, body := splitHeaderFields(signed.Bytes())
+				if err := validator.verifyMessageSignature(fields, body, &fields[0]); err != nil {
+					t.Errorf("verifyMessageSignature() error = %v", err)
+				}
+
+				tampered := bytes.Replace(signed.Bytes(), []byte("Hello list"), []byte("Hello all"), 1)
+				fields, body = splitHeaderFields(tampered)
+				if err := validator.verifyMessageSignature(fields, body, &fields[0]); err == nil {
+					t.Error("verifyMessageSignature() accepted a modified body")
+				}
+			})
+		}
+	}
+}
+
+func TestValidateARC(t *testing.T) {
+	key, record := newARCTestKey(t)
+	const authResults = "lists.example; spf=pass smtp.mailfrom=user@sender.example; dkim=pass header.d=sender.example"
+
+	oneHop := arcSignTestMessage(t, key, []byte(arcTestMessage), 1, ARCNone, authResults)
+	twoHops := arcSignTestMessage(t, key, oneHop, 2, ARCPass, "lists.example; arc=pass")
+
+	tests := []struct {
+		name    string
+		message []byte
+		want    string
+	}{
+		{"no ARC headers", []byte(arcTestMessage), ARCNone},
+		{"one set", oneHop, ARCPass},
+		{"two sets", twoHops, ARCPass},
+		{"body modified after sealing", bytes.Replace(oneHop, []byte("Hello list"), []byte("Hello all"), 1), ARCFail},
+		{"recorded results modified", bytes.Replace(oneHop, []byte("dkim=pass"), []byte("dkim=fail"), 1), ARCFail},
+		{"earlier set modified", bytes.Replace(twoHops, []byte("spf=pass"), []byte("spf=softfail"), 1), ARCFail},
+		{"first set must have cv=none", arcSignTestMessage(t, key, []byte(arcTestMessage), 1, ARCPass, authResults), ARCFail},
+		{"chain already failed", arcSignTestMessage(t, key, oneHop, 2, ARCFail, "lists.example; arc=fail"), ARCFail},
+		{"missing instance", arcSignTestMessage(t, key, []byte(arcTestMessage), 2, ARCNone, authResults), ARCFail},
+	}
+
+	validator := NewValidator(&Config{})
+	validator.resolver = &fakeResolver{txt: map[string][]string{"arc._domainkey.lists.example": {record}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, preserved := validator.validateARC(tt.message)
+			if got != tt.want {
+				t.Errorf("validateARC() = %s, want %s", got, tt.want)
+			}
+			if (preserved != nil) != (got == ARCPass) {
+				t.Errorf("validateARC() preserved = %+v with status %s", preserved, got)
+			}
+		})
+	}
+
+	_, preserved := validator.validateARC(oneHop)
+	want := &arcAuthResults{Sealer: "lists.example", SPFResult: "pass", SPFDomain: "sender.example", DKIMDomains: []string{"sender.example"}}
+	if !reflect.DeepEqual(preserved, want) {
+		t.Errorf("validateARC() preserved = %+v, want %+v", preserved, want)
+	}
+}
+
+func TestValidateEmailDMARCViaARC(t *testing.T) {
+	key, record := newARCTestKey(t)
+	// The list rewrote nothing but relays from its own IP, so SPF for sender.example fails
+	message := arcSignTestMessage(t, key, []byte(arcTestMessage), 1, ARCNone,
+		"lists.example; spf=pass smtp.mailfrom=user@sender.example; dkim=pass header.d=sender.example")
+
+	tests := []struct {
+		name      string
+		checkARC  bool
+		trusted   []string
+		wantARC   string
+		wantDMARC string
+	}{
+		{"ARC not checked", false, nil, "", "fail"},
+		{"untrusted sealer", true, nil, ARCPass, "fail"},
+		{"trusted sealer", true, []string{"Lists.Example."}, ARCPass, "pass"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Validation.CheckSPF = true
+			cfg.Validation.CheckDMARC = true
+			cfg.Validation.CheckARC = tt.checkARC
+			cfg.Validation.ARCTrustedSealers = tt.trusted
+
+			validator := NewValidator(cfg)
+			validator.resolver = &fakeResolver{txt: map[string][]string{
+				"arc._domainkey.lists.example": {record},
+				"sender.example":               {"v=spf1 ip4:192.0.2.1 -all"},
+				"_dmarc.sender.example":        {"v=DMARC1; p=reject"},
+			}}
+
+			result := validator.ValidateEmail(message, "user@sender.example", "198.51.100.7", "lists.example")
+			if result.ARCResult != tt.wantARC {
+				t.Errorf("ARCResult = %q, want %q", result.ARCResult, tt.wantARC)
+			}
+			if result.DMARCResult != tt.wantDMARC {
+				t.Errorf("DMARCResult = %q, want %q", result.DMARCResult, tt.wantDMARC)
+			}
+		})
+	}
+}
+
+func TestStripSignatureValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"ARC-Seal: i=1; a=rsa-sha256; b=abc\r\n def==\r\n", "ARC-Seal: i=1; a=rsa-sha256; b=\r\n"},
+		{"DKIM-Signature: b=abc; bh=xyz/b==; d=example.com\r\n", "DKIM-Signature: b=; bh=xyz/b==; d=example.com\r\n"},
+		{"DKIM-Signature: a=rsa-sha256;\r\n\tb = abc;\r\n\th=from\r\n", "DKIM-Signature: a=rsa-sha256;\r\n\tb =;\r\n\th=from\r\n"},
+	}
+	for _, tt := range tests {
+		if got := stripSignatureValue(tt.raw); got != tt.want {
+			t.Errorf("stripSignatureValue(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
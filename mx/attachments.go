@@ -0,0 +1,139 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/emersion/go-smtp"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Attachment policy actions
+const (
+	AttachmentActionAllow  = "allow"
+	AttachmentActionFlag   = "flag"
+	AttachmentActionReject = "reject"
+)
+
+// dangerousExtensions are executable or script types commonly hidden behind a
+// decoy extension (e.g. invoice.pdf.exe)
+var dangerousExtensions = map[string]bool{
+	"exe": true, "scr": true, "com": true, "pif": true, "bat": true,
+	"cmd": true, "vbs": true, "vbe": true, "js": true, "jse": true,
+	"wsf": true, "wsh": true, "msi": true, "msp": true, "hta": true,
+	"cpl": true, "jar": true, "ps1": true, "lnk": true, "reg": true,
+	"dll": true, "sys": true, "application": true, "gadget": true,
+}
+
+// decoyExtensions are document and media types a recipient expects to open
+// safely; only these make a following executable extension suspicious, so
+// names like jquery.min.js or setup.v2.exe are left alone
+var decoyExtensions = map[string]bool{
+	"pdf": true, "doc": true, "docx": true, "xls": true, "xlsx": true,
+	"ppt": true, "pptx": true, "odt": true, "ods": true, "rtf": true,
+	"txt": true, "csv": true, "htm": true, "html": true, "zip": true,
+	"jpg": true, "jpeg": true, "png": true, "gif": true, "bmp": true,
+	"mp3": true, "mp4": true, "wav": true, "avi": true, "mov": true,
+}
+
+// normalizeFilename folds unicode tricks out of an attachment filename:
+// compatibility forms are normalized, invisible/bidi format characters are
+// dropped and trailing dots/spaces (ignored by Windows) are trimmed
+func normalizeFilename(filename string) string {
+	filename = norm.NFKC.String(filename)
+	filename = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.TrimRight(filename, " .\t")
+	return strings.ToLower(path.Base(strings.ReplaceAll(filename, "\\", "/")))
+}
+
+// hasDangerousDoubleExtension reports whether a filename hides an executable
+// extension behind a document or media decoy, e.g. "invoice.pdf.exe"
+func hasDangerousDoubleExtension(filename string) bool {
+	parts := strings.Split(normalizeFilename(filename), ".")
+	if len(parts) < 3 {
+		return false
+	}
+
+	final := strings.TrimSpace(parts[len(parts)-1])
+	decoy := strings.TrimSpace(parts[len(parts)-2])
+	return decoyExtensions[decoy] && dangerousExtensions[final]
+}
+
+// checkAttachmentTotal records the decoded attachment total and enforces
+// tempmail.max_total_attachment_size_mb; many small files can add up past
+// what per-file and raw message limits suggest once encodings are undone
+// Flagging marks the attachments past the cap suspicious, rejecting returns a 552
+func (s *Session) checkAttachmentTotal(attachments []AttachmentData) error {
+	var total int64
+	for _, att := range attachments {
+		total += att.SizeBytes
+	}
+	metricAttachmentBytes.Add(total)
+	observeMax(metricAttachmentMaxTotal, total)
+
+	if s.cfg == nil || s.cfg.Tempmail.MaxTotalAttachmentSizeMB <= 0 {
+		return nil
+	}
+	limit := int64(s.cfg.Tempmail.MaxTotalAttachmentSizeMB) * 1024 * 1024
+	if total <= limit {
+		return nil
+	}
+	metricAttachmentOverLimit.Add(1)
+
+	if s.cfg.Tempmail.AttachmentTotalAction != AttachmentActionFlag {
+		s.logger().Info("REJECTED: Attachments over total size limit", "bytes", total, "files", len(attachments), "max", limit)
+		return &smtp.SMTPError{
+			Code:         552,
+			EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+			Message:      "Total attachment size exceeds limit",
+		}
+	}
+
+	var running int64
+	for i := range attachments {
+		running += attachments[i].SizeBytes
+		if running > limit {
+			attachments[i].Suspicious = true
+		}
+	}
+	s.logger().Info("FLAGGED: Attachments over total size limit", "bytes", total, "files", len(attachments), "max", limit)
+	return nil
+}
+
+// applyAttachmentPolicy checks attachment names against attachments.double_extension
+// Suspicious attachments are marked in place when flagging; rejecting returns a 550
+func (s *Session) applyAttachmentPolicy(attachments []AttachmentData) error {
+	action := AttachmentActionAllow
+	if s.cfg != nil && s.cfg.Attachments.DoubleExtension != "" {
+		action = s.cfg.Attachments.DoubleExtension
+	}
+	if action == AttachmentActionAllow {
+		return nil
+	}
+
+	for i := range attachments {
+		if !hasDangerousDoubleExtension(attachments[i].Filename) {
+			continue
+		}
+
+		if action == AttachmentActionReject {
+			s.logger().Info("REJECTED: Suspicious attachment name", "filename", attachments[i].Filename)
+			return &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+				Message:      "Message rejected: suspicious attachment name",
+			}
+		}
+
+		s.logger().Info("FLAGGED: Suspicious attachment name", "filename", attachments[i].Filename)
+		attachments[i].Suspicious = true
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestHasDangerousDoubleExtension(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     bool
+	}{
+		{"invoice.pdf.exe", true},
+		{"INVOICE.PDF.EXE", true},
+		{"invoice.pdf.exe ", true},
+		{"invoice.pdf.exe...", true},
+		{"invoice.pdf\u200b.exe", true},
+		{"invoice.pdf.\uff45\uff58\uff45", true}, // fullwidth "exe"
+		{"C:\\Users\\bob\\report.doc.scr", true},
+		{"archive.tar.gz", false},
+		{"setup.exe", false},
+		{"photo.jpg", false},
+		{"notes.v2.txt", false},
+		{"photo.jpeg.scr", true},
+		{"jquery.min.js", false},
+		{"setup.v2.exe", false},
+		{"app.2024.msi", false},
+		{"backup.tar.exe", false},
+		{"..exe", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := hasDangerousDoubleExtension(tt.filename); got != tt.want {
+				t.Errorf("hasDangerousDoubleExtension(%q) = %v, want %v", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+const doubleExtensionMessage = `From: sender@example.com
+To: user@tempmail.example.com
+Subject: Invoice
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+See attached.
+--b
+Content-Type: application/octet-stream
+Content-Disposition: attachment; filename="invoice.pdf.exe"
+
+MZ
+--b
+Content-Type: application/gzip
+Content-Disposition: attachment; filename="archive.tar.gz"
+
+gz
+--b--
+`
+
+func TestSessionAttachmentPolicy(t *testing.T) {
+	tests := []struct {
+		action         string
+		wantReject     bool
+		wantSuspicious []bool
+	}{
+		{AttachmentActionAllow, false, []bool{false, false}},
+		{AttachmentActionFlag, false, []bool{true, false}},
+		{AttachmentActionReject, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Attachments.DoubleExtension = tt.action
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			s.Mail("sender@example.com", nil)
+			s.Rcpt("user@tempmail.example.com", nil)
+
+			err := s.Data(strings.NewReader(doubleExtensionMessage))
+			if tt.wantReject {
+				var smtpErr *smtp.SMTPError
+				if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+					t.Fatalf("Data() error = %v, want 550 rejection", err)
+				}
+				if len(mockDB.stored) != 0 {
+					t.Error("rejected message should not be stored")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+
+			atts := mockDB.attachments[0]
+			if len(atts) != len(tt.wantSuspicious) {
+				t.Fatalf("stored %d attachments, want %d", len(atts), len(tt.wantSuspicious))
+			}
+			for i, want := range tt.wantSuspicious {
+				if atts[i].Suspicious != want {
+					t.Errorf("attachment %q Suspicious = %v, want %v", atts[i].Filename, atts[i].Suspicious, want)
+				}
+			}
+		})
+	}
+}
+
+// manyAttachmentsMessage builds a message with count base64 attachments of size bytes each
+func manyAttachmentsMessage(count, size int) string {
+	var b strings.Builder
+	b.WriteString("From: sender@example.com\r\nTo: user@tempmail.example.com\r\nSubject: Files\r\n")
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"b\"\r\n\r\n")
+	b.WriteString("--b\r\nContent-Type: text/plain\r\n\r\nSee attached\r\n")
+	encoded := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("x"), size))
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&b, "--b\r\nContent-Type: application/octet-stream\r\n"+
+			"Content-Disposition: attachment; filename=\"part%d.bin\"\r\nContent-Transfer-Encoding: base64\r\n\r\n", i)
+		for line := encoded; len(line) > 0; {
+			n := min(76, len(line))
+			b.WriteString(line[:n] + "\r\n")
+			line = line[n:]
+		}
+	}
+	b.WriteString("--b--\r\n")
+	return b.String()
+}
+
+func TestSessionAttachmentTotalLimit(t *testing.T) {
+	// Four 300 KB files: each is small, together they pass a 1 MB cap
+	message := manyAttachmentsMessage(4, 300*1024)
+
+	tests := []struct {
+		name           string
+		limitMB        int
+		action         string
+		wantReject     bool
+		wantSuspicious []bool
+	}{
+		{"unlimited", 0, AttachmentActionReject, false, []bool{false, false, false, false}},
+		{"under the cap", 2, AttachmentActionReject, false, []bool{false, false, false, false}},
+		{"over the cap rejected", 1, AttachmentActionReject, true, nil},
+		{"over the cap flagged", 1, AttachmentActionFlag, false, []bool{false, false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Tempmail.MaxTotalAttachmentSizeMB = tt.limitMB
+			cfg.Tempmail.AttachmentTotalAction = tt.action
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			s.Mail("sender@example.com", nil)
+			s.Rcpt("user@tempmail.example.com", nil)
+
+			overBefore := metricAttachmentOverLimit.Value()
+			err := s.Data(strings.NewReader(message))
+			if tt.wantReject {
+				var smtpErr *smtp.SMTPError
+				if !errors.As(err, &smtpErr) || smtpErr.Code != 552 {
+					t.Fatalf("Data() error = %v, want 552 rejection", err)
+				}
+				if len(mockDB.stored) != 0 {
+					t.Error("rejected message should not be stored")
+				}
+				if metricAttachmentOverLimit.Value() != overBefore+1 {
+					t.Error("over-limit metric not incremented")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+
+			atts := mockDB.attachments[0]
+			if len(atts) != len(tt.wantSuspicious) {
+				t.Fatalf("stored %d attachments, want %d", len(atts), len(tt.wantSuspicious))
+			}
+			for i, want := range tt.wantSuspicious {
+				if atts[i].Suspicious != want {
+					t.Errorf("attachment %q Suspicious = %v, want %v", atts[i].Filename, atts[i].Suspicious, want)
+				}
+			}
+			if metricAttachmentMaxTotal.Value() < 4*300*1024 {
+				t.Errorf("max total metric = %d, want at least %d", metricAttachmentMaxTotal.Value(), 4*300*1024)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math/rand/v2"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Actions for mail failing SPF, DKIM and DMARC together (validation.reject_unauthenticated)
+const (
+	UnauthenticatedAllow  = "allow"
+	UnauthenticatedFlag   = "flag"
+	UnauthenticatedReject = "reject"
+)
+
+// Actions for a third-party DKIM signature on mail from a domain without DMARC
+// (validation.dkim_misaligned)
+const (
+	DKIMMisalignedAllow  = "allow"
+	DKIMMisalignedFlag   = "flag"
+	DKIMMisalignedReject = "reject"
+)
+
+var errSMTPUnauthenticated = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 26},
+	Message:      "Message failed SPF, DKIM and DMARC authentication",
+}
+
+// isUnauthenticated reports whether SPF, DKIM and DMARC all failed
+// A disabled check never counts as failed, and "none" (nothing published) only
+// counts when validation.unauthenticated_none_fails is set
+func isUnauthenticated(result *ValidationResult, cfg *Config) bool {
+	v := cfg.Validation
+	noneFails := v.UnauthenticatedNoneFails
+
+	spfChecked := v.CheckSPF || v.TrustExistingAuthResults
+	spfFailed := result.SPFResult == "fail" || result.SPFResult == "softfail" ||
+		(noneFails && result.SPFResult == "none")
+
+	// DKIMValid is nil when unchecked, false for both bad and missing signatures
+	dkimFailed := result.DKIMValid != nil && !*result.DKIMValid
+
+	dmarcChecked := v.CheckDMARC || v.TrustExistingAuthResults
+	dmarcFailed := result.DMARCResult == "fail" || (noneFails && result.DMARCResult == "none")
+
+	return spfChecked && spfFailed && dkimFailed && dmarcChecked && dmarcFailed
+}
+
+var errSMTPDKIMMisaligned = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "DKIM signature does not match the sender domain",
+}
+
+// isDKIMMisaligned reports whether the message carries valid DKIM signatures but
+// none from the From: header's organizational domain, while that domain publishes
+// no DMARC policy that would otherwise decide the question
+func isDKIMMisaligned(result *ValidationResult, fromDomain string, cfg *Config) bool {
+	if fromDomain == "" || len(result.DKIMDomains) == 0 {
+		return false
+	}
+	if !cfg.Validation.CheckDMARC || result.DMARCResult != "none" {
+		return false
+	}
+
+	for _, domain := range result.DKIMDomains {
+		if dmarcAligned(domain, fromDomain, DMARCAlignmentRelaxed) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDKIMAlignment applies validation.dkim_misaligned, returning whether the
+// message should be flagged or an error to reject it with
+func (s *Session) checkDKIMAlignment(result *ValidationResult) (bool, error) {
+	if s.cfg == nil {
+		return false, nil
+	}
+	action := s.cfg.Validation.DKIMMisaligned
+	// The From: header is what readers see, so it is the domain a signature must match
+	if action == DKIMMisalignedAllow || !isDKIMMisaligned(result, result.DMARCDomain, s.cfg) {
+		return false, nil
+	}
+
+	if action == DKIMMisalignedReject {
+		s.logger().Info("REJECTED: DKIM signed for a sender that publishes no DMARC", "dkim_domains", result.DKIMDomains, "header_from", result.DMARCDomain)
+		return false, errSMTPDKIMMisaligned
+	}
+
+	s.logger().Info("FLAGGED: DKIM signed for a sender that publishes no DMARC", "dkim_domains", result.DKIMDomains, "header_from", result.DMARCDomain)
+	return true, nil
+}
+
+// checkUnauthenticated applies validation.reject_unauthenticated, returning
+// whether the message should be flagged or an error to reject it with
+func (s *Session) checkUnauthenticated(result *ValidationResult) (bool, error) {
+	if s.cfg == nil {
+		return false, nil
+	}
+	action := s.cfg.Validation.RejectUnauthenticated
+	if action == "" || action == UnauthenticatedAllow || !isUnauthenticated(result, s.cfg) {
+		return false, nil
+	}
+
+	if action == UnauthenticatedReject {
+		s.logger().Info("REJECTED: Unauthenticated message", "from", s.from,
+			"spf", result.SPFResult, "dkim", formatBoolPtr(result.DKIMValid), "dmarc", result.DMARCResult)
+		return false, errSMTPUnauthenticated
+	}
+
+	s.logger().Info("FLAGGED: Unauthenticated message", "from", s.from,
+		"spf", result.SPFResult, "dkim", formatBoolPtr(result.DKIMValid), "dmarc", result.DMARCResult)
+	return true, nil
+}
+
+var errSMTPDMARCReject = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Message rejected due to the sender's DMARC policy",
+}
+
+// dmarcSampled decides whether a failing message falls within a policy's pct=
+func dmarcSampled(percent int) bool {
+	return rand.IntN(100) < percent
+}
+
+// dmarcDisposition returns the policy to apply to a message that failed DMARC
+// Per RFC 7489 section 6.6.4, messages outside the pct= sample get the next
+// milder policy: reject becomes quarantine, quarantine becomes none
+func dmarcDisposition(policy *DMARCPolicy, fromDomain string) string {
+	disposition := policy.EffectivePolicy(fromDomain)
+	if disposition == DMARCPolicyNone || dmarcSampled(policy.Percent) {
+		return disposition
+	}
+	if disposition == DMARCPolicyReject {
+		return DMARCPolicyQuarantine
+	}
+	return DMARCPolicyNone
+}
+
+// checkDMARCPolicy applies validation.enforce_dmarc, returning whether the
+// message should be quarantined or an error to reject it with
+func (s *Session) checkDMARCPolicy(result *ValidationResult) (bool, error) {
+	if s.cfg == nil || !s.cfg.Validation.EnforceDMARC || result.DMARCResult != "fail" || result.DMARCPolicy == nil {
+		return false, nil
+	}
+
+	switch dmarcDisposition(result.DMARCPolicy, result.DMARCDomain) {
+	case DMARCPolicyReject:
+		s.logger().Info("REJECTED: DMARC failed", "policy", "reject", "dmarc_domain", result.DMARCDomain, "from", s.from)
+		return false, errSMTPDMARCReject
+	case DMARCPolicyQuarantine:
+		s.logger().Info("QUARANTINED: DMARC failed", "policy", "quarantine", "dmarc_domain", result.DMARCDomain, "from", s.from)
+		return true, nil
+	}
+	return false, nil
+}
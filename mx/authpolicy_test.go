@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestIsUnauthenticated(t *testing.T) {
+	valid, invalid := true, false
+
+	tests := []struct {
+		name      string
+		result    ValidationResult
+		noneFails bool
+		noDMARC   bool // check_dmarc disabled
+		want      bool
+	}{
+		{"all fail", ValidationResult{SPFResult: "fail", DKIMValid: &invalid, DMARCResult: "fail"}, false, false, true},
+		{"softfail counts", ValidationResult{SPFResult: "softfail", DKIMValid: &invalid, DMARCResult: "fail"}, false, false, true},
+		{"dkim passes", ValidationResult{SPFResult: "fail", DKIMValid: &valid, DMARCResult: "fail"}, false, false, false},
+		{"spf passes", ValidationResult{SPFResult: "pass", DKIMValid: &invalid, DMARCResult: "fail"}, false, false, false},
+		{"dkim unchecked", ValidationResult{SPFResult: "fail", DMARCResult: "fail"}, false, false, false},
+		{"nothing published lenient", ValidationResult{SPFResult: "none", DKIMValid: &invalid, DMARCResult: "none"}, false, false, false},
+		{"nothing published strict", ValidationResult{SPFResult: "none", DKIMValid: &invalid, DMARCResult: "none"}, true, false, true},
+		{"neutral never fails", ValidationResult{SPFResult: "neutral", DKIMValid: &invalid, DMARCResult: "fail"}, true, false, false},
+		{"dmarc disabled strict", ValidationResult{SPFResult: "fail", DKIMValid: &invalid, DMARCResult: "none"}, true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Validation.CheckSPF = true
+			cfg.Validation.CheckDKIM = true
+			cfg.Validation.CheckDMARC = !tt.noDMARC
+			cfg.Validation.UnauthenticatedNoneFails = tt.noneFails
+			if got := isUnauthenticated(&tt.result, cfg); got != tt.want {
+				t.Errorf("isUnauthenticated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionRejectUnauthenticated(t *testing.T) {
+	message := "From: sender@sender.example\r\nTo: user@tempmail.example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+
+	tests := []struct {
+		name     string
+		spf      string // SPF record for sender.example
+		action   string
+		wantCode int // 0 = accepted
+		wantFlag bool
+	}{
+		{"fully unauthenticated rejected", "v=spf1 -all", UnauthenticatedReject, 550, false},
+		{"fully unauthenticated flagged", "v=spf1 -all", UnauthenticatedFlag, 0, true},
+		{"fully unauthenticated allowed", "v=spf1 -all", UnauthenticatedAllow, 0, false},
+		{"spf pass is partially authenticated", "v=spf1 ip4:127.0.0.1 -all", UnauthenticatedReject, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 1
+			cfg.Validation.CheckSPF = true
+			cfg.Validation.CheckDKIM = true
+			cfg.Validation.CheckDMARC = true
+			cfg.Validation.RejectUnauthenticated = tt.action
+
+			validator := NewValidator(cfg)
+			validator.resolver = &fakeResolver{txt: map[string][]string{
+				"sender.example":        {tt.spf},
+				"_dmarc.sender.example": {"v=DMARC1; p=reject"},
+			}}
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, validator, cfg.GetDomainMap())
+			if err := s.Mail("sender@sender.example", nil); err != nil {
+				t.Fatalf("Mail() error = %v", err)
+			}
+			if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+				t.Fatalf("Rcpt() error = %v", err)
+			}
+
+			err := s.Data(strings.NewReader(message))
+			if tt.wantCode != 0 {
+				var smtpErr *smtp.SMTPError
+				if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+					t.Fatalf("Data() error = %v, want %d", err, tt.wantCode)
+				}
+				if len(mockDB.stored) != 0 {
+					t.Error("rejected message was stored")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Data() error = %v, want accepted", err)
+			}
+			if len(mockDB.stored) != 1 {
+				t.Fatalf("stored %d emails, want 1", len(mockDB.stored))
+			}
+			if got := mockDB.stored[0].Unauthenticated; got != tt.wantFlag {
+				t.Errorf("Unauthenticated = %v, want %v", got, tt.wantFlag)
+			}
+		})
+	}
+}
+
+func TestSessionDKIMMisaligned(t *testing.T) {
+	// Alignment is judged on the From: header, which these set to brand.example
+	signed, record := signTestMessageFrom(t, 1024, "esp.example", "sel", "brand.example")
+	ownSigned, ownRecord := signTestMessageFrom(t, 1024, "brand.example", "sel", "brand.example")
+	espSigned, espRecord := signTestMessageFrom(t, 1024, "esp.example", "esp", "esp.example")
+
+	tests := []struct {
+		name     string
+		message  []byte
+		from     string
+		dmarc    bool // the From: header domain publishes DMARC
+		action   string
+		wantCode int // 0 = accepted
+		wantFlag bool
+	}{
+		{"third-party signature flagged", signed, "sender@brand.example", false, DKIMMisalignedFlag, 0, true},
+		{"third-party signature rejected", signed, "sender@brand.example", false, DKIMMisalignedReject, 550, false},
+		{"third-party signature allowed", signed, "sender@brand.example", false, DKIMMisalignedAllow, 0, false},
+		{"aligned signature", ownSigned, "sender@brand.example", false, DKIMMisalignedReject, 0, false},
+		{"aligned subdomain sender", ownSigned, "bounces@mail.brand.example", false, DKIMMisalignedReject, 0, false},
+		{"sender with DMARC left to DMARC", signed, "sender@brand.example", true, DKIMMisalignedReject, 0, false},
+		{"null sender judged on From", signed, "", false, DKIMMisalignedReject, 550, false},
+		{"MAIL FROM matches the signer but From does not", signed, "bounces@esp.example", false, DKIMMisalignedReject, 550, false},
+		{"From matches the signer but MAIL FROM does not", espSigned, "sender@brand.example", false, DKIMMisalignedReject, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 1
+			cfg.Validation.CheckDKIM = true
+			cfg.Validation.CheckDMARC = true
+			cfg.Validation.DKIMMisaligned = tt.action
+
+			validator := NewValidator(cfg)
+			resolver := &fakeResolver{txt: map[string][]string{
+				"sel._domainkey.esp.example":   {record},
+				"sel._domainkey.brand.example": {ownRecord},
+				"esp._domainkey.esp.example":   {espRecord},
+			}}
+			if tt.dmarc {
+				resolver.txt["_dmarc.brand.example"] = []string{"v=DMARC1; p=none"}
+			}
+			validator.resolver = resolver
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"recipient@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, validator, cfg.GetDomainMap())
+			if err := s.Mail(tt.from, nil); err != nil {
+				t.Fatalf("Mail() error = %v", err)
+			}
+			if err := s.Rcpt("recipient@tempmail.example.com", nil); err != nil {
+				t.Fatalf("Rcpt() error = %v", err)
+			}
+
+			err := s.Data(bytes.NewReader(tt.message))
+			if tt.wantCode != 0 {
+				var smtpErr *smtp.SMTPError
+				if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+					t.Fatalf("Data() error = %v, want %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Data() error = %v, want accepted", err)
+			}
+			if len(mockDB.stored) != 1 {
+				t.Fatalf("stored %d emails, want 1", len(mockDB.stored))
+			}
+			if got := mockDB.stored[0].DKIMMisaligned; got != tt.wantFlag {
+				t.Errorf("DKIMMisaligned = %v, want %v", got, tt.wantFlag)
+			}
+		})
+	}
+}
+
+func TestDMARCDisposition(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+		from   string
+		want   string
+	}{
+		{"reject", "v=DMARC1; p=reject", "sender.example", DMARCPolicyReject},
+		{"quarantine", "v=DMARC1; p=quarantine", "sender.example", DMARCPolicyQuarantine},
+		{"none", "v=DMARC1; p=none", "sender.example", DMARCPolicyNone},
+		{"subdomain policy", "v=DMARC1; p=reject; sp=quarantine", "mail.sender.example", DMARCPolicyQuarantine},
+		{"reject outside pct quarantines", "v=DMARC1; p=reject; pct=0", "sender.example", DMARCPolicyQuarantine},
+		{"quarantine outside pct accepts", "v=DMARC1; p=quarantine; pct=0", "sender.example", DMARCPolicyNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := parseDMARCRecord(tt.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy.Domain = "sender.example"
+			if got := dmarcDisposition(policy, tt.from); got != tt.want {
+				t.Errorf("dmarcDisposition() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionEnforceDMARC(t *testing.T) {
+	message := "From: sender@sender.example\r\nTo: user@tempmail.example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+
+	tests := []struct {
+		name           string
+		dmarc          string // DMARC record for sender.example
+		spf            string // SPF record for sender.example
+		enforce        bool
+		wantCode       int // 0 = accepted
+		wantQuarantine bool
+	}{
+		{"reject enforced", "v=DMARC1; p=reject", "v=spf1 -all", true, 550, false},
+		{"quarantine enforced", "v=DMARC1; p=quarantine", "v=spf1 -all", true, 0, true},
+		{"none only monitors", "v=DMARC1; p=none", "v=spf1 -all", true, 0, false},
+		{"reject not enforced", "v=DMARC1; p=reject", "v=spf1 -all", false, 0, false},
+		{"passing mail unaffected", "v=DMARC1; p=reject", "v=spf1 ip4:127.0.0.1 -all", true, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 1
+			cfg.Validation.CheckSPF = true
+			cfg.Validation.CheckDMARC = true
+			cfg.Validation.EnforceDMARC = tt.enforce
+
+			validator := NewValidator(cfg)
+			validator.resolver = &fakeResolver{txt: map[string][]string{
+				"sender.example":        {tt.spf},
+				"_dmarc.sender.example": {tt.dmarc},
+			}}
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, validator, cfg.GetDomainMap())
+			if err := s.Mail("sender@sender.example", nil); err != nil {
+				t.Fatalf("Mail() error = %v", err)
+			}
+			if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+				t.Fatalf("Rcpt() error = %v", err)
+			}
+
+			err := s.Data(strings.NewReader(message))
+			if tt.wantCode != 0 {
+				var smtpErr *smtp.SMTPError
+				if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+					t.Fatalf("Data() error = %v, want %d", err, tt.wantCode)
+				}
+				if len(mockDB.stored) != 0 {
+					t.Error("rejected message was stored")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Data() error = %v, want accepted", err)
+			}
+			if len(mockDB.stored) != 1 {
+				t.Fatalf("stored %d emails, want 1", len(mockDB.stored))
+			}
+			if got := mockDB.stored[0].Quarantined; got != tt.wantQuarantine {
+				t.Errorf("Quarantined = %v, want %v", got, tt.wantQuarantine)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/textproto"
+	"strings"
+
+	"github.com/emersion/go-msgauth/authres"
+)
+
+// upstreamAuthResults holds results taken from a trusted Authentication-Results header
+// Empty strings / nil mean the upstream header did not report that method
+type upstreamAuthResults struct {
+	DKIMValid   *bool
+	SPFResult   string
+	SPFIdentity string
+	DMARCResult string
+}
+
+// parseTrustedAuthResults returns the results from the topmost
+// Authentication-Results header whose authserv-id matches authservID
+// Lower headers may have been forged by the sender, so only the first match is used
+func parseTrustedAuthResults(rawMessage []byte, authservID string) *upstreamAuthResults {
+	header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(rawMessage))).ReadMIMEHeader()
+
+	for _, value := range header.Values("Authentication-Results") {
+		identifier, results, err := authres.Parse(value)
+		if err != nil || !strings.EqualFold(identifier, authservID) {
+			continue
+		}
+
+		upstream := &upstreamAuthResults{}
+		for _, result := range results {
+			switch r := result.(type) {
+			case *authres.DKIMResult:
+				// Any passing signature is enough
+				pass := r.Value == authres.ResultPass
+				if upstream.DKIMValid == nil || pass {
+					upstream.DKIMValid = &pass
+				}
+			case *authres.SPFResult:
+				upstream.SPFResult = string(r.Value)
+				upstream.SPFIdentity = SPFIdentityMailFrom
+				if r.From == "" && r.Helo != "" {
+					upstream.SPFIdentity = SPFIdentityHelo
+				}
+			case *authres.DMARCResult:
+				upstream.DMARCResult = string(r.Value)
+			}
+		}
+		return upstream
+	}
+
+	return nil
+}
+
+// trustedAuthResults parses upstream results when validation.trust_existing_authresults is set
+// The header is ignored unless clientIP is one of validation.trusted_upstream_ips
+func (v *Validator) trustedAuthResults(rawMessage []byte, clientIP string) *upstreamAuthResults {
+	if !v.cfg.Validation.TrustExistingAuthResults || v.cfg.Validation.TrustedAuthservID == "" {
+		return nil
+	}
+	if !isTrustedUpstream(clientIP, v.cfg.Validation.TrustedUpstreamIPs) {
+		return nil
+	}
+
+	upstream := parseTrustedAuthResults(rawMessage, v.cfg.Validation.TrustedAuthservID)
+	if upstream != nil {
+		v.logger().Info("Using Authentication-Results from trusted authserv-id",
+			"authserv_id", v.cfg.Validation.TrustedAuthservID, "dkim_valid", formatBoolPtr(upstream.DKIMValid),
+			"spf_result", upstream.SPFResult, "dmarc_result", upstream.DMARCResult)
+	}
+	return upstream
+}
+
+// isTrustedUpstream reports whether ip matches one of the trusted upstream entries
+func isTrustedUpstream(ip string, entries []string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, entry := range entries {
+		if matchIP(parsed, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatAuthResults builds the Authentication-Results value (RFC 8601) recording
+// our SPF, DKIM, DMARC and ARC verdicts, one method per folded line
+// Methods we did not run are left out; SPF and DMARC always report at least none
+func formatAuthResults(authservID string, result *ValidationResult, from, helo, clientIP string) string {
+	var results []authres.Result
+
+	spf := &authres.SPFResult{Value: authres.ResultValue(result.SPFResult), From: from}
+	if result.SPFIdentity == SPFIdentityHelo || from == "" {
+		spf.From, spf.Helo = "", helo
+	}
+	results = append(results, spf)
+
+	switch {
+	case result.DKIMDetails != nil && len(result.DKIMDetails) == 0:
+		results = append(results, &authres.DKIMResult{Value: authres.ResultNone})
+	case result.DKIMDetails != nil:
+		for _, signature := range result.DKIMDetails {
+			value := authres.ResultValue(authres.ResultFail)
+			if signature.Valid {
+				value = authres.ResultPass
+			}
+			results = append(results, &authres.GenericResult{Method: "dkim", Value: value, Params: map[string]string{
+				"header.d": signature.Domain,
+				"header.s": signature.Selector,
+				"reason":   signature.Error,
+			}})
+		}
+	case result.DKIMValid != nil:
+		// Taken from a trusted upstream header, which gives no per-signature detail
+		value := authres.ResultValue(authres.ResultFail)
+		if *result.DKIMValid {
+			value = authres.ResultPass
+		}
+		results = append(results, &authres.DKIMResult{Value: value})
+	}
+
+	results = append(results, &authres.DMARCResult{Value: authres.ResultValue(result.DMARCResult), From: result.DMARCDomain})
+
+	if result.ARCResult != "" {
+		results = append(results, &authres.GenericResult{Method: "arc", Value: authres.ResultValue(result.ARCResult),
+			Params: map[string]string{"smtp.remote-ip": clientIP}})
+	}
+
+	var value strings.Builder
+	value.WriteString(authservID)
+	for _, r := range results {
+		// Format joins results on one line; format each alone so we can fold them
+		method := strings.TrimPrefix(authres.Format("", []authres.Result{r}), "; ")
+		value.WriteString(";\r\n\t" + strings.TrimSpace(method))
+	}
+	return value.String()
+}
+
+// authservIDOf returns the authserv-id of an Authentication-Results value
+func authservIDOf(value string) string {
+	id, _, _ := strings.Cut(value, ";")
+	fields := strings.Fields(id)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// removeAuthResults drops Authentication-Results headers carrying our authserv-id
+// A sender could otherwise forge our verdict (RFC 8601 section 5)
+func removeAuthResults(rawMessage []byte, authservID string) []byte {
+	return removeHeaderFields(rawMessage, func(name, value string) bool {
+		return strings.EqualFold(name, "Authentication-Results") && strings.EqualFold(authservIDOf(value), authservID)
+	})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const upstreamMessage = "Authentication-Results: mx.upstream.example;\r\n" +
+	"  dkim=pass header.d=sender.example header.s=sel;\r\n" +
+	"  spf=softfail smtp.mailfrom=user@sender.example;\r\n" +
+	"  dmarc=pass header.from=sender.example\r\n" +
+	"Authentication-Results: mx.upstream.example; dkim=fail; spf=pass; dmarc=fail\r\n" +
+	"From: user@sender.example\r\n" +
+	"Subject: Test\r\n" +
+	"\r\n" +
+	"Body\r\n"
+
+func TestParseTrustedAuthResults(t *testing.T) {
+	tests := []struct {
+		name       string
+		message    string
+		authservID string
+		wantNil    bool
+		wantDKIM   string
+		wantSPF    string
+		wantID     string
+		wantDMARC  string
+	}{
+		{"topmost trusted header", upstreamMessage, "mx.upstream.example", false, "true", "softfail", SPFIdentityMailFrom, "pass"},
+		{"authserv-id is case-insensitive", upstreamMessage, "MX.Upstream.Example", false, "true", "softfail", SPFIdentityMailFrom, "pass"},
+		{"untrusted authserv-id", upstreamMessage, "mx.other.example", true, "", "", "", ""},
+		{"no header", "From: a@b.example\r\n\r\nBody\r\n", "mx.upstream.example", true, "", "", "", ""},
+		{
+			"helo identity",
+			"Authentication-Results: mx.upstream.example; spf=pass smtp.helo=mail.sender.example\r\n\r\nBody\r\n",
+			"mx.upstream.example", false, "null", "pass", SPFIdentityHelo, "",
+		},
+		{
+			"forged header below trusted one is ignored",
+			"Authentication-Results: mx.upstream.example; dkim=fail\r\n" +
+				"Authentication-Results: mx.upstream.example; dkim=pass\r\n\r\nBody\r\n",
+			"mx.upstream.example", false, "false", "", "", "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseTrustedAuthResults([]byte(tt.message), tt.authservID)
+			if tt.wantNil {
+				if got != nil {
+					t.Fatalf("parseTrustedAuthResults() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("parseTrustedAuthResults() = nil")
+			}
+			if dkim := formatBoolPtr(got.DKIMValid); dkim != tt.wantDKIM {
+				t.Errorf("DKIMValid = %s, want %s", dkim, tt.wantDKIM)
+			}
+			if got.SPFResult != tt.wantSPF || got.SPFIdentity != tt.wantID {
+				t.Errorf("SPF = (%s, %s), want (%s, %s)", got.SPFResult, got.SPFIdentity, tt.wantSPF, tt.wantID)
+			}
+			if got.DMARCResult != tt.wantDMARC {
+				t.Errorf("DMARCResult = %s, want %s", got.DMARCResult, tt.wantDMARC)
+			}
+		})
+	}
+}
+
+func TestValidateEmailTrustedAuthResults(t *testing.T) {
+	cfg := &Config{}
+	cfg.Validation.CheckDKIM = true
+	cfg.Validation.CheckSPF = true
+	cfg.Validation.CheckDMARC = true
+	cfg.Validation.TrustExistingAuthResults = true
+	cfg.Validation.TrustedAuthservID = "mx.upstream.example"
+	cfg.Validation.TrustedUpstreamIPs = []string{"192.0.2.0/24"}
+
+	validator := NewValidator(cfg)
+	// Any DNS lookup would fail the test: upstream results must be used instead
+	validator.resolver = &fakeResolver{}
+
+	result := validator.ValidateEmail([]byte(upstreamMessage), "user@sender.example", "192.0.2.1", "mail.sender.example")
+	if result.DKIMValid == nil || !*result.DKIMValid {
+		t.Errorf("DKIMValid = %s, want true", formatBoolPtr(result.DKIMValid))
+	}
+	if result.SPFResult != "softfail" || result.SPFIdentity != SPFIdentityMailFrom {
+		t.Errorf("SPF = (%s, %s), want (softfail, mailfrom)", result.SPFResult, result.SPFIdentity)
+	}
+	if result.DMARCResult != "pass" {
+		t.Errorf("DMARCResult = %s, want pass", result.DMARCResult)
+	}
+}
+
+func TestValidateEmailForgedAuthResults(t *testing.T) {
+	cfg := &Config{}
+	cfg.Validation.CheckSPF = true
+	cfg.Validation.TrustExistingAuthResults = true
+	cfg.Validation.TrustedAuthservID = "mx.upstream.example"
+	cfg.Validation.TrustedUpstreamIPs = []string{"192.0.2.0/24"}
+
+	validator := NewValidator(cfg)
+	validator.resolver = &fakeResolver{}
+
+	// The header names the trusted authserv-id but the client is not the upstream
+	result := validator.ValidateEmail([]byte(upstreamMessage), "user@sender.example", "198.51.100.7", "mail.sender.example")
+	if result.DKIMValid != nil {
+		t.Errorf("DKIMValid = %s, want null: DKIM was not checked locally", formatBoolPtr(result.DKIMValid))
+	}
+	if result.SPFResult == "softfail" {
+		t.Error("SPF result was taken from a forged Authentication-Results header")
+	}
+	if result.DMARCResult != "none" {
+		t.Errorf("DMARCResult = %s, want none", result.DMARCResult)
+	}
+}
+
+func TestFormatAuthResults(t *testing.T) {
+	pass, fail := true, false
+	tests := []struct {
+		name   string
+		result *ValidationResult
+		from   string
+		want   string
+	}{
+		{
+			"nothing checked",
+			&ValidationResult{SPFResult: "none", DMARCResult: "none"},
+			"user@sender.example",
+			"mx.tempmail.example;\r\n\tspf=none smtp.mailfrom=user@sender.example;\r\n\tdmarc=none",
+		},
+		{
+			"local checks",
+			&ValidationResult{
+				SPFResult:   "pass",
+				SPFIdentity: SPFIdentityMailFrom,
+				DKIMValid:   &pass,
+				DKIMDetails: []DKIMSignatureResult{
+					{Domain: "sender.example", Selector: "sel", Valid: true},
+					{Domain: "esp.example", Selector: "s1", Error: "body hash did not verify"},
+				},
+				DMARCResult: "pass",
+				DMARCDomain: "sender.example",
+				ARCResult:   ARCNone,
+			},
+			"user@sender.example",
+			"mx.tempmail.example;\r\n\tspf=pass smtp.mailfrom=user@sender.example;" +
+				"\r\n\tdkim=pass header.d=sender.example header.s=sel;" +
+				"\r\n\tdkim=fail reason=\"body hash did not verify\" header.d=esp.example header.s=s1;" +
+				"\r\n\tdmarc=pass header.from=sender.example;" +
+				"\r\n\tarc=none smtp.remote-ip=192.0.2.1",
+		},
+		{
+			"unsigned, helo identity",
+			&ValidationResult{SPFResult: "fail", SPFIdentity: SPFIdentityHelo, DKIMDetails: []DKIMSignatureResult{}, DMARCResult: "none"},
+			"user@sender.example",
+			"mx.tempmail.example;\r\n\tspf=fail smtp.helo=mail.sender.example;\r\n\tdkim=none;\r\n\tdmarc=none",
+		},
+		{
+			"null sender, upstream DKIM",
+			&ValidationResult{SPFResult: "pass", DKIMValid: &fail, DMARCResult: "fail"},
+			"",
+			"mx.tempmail.example;\r\n\tspf=pass smtp.helo=mail.sender.example;\r\n\tdkim=fail;\r\n\tdmarc=fail",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatAuthResults("mx.tempmail.example", tt.result, tt.from, "mail.sender.example", "192.0.2.1")
+			if got != tt.want {
+				t.Errorf("formatAuthResults() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveAuthResults(t *testing.T) {
+	message := "Authentication-Results: MX.Tempmail.Example; spf=pass\r\n" +
+		"Authentication-Results: mx.upstream.example;\r\n" +
+		"  dkim=pass header.d=sender.example\r\n" +
+		"Authentication-Results:\r\n" +
+		"  mx.tempmail.example 1;\r\n" +
+		"  dkim=pass header.d=sender.example\r\n" +
+		"From: user@sender.example\r\n" +
+		"\r\n" +
+		"Authentication-Results: mx.tempmail.example; spf=pass\r\n"
+	want := "Authentication-Results: mx.upstream.example;\r\n" +
+		"  dkim=pass header.d=sender.example\r\n" +
+		"From: user@sender.example\r\n" +
+		"\r\n" +
+		"Authentication-Results: mx.tempmail.example; spf=pass\r\n"
+
+	if got := string(removeAuthResults([]byte(message), "mx.tempmail.example")); got != want {
+		t.Errorf("removeAuthResults() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSessionDataAuthResults(t *testing.T) {
+	message := "Authentication-Results: mx.tempmail.example; dmarc=pass\r\n" +
+		"From: sender@sender.example\r\nTo: user@tempmail.example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 1
+	cfg.Server.Hostname = "mx.tempmail.example"
+	cfg.Validation.CheckSPF = true
+	cfg.Validation.CheckDMARC = true
+
+	validator := NewValidator(cfg)
+	validator.resolver = &fakeResolver{txt: map[string][]string{
+		"sender.example":        {"v=spf1 -all"},
+		"_dmarc.sender.example": {"v=DMARC1; p=none"},
+	}}
+
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, validator, cfg.GetDomainMap())
+	s.Mail("sender@sender.example", nil)
+	s.Rcpt("user@tempmail.example.com", nil)
+	if err := s.Data(strings.NewReader(message)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	if len(mockDB.stored) != 1 {
+		t.Fatalf("stored %d emails, want 1", len(mockDB.stored))
+	}
+	got := mockDB.stored[0]
+
+	header := "Authentication-Results: mx.tempmail.example;\r\n\tspf=fail smtp.mailfrom=sender@sender.example;" +
+		"\r\n\tdmarc=fail header.from=sender.example\r\n"
+	if raw := string(got.RawMessage); !strings.HasPrefix(raw, header) || strings.Contains(raw, "dmarc=pass") {
+		t.Errorf("RawMessage = %q, want our header replacing the forged one", raw)
+	}
+	if !strings.Contains(got.RawHeaders, "Authentication-Results: mx.tempmail.example; spf=fail") ||
+		strings.Contains(got.RawHeaders, "dmarc=pass") {
+		t.Errorf("RawHeaders = %q, want our header replacing the forged one", got.RawHeaders)
+	}
+	if string(got.OriginalMessage) != message {
+		t.Errorf("OriginalMessage = %q, want the message as received", got.OriginalMessage)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Attachment byte storage backends (storage.backend)
+const (
+	StorageBackendPostgres   = "postgres"   // bytes in attachments.data (default)
+	StorageBackendFilesystem = "filesystem" // files under storage.path
+	StorageBackendS3         = "s3"         // objects in an S3-compatible bucket
+)
+
+// Storage holds attachment bytes outside the database; the attachments table
+// then keeps only the key Put returns
+// Keys name content and are shared between emails, so deleting an email never
+// deletes its objects; SweepAttachmentStore removes the unreferenced ones
+type Storage interface {
+	Put(key string, data []byte) (string, error)
+	Get(key string) ([]byte, error)
+	// Delete removes key; a missing key is not an error
+	Delete(key string) error
+	// Walk calls fn with every stored key and when it was last written
+	Walk(fn func(key string, modified time.Time) error) error
+}
+
+// NewStorage returns the configured attachment store, nil for the postgres backend
+func NewStorage(cfg *Config) (Storage, error) {
+	switch cfg.Storage.Backend {
+	case StorageBackendFilesystem:
+		fs, err := NewFileStorage(cfg.Storage.Path)
+		if err != nil {
+			return nil, err
+		}
+		return fs, nil
+	case StorageBackendS3:
+		s3 := cfg.Storage.S3
+		return &S3Storage{
+			Endpoint:  strings.TrimSuffix(s3.Endpoint, "/"),
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			Prefix:    s3.Prefix,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			Client:    &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// attachmentKey names attachment bytes by content, so retried stores and
+// repeated inline images share one object
+func attachmentKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	return digest[:2] + "/" + digest
+}
+
+// validStorageKey rejects keys that could escape the store's root
+func validStorageKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// FileStorage keeps attachments as files below a root directory
+type FileStorage struct {
+	root string
+}
+
+// NewFileStorage returns a FileStorage rooted at dir, creating it if needed
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create attachment directory: %w", err)
+	}
+	return &FileStorage{root: dir}, nil
+}
+
+// Put writes data under key; an existing file is kept since keys name content,
+// but its modification time is refreshed so a sweep does not take it as stale
+func (f *FileStorage) Put(key string, data []byte) (string, error) {
+	if !validStorageKey(key) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	file := filepath.Join(f.root, filepath.FromSlash(key))
+	if _, err := os.Stat(file); err == nil {
+		now := time.Now()
+		if err := os.Chtimes(file, now, now); err != nil {
+			return "", fmt.Errorf("failed to refresh attachment: %w", err)
+		}
+		return key, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o750); err != nil {
+		return "", fmt.Errorf("failed to create attachment directory: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial attachment
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create attachment file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write attachment: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to sync attachment: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write attachment: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return "", fmt.Errorf("failed to commit attachment: %w", err)
+	}
+	return key, nil
+}
+
+// Get reads the attachment stored under key
+func (f *FileStorage) Get(key string) ([]byte, error) {
+	if !validStorageKey(key) {
+		return nil, fmt.Errorf("invalid storage key %q", key)
+	}
+	data, err := os.ReadFile(filepath.Join(f.root, filepath.FromSlash(key)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment: %w", err)
+	}
+	return data, nil
+}
+
+// Delete removes the file stored under key
+func (f *FileStorage) Delete(key string) error {
+	if !validStorageKey(key) {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	err := os.Remove(filepath.Join(f.root, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return nil
+}
+
+// Walk visits every attachment file, skipping writes still in progress
+func (f *FileStorage) Walk(fn func(key string, modified time.Time) error) error {
+	return filepath.WalkDir(f.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(f.root, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), info.ModTime())
+	})
+}
+
+// S3Storage keeps attachments in an S3-compatible bucket (AWS, MinIO, R2, ...)
+// using path-style URLs and Signature Version 4
+type S3Storage struct {
+	Endpoint  string // e.g. https://s3.eu-west-1.amazonaws.com
+	Region    string
+	Bucket    string
+	Prefix    string // prepended to every object key
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+	now       func() time.Time // for tests; nil means time.Now
+}
+
+// Put uploads data as object Prefix+key and returns key
+func (s *S3Storage) Put(key string, data []byte) (string, error) {
+	if !validStorageKey(key) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	resp, err := s.do(http.MethodPut, key, data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to upload attachment: S3 returned %s", resp.Status)
+	}
+	return key, nil
+}
+
+// Get downloads the object stored under key
+func (s *S3Storage) Get(key string) ([]byte, error) {
+	if !validStorageKey(key) {
+		return nil, fmt.Errorf("invalid storage key %q", key)
+	}
+	resp, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("failed to download attachment: S3 returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download attachment: %w", err)
+	}
+	return data, nil
+}
+
+// Delete removes the object stored under key
+func (s *S3Storage) Delete(key string) error {
+	if !validStorageKey(key) {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	resp, err := s.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("failed to delete attachment: S3 returned %s", resp.Status)
+}
+
+// s3ListResult is the part of a ListObjectsV2 response Walk reads
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Walk lists every object under Prefix, one ListObjectsV2 page at a time
+func (s *S3Storage) Walk(fn func(key string, modified time.Time) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		// SigV4 wants %20 rather than + in the canonical query
+		resp, err := s.request(http.MethodGet, "/"+s.Bucket, strings.ReplaceAll(query.Encode(), "+", "%20"), nil)
+		if err != nil {
+			return err
+		}
+		var page s3ListResult
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return fmt.Errorf("failed to list attachments: S3 returned %s", resp.Status)
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to list attachments: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			if err := fn(strings.TrimPrefix(object.Key, s.Prefix), object.LastModified); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for the object under key
+func (s *S3Storage) do(method, key string, body []byte) (*http.Response, error) {
+	return s.request(method, "/"+s.Bucket+"/"+s.Prefix+key, "", body)
+}
+
+// request sends a signed request for path; query must already be canonical
+// (sorted and escaped) since it is signed as given
+func (s *S3Storage) request(method, path, query string, body []byte) (*http.Response, error) {
+	target := s.Endpoint + (&url.URL{Path: path}).EscapedPath()
+	if query != "" {
+		target += "?" + query
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build S3 request: %w", err)
+	}
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	s.sign(req, body, now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (s *S3Storage) sign(req *http.Request, body []byte, t time.Time) {
+	const algorithm = "AWS4-HMAC-SHA256"
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
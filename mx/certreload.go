@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = time.Minute
+
+// certReloader serves the STARTTLS certificate from cert_file/key_file and
+// swaps in a new one when the files change, so rotation needs no restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // newest mtime of the two files at the last load
+}
+
+// newCertReloader loads the initial certificate; failure is fatal at startup
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads and validates the files, then swaps the certificate
+// A bad pair or an expired certificate is refused and the current one kept
+func (r *certReloader) Reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse TLS certificate: %w", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("TLS certificate expired %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	slog.Info("TLS certificate loaded", "cert", r.certFile, "subject", leaf.Subject.CommonName,
+		"not_after", leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// GetCertificate returns the current certificate for each handshake
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// filesModTime returns the newer modification time of the cert and key
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var newest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// changed reports whether either file was modified since the last load
+func (r *certReloader) changed() bool {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !modTime.Equal(r.modTime)
+}
+
+// watch reloads the certificate when its files change, until ctx is done
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.Reload(); err != nil {
+					slog.Error("TLS certificate changed but was not reloaded, keeping current", "error", err)
+				}
+			}
+		}
+	}()
+}
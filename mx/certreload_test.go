@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// copyFile overwrites dst with src's contents, bumping its mtime
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	if err := os.WriteFile(dst, mustReadFile(t, src), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", dst, err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(dst, later, later); err != nil {
+		t.Fatalf("Failed to touch %s: %v", dst, err)
+	}
+}
+
+// servedCert returns the DER of the certificate the reloader hands out
+func servedCert(t *testing.T, r *certReloader) []byte {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	original := servedCert(t, r)
+
+	// A rotated pair replaces the served certificate
+	newCert, newKey := writeTestCert(t)
+	copyFile(t, newCert, certFile)
+	copyFile(t, newKey, keyFile)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	rotated := servedCert(t, r)
+	if bytes.Equal(rotated, original) {
+		t.Fatal("Reload() kept the old certificate")
+	}
+
+	// Broken files are refused and the current certificate kept
+	otherCert, _ := writeTestCert(t)
+	tests := []struct {
+		name string
+		cert string // contents written over cert_file
+	}{
+		{"garbage", "not a certificate"},
+		{"mismatched key", string(mustReadFile(t, otherCert))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(certFile, []byte(tt.cert), 0644); err != nil {
+				t.Fatalf("Failed to write cert: %v", err)
+			}
+			if err := r.Reload(); err == nil {
+				t.Error("Reload() accepted an invalid certificate")
+			}
+			if !bytes.Equal(servedCert(t, r), rotated) {
+				t.Error("failed Reload() replaced the served certificate")
+			}
+		})
+	}
+
+	if _, err := newCertReloader(certFile+".missing", keyFile); err == nil {
+		t.Error("newCertReloader() succeeded with a missing file")
+	}
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	original := servedCert(t, r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.watch(ctx, 10*time.Millisecond)
+
+	newCert, newKey := writeTestCert(t)
+	copyFile(t, newKey, keyFile)
+	copyFile(t, newCert, certFile)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for bytes.Equal(servedCert(t, r), original) {
+		if time.Now().After(deadline) {
+			t.Fatal("changed certificate files were not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func mustReadFile(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return data
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Defaults for the tempmail expiry settings, matching the API
+const (
+	defaultAddressLifetimeHours = 24
+	defaultCleanupIntervalHours = 1
+)
+
+// ExpiryCleaner deletes expired addresses and emails older than lifetime
+type ExpiryCleaner interface {
+	CleanupExpired(lifetime time.Duration) (int64, int64, error)
+}
+
+// AttachmentSweeper deletes stored attachment objects no email refers to any
+// more; a cleaner that implements it is swept after each pass
+type AttachmentSweeper interface {
+	SweepAttachmentStore(grace time.Duration) (int, error)
+}
+
+// startExpiryCleanup runs a cleanup pass now and then every interval until ctx is done
+// The returned channel is closed once the goroutine has exited, so shutdown can
+// wait for a pass in progress
+func startExpiryCleanup(ctx context.Context, cleaner ExpiryCleaner, lifetime, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			runExpiryCleanup(cleaner, lifetime)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// runExpiryCleanup performs one cleanup pass, logging what was deleted
+func runExpiryCleanup(cleaner ExpiryCleaner, lifetime time.Duration) {
+	emails, addresses, err := cleaner.CleanupExpired(lifetime)
+	if err != nil {
+		slog.Warn("Expiry cleanup failed", "error", err)
+	} else if emails > 0 || addresses > 0 {
+		slog.Info("Cleanup: Deleted expired addresses and emails", "addresses", addresses, "emails", emails, "older_than", lifetime)
+	}
+
+	// Objects also go stale through retention and API deletes, so sweep even
+	// when the pass above failed
+	sweeper, ok := cleaner.(AttachmentSweeper)
+	if !ok {
+		return
+	}
+	deleted, err := sweeper.SweepAttachmentStore(attachmentSweepGrace)
+	if err != nil {
+		slog.Warn("Attachment sweep failed", "deleted", deleted, "error", err)
+	} else if deleted > 0 {
+		slog.Info("Cleanup: Deleted unreferenced attachment objects", "deleted", deleted)
+	}
+}
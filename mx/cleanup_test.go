@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExpiryCleaner counts cleanup passes and the lifetimes they were given
+type fakeExpiryCleaner struct {
+	mu        sync.Mutex
+	passes    int
+	lifetimes []time.Duration
+}
+
+func (f *fakeExpiryCleaner) CleanupExpired(lifetime time.Duration) (int64, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.passes++
+	f.lifetimes = append(f.lifetimes, lifetime)
+	return 1, 1, nil
+}
+
+func (f *fakeExpiryCleaner) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.passes
+}
+
+func TestStartExpiryCleanup(t *testing.T) {
+	cleaner := &fakeExpiryCleaner{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startExpiryCleanup(ctx, cleaner, 24*time.Hour, 10*time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for cleaner.count() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("cleanup ran %d times, want at least 3", cleaner.count())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("cleanup goroutine did not stop after cancel")
+	}
+
+	passes := cleaner.count()
+	time.Sleep(30 * time.Millisecond)
+	if cleaner.count() != passes {
+		t.Errorf("cleanup ran after shutdown")
+	}
+	for _, lifetime := range cleaner.lifetimes {
+		if lifetime != 24*time.Hour {
+			t.Errorf("CleanupExpired() lifetime = %s, want 24h", lifetime)
+		}
+	}
+}
+
+// sweepingCleaner also sweeps the attachment store, failing its cleanup pass
+type sweepingCleaner struct {
+	sweeps []time.Duration
+}
+
+func (s *sweepingCleaner) CleanupExpired(time.Duration) (int64, int64, error) {
+	return 0, 0, errors.New("database unavailable")
+}
+
+func (s *sweepingCleaner) SweepAttachmentStore(grace time.Duration) (int, error) {
+	s.sweeps = append(s.sweeps, grace)
+	return 2, nil
+}
+
+func TestRunExpiryCleanupSweepsAttachments(t *testing.T) {
+	cleaner := &sweepingCleaner{}
+	runExpiryCleanup(cleaner, 24*time.Hour)
+	if len(cleaner.sweeps) != 1 || cleaner.sweeps[0] != attachmentSweepGrace {
+		t.Errorf("sweeps = %v, want one with grace %s", cleaner.sweeps, attachmentSweepGrace)
+	}
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,22 +16,92 @@ import (
 type Config struct {
 	Domains []string `yaml:"domains"`
 
+	// DomainsConfig holds optional per-domain settings keyed by domain
+	DomainsConfig map[string]DomainConfig `yaml:"domains_config"`
+
 	Database struct {
 		URL      string `yaml:"url"`
 		PoolSize int    `yaml:"pool_size"`
+
+		// Pool tuning; unset values derive from pool_size
+		MaxOpenConns           int `yaml:"max_open_conns"`
+		MaxIdleConns           int `yaml:"max_idle_conns"`
+		ConnMaxLifetimeMinutes int `yaml:"conn_max_lifetime_minutes"`
+
+		// Deadline for the queries made while receiving a message
+		QueryTimeoutSeconds int `yaml:"query_timeout_seconds"`
+		// Retries of a message store after a transient error
+		MaxRetries int `yaml:"max_retries"`
 	} `yaml:"database"`
 
 	Server struct {
-		APIPort        int    `yaml:"api_port"`
-		MXPort         int    `yaml:"mx_port"`
-		MaxMsgSizeMB   int    `yaml:"max_message_size_mb"`
-		Hostname       string `yaml:"hostname"`
+		APIPort      int    `yaml:"api_port"`
+		MXPort       int    `yaml:"mx_port"`
+		MaxMsgSizeMB int    `yaml:"max_message_size_mb"`
+		Hostname     string `yaml:"hostname"`
+
+		// SenderMaxMsgSizeMB raises max_message_size_mb for trusted sender domains
+		// Keyed on the MAIL FROM domain; only applied once SPF or aligned DMARC
+		// authenticates it, so without validation the global limit holds
+		SenderMaxMsgSizeMB map[string]int `yaml:"sender_max_message_size_mb"`
+
+		// ShutdownGraceSeconds is how long a shutdown waits for active sessions
+		// to finish before closing them (0 = default 30)
+		ShutdownGraceSeconds int `yaml:"shutdown_grace_seconds"`
+
+		// ProxyProtocol expects a PROXY protocol v1 or v2 header on every
+		// connection and takes the client address from it
+		// ProxyTrustedCIDRs lists the load balancers allowed to send one
+		ProxyProtocol     bool     `yaml:"proxy_protocol"`
+		ProxyTrustedCIDRs []string `yaml:"proxy_trusted_cidrs"`
+
+		// HealthPort serves /healthz, /readyz and /debug/vars over HTTP (0 = disabled)
+		HealthPort int `yaml:"health_port"`
+
+		// ReadTimeoutSeconds and WriteTimeoutSeconds bound each command read
+		// and reply write (0 = default 30)
+		ReadTimeoutSeconds  int `yaml:"read_timeout_seconds"`
+		WriteTimeoutSeconds int `yaml:"write_timeout_seconds"`
+
+		// MaxSessionSeconds closes a connection still open after this long,
+		// however active it is (0 = default 600)
+		MaxSessionSeconds int `yaml:"max_session_seconds"`
+
+		// MaxRecipients caps accepted RCPT TO commands per message; what
+		// happens past it is recipients.overflow (0 = default 50)
+		MaxRecipients int `yaml:"max_recipients"`
 	} `yaml:"server"`
 
 	TLS struct {
 		Enabled  bool   `yaml:"enabled"`
 		CertFile string `yaml:"cert_file"`
 		KeyFile  string `yaml:"key_file"`
+
+		// SessionTickets toggles TLS session ticket resumption.
+		// nil keeps Go's default (enabled).
+		SessionTickets *bool `yaml:"session_tickets"`
+
+		// Require refuses MAIL FROM with 530 until the client has issued
+		// STARTTLS; require_by_network entries override it per network
+		Require bool `yaml:"require"`
+
+		// RequireByNetwork maps client CIDRs to whether STARTTLS is required
+		// before MAIL FROM; the most specific match wins
+		RequireByNetwork map[string]bool `yaml:"require_by_network"`
+
+		// ACME obtains and renews certificates automatically (e.g. Let's
+		// Encrypt) instead of loading cert_file and key_file
+		ACME struct {
+			Enabled bool     `yaml:"enabled"`
+			Email   string   `yaml:"email"`
+			Domains []string `yaml:"domains"` // defaults to server.hostname
+			// CacheDir keeps the account key and issued certificates
+			CacheDir string `yaml:"cache_dir"`
+			// ChallengeAddr serves TLS-ALPN-01 challenges; the CA connects on port 443
+			ChallengeAddr string `yaml:"challenge_addr"`
+			// DirectoryURL selects the CA; empty means Let's Encrypt production
+			DirectoryURL string `yaml:"directory_url"`
+		} `yaml:"acme"`
 	} `yaml:"tls"`
 
 	Tempmail struct {
@@ -34,6 +109,40 @@ type Config struct {
 		MaxEmailsPerAddress  int    `yaml:"max_emails_per_address"`
 		CleanupIntervalHours int    `yaml:"cleanup_interval_hours"`
 		AddressFormat        string `yaml:"address_format"`
+
+		// MaxBytesPerAddress deletes an address's oldest emails once its messages and
+		// attachments together exceed this many bytes (0 = no byte quota)
+		MaxBytesPerAddress int64 `yaml:"max_bytes_per_address"`
+
+		// Deduplicate drops a message whose Message-ID the recipient address
+		// already has; messages without a Message-ID are always stored
+		Deduplicate bool `yaml:"deduplicate"`
+
+		// BounceAddress stores null-sender mail (MAIL FROM:<>, i.e. bounces and
+		// other DSNs) under this one address instead of each recipient's;
+		// the original RCPT is kept as delivered_to (empty = store normally)
+		BounceAddress string `yaml:"bounce_address"`
+
+		// AutoCreateAddresses creates an unknown recipient address on its first
+		// email instead of rejecting it, so the MX works without the API;
+		// domains_config.<domain>.max_addresses still applies
+		AutoCreateAddresses bool `yaml:"auto_create_addresses"`
+
+		// DetectLanguage stores the detected body language with each email
+		DetectLanguage bool `yaml:"detect_language"`
+
+		// AddMissingDate adds a Date header with the received time to stored
+		// messages that arrive without one
+		AddMissingDate bool `yaml:"add_missing_date"`
+
+		// NormalizeSubjects collapses whitespace runs (newlines, tabs, repeated
+		// spaces) in stored subjects; the original is kept as raw_subject
+		NormalizeSubjects bool `yaml:"normalize_subjects"`
+
+		// MaxTotalAttachmentSizeMB caps the decoded size of all attachments in a message (0 = unlimited)
+		// AttachmentTotalAction is reject (552) or flag (mark the attachments past the cap suspicious)
+		MaxTotalAttachmentSizeMB int    `yaml:"max_total_attachment_size_mb"`
+		AttachmentTotalAction    string `yaml:"attachment_total_action"`
 	} `yaml:"tempmail"`
 
 	Validation struct {
@@ -41,14 +150,278 @@ type Config struct {
 		CheckSPF     bool `yaml:"check_spf"`
 		CheckDMARC   bool `yaml:"check_dmarc"`
 		StoreResults bool `yaml:"store_results"`
+
+		// CheckARC verifies ARC chains (forwarded and mailing list mail); results
+		// preserved by a passing chain count for DMARC only when the latest
+		// sealer's d= is listed in ARCTrustedSealers
+		CheckARC          bool     `yaml:"check_arc"`
+		ARCTrustedSealers []string `yaml:"arc_trusted_sealers"`
+
+		// MinDKIMKeyBits rejects DKIM signatures made with RSA keys below this size
+		// 0 keeps the verifier's floor of 1024 bits
+		MinDKIMKeyBits int `yaml:"min_dkim_key_bits"`
+
+		// TrustExistingAuthResults uses Authentication-Results added by an upstream
+		// MTA identified by TrustedAuthservID instead of re-running those checks
+		// Only clients in TrustedUpstreamIPs (IPs/CIDRs) are believed; anyone else
+		// could write that header themselves
+		TrustExistingAuthResults bool     `yaml:"trust_existing_authresults"`
+		TrustedAuthservID        string   `yaml:"trusted_authserv_id"`
+		TrustedUpstreamIPs       []string `yaml:"trusted_upstream_ips"`
+
+		// RejectPTRPattern refuses clients whose reverse DNS matches this regex
+		// (generic/dynamic hostnames); PTRFailClosed also tempfails when the lookup fails
+		RejectPTRPattern  string `yaml:"reject_ptr_pattern"`
+		PTRTimeoutSeconds int    `yaml:"ptr_timeout_seconds"`
+		PTRFailClosed     bool   `yaml:"ptr_fail_closed"`
+
+		// DKIMKeyOverrides maps selector._domainkey.domain to a key record,
+		// consulted before DNS (testing, air-gapped setups)
+		DKIMKeyOverrides map[string]string `yaml:"dkim_key_overrides"`
+
+		// MailFromSyntax sets how strictly MAIL FROM is checked: off, basic or strict
+		MailFromSyntax string `yaml:"mail_from_syntax"`
+
+		// RejectUnauthenticated handles mail failing SPF, DKIM and DMARC at once:
+		// allow, flag or reject; UnauthenticatedNoneFails also counts "none" as failing
+		RejectUnauthenticated    string `yaml:"reject_unauthenticated"`
+		UnauthenticatedNoneFails bool   `yaml:"unauthenticated_none_fails"`
+
+		// DKIMMisaligned handles valid DKIM signatures from a domain other than the
+		// From: header's when that domain has no DMARC policy: allow, flag or reject
+		DKIMMisaligned string `yaml:"dkim_misaligned"`
+
+		// EnforceDMARC honors the sender's published policy when DMARC fails:
+		// p=reject is refused at DATA, p=quarantine is stored flagged (subject to pct=)
+		EnforceDMARC bool `yaml:"enforce_dmarc"`
 	} `yaml:"validation"`
 
+	Attachments struct {
+		// DoubleExtension handles names like "invoice.pdf.exe": allow, flag or reject
+		DoubleExtension string `yaml:"double_extension"`
+	} `yaml:"attachments"`
+
+	Recipients struct {
+		// MaxRecipients is a deprecated alias for server.max_recipients
+		MaxRecipients int `yaml:"max_recipients"`
+		// MaxStoredCopies caps how many recipients a message is stored for (0 = unlimited)
+		MaxStoredCopies int `yaml:"max_stored_copies"`
+		// MaxStoredMB caps message size x stored copies (0 = unlimited)
+		MaxStoredMB int `yaml:"max_stored_mb"`
+		// Overflow is reject (4xx/5xx) or drop (accept, store up to the limit)
+		Overflow string `yaml:"overflow"`
+	} `yaml:"recipients"`
+
+	Privacy struct {
+		// StripTrackers removes open-tracking pixels from stored HTML
+		StripTrackers bool `yaml:"strip_trackers"`
+		// TrackerDomains replaces the built-in list of tracker hosts
+		TrackerDomains []string `yaml:"tracker_domains"`
+		// ImageProxyBase rewrites remote image URLs (img src/srcset, picture sources,
+		// background attributes, CSS url()) to this prefix + the escaped original
+		ImageProxyBase string `yaml:"image_proxy_base"`
+		// RedactReceivedIPs hides IPs in stored Received headers: off, mask or remove
+		RedactReceivedIPs string `yaml:"redact_received_ips"`
+	} `yaml:"privacy"`
+
+	Spam struct {
+		// DetectImageSpam flags messages that are an image with negligible text
+		DetectImageSpam bool `yaml:"detect_image_spam"`
+		// ImageSpamMaxTextChars is the text length below which the body counts as negligible
+		ImageSpamMaxTextChars int `yaml:"image_spam_max_text_chars"`
+		// DetectBCCOnly flags messages with no To/Cc header (all recipients BCC'd)
+		DetectBCCOnly bool `yaml:"detect_bcc_only"`
+		// HeaderRecipientMatch handles single-recipient mail whose RCPT TO is
+		// missing from To/Cc: allow, flag (default) or reject
+		HeaderRecipientMatch string `yaml:"header_recipient_match"`
+		// ScoreMessages stores a spam verdict (score, rules, disposition) per message
+		ScoreMessages bool `yaml:"score_messages"`
+		// FlagThreshold is the score at which the disposition becomes flag
+		FlagThreshold float64 `yaml:"flag_threshold"`
+	} `yaml:"spam"`
+
+	RateLimit struct {
+		// MessagesPerMinute is the base per-IP message rate (0 disables rate limiting)
+		MessagesPerMinute float64 `yaml:"messages_per_minute"`
+		// Reputation moves the rate between these bounds (default: the base rate)
+		MinMessagesPerMinute float64 `yaml:"min_messages_per_minute"`
+		MaxMessagesPerMinute float64 `yaml:"max_messages_per_minute"`
+		// MaxConnectionsPerIP caps concurrent sessions from one client IP (0 = unlimited)
+		MaxConnectionsPerIP int `yaml:"max_connections_per_ip"`
+	} `yaml:"ratelimit"`
+
+	Blocklist struct {
+		// IPs lists client IPs/CIDRs refused at connection time
+		IPs []string `yaml:"ips"`
+		// AllowIPs lists IPs/CIDRs accepted even when a blocklist entry matches
+		AllowIPs []string `yaml:"allow_ips"`
+		// BlockedSenderDomains rejects MAIL FROM domains: exact names, or *.domain for subdomains
+		BlockedSenderDomains []string `yaml:"blocked_sender_domains"`
+	} `yaml:"blocklist"`
+
+	Greylist struct {
+		// Enabled tempfails the first attempt of each (client IP, sender, recipient) triple
+		Enabled bool `yaml:"enabled"`
+		// DelayMinutes is how long a triple must wait before a retry is accepted
+		DelayMinutes int `yaml:"delay_minutes"`
+		// ExpiryHours forgets triples not seen for this long; they are greylisted again
+		ExpiryHours int `yaml:"expiry_hours"`
+		// ResponseCode is the tempfail code for greylisted attempts (450 or 451)
+		ResponseCode int `yaml:"response_code"`
+		// ResponseMessage is the text sent with the tempfail
+		ResponseMessage string `yaml:"response_message"`
+		// BypassIPs lists IPs/CIDRs that are never greylisted
+		BypassIPs []string `yaml:"bypass_ips"`
+		// BypassSenders lists sender addresses or domains that are never greylisted
+		BypassSenders []string `yaml:"bypass_senders"`
+	} `yaml:"greylist"`
+
+	DNSBL struct {
+		// Zones are DNS blocklists the client IP is checked against at
+		// MAIL FROM (e.g. zen.spamhaus.org); a listed client gets a 554
+		Zones []string `yaml:"zones"`
+		// TimeoutSeconds bounds all zone lookups together (0 = default 2)
+		TimeoutSeconds int `yaml:"timeout_seconds"`
+	} `yaml:"dnsbl"`
+
+	Webhooks struct {
+		// BatchWindowSeconds coalesces emails to one recipient within the window
+		// into a single email.batch event (0 = one event per email)
+		BatchWindowSeconds int `yaml:"batch_window_seconds"`
+
+		// URL receives each event as a JSON POST signed with Secret
+		// (HMAC-SHA256 in X-Tempmail-Signature); empty disables delivery
+		URL            string `yaml:"url"`
+		Secret         string `yaml:"secret"`
+		TimeoutSeconds int    `yaml:"timeout_seconds"` // per attempt (0 = default 10)
+	} `yaml:"webhooks"`
+
+	Notify struct {
+		// Backend publishes a small event (email ID, recipient) per stored
+		// email to a message queue: redis or nats; empty disables it
+		Backend string `yaml:"backend"`
+		// URL is redis://[[user]:password@]host[:port] or
+		// nats://[user:password@|token@]host[:port]
+		URL string `yaml:"url"`
+		// Channel is the Redis channel or NATS subject (default tempmail.events)
+		Channel string `yaml:"channel"`
+	} `yaml:"notify"`
+
+	Storage struct {
+		// GlobalMaxGB caps total stored bytes (emails + attachments), 0 = unlimited
+		GlobalMaxGB float64 `yaml:"global_max_gb"`
+		// CheckIntervalMinutes is how often usage is recomputed
+		CheckIntervalMinutes int `yaml:"check_interval_minutes"`
+		// OverCapAction is tempfail (452 new mail) or cleanup (delete oldest data)
+		OverCapAction string `yaml:"over_cap_action"`
+		// AttachmentFailure is rollback (a failed attachment insert drops the
+		// message) or skip (store the message without that attachment)
+		AttachmentFailure string `yaml:"attachment_failure"`
+
+		// Backend is where attachment bytes live: postgres (attachments.data),
+		// filesystem or s3; the latter two leave only a storage key in the table
+		Backend string `yaml:"backend"`
+		// Path is the filesystem backend's root directory
+		Path string `yaml:"path"`
+		// S3 locates the s3 backend's bucket (path-style, any S3-compatible service)
+		S3 struct {
+			Endpoint  string `yaml:"endpoint"`
+			Region    string `yaml:"region"`
+			Bucket    string `yaml:"bucket"`
+			Prefix    string `yaml:"prefix"`
+			AccessKey string `yaml:"access_key"`
+			SecretKey string `yaml:"secret_key"`
+		} `yaml:"s3"`
+	} `yaml:"storage"`
+
+	Spool struct {
+		// Dir enables the write-ahead spool: accepted mail is fsynced here and
+		// acknowledged, then committed to the database in the background
+		Dir string `yaml:"dir"`
+		// RetryIntervalSeconds is how often pending entries are retried while the database is down
+		RetryIntervalSeconds int `yaml:"retry_interval_seconds"`
+	} `yaml:"spool"`
+
+	GeoIP struct {
+		// DBPath is a MaxMind (MMDB) country or combined database; empty disables GeoIP
+		DBPath string `yaml:"db_path"`
+		// ASNDBPath is an optional separate ASN database (e.g. GeoLite2-ASN)
+		ASNDBPath string `yaml:"asn_db_path"`
+	} `yaml:"geoip"`
+
+	Diagnostics struct {
+		// CheckMX verifies at startup that each domain's MX points at server.hostname
+		CheckMX bool `yaml:"check_mx"`
+		// CheckMXIntervalHours repeats the check periodically (0 = startup only)
+		CheckMXIntervalHours int `yaml:"check_mx_interval_hours"`
+	} `yaml:"diagnostics"`
+
+	// Responses replaces the text (not the codes) sent for a rejection category
+	Responses map[string]string `yaml:"responses"`
+
 	Logging struct {
-		Level  string `yaml:"level"`
+		// Level is the minimum level logged: debug, info (default), warn or error
+		Level string `yaml:"level"`
+
+		// Format is text (default) or json, one object per line
 		Format string `yaml:"format"`
+
+		// SampleRate is the fraction of connections whose routine logs are kept
+		// (0 or unset = all); rejections and errors are always logged
+		SampleRate float64 `yaml:"sample_rate"`
 	} `yaml:"logging"`
 }
 
+// Recipient modes (domains_config.<domain>.mode)
+const (
+	DomainModeCatchAll = "catch_all" // any local part is accepted and created on first mail
+	DomainModeExplicit = "explicit"  // only existing addresses; others get a 550 at RCPT
+)
+
+// DomainConfig holds per-domain settings
+type DomainConfig struct {
+	// Accepting controls whether new mail is accepted for the domain
+	// Unset means accepting; false retires the domain while existing
+	// addresses and emails stay readable through the API
+	Accepting *bool `yaml:"accepting"`
+
+	// CatchAllAddress stores mail for any local part of the domain under this one
+	// address; the original RCPT is kept as delivered_to
+	CatchAllAddress string `yaml:"catch_all_address"`
+
+	// Silent stores mail normally but suppresses outbound side effects
+	// (events and webhooks), for validating a new domain quietly
+	Silent bool `yaml:"silent"`
+
+	// MaxAddresses caps active addresses under the domain (0 = unlimited)
+	MaxAddresses int `yaml:"max_addresses"`
+
+	// Mode is catch_all or explicit. Unset follows tempmail.auto_create_addresses
+	// and tempfails unknown recipients, so a sender retries once the address
+	// has been created through the API
+	Mode string `yaml:"mode"`
+}
+
+// DBPool returns the database connection pool settings
+func (c *Config) DBPool() DBPoolConfig {
+	return DBPoolConfig{
+		MaxOpenConns:    c.Database.MaxOpenConns,
+		MaxIdleConns:    c.Database.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(c.Database.ConnMaxLifetimeMinutes) * time.Minute,
+	}
+}
+
+// IsAccepting reports whether the domain accepts new mail
+func (d DomainConfig) IsAccepting() bool {
+	return d.Accepting == nil || *d.Accepting
+}
+
+// CreatesAddresses reports whether unknown local parts are accepted and
+// created on first mail, given tempmail.auto_create_addresses
+func (d DomainConfig) CreatesAddresses(autoCreate bool) bool {
+	return d.Mode == DomainModeCatchAll || (d.Mode == "" && autoCreate)
+}
+
 // LoadConfig loads configuration from YAML file
 func LoadConfig(configPath string) (*Config, error) {
 	// Read YAML file
@@ -65,11 +438,128 @@ func LoadConfig(configPath string) (*Config, error) {
 
 	// Validate required fields
 	if cfg.Database.URL == "" {
-		return nil, fmt.Errorf("database.url is required")
+		return nil, configErrorf("database.url", "is required")
 	}
 
 	if len(cfg.Domains) == 0 {
-		return nil, fmt.Errorf("at least one domain is required")
+		return nil, configErrorf("domains", "must list at least one domain")
+	}
+	for i, domain := range cfg.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			return nil, configErrorf("domains", "has an empty entry")
+		}
+		cfg.Domains[i] = domain
+	}
+
+	// Normalize per-domain settings and make sure they refer to configured domains
+	if len(cfg.DomainsConfig) > 0 {
+		domains := make(map[string]bool, len(cfg.Domains))
+		for _, domain := range cfg.Domains {
+			domains[strings.ToLower(domain)] = true
+		}
+		normalized := make(map[string]DomainConfig, len(cfg.DomainsConfig))
+		for domain, domainCfg := range cfg.DomainsConfig {
+			domain = strings.ToLower(domain)
+			if !domains[domain] {
+				return nil, configErrorf("domains_config."+domain, "refers to a domain not listed in domains")
+			}
+			if domainCfg.CatchAllAddress != "" {
+				addr, err := mail.ParseAddress(domainCfg.CatchAllAddress)
+				if err != nil {
+					return nil, configErrorf("domains_config."+domain+".catch_all_address", "is not a valid address: %w", err)
+				}
+				catchAll := strings.ToLower(addr.Address)
+				if !domains[catchAll[strings.LastIndex(catchAll, "@")+1:]] {
+					return nil, configErrorf("domains_config."+domain+".catch_all_address", "must be in a configured domain")
+				}
+				domainCfg.CatchAllAddress = catchAll
+			}
+			if domainCfg.MaxAddresses < 0 {
+				return nil, configErrorf("domains_config."+domain+".max_addresses", "must not be negative")
+			}
+			switch domainCfg.Mode {
+			case "", DomainModeCatchAll, DomainModeExplicit:
+			default:
+				return nil, configErrorf("domains_config."+domain+".mode", "must be catch_all or explicit")
+			}
+			normalized[domain] = domainCfg
+		}
+		cfg.DomainsConfig = normalized
+	}
+
+	if err := validateRateLimitConfig(&cfg); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Spam.HeaderRecipientMatch {
+	case "":
+		cfg.Spam.HeaderRecipientMatch = RecipientMatchFlag
+	case RecipientMatchAllow, RecipientMatchFlag, RecipientMatchReject:
+	default:
+		return nil, configErrorf("spam.header_recipient_match", "must be allow, flag or reject")
+	}
+	if len(cfg.TLS.RequireByNetwork) > 0 {
+		if _, err := NewTLSPolicy(cfg.TLS.RequireByNetwork); err != nil {
+			return nil, configErrorf("tls.require_by_network", "has %v", err)
+		}
+		if !cfg.TLS.Enabled {
+			return nil, configErrorf("tls.require_by_network", "requires tls.enabled")
+		}
+	}
+	if cfg.TLS.Require && !cfg.TLS.Enabled {
+		return nil, configErrorf("tls.require", "requires tls.enabled")
+	}
+
+	if _, err := parseLogLevel(cfg.Logging.Level); err != nil {
+		return nil, configErrorf("logging.level", "must be debug, info, warn or error")
+	}
+	switch strings.ToLower(cfg.Logging.Format) {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return nil, configErrorf("logging.format", "must be text or json")
+	}
+
+	if cfg.Logging.SampleRate < 0 || cfg.Logging.SampleRate > 1 {
+		return nil, configErrorf("logging.sample_rate", "must be between 0 and 1")
+	}
+
+	if err := validateResponses(&cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Spam.FlagThreshold < 0 {
+		return nil, configErrorf("spam.flag_threshold", "must not be negative")
+	}
+
+	if cfg.Tempmail.MaxTotalAttachmentSizeMB < 0 {
+		return nil, configErrorf("tempmail.max_total_attachment_size_mb", "must not be negative")
+	}
+	switch cfg.Tempmail.AttachmentTotalAction {
+	case "":
+		cfg.Tempmail.AttachmentTotalAction = AttachmentActionReject
+	case AttachmentActionFlag, AttachmentActionReject:
+	default:
+		return nil, configErrorf("tempmail.attachment_total_action", "must be flag or reject")
+	}
+	if cfg.Tempmail.BounceAddress != "" {
+		addr, err := mail.ParseAddress(cfg.Tempmail.BounceAddress)
+		if err != nil {
+			return nil, configErrorf("tempmail.bounce_address", "is not a valid address: %w", err)
+		}
+		bounce := strings.ToLower(addr.Address)
+		if _, ok := cfg.GetDomainMap()[addressDomain(bounce)]; !ok {
+			return nil, configErrorf("tempmail.bounce_address", "must be in a configured domain")
+		}
+		cfg.Tempmail.BounceAddress = bounce
+	}
+
+	switch cfg.Attachments.DoubleExtension {
+	case "":
+		cfg.Attachments.DoubleExtension = AttachmentActionAllow
+	case AttachmentActionAllow, AttachmentActionFlag, AttachmentActionReject:
+	default:
+		return nil, configErrorf("attachments.double_extension", "must be allow, flag or reject")
 	}
 
 	// Set defaults
@@ -82,11 +572,295 @@ func LoadConfig(configPath string) (*Config, error) {
 	if cfg.Server.MaxMsgSizeMB == 0 {
 		cfg.Server.MaxMsgSizeMB = 10
 	}
+	if cfg.Server.ShutdownGraceSeconds < 0 {
+		return nil, configErrorf("server.shutdown_grace_seconds", "must not be negative")
+	}
+	if cfg.Server.ShutdownGraceSeconds == 0 {
+		cfg.Server.ShutdownGraceSeconds = defaultShutdownGraceSeconds
+	}
+	for key, seconds := range map[string]*int{
+		"server.read_timeout_seconds":  &cfg.Server.ReadTimeoutSeconds,
+		"server.write_timeout_seconds": &cfg.Server.WriteTimeoutSeconds,
+		"server.max_session_seconds":   &cfg.Server.MaxSessionSeconds,
+	} {
+		if *seconds < 0 {
+			return nil, configErrorf(key, "must not be negative")
+		}
+	}
+	if cfg.Server.ReadTimeoutSeconds == 0 {
+		cfg.Server.ReadTimeoutSeconds = defaultIOTimeoutSeconds
+	}
+	if cfg.Server.WriteTimeoutSeconds == 0 {
+		cfg.Server.WriteTimeoutSeconds = defaultIOTimeoutSeconds
+	}
+	if cfg.Server.MaxSessionSeconds == 0 {
+		cfg.Server.MaxSessionSeconds = defaultMaxSessionSeconds
+	}
+	if cfg.Server.MaxRecipients < 0 {
+		return nil, configErrorf("server.max_recipients", "must not be negative")
+	}
+	if cfg.Recipients.MaxRecipients > 0 {
+		if cfg.Server.MaxRecipients != 0 && cfg.Server.MaxRecipients != cfg.Recipients.MaxRecipients {
+			return nil, configErrorf("recipients.max_recipients", "conflicts with server.max_recipients; set only server.max_recipients")
+		}
+		cfg.Server.MaxRecipients = cfg.Recipients.MaxRecipients
+	}
+	if cfg.Server.MaxRecipients == 0 {
+		cfg.Server.MaxRecipients = defaultMaxRecipients
+	}
+	if cfg.Server.ProxyProtocol && len(cfg.Server.ProxyTrustedCIDRs) == 0 {
+		return nil, configErrorf("server.proxy_trusted_cidrs", "is required with proxy_protocol")
+	}
+	for _, entry := range cfg.Server.ProxyTrustedCIDRs {
+		if _, err := parseCIDROrIP(entry); err != nil {
+			return nil, configErrorf("server.proxy_trusted_cidrs", "has invalid IP or CIDR %q", entry)
+		}
+	}
+	if cfg.Server.HealthPort < 0 || cfg.Server.HealthPort > 65535 {
+		return nil, configErrorf("server.health_port", "must be between 0 and 65535")
+	}
+	if cfg.Server.HealthPort != 0 && cfg.Server.HealthPort == cfg.Server.MXPort {
+		return nil, configErrorf("server.health_port", "must differ from server.mx_port")
+	}
+	if len(cfg.Server.SenderMaxMsgSizeMB) > 0 {
+		overrides := make(map[string]int, len(cfg.Server.SenderMaxMsgSizeMB))
+		for domain, sizeMB := range cfg.Server.SenderMaxMsgSizeMB {
+			// Overrides may only raise the limit
+			if sizeMB <= cfg.Server.MaxMsgSizeMB {
+				return nil, configErrorf("server.sender_max_message_size_mb", "%s (%d MB) must exceed max_message_size_mb (%d MB)",
+					domain, sizeMB, cfg.Server.MaxMsgSizeMB)
+			}
+			overrides[strings.ToLower(strings.TrimSuffix(domain, "."))] = sizeMB
+		}
+		cfg.Server.SenderMaxMsgSizeMB = overrides
+	}
 	if cfg.Database.PoolSize == 0 {
 		cfg.Database.PoolSize = 10
 	}
+	if cfg.Database.MaxOpenConns < 0 || cfg.Database.MaxIdleConns < 0 || cfg.Database.ConnMaxLifetimeMinutes < 0 {
+		return nil, configErrorf("database", "pool settings must not be negative")
+	}
+	if cfg.Database.MaxOpenConns == 0 {
+		cfg.Database.MaxOpenConns = cfg.Database.PoolSize
+	}
+	if cfg.Database.MaxIdleConns == 0 {
+		cfg.Database.MaxIdleConns = cfg.Database.MaxOpenConns / 2
+	}
+	if cfg.Database.MaxIdleConns > cfg.Database.MaxOpenConns {
+		return nil, configErrorf("database.max_idle_conns", "must not exceed max_open_conns")
+	}
+	if cfg.Database.ConnMaxLifetimeMinutes == 0 {
+		cfg.Database.ConnMaxLifetimeMinutes = 5
+	}
+	if cfg.Database.QueryTimeoutSeconds < 0 {
+		return nil, configErrorf("database.query_timeout_seconds", "must not be negative")
+	}
+	if cfg.Database.QueryTimeoutSeconds == 0 {
+		cfg.Database.QueryTimeoutSeconds = defaultQueryTimeoutSeconds
+	}
+	if cfg.Database.MaxRetries < 0 {
+		return nil, configErrorf("database.max_retries", "must not be negative")
+	}
+	if cfg.Database.MaxRetries == 0 {
+		cfg.Database.MaxRetries = defaultMaxRetries
+	}
+	if cfg.Tempmail.AddressLifetimeHours < 0 || cfg.Tempmail.CleanupIntervalHours < 0 {
+		return nil, configErrorf("tempmail", "address_lifetime_hours and cleanup_interval_hours must not be negative")
+	}
+	if cfg.Tempmail.AddressLifetimeHours == 0 {
+		cfg.Tempmail.AddressLifetimeHours = defaultAddressLifetimeHours
+	}
+	if cfg.Tempmail.CleanupIntervalHours == 0 {
+		cfg.Tempmail.CleanupIntervalHours = defaultCleanupIntervalHours
+	}
+	if cfg.Tempmail.MaxEmailsPerAddress < 0 {
+		return nil, configErrorf("tempmail.max_emails_per_address", "must not be negative")
+	}
 	if cfg.Tempmail.MaxEmailsPerAddress == 0 {
-		cfg.Tempmail.MaxEmailsPerAddress = 100
+		cfg.Tempmail.MaxEmailsPerAddress = defaultMaxEmailsPerAddress
+	}
+	// A quota below one message would delete mail as soon as it is stored
+	if quota := cfg.Tempmail.MaxBytesPerAddress; quota < 0 || (quota > 0 && quota < cfg.GetMaxMessageSize()) {
+		return nil, configErrorf("tempmail.max_bytes_per_address", "must be 0 (no quota) or at least max_message_size_mb (%d bytes)",
+			cfg.GetMaxMessageSize())
+	}
+
+	switch cfg.Validation.MailFromSyntax {
+	case "":
+		cfg.Validation.MailFromSyntax = MailFromSyntaxBasic
+	case MailFromSyntaxOff, MailFromSyntaxBasic, MailFromSyntaxStrict:
+	default:
+		return nil, configErrorf("validation.mail_from_syntax", "must be off, basic or strict")
+	}
+
+	switch cfg.Validation.RejectUnauthenticated {
+	case "":
+		cfg.Validation.RejectUnauthenticated = UnauthenticatedAllow
+	case UnauthenticatedAllow, UnauthenticatedFlag, UnauthenticatedReject:
+	default:
+		return nil, configErrorf("validation.reject_unauthenticated", "must be allow, flag or reject")
+	}
+
+	switch cfg.Validation.DKIMMisaligned {
+	case "":
+		cfg.Validation.DKIMMisaligned = DKIMMisalignedFlag
+	case DKIMMisalignedAllow, DKIMMisalignedFlag, DKIMMisalignedReject:
+	default:
+		return nil, configErrorf("validation.dkim_misaligned", "must be allow, flag or reject")
+	}
+
+	if base := cfg.Privacy.ImageProxyBase; base != "" {
+		if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, configErrorf("privacy.image_proxy_base", "must be an http(s) URL")
+		}
+	}
+	switch cfg.Privacy.RedactReceivedIPs {
+	case "":
+		cfg.Privacy.RedactReceivedIPs = RedactIPsOff
+	case RedactIPsOff, RedactIPsMask, RedactIPsRemove:
+	default:
+		return nil, configErrorf("privacy.redact_received_ips", "must be off, mask or remove")
+	}
+
+	if cfg.Recipients.MaxRecipients < 0 || cfg.Recipients.MaxStoredCopies < 0 || cfg.Recipients.MaxStoredMB < 0 {
+		return nil, configErrorf("recipients", "limits must not be negative")
+	}
+	switch cfg.Recipients.Overflow {
+	case "":
+		cfg.Recipients.Overflow = OverflowReject
+	case OverflowReject, OverflowDrop:
+	default:
+		return nil, configErrorf("recipients.overflow", "must be reject or drop")
+	}
+
+	if err := validateGreylistConfig(&cfg); err != nil {
+		return nil, err
+	}
+	if err := validateIPFilterConfig(&cfg); err != nil {
+		return nil, err
+	}
+
+	for i, zone := range cfg.DNSBL.Zones {
+		zone = strings.Trim(strings.ToLower(strings.TrimSpace(zone)), ".")
+		if zone == "" || strings.ContainsAny(zone, " /:@") {
+			return nil, configErrorf("dnsbl.zones", "has invalid zone %q", cfg.DNSBL.Zones[i])
+		}
+		cfg.DNSBL.Zones[i] = zone
+	}
+	if cfg.DNSBL.TimeoutSeconds < 0 {
+		return nil, configErrorf("dnsbl.timeout_seconds", "must not be negative")
+	}
+
+	if cfg.Webhooks.BatchWindowSeconds < 0 {
+		return nil, configErrorf("webhooks.batch_window_seconds", "must not be negative")
+	}
+	if cfg.Webhooks.URL != "" {
+		if u, err := url.Parse(cfg.Webhooks.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, configErrorf("webhooks.url", "must be an http(s) URL")
+		}
+		if cfg.Webhooks.Secret == "" {
+			return nil, configErrorf("webhooks.secret", "is required with webhooks.url")
+		}
+	}
+	if cfg.Webhooks.TimeoutSeconds < 0 {
+		return nil, configErrorf("webhooks.timeout_seconds", "must not be negative")
+	}
+	if cfg.Webhooks.TimeoutSeconds == 0 {
+		cfg.Webhooks.TimeoutSeconds = defaultWebhookTimeoutSeconds
+	}
+	if cfg.Notify.Backend != "" {
+		if _, err := NewPublisher(&cfg); err != nil {
+			return nil, err
+		}
+		if cfg.Notify.Channel == "" {
+			cfg.Notify.Channel = defaultNotifyChannel
+		}
+		if strings.ContainsAny(cfg.Notify.Channel, " \t\r\n") {
+			return nil, configErrorf("notify.channel", "must not contain whitespace")
+		}
+	}
+
+	if cfg.Storage.GlobalMaxGB < 0 {
+		return nil, configErrorf("storage.global_max_gb", "must not be negative")
+	}
+	switch cfg.Storage.OverCapAction {
+	case "":
+		cfg.Storage.OverCapAction = StorageActionTempfail
+	case StorageActionTempfail, StorageActionCleanup:
+	default:
+		return nil, configErrorf("storage.over_cap_action", "must be tempfail or cleanup")
+	}
+	switch cfg.Storage.AttachmentFailure {
+	case "":
+		cfg.Storage.AttachmentFailure = AttachmentFailureRollback
+	case AttachmentFailureRollback, AttachmentFailureSkip:
+	default:
+		return nil, configErrorf("storage.attachment_failure", "must be rollback or skip")
+	}
+	switch cfg.Storage.Backend {
+	case "":
+		cfg.Storage.Backend = StorageBackendPostgres
+	case StorageBackendPostgres:
+	case StorageBackendFilesystem:
+		if cfg.Storage.Path == "" {
+			return nil, configErrorf("storage.path", "is required with the filesystem backend")
+		}
+	case StorageBackendS3:
+		s3 := cfg.Storage.S3
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			return nil, configErrorf("storage.s3", "endpoint, bucket, access_key and secret_key are required with the s3 backend")
+		}
+		if cfg.Storage.S3.Region == "" {
+			cfg.Storage.S3.Region = "us-east-1"
+		}
+	default:
+		return nil, configErrorf("storage.backend", "must be postgres, filesystem or s3")
+	}
+	if cfg.Storage.CheckIntervalMinutes <= 0 {
+		cfg.Storage.CheckIntervalMinutes = 5
+	}
+	if cfg.Spool.RetryIntervalSeconds < 0 {
+		return nil, configErrorf("spool.retry_interval_seconds", "must not be negative")
+	}
+	if cfg.Spool.RetryIntervalSeconds == 0 {
+		cfg.Spool.RetryIntervalSeconds = defaultSpoolRetrySeconds
+	}
+
+	if cfg.Validation.RejectPTRPattern != "" {
+		if _, err := regexp.Compile(cfg.Validation.RejectPTRPattern); err != nil {
+			return nil, configErrorf("validation.reject_ptr_pattern", "is not a valid regex: %w", err)
+		}
+		if cfg.Validation.PTRTimeoutSeconds == 0 {
+			cfg.Validation.PTRTimeoutSeconds = defaultPTRTimeoutSeconds
+		}
+	}
+
+	if cfg.Validation.TrustExistingAuthResults && cfg.Validation.TrustedAuthservID == "" {
+		return nil, configErrorf("validation.trusted_authserv_id", "is required with trust_existing_authresults")
+	}
+	if cfg.Validation.TrustExistingAuthResults && len(cfg.Validation.TrustedUpstreamIPs) == 0 {
+		return nil, configErrorf("validation.trusted_upstream_ips", "is required with trust_existing_authresults")
+	}
+	for _, entry := range cfg.Validation.TrustedUpstreamIPs {
+		if _, err := parseCIDROrIP(entry); err != nil {
+			return nil, configErrorf("validation.trusted_upstream_ips", "has invalid IP or CIDR %q", entry)
+		}
+	}
+
+	if len(cfg.Validation.DKIMKeyOverrides) > 0 {
+		overrides := make(map[string]string, len(cfg.Validation.DKIMKeyOverrides))
+		for name, record := range cfg.Validation.DKIMKeyOverrides {
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			if !strings.Contains(name, "._domainkey.") {
+				return nil, configErrorf("validation.dkim_key_overrides", "key %q must be selector._domainkey.domain", name)
+			}
+			overrides[name] = record
+		}
+		cfg.Validation.DKIMKeyOverrides = overrides
+	}
+
+	if cfg.Validation.MinDKIMKeyBits < 0 {
+		return nil, configErrorf("validation.min_dkim_key_bits", "must not be negative")
 	}
 
 	// Set TLS defaults
@@ -96,6 +870,27 @@ func LoadConfig(configPath string) (*Config, error) {
 	if cfg.TLS.KeyFile == "" {
 		cfg.TLS.KeyFile = "/config/certs/key.pem"
 	}
+	if cfg.TLS.ACME.Enabled {
+		if !cfg.TLS.Enabled {
+			return nil, configErrorf("tls.acme.enabled", "requires tls.enabled")
+		}
+		if len(cfg.TLS.ACME.Domains) == 0 {
+			cfg.TLS.ACME.Domains = []string{cfg.Server.Hostname}
+		}
+		for i, d := range cfg.TLS.ACME.Domains {
+			d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+			if d == "" || strings.ContainsAny(d, " /:*") {
+				return nil, configErrorf("tls.acme.domains", "has invalid domain %q", cfg.TLS.ACME.Domains[i])
+			}
+			cfg.TLS.ACME.Domains[i] = d
+		}
+		if cfg.TLS.ACME.CacheDir == "" {
+			cfg.TLS.ACME.CacheDir = defaultACMECacheDir
+		}
+		if cfg.TLS.ACME.ChallengeAddr == "" {
+			cfg.TLS.ACME.ChallengeAddr = defaultACMEChallengeAddr
+		}
+	}
 
 	return &cfg, nil
 }
@@ -105,11 +900,96 @@ func (c *Config) GetMaxMessageSize() int64 {
 	return int64(c.Server.MaxMsgSizeMB) * 1024 * 1024
 }
 
-// GetDomainMap returns domains as a map for fast lookup
-func (c *Config) GetDomainMap() map[string]bool {
-	domains := make(map[string]bool)
+// MaxRecipients returns server.max_recipients, the default when unset
+func (c *Config) MaxRecipients() int {
+	if c.Server.MaxRecipients <= 0 {
+		return defaultMaxRecipients
+	}
+	return c.Server.MaxRecipients
+}
+
+// ShutdownGrace returns server.shutdown_grace_seconds as a duration
+func (c *Config) ShutdownGrace() time.Duration {
+	return time.Duration(c.Server.ShutdownGraceSeconds) * time.Second
+}
+
+// ReadTimeout returns server.read_timeout_seconds as a duration
+func (c *Config) ReadTimeout() time.Duration {
+	return time.Duration(c.Server.ReadTimeoutSeconds) * time.Second
+}
+
+// WriteTimeout returns server.write_timeout_seconds as a duration
+func (c *Config) WriteTimeout() time.Duration {
+	return time.Duration(c.Server.WriteTimeoutSeconds) * time.Second
+}
+
+// MaxSession returns server.max_session_seconds as a duration
+func (c *Config) MaxSession() time.Duration {
+	return time.Duration(c.Server.MaxSessionSeconds) * time.Second
+}
+
+// QueryTimeout returns database.query_timeout_seconds as a duration
+func (c *Config) QueryTimeout() time.Duration {
+	return time.Duration(c.Database.QueryTimeoutSeconds) * time.Second
+}
+
+// MaxMessageSizeFor returns max message size in bytes for a sender domain,
+// which is the global limit unless an override raises it
+func (c *Config) MaxMessageSizeFor(senderDomain string) int64 {
+	if sizeMB, ok := c.Server.SenderMaxMsgSizeMB[strings.ToLower(senderDomain)]; ok && sizeMB > c.Server.MaxMsgSizeMB {
+		return int64(sizeMB) * 1024 * 1024
+	}
+	return c.GetMaxMessageSize()
+}
+
+// LargestMessageSize returns the highest limit any sender may use, for the SMTP layer
+func (c *Config) LargestMessageSize() int64 {
+	largest := c.GetMaxMessageSize()
+	for domain := range c.Server.SenderMaxMsgSizeMB {
+		if size := c.MaxMessageSizeFor(domain); size > largest {
+			largest = size
+		}
+	}
+	return largest
+}
+
+// GetDomainMap returns each accepted domain with its settings, for fast
+// lookup; domains without a domains_config entry get the zero value
+func (c *Config) GetDomainMap() map[string]DomainConfig {
+	domains := make(map[string]DomainConfig, len(c.Domains))
 	for _, domain := range c.Domains {
-		domains[domain] = true
+		domains[domain] = c.GetDomainConfig(domain)
 	}
 	return domains
 }
+
+// GetDomainConfig returns the per-domain settings for a domain
+// Domains without an entry get the zero value (all defaults)
+func (c *Config) GetDomainConfig(domain string) DomainConfig {
+	return c.DomainsConfig[strings.ToLower(domain)]
+}
+
+// validateRateLimitConfig checks the ratelimit section and defaults the reputation bounds
+func validateRateLimitConfig(cfg *Config) error {
+	rl := &cfg.RateLimit
+	if rl.MessagesPerMinute < 0 || rl.MinMessagesPerMinute < 0 || rl.MaxMessagesPerMinute < 0 {
+		return configErrorf("ratelimit", "rates must not be negative")
+	}
+	if rl.MaxConnectionsPerIP < 0 {
+		return configErrorf("ratelimit.max_connections_per_ip", "must not be negative")
+	}
+	if rl.MessagesPerMinute == 0 {
+		return nil
+	}
+
+	if rl.MinMessagesPerMinute == 0 {
+		rl.MinMessagesPerMinute = rl.MessagesPerMinute
+	}
+	if rl.MaxMessagesPerMinute == 0 {
+		rl.MaxMessagesPerMinute = rl.MessagesPerMinute
+	}
+	if rl.MinMessagesPerMinute > rl.MessagesPerMinute || rl.MaxMessagesPerMinute < rl.MessagesPerMinute {
+		return configErrorf("ratelimit", "requires min_messages_per_minute <= messages_per_minute <= max_messages_per_minute")
+	}
+	return nil
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -235,15 +237,8 @@ func TestConfigGetMaxMessageSize(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := &Config{
-				Server: struct {
-					APIPort        int    `yaml:"api_port"`
-					MXPort         int    `yaml:"mx_port"`
-					MaxMsgSizeMB   int    `yaml:"max_message_size_mb"`
-					Hostname       string `yaml:"hostname"`
-				}{
-					MaxMsgSizeMB: tt.sizeMB,
-				},
 			}
+			cfg.Server.MaxMsgSizeMB = tt.sizeMB
 
 			if got := cfg.GetMaxMessageSize(); got != tt.wantBytes {
 				t.Errorf("GetMaxMessageSize() = %v, want %v", got, tt.wantBytes)
@@ -265,13 +260,13 @@ func TestConfigGetDomainMap(t *testing.T) {
 
 	expectedDomains := []string{"tempmail.example.com", "temp.test", "mail.local"}
 	for _, domain := range expectedDomains {
-		if !domainMap[domain] {
+		if _, ok := domainMap[domain]; !ok {
 			t.Errorf("GetDomainMap() missing domain %v", domain)
 		}
 	}
 
 	// Check that non-configured domain is not present
-	if domainMap["notconfigured.com"] {
+	if _, ok := domainMap["notconfigured.com"]; ok {
 		t.Error("GetDomainMap() should not contain unconfigured domains")
 	}
 }
@@ -308,3 +303,421 @@ tls:
 		t.Errorf("LoadConfig() default KeyFile = %v, want /config/certs/key.pem", cfg.TLS.KeyFile)
 	}
 }
+
+func TestLoadConfigDomainsConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "test.yaml")
+
+	config := `
+domains:
+  - tempmail.test
+  - retired.test
+database:
+  url: postgresql://localhost/tempmail
+domains_config:
+  Retired.Test:
+    accepting: false
+`
+
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	if cfg.GetDomainConfig("retired.test").IsAccepting() {
+		t.Error("retired.test should not be accepting")
+	}
+
+	if !cfg.GetDomainConfig("tempmail.test").IsAccepting() {
+		t.Error("tempmail.test should be accepting by default")
+	}
+}
+
+func TestLoadConfigDomainsConfigUnknownDomain(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "test.yaml")
+
+	config := `
+domains:
+  - tempmail.test
+database:
+  url: postgresql://localhost/tempmail
+domains_config:
+  other.test:
+    accepting: false
+`
+
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("LoadConfig() should reject domains_config for an unlisted domain")
+	}
+}
+
+func TestLoadConfigDBPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		database string
+		want     DBPoolConfig
+		wantErr  bool
+	}{
+		{
+			name:     "derived from pool_size",
+			database: "  pool_size: 20\n",
+			want:     DBPoolConfig{MaxOpenConns: 20, MaxIdleConns: 10, ConnMaxLifetime: 5 * time.Minute},
+		},
+		{
+			name:     "explicit settings",
+			database: "  max_open_conns: 30\n  max_idle_conns: 4\n  conn_max_lifetime_minutes: 15\n",
+			want:     DBPoolConfig{MaxOpenConns: 30, MaxIdleConns: 4, ConnMaxLifetime: 15 * time.Minute},
+		},
+		{
+			name:     "idle above open",
+			database: "  max_open_conns: 5\n  max_idle_conns: 10\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\n" + tt.database
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := cfg.DBPool(); got != tt.want {
+				t.Errorf("DBPool() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigSenderMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		wantErr bool
+	}{
+		{"raises limit", "  max_message_size_mb: 10\n  sender_max_message_size_mb:\n    Partner.Example: 50\n", false},
+		{"does not raise limit", "  max_message_size_mb: 10\n  sender_max_message_size_mb:\n    partner.example: 5\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\nserver:\n" + tt.server
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := cfg.MaxMessageSizeFor("partner.example"); got != 50*1024*1024 {
+				t.Errorf("MaxMessageSizeFor(partner.example) = %d, want 50 MB", got)
+			}
+			if got := cfg.MaxMessageSizeFor("other.example"); got != 10*1024*1024 {
+				t.Errorf("MaxMessageSizeFor(other.example) = %d, want 10 MB", got)
+			}
+			if got := cfg.LargestMessageSize(); got != 50*1024*1024 {
+				t.Errorf("LargestMessageSize() = %d, want 50 MB", got)
+			}
+		})
+	}
+}
+
+func TestLoadConfigCatchAllAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		catchAll string
+		want     string
+		wantErr  bool
+	}{
+		{"normalized", "Inbox@Tempmail.Test", "inbox@tempmail.test", false},
+		{"invalid address", "not an address", "", true},
+		{"foreign domain", "inbox@elsewhere.example", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\n" +
+				"domains_config:\n  tempmail.test:\n    catch_all_address: \"" + tt.catchAll + "\"\n"
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := cfg.GetDomainConfig("tempmail.test").CatchAllAddress; got != tt.want {
+				t.Errorf("CatchAllAddress = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDomainMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		wantErr bool
+	}{
+		{"unset", "", false},
+		{"catch_all", DomainModeCatchAll, false},
+		{"explicit", DomainModeExplicit, false},
+		{"unknown", "open", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\n" +
+				"domains_config:\n  Tempmail.Test:\n    mode: \"" + tt.mode + "\"\n"
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.GetDomainMap()["tempmail.test"].Mode != tt.mode {
+				t.Errorf("GetDomainMap() mode = %q, want %q", cfg.GetDomainMap()["tempmail.test"].Mode, tt.mode)
+			}
+		})
+	}
+}
+
+func TestLoadConfigBounceAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		bounce  string
+		want    string
+		wantErr bool
+	}{
+		{"unset", "", "", false},
+		{"normalized", "Bounces@Tempmail.Test", "bounces@tempmail.test", false},
+		{"invalid address", "not an address", "", true},
+		{"foreign domain", "bounces@elsewhere.example", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\n" +
+				"tempmail:\n  bounce_address: \"" + tt.bounce + "\"\n"
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Tempmail.BounceAddress != tt.want {
+				t.Errorf("BounceAddress = %q, want %q", cfg.Tempmail.BounceAddress, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTLSRequire(t *testing.T) {
+	tests := []struct {
+		name    string
+		tls     string
+		wantErr bool
+	}{
+		{"with TLS enabled", "  enabled: true\n  require: true\n", false},
+		{"without TLS", "  enabled: false\n  require: true\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\ntls:\n" + tt.tls
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !cfg.TLS.Require {
+				t.Error("TLS.Require = false, want true")
+			}
+		})
+	}
+}
+
+func TestLoadConfigACME(t *testing.T) {
+	tests := []struct {
+		name        string
+		tls         string
+		wantErr     bool
+		wantDomains []string
+	}{
+		{"defaults to hostname", "  enabled: true\n  acme:\n    enabled: true\n", false, []string{"mx.tempmail.test"}},
+		{"listed domains", "  enabled: true\n  acme:\n    enabled: true\n    domains: [\"MX1.Tempmail.Test.\", mx2.tempmail.test]\n", false, []string{"mx1.tempmail.test", "mx2.tempmail.test"}},
+		{"without TLS", "  enabled: false\n  acme:\n    enabled: true\n", true, nil},
+		{"wildcard domain", "  enabled: true\n  acme:\n    enabled: true\n    domains: [\"*.tempmail.test\"]\n", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\nserver:\n  hostname: mx.tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\ntls:\n" + tt.tls
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(cfg.TLS.ACME.Domains, tt.wantDomains) {
+				t.Errorf("ACME.Domains = %v, want %v", cfg.TLS.ACME.Domains, tt.wantDomains)
+			}
+			if cfg.TLS.ACME.CacheDir != defaultACMECacheDir || cfg.TLS.ACME.ChallengeAddr != defaultACMEChallengeAddr {
+				t.Errorf("ACME cache_dir, challenge_addr = %q, %q; want defaults", cfg.TLS.ACME.CacheDir, cfg.TLS.ACME.ChallengeAddr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		webhooks string
+		wantErr  bool
+	}{
+		{"disabled", "  batch_window_seconds: 0\n", false},
+		{"url and secret", "  url: https://hooks.example.com/mail\n  secret: s3cret\n", false},
+		{"missing secret", "  url: https://hooks.example.com/mail\n", true},
+		{"not http", "  url: ftp://hooks.example.com/mail\n  secret: s3cret\n", true},
+		{"negative timeout", "  timeout_seconds: -1\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\nwebhooks:\n" + tt.webhooks
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Webhooks.TimeoutSeconds != defaultWebhookTimeoutSeconds {
+				t.Errorf("Webhooks.TimeoutSeconds = %d, want default %d", cfg.Webhooks.TimeoutSeconds, defaultWebhookTimeoutSeconds)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		server      string
+		wantRead    int
+		wantWrite   int
+		wantSession int
+		wantErr     bool
+	}{
+		{"defaults", "  mx_port: 25\n", defaultIOTimeoutSeconds, defaultIOTimeoutSeconds, defaultMaxSessionSeconds, false},
+		{"explicit", "  read_timeout_seconds: 120\n  write_timeout_seconds: 60\n  max_session_seconds: 900\n", 120, 60, 900, false},
+		{"negative read", "  read_timeout_seconds: -1\n", 0, 0, 0, true},
+		{"negative write", "  write_timeout_seconds: -1\n", 0, 0, 0, true},
+		{"negative session", "  max_session_seconds: -1\n", 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\nserver:\n" + tt.server
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.Server.ReadTimeoutSeconds != tt.wantRead || cfg.Server.WriteTimeoutSeconds != tt.wantWrite ||
+				cfg.Server.MaxSessionSeconds != tt.wantSession {
+				t.Errorf("timeouts = read %d, write %d, session %d; want %d, %d, %d",
+					cfg.Server.ReadTimeoutSeconds, cfg.Server.WriteTimeoutSeconds, cfg.Server.MaxSessionSeconds,
+					tt.wantRead, tt.wantWrite, tt.wantSession)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMaxRecipients(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		want    int
+		wantErr bool
+	}{
+		{"default", "  mx_port: 25\n", defaultMaxRecipients, false},
+		{"lower", "  max_recipients: 5\n", 5, false},
+		{"higher", "  max_recipients: 500\n", 500, false},
+		{"negative", "  max_recipients: -1\n", 0, true},
+		{"legacy alias", "  mx_port: 25\nrecipients:\n  max_recipients: 7\n", 7, false},
+		{"alias agrees", "  max_recipients: 7\nrecipients:\n  max_recipients: 7\n", 7, false},
+		{"alias conflicts", "  max_recipients: 50\nrecipients:\n  max_recipients: 7\n", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\nserver:\n" + tt.server
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			cfg, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Server.MaxRecipients != tt.want {
+				t.Errorf("Server.MaxRecipients = %d, want %d", cfg.Server.MaxRecipients, tt.want)
+			}
+		})
+	}
+}
@@ -1,36 +1,105 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // DB wraps the database connection
 type DB struct {
 	conn *sql.DB
+
+	// skipFailedAttachments stores a message without attachments whose insert
+	// failed instead of rolling it back (storage.attachment_failure: skip)
+	skipFailedAttachments bool
+
+	// maxEmailsPerAddress is tempmail.max_emails_per_address, used by EnforceEmailLimit
+	maxEmailsPerAddress int
+	// maxBytesPerAddress is tempmail.max_bytes_per_address, used by EnforceByteLimit
+	maxBytesPerAddress int64
+
+	// greylistDelay and greylistExpiry drive CheckGreylist (greylist.delay_minutes, expiry_hours)
+	greylistDelay  time.Duration
+	greylistExpiry time.Duration
+
+	// queryTimeout bounds background queries not tied to a session
+	// (database.query_timeout_seconds); zero means no deadline
+	queryTimeout time.Duration
+
+	// deduplicate skips storing a Message-ID the address already has
+	// (tempmail.deduplicate)
+	deduplicate bool
+
+	// addressLifetime is how long an address created by StoreEmail lasts
+	addressLifetime time.Duration
+
+	// attachmentStore keeps attachment bytes outside the database
+	// (storage.backend); nil stores them in attachments.data
+	attachmentStore Storage
+
+	// maxRetries and retryDelay control StoreEmail retries of transient
+	// errors (database.max_retries); zero values use the defaults
+	maxRetries int
+	retryDelay time.Duration
 }
 
 // EmailData represents an email to be stored
 type EmailData struct {
-	MessageID      string
-	Subject        string
-	FromAddr       string
-	ToAddr         string
-	RawHeaders     string
-	BodyPlain      string
-	BodyHTML       string
-	RawMessage     []byte
-	SizeBytes      int64
-	DKIMValid      *bool  // nullable
-	SPFResult      string // pass, fail, softfail, neutral, none, temperror, permerror
-	DMARCResult    string // pass, fail, none
-	HasAttachments bool
-	ReceivedAt     time.Time
+	MessageID          string
+	Subject            string
+	FromAddr           string
+	ReturnPath         string // envelope sender / Return-Path, empty for the null sender
+	ToAddr             string
+	DeliveredTo        string // original RCPT TO, differs from ToAddr for catch-all domains
+	RawHeaders         string
+	BodyPlain          string
+	BodyHTML           string
+	BodyLanguage       string // ISO 639-1 code, empty if not detected
+	RawMessage         []byte
+	OriginalMessage    []byte `json:"-"` // message as received, before header additions; not stored
+	RawSHA256          string // hex SHA-256 of the message as received, before header additions
+	Envelope           []byte // SMTP envelope as JSON, nil if unknown
+	SessionID          string // ID of the delivering SMTP session, as in its session_id log attribute
+	SizeBytes          int64
+	DKIMValid          *bool                 // nullable
+	DKIMAlgorithm      string                // a= tag of the accepted DKIM signature, e.g. rsa-sha256
+	DKIMDetails        []DKIMSignatureResult // per-signature results, nil if DKIM was not checked locally
+	SPFResult          string                // pass, fail, softfail, neutral, none, temperror, permerror
+	SPFIdentity        string                // identity SPF was checked against: mailfrom or helo
+	DMARCResult        string                // pass, fail, none
+	ARCResult          string                // none, pass, fail; empty if ARC was not checked
+	HasAttachments     bool
+	ImageSpamCandidate bool         // image attachment with negligible text, for the spam scorer
+	BCCOnly            bool         // no To/Cc header, every recipient was BCC'd
+	RecipientMismatch  bool         // single envelope recipient missing from To/Cc
+	Unauthenticated    bool         // failed SPF, DKIM and DMARC (validation.reject_unauthenticated: flag)
+	DKIMMisaligned     bool         // DKIM signed by another domain, sender has no DMARC
+	DateMissing        bool         // no usable Date header (missing or unparseable)
+	RawSubject         string       // subject before normalization, empty if unchanged
+	Quarantined        bool         // failed DMARC with p=quarantine (validation.enforce_dmarc)
+	Spam               *SpamVerdict // nil when spam scoring is off
+	ClientCountry      string       // GeoIP country of the sending client, empty if unknown
+	ClientASN          uint32       // GeoIP ASN of the sending client, 0 if unknown
+	IsBounce           bool         // null MAIL FROM (<>): a bounce or other DSN
+	CreateAddress      bool         // StoreEmail creates ToAddr if missing (catch_all domains, postmaster)
+	ReceivedAt         time.Time
+
+	// ID is the stored emails.id, set by StoreEmail
+	ID string
+	// FirstEmail is set by StoreEmail when this is the address's first delivery
+	FirstEmail bool
+	// Duplicate is set by StoreEmail when the address already had this
+	// Message-ID and nothing was stored (tempmail.deduplicate)
+	Duplicate bool
 }
 
 // AttachmentData represents an email attachment
@@ -39,19 +108,32 @@ type AttachmentData struct {
 	ContentType string
 	SizeBytes   int64
 	Data        []byte
+	Suspicious  bool   // flagged by the attachment policy (e.g. double extension)
+	StorageKey  string // set when the bytes live in the attachment store, not the table
+}
+
+// Migration is a schema migration script from db/migrations
+type Migration struct {
+	Name string
+	SQL  string
+}
+
+// DBPoolConfig holds connection pool limits
+type DBPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
 }
 
 // NewDB creates a new database connection
-func NewDB(databaseURL string, poolSize int) (*DB, error) {
+func NewDB(databaseURL string, pool DBPoolConfig) (*DB, error) {
 	conn, err := sql.Open("postgres", databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Configure connection pool
-	conn.SetMaxOpenConns(poolSize)
-	conn.SetMaxIdleConns(poolSize / 2)
-	conn.SetConnMaxLifetime(5 * time.Minute)
+	configurePool(conn, pool)
 
 	// Test connection
 	if err := conn.Ping(); err != nil {
@@ -61,50 +143,193 @@ func NewDB(databaseURL string, poolSize int) (*DB, error) {
 	return &DB{conn: conn}, nil
 }
 
+// configurePool applies pool limits to conn
+func configurePool(conn *sql.DB, pool DBPoolConfig) {
+	conn.SetMaxOpenConns(pool.MaxOpenConns)
+	conn.SetMaxIdleConns(pool.MaxIdleConns)
+	conn.SetConnMaxLifetime(pool.ConnMaxLifetime)
+}
+
+// PingContext checks the database connection
+func (db *DB) PingContext(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+// Healthy checks that the pool still reaches the database, for readiness
+// probes; it reuses the pool rather than opening a new connection
+func (db *DB) Healthy(ctx context.Context) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	return db.conn.PingContext(ctx)
+}
+
+// DryRunMigrations executes migration scripts in a transaction that is always
+// rolled back, proving they apply against the live schema without changing it
+func (db *DB) DryRunMigrations(ctx context.Context, migrations []Migration) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, m := range migrations {
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// defaultQueryTimeoutSeconds applies when database.query_timeout_seconds is
+// unset; it stays under the 30s SMTP write timeout
+const defaultQueryTimeoutSeconds = 20
+
+// queryContext derives a context from parent that ends after queryTimeout
+func (db *DB) queryContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, db.queryTimeout)
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
-	log.Println("Closing database connection...")
+	slog.Info("Closing database connection")
 	return db.conn.Close()
 }
 
 // StoreEmail stores an email and its attachments in the database
 func (db *DB) StoreEmail(email *EmailData, attachments []AttachmentData) error {
-	tx, err := db.conn.Begin()
+	return db.StoreEmailContext(context.Background(), email, attachments)
+}
+
+// StoreEmailContext is StoreEmail with every statement bound to ctx, so a
+// cancelled or expired ctx aborts the transaction
+// Transient failures (lost connection, serialization conflict) retry the
+// whole transaction with backoff, then fail with ErrStorageUnavailable
+func (db *DB) StoreEmailContext(ctx context.Context, email *EmailData, attachments []AttachmentData) error {
+	maxRetries, delay := db.maxRetries, db.retryDelay
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	// Upload once, outside the transaction; keys name content, so an
+	// attempt that is rolled back leaves nothing a retry would duplicate
+	attachments, err := db.offloadAttachments(attachments)
+	if err != nil {
+		return err
+	}
+	return withRetry(ctx, maxRetries, delay, func() error {
+		return db.storeEmail(ctx, email, attachments)
+	})
+}
+
+// storeEmail makes one attempt at storing an email in a single transaction
+func (db *DB) storeEmail(ctx context.Context, email *EmailData, attachments []AttachmentData) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
+	var spamScore *float64
+	var spamRules []byte
+	var spamDisposition *string
+	if email.Spam != nil {
+		spamScore = &email.Spam.Score
+		spamDisposition = &email.Spam.Disposition
+		if spamRules, err = json.Marshal(email.Spam.Rules); err != nil {
+			return fmt.Errorf("failed to encode spam rules: %w", err)
+		}
+	}
+
+	var dkimDetails []byte
+	if email.DKIMDetails != nil {
+		if dkimDetails, err = json.Marshal(email.DKIMDetails); err != nil {
+			return fmt.Errorf("failed to encode DKIM details: %w", err)
+		}
+	}
+
+	var clientASN *int64
+	if email.ClientASN != 0 {
+		asn := int64(email.ClientASN)
+		clientASN = &asn
+	}
+
+	// Find address for recipient, which must exist unless the session allows creating it
+	addressID, err := db.getAddressContext(ctx, tx, email.ToAddr, email.CreateAddress, email.SessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get address: %w", err)
+	}
+
+	// Skip redeliveries, e.g. a list sending the same post twice (the
+	// address row is locked by getAddress, so concurrent copies serialize)
+	email.Duplicate = false
+	if db.deduplicate && email.MessageID != "" {
+		exists, err := emailExists(ctx, tx, email.MessageID, addressID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			slog.Info("Skipped duplicate email", "session_id", email.SessionID, "message_id", email.MessageID, "address_id", addressID)
+			email.Duplicate = true
+			return nil
+		}
+	}
+
 	// Insert email
 	var emailID string
-	err = tx.QueryRow(`
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO emails (
 			message_id, subject, from_address, to_address, raw_headers,
-			body_plain, body_html, raw_message, size_bytes,
-			dkim_valid, spf_result, dmarc_result, has_attachments, received_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			body_plain, body_html, body_language, raw_message, envelope, size_bytes,
+			dkim_valid, dkim_algorithm, spf_result, dmarc_result, has_attachments, received_at,
+			return_path, image_spam_candidate, spf_identity, raw_message_sha256,
+			bcc_only, delivered_to, recipient_mismatch,
+			spam_score, spam_rules, spam_disposition, unauthenticated,
+			client_country, client_asn, dkim_misaligned, date_missing, raw_subject, quarantined,
+			dkim_details, arc_result, session_id, is_bounce
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24,
+			$25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37, $38)
 		RETURNING id
 	`,
 		email.MessageID, email.Subject, email.FromAddr, email.ToAddr,
-		email.RawHeaders, email.BodyPlain, email.BodyHTML, email.RawMessage,
-		email.SizeBytes, email.DKIMValid, email.SPFResult, email.DMARCResult,
+		email.RawHeaders, email.BodyPlain, email.BodyHTML, email.BodyLanguage,
+		email.RawMessage, nullableJSON(email.Envelope), email.SizeBytes,
+		email.DKIMValid, email.DKIMAlgorithm, email.SPFResult, email.DMARCResult,
 		email.HasAttachments, email.ReceivedAt,
+		email.ReturnPath, email.ImageSpamCandidate, nullableString(email.SPFIdentity),
+		nullableString(email.RawSHA256), email.BCCOnly, nullableString(email.DeliveredTo),
+		email.RecipientMismatch,
+		spamScore, nullableJSON(spamRules), spamDisposition, email.Unauthenticated,
+		nullableString(email.ClientCountry), clientASN, email.DKIMMisaligned, email.DateMissing,
+		nullableString(email.RawSubject), email.Quarantined, nullableJSON(dkimDetails),
+		nullableString(email.ARCResult), nullableString(email.SessionID), email.IsBounce,
 	).Scan(&emailID)
 
 	if err != nil {
 		return fmt.Errorf("failed to insert email: %w", err)
 	}
 
-	log.Printf("Stored email %s with ID %s", email.MessageID, emailID)
+	slog.Info("Stored email", "session_id", email.SessionID, "message_id", email.MessageID, "email_id", emailID, "to", email.ToAddr)
+	email.ID = emailID
 
-	// Find address for recipient (must already exist)
-	addressID, err := db.getAddress(tx, email.ToAddr)
+	// Record the first-ever delivery (address row is locked by getAddress); the
+	// column is set once, so emptying the address later does not repeat it
+	result, err := tx.ExecContext(ctx, `
+		UPDATE addresses SET first_email_at = NOW() WHERE id = $1 AND first_email_at IS NULL
+	`, addressID)
 	if err != nil {
-		return fmt.Errorf("failed to get address: %w", err)
+		return fmt.Errorf("failed to record first delivery: %w", err)
 	}
+	marked, _ := result.RowsAffected()
+	email.FirstEmail = marked == 1
 
 	// Link email to address
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO email_recipients (email_id, address_id)
 		VALUES ($1, $2)
 	`, emailID, addressID)
@@ -113,16 +338,15 @@ func (db *DB) StoreEmail(email *EmailData, attachments []AttachmentData) error {
 	}
 
 	// Store attachments
-	for _, att := range attachments {
-		_, err = tx.Exec(`
-			INSERT INTO attachments (email_id, filename, content_type, size_bytes, data)
-			VALUES ($1, $2, $3, $4, $5)
-		`, emailID, att.Filename, att.ContentType, att.SizeBytes, att.Data)
-
-		if err != nil {
-			return fmt.Errorf("failed to insert attachment: %w", err)
+	stored, err := db.storeAttachments(ctx, tx, emailID, attachments)
+	if err != nil {
+		return err
+	}
+	if len(attachments) > 0 && stored == 0 {
+		if _, err := tx.ExecContext(ctx, `UPDATE emails SET has_attachments = FALSE WHERE id = $1`, emailID); err != nil {
+			return fmt.Errorf("failed to clear attachment flag: %w", err)
 		}
-		log.Printf("Stored attachment: %s (%d bytes)", att.Filename, att.SizeBytes)
+		email.HasAttachments = false
 	}
 
 	// Commit transaction
@@ -130,29 +354,199 @@ func (db *DB) StoreEmail(email *EmailData, attachments []AttachmentData) error {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	// Asynchronously enforce email limit (don't block email reception)
+	// Asynchronously enforce email and byte limits (don't block email reception)
+	// The session's ctx ends with the connection, so the cleanup gets its own
 	go func() {
-		if err := db.EnforceEmailLimit(addressID); err != nil {
-			log.Printf("Warning: Failed to enforce email limit for address %s: %v", addressID, err)
+		ctx, cancel := db.queryContext(context.Background())
+		defer cancel()
+		if err := db.EnforceEmailLimitContext(ctx, addressID); err != nil {
+			slog.Warn("Failed to enforce email limit", "address_id", addressID, "error", err)
+		}
+		if err := db.EnforceByteLimitContext(ctx, addressID); err != nil {
+			slog.Warn("Failed to enforce byte limit", "address_id", addressID, "error", err)
 		}
 	}()
 
 	return nil
 }
 
-// getAddress gets existing address by email (does not create)
-func (db *DB) getAddress(tx *sql.Tx, email string) (string, error) {
+// nullableJSON converts empty JSON bytes to NULL for jsonb columns
+func nullableJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+// nullableString converts an empty string to NULL
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// attachmentBatchSize caps the rows per multi-row INSERT, keeping the seven
+// parameters per row well under PostgreSQL's 65535 bind parameter limit
+const attachmentBatchSize = 1000
+
+// storeAttachments inserts the attachments of an email inside tx in as few
+// round trips as possible and returns how many were stored
+// With skipFailedAttachments a failed batch is rolled back to a savepoint and
+// retried one row at a time so only the offending attachments are dropped
+func (db *DB) storeAttachments(ctx context.Context, tx *sql.Tx, emailID string, attachments []AttachmentData) (int, error) {
+	if len(attachments) == 0 {
+		return 0, nil
+	}
+
+	if !db.skipFailedAttachments {
+		if err := insertAttachments(ctx, tx, emailID, attachments); err != nil {
+			return 0, err
+		}
+		logStoredAttachments(attachments)
+		return len(attachments), nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT attachments`); err != nil {
+		return 0, fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	if err := insertAttachments(ctx, tx, emailID, attachments); err == nil {
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT attachments`); err != nil {
+			return 0, fmt.Errorf("failed to release savepoint: %w", err)
+		}
+		logStoredAttachments(attachments)
+		return len(attachments), nil
+	}
+	if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT attachments`); err != nil {
+		return 0, fmt.Errorf("failed to roll back to savepoint: %w", err)
+	}
+
+	stored := 0
+	for _, att := range attachments {
+		ok, err := db.storeAttachment(ctx, tx, emailID, att)
+		if err != nil {
+			return stored, err
+		}
+		if ok {
+			stored++
+			slog.Info("Stored attachment", "filename", att.Filename, "size", att.SizeBytes)
+		}
+	}
+	return stored, nil
+}
+
+// insertAttachments adds attachments with multi-row INSERT statements
+func insertAttachments(ctx context.Context, tx *sql.Tx, emailID string, attachments []AttachmentData) error {
+	for start := 0; start < len(attachments); start += attachmentBatchSize {
+		batch := attachments[start:min(start+attachmentBatchSize, len(attachments))]
+
+		var query strings.Builder
+		query.WriteString(`INSERT INTO attachments (email_id, filename, content_type, size_bytes, data, suspicious, storage_key) VALUES `)
+		args := make([]interface{}, 0, len(batch)*7)
+		for i, att := range batch {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7)
+			args = append(args, attachmentRow(emailID, att)...)
+		}
+
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return fmt.Errorf("failed to insert attachments: %w", err)
+		}
+	}
+	return nil
+}
+
+// attachmentRow returns the INSERT parameters for att; offloaded attachments
+// store their key and no bytes
+func attachmentRow(emailID string, att AttachmentData) []interface{} {
+	var data interface{} = att.Data
+	if att.StorageKey != "" {
+		data = nil
+	}
+	return []interface{}{emailID, att.Filename, att.ContentType, att.SizeBytes, data, att.Suspicious, nullableString(att.StorageKey)}
+}
+
+// offloadAttachments writes attachment bytes to the attachment store and
+// returns copies carrying the storage key; without a store it returns attachments
+func (db *DB) offloadAttachments(attachments []AttachmentData) ([]AttachmentData, error) {
+	if db.attachmentStore == nil || len(attachments) == 0 {
+		return attachments, nil
+	}
+
+	offloaded := make([]AttachmentData, len(attachments))
+	for i, att := range attachments {
+		key, err := db.attachmentStore.Put(attachmentKey(att.Data), att.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store attachment %s: %w", att.Filename, err)
+		}
+		att.StorageKey = key
+		att.Data = nil
+		offloaded[i] = att
+	}
+	return offloaded, nil
+}
+
+// logStoredAttachments logs each attachment of a successful batch insert
+func logStoredAttachments(attachments []AttachmentData) {
+	for _, att := range attachments {
+		slog.Info("Stored attachment", "filename", att.Filename, "size", att.SizeBytes)
+	}
+}
+
+// storeAttachment inserts one attachment of an email inside tx
+// With skipFailedAttachments a failed insert is logged and reported as not
+// stored; it runs under a savepoint since PostgreSQL aborts the whole
+// transaction on any failed statement
+func (db *DB) storeAttachment(ctx context.Context, tx *sql.Tx, emailID string, att AttachmentData) (bool, error) {
+	const insert = `
+		INSERT INTO attachments (email_id, filename, content_type, size_bytes, data, suspicious, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	if !db.skipFailedAttachments {
+		if _, err := tx.ExecContext(ctx, insert, attachmentRow(emailID, att)...); err != nil {
+			return false, fmt.Errorf("failed to insert attachment: %w", err)
+		}
+		return true, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT attachment`); err != nil {
+		return false, fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, insert, attachmentRow(emailID, att)...); err != nil {
+		slog.Warn("Skipping attachment", "filename", att.Filename, "size", att.SizeBytes, "email_id", emailID, "error", err)
+		if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT attachment`); err != nil {
+			return false, fmt.Errorf("failed to roll back to savepoint: %w", err)
+		}
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT attachment`); err != nil {
+		return false, fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return true, nil
+}
+
+// getAddressContext gets existing address by email, creating it only when
+// create is set (EmailData.CreateAddress); sessionID tags the creation log line
+// The address row is locked until the transaction ends so concurrent
+// deliveries to the same address are serialized
+func (db *DB) getAddressContext(ctx context.Context, tx *sql.Tx, email string, create bool, sessionID string) (string, error) {
 	// Normalize email to lowercase for case-insensitive matching
 	normalizedEmail := strings.ToLower(email)
 
 	// Find existing address using normalized email
 	var addressID string
-	err := tx.QueryRow(`
-		SELECT id FROM addresses WHERE email = $1
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM addresses WHERE email = $1 FOR UPDATE
 	`, normalizedEmail).Scan(&addressID)
 
+	if err == sql.ErrNoRows && create {
+		return db.createAddress(ctx, tx, normalizedEmail, sessionID)
+	}
 	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("address does not exist: %s", normalizedEmail)
+		return "", fmt.Errorf("%w: %s", ErrAddressNotFound, normalizedEmail)
 	}
 
 	if err != nil {
@@ -162,14 +556,73 @@ func (db *DB) getAddress(tx *sql.Tx, email string) (string, error) {
 	return addressID, nil
 }
 
+// createAddress inserts email with a generated token, expiring after
+// addressLifetime; if a concurrent delivery created it first, that row is used
+func (db *DB) createAddress(ctx context.Context, tx *sql.Tx, email, sessionID string) (string, error) {
+	var addressID string
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO addresses (email, token, expires_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email
+		RETURNING id
+	`, email, generateSimpleToken(), db.addressLifetime.Seconds()).Scan(&addressID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create address: %w", err)
+	}
+
+	slog.Info("Created address", "session_id", sessionID, "to", email, "address_id", addressID, "lifetime", db.addressLifetime)
+	return addressID, nil
+}
+
+// simpleTokenPrefix marks address tokens minted by the MX rather than the API
+const simpleTokenPrefix = "auto_"
+
+// generateSimpleToken returns a URL-safe access token for an address the MX
+// creates itself; 32 random bytes keep it unguessable and collision-free
+func generateSimpleToken() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return simpleTokenPrefix + base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// EmailExists reports whether the address already has an email with messageID
+func (db *DB) EmailExists(messageID, addressID string) (bool, error) {
+	return emailExists(context.Background(), db.conn, messageID, addressID)
+}
+
+// emailExists is EmailExists on q, so StoreEmail can check inside its transaction
+func emailExists(ctx context.Context, q rowQueryer, messageID, addressID string) (bool, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM emails e
+			JOIN email_recipients er ON er.email_id = e.id
+			WHERE e.message_id = $1 AND er.address_id = $2
+		)
+	`, messageID, addressID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for duplicate email: %w", err)
+	}
+	return exists, nil
+}
 
 // AddressExists checks if an email address exists in the database
 func (db *DB) AddressExists(email string) (bool, error) {
+	return db.AddressExistsContext(context.Background(), email)
+}
+
+// AddressExistsContext is AddressExists bound to ctx
+func (db *DB) AddressExistsContext(ctx context.Context, email string) (bool, error) {
 	// Normalize email to lowercase for case-insensitive matching
 	normalizedEmail := strings.ToLower(email)
 
 	var exists bool
-	err := db.conn.QueryRow(`
+	err := db.conn.QueryRowContext(ctx, `
 		SELECT EXISTS(SELECT 1 FROM addresses WHERE email = $1)
 	`, normalizedEmail).Scan(&exists)
 
@@ -180,18 +633,51 @@ func (db *DB) AddressExists(email string) (bool, error) {
 	return exists, nil
 }
 
+// IsBlackhole reports whether mail to the address should be accepted and discarded
+func (db *DB) IsBlackhole(email string) (bool, error) {
+	return db.IsBlackholeContext(context.Background(), email)
+}
+
+// IsBlackholeContext is IsBlackhole bound to ctx
+func (db *DB) IsBlackholeContext(ctx context.Context, email string) (bool, error) {
+	var blackhole bool
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COALESCE(blackhole, FALSE) FROM addresses WHERE email = $1
+	`, strings.ToLower(email)).Scan(&blackhole)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check blackhole flag: %w", err)
+	}
+
+	return blackhole, nil
+}
+
 // CheckDomainAllowed checks if a domain is in the allowed list
 func (db *DB) CheckDomainAllowed(domain string, allowedDomains map[string]bool) bool {
 	return allowedDomains[strings.ToLower(domain)]
 }
 
+// defaultMaxEmailsPerAddress applies when tempmail.max_emails_per_address is unset
+const defaultMaxEmailsPerAddress = 100
+
 // EnforceEmailLimit enforces max emails per address by deleting oldest
 func (db *DB) EnforceEmailLimit(addressID string) error {
-	// This is called asynchronously after storing email
-	// Get max from config - for now hardcode to 100
-	maxEmails := 100
+	return db.EnforceEmailLimitContext(context.Background(), addressID)
+}
 
-	result, err := db.conn.Exec(`
+// EnforceEmailLimitContext is EnforceEmailLimit bound to ctx
+func (db *DB) EnforceEmailLimitContext(ctx context.Context, addressID string) error {
+	// A per-address max_emails (set through the API) takes precedence
+	// An unset limit falls back to the default rather than disabling retention
+	maxEmails := db.maxEmailsPerAddress
+	if maxEmails <= 0 {
+		maxEmails = defaultMaxEmailsPerAddress
+	}
+
+	result, err := db.conn.ExecContext(ctx, `
 		DELETE FROM emails
 		WHERE id IN (
 			SELECT e.id
@@ -199,7 +685,7 @@ func (db *DB) EnforceEmailLimit(addressID string) error {
 			JOIN email_recipients er ON er.email_id = e.id
 			WHERE er.address_id = $1
 			ORDER BY e.received_at DESC
-			OFFSET $2
+			OFFSET (SELECT COALESCE(max_emails, $2) FROM addresses WHERE id = $1)
 		)
 	`, addressID, maxEmails)
 
@@ -209,8 +695,273 @@ func (db *DB) EnforceEmailLimit(addressID string) error {
 
 	deleted, _ := result.RowsAffected()
 	if deleted > 0 {
-		log.Printf("Deleted %d old emails for address %s (enforcing limit of %d)", deleted, addressID, maxEmails)
+		slog.Info("Deleted old emails (enforcing retention limit)", "deleted", deleted, "address_id", addressID)
 	}
 
 	return nil
 }
+
+// EnforceByteLimit deletes an address's oldest emails until the rest fit in
+// maxBytesPerAddress; each email counts its message plus its attachments, as
+// TotalStoredBytes does
+func (db *DB) EnforceByteLimit(addressID string) error {
+	return db.EnforceByteLimitContext(context.Background(), addressID)
+}
+
+// EnforceByteLimitContext is EnforceByteLimit bound to ctx
+func (db *DB) EnforceByteLimitContext(ctx context.Context, addressID string) error {
+	if db.maxBytesPerAddress <= 0 {
+		return nil
+	}
+
+	// Keep the newest emails whose running total fits the quota
+	result, err := db.conn.ExecContext(ctx, `
+		DELETE FROM emails
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT e.id, SUM(
+					e.size_bytes + COALESCE((SELECT SUM(a.size_bytes) FROM attachments a WHERE a.email_id = e.id), 0)
+				) OVER (ORDER BY e.received_at DESC, e.id DESC) AS running_bytes
+				FROM emails e
+				JOIN email_recipients er ON er.email_id = e.id
+				WHERE er.address_id = $1
+			) sized
+			WHERE running_bytes > $2
+		)
+	`, addressID, db.maxBytesPerAddress)
+
+	if err != nil {
+		return fmt.Errorf("failed to enforce byte limit: %w", err)
+	}
+
+	deleted, _ := result.RowsAffected()
+	if deleted > 0 {
+		slog.Info("Deleted old emails (enforcing byte quota)", "deleted", deleted, "address_id", addressID)
+	}
+
+	return nil
+}
+
+// TotalStoredBytes returns the bytes stored across emails and attachments
+func (db *DB) TotalStoredBytes() (int64, error) {
+	var total int64
+	err := db.conn.QueryRow(`
+		SELECT
+			(SELECT COALESCE(SUM(size_bytes), 0) FROM emails) +
+			(SELECT COALESCE(SUM(size_bytes), 0) FROM attachments)
+	`).Scan(&total)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute stored bytes: %w", err)
+	}
+
+	return total, nil
+}
+
+// CountAddresses returns the number of unexpired addresses under domain
+func (db *DB) CountAddresses(domain string) (int64, error) {
+	var count int64
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) FROM addresses
+		WHERE lower(split_part(email, '@', 2)) = $1 AND expires_at > NOW()
+	`, strings.ToLower(domain)).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to count addresses: %w", err)
+	}
+	return count, nil
+}
+
+// CountAddressesByDomain returns the number of unexpired addresses per domain
+func (db *DB) CountAddressesByDomain() (map[string]int64, error) {
+	rows, err := db.conn.Query(`
+		SELECT lower(split_part(email, '@', 2)) AS domain, COUNT(*)
+		FROM addresses
+		WHERE expires_at > NOW()
+		GROUP BY domain
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count addresses: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var domain string
+		var count int64
+		if err := rows.Scan(&domain, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan address count: %w", err)
+		}
+		counts[domain] = count
+	}
+	return counts, rows.Err()
+}
+
+// CleanupExpired deletes expired addresses (cascading to their emails), then
+// emails received more than lifetime ago; lifetime <= 0 keeps emails of live addresses
+// Returns the number of emails and addresses deleted
+func (db *DB) CleanupExpired(lifetime time.Duration) (int64, int64, error) {
+	var addresses int64
+	if err := db.conn.QueryRow(`SELECT cleanup_expired_addresses()`).Scan(&addresses); err != nil {
+		return 0, 0, fmt.Errorf("failed to clean up expired addresses: %w", err)
+	}
+	if lifetime <= 0 {
+		return 0, addresses, nil
+	}
+
+	result, err := db.conn.Exec(`
+		DELETE FROM emails
+		WHERE received_at < NOW() - make_interval(secs => $1)
+	`, lifetime.Seconds())
+	if err != nil {
+		return 0, addresses, fmt.Errorf("failed to clean up expired emails: %w", err)
+	}
+
+	emails, _ := result.RowsAffected()
+	return emails, addresses, nil
+}
+
+// attachmentSweepGrace is how old an unreferenced object must be before the
+// sweep deletes it, so a store still in (or retrying) its transaction keeps
+// the objects it has already put
+const attachmentSweepGrace = time.Hour
+
+// attachmentSweepBatch is how many keys one reference check looks up
+const attachmentSweepBatch = 500
+
+// SweepAttachmentStore deletes objects in the attachment store that no
+// attachments row references and that were last written more than grace ago
+// Retention, cleanup, API deletes (by CASCADE) and failed stores all leave
+// such objects behind. Returns the number deleted
+func (db *DB) SweepAttachmentStore(grace time.Duration) (int, error) {
+	if db.attachmentStore == nil {
+		return 0, nil
+	}
+
+	cutoff := time.Now().Add(-grace)
+	deleted := 0
+	var batch []string
+	flush := func() error {
+		n, err := db.deleteUnreferenced(batch)
+		deleted += n
+		batch = batch[:0]
+		return err
+	}
+	err := db.attachmentStore.Walk(func(key string, modified time.Time) error {
+		if modified.After(cutoff) {
+			return nil
+		}
+		batch = append(batch, key)
+		if len(batch) < attachmentSweepBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	if err != nil {
+		return deleted, fmt.Errorf("failed to sweep attachment store: %w", err)
+	}
+	return deleted, nil
+}
+
+// deleteUnreferenced removes the keys no attachments row refers to
+func (db *DB) deleteUnreferenced(keys []string) (int, error) {
+	rows, err := db.conn.Query(`
+		SELECT DISTINCT storage_key FROM attachments WHERE storage_key = ANY($1)
+	`, pq.Array(keys))
+	if err != nil {
+		return 0, fmt.Errorf("failed to check attachment references: %w", err)
+	}
+	defer rows.Close()
+
+	referenced := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return 0, fmt.Errorf("failed to check attachment references: %w", err)
+		}
+		referenced[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to check attachment references: %w", err)
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if referenced[key] {
+			continue
+		}
+		if err := db.attachmentStore.Delete(key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// CheckGreylist records a delivery attempt and reports whether the triple was first
+// seen at least greylistDelay ago
+// A triple idle for longer than greylistExpiry starts over, as if never seen
+func (db *DB) CheckGreylist(triple GreylistTriple) (bool, error) {
+	var passed bool
+	err := db.conn.QueryRow(`
+		INSERT INTO greylist (client_ip, sender, recipient)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (client_ip, sender, recipient) DO UPDATE SET
+			first_seen = CASE
+				WHEN greylist.last_seen < NOW() - make_interval(secs => $5) THEN NOW()
+				ELSE greylist.first_seen
+			END,
+			last_seen = NOW()
+		RETURNING first_seen <= NOW() - make_interval(secs => $4)
+	`, triple.IP, strings.ToLower(triple.Sender), strings.ToLower(triple.Recipient),
+		db.greylistDelay.Seconds(), db.greylistExpiry.Seconds()).Scan(&passed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check greylist: %w", err)
+	}
+	return passed, nil
+}
+
+// CleanupGreylist deletes triples not seen within greylistExpiry
+func (db *DB) CleanupGreylist() (int64, error) {
+	result, err := db.conn.Exec(`
+		DELETE FROM greylist
+		WHERE last_seen < NOW() - make_interval(secs => $1)
+	`, db.greylistExpiry.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean up greylist: %w", err)
+	}
+
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
+
+// DeleteOldestEmails deletes up to limit emails across all addresses, oldest first
+// It also returns the bytes freed, counted the same way as TotalStoredBytes
+func (db *DB) DeleteOldestEmails(limit int) (int64, int64, error) {
+	// The attachments sum reads the statement snapshot, before the cascade runs
+	var deleted, freed int64
+	err := db.conn.QueryRow(`
+		WITH deleted AS (
+			DELETE FROM emails
+			WHERE id IN (
+				SELECT id FROM emails
+				ORDER BY received_at ASC
+				LIMIT $1
+			)
+			RETURNING id, size_bytes
+		)
+		SELECT
+			(SELECT COUNT(*) FROM deleted),
+			(SELECT COALESCE(SUM(size_bytes), 0) FROM deleted) +
+			(SELECT COALESCE(SUM(size_bytes), 0) FROM attachments WHERE email_id IN (SELECT id FROM deleted))
+	`, limit).Scan(&deleted, &freed)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete oldest emails: %w", err)
+	}
+
+	return deleted, freed, nil
+}
@@ -1,10 +1,34 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
 )
 
+// newMockDB returns a DB backed by sqlmock
+func newMockDB(t *testing.T) (*DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &DB{conn: conn}, mock
+}
+
 func TestCheckDomainAllowed(t *testing.T) {
 	// Create a DB instance (we don't need actual connection for this test)
 	db := &DB{}
@@ -159,3 +183,694 @@ func TestEmailDataWithNullDKIM(t *testing.T) {
 		t.Error("EmailData.DKIMValid should be nil when not checked")
 	}
 }
+
+func TestStoreEmailFirstEmail(t *testing.T) {
+	tests := []struct {
+		name      string
+		marked    int64 // rows the first_email_at update touches
+		wantFirst bool
+	}{
+		{
+			name:      "first email to address",
+			marked:    1,
+			wantFirst: true,
+		},
+		{
+			// Also an address whose emails retention or cleanup has since deleted
+			name:      "address already received mail",
+			marked:    0,
+			wantFirst: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM addresses WHERE email = \\$1 FOR UPDATE").
+				WithArgs("user@tempmail.example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("addr-1"))
+			mock.ExpectQuery("INSERT INTO emails").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("email-1"))
+			mock.ExpectExec("UPDATE addresses SET first_email_at").
+				WithArgs("addr-1").
+				WillReturnResult(sqlmock.NewResult(0, tt.marked))
+			mock.ExpectExec("INSERT INTO email_recipients").
+				WithArgs("email-1", "addr-1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			email := &EmailData{
+				MessageID:  "<test@example.com>",
+				FromAddr:   "sender@example.com",
+				ToAddr:     "user@tempmail.example.com",
+				RawMessage: []byte("test"),
+				ReceivedAt: time.Now(),
+			}
+
+			if err := db.StoreEmail(email, nil); err != nil {
+				t.Fatalf("StoreEmail() error = %v", err)
+			}
+
+			if email.FirstEmail != tt.wantFirst {
+				t.Errorf("StoreEmail() FirstEmail = %v, want %v", email.FirstEmail, tt.wantFirst)
+			}
+			if email.ID != "email-1" {
+				t.Errorf("StoreEmail() ID = %q, want email-1", email.ID)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+// poolTestDriver hands out no-op connections so pool behavior can be observed
+type poolTestDriver struct{}
+
+func (poolTestDriver) Open(name string) (driver.Conn, error) { return poolTestConn{}, nil }
+
+type poolTestConn struct{}
+
+func (poolTestConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+func (poolTestConn) Close() error              { return nil }
+func (poolTestConn) Begin() (driver.Tx, error) { return nil, errors.New("not implemented") }
+
+func init() {
+	sql.Register("pooltest", poolTestDriver{})
+}
+
+func TestConfigurePool(t *testing.T) {
+	conn, err := sql.Open("pooltest", "")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer conn.Close()
+
+	configurePool(conn, DBPoolConfig{MaxOpenConns: 6, MaxIdleConns: 2, ConnMaxLifetime: time.Minute})
+
+	if got := conn.Stats().MaxOpenConnections; got != 6 {
+		t.Errorf("MaxOpenConnections = %d, want 6", got)
+	}
+
+	// Check out more connections than may idle, then return them all
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for i := 0; i < 5; i++ {
+		c, err := conn.Conn(ctx)
+		if err != nil {
+			t.Fatalf("Conn() error = %v", err)
+		}
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+
+	stats := conn.Stats()
+	if stats.Idle != 2 {
+		t.Errorf("Idle = %d, want 2", stats.Idle)
+	}
+	if stats.MaxIdleClosed != 3 {
+		t.Errorf("MaxIdleClosed = %d, want 3", stats.MaxIdleClosed)
+	}
+}
+
+func TestCountAddressesByDomain(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery(`SELECT lower\(split_part\(email, '@', 2\)\) AS domain, COUNT\(\*\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"domain", "count"}).
+			AddRow("a.example", 4).
+			AddRow("b.example", 1))
+
+	counts, err := db.CountAddressesByDomain()
+	if err != nil {
+		t.Fatalf("CountAddressesByDomain() error = %v", err)
+	}
+	if counts["a.example"] != 4 || counts["b.example"] != 1 || len(counts) != 2 {
+		t.Errorf("CountAddressesByDomain() = %v", counts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStoreEmailAttachmentFailure(t *testing.T) {
+	attachments := []AttachmentData{
+		{Filename: "good.txt", ContentType: "text/plain", SizeBytes: 4, Data: []byte("good")},
+		{Filename: "huge.bin", ContentType: "application/octet-stream", SizeBytes: 3, Data: []byte("bad")},
+	}
+	insertFailure := errors.New("value too long")
+
+	expectEmail := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM addresses WHERE email = \\$1 FOR UPDATE").
+			WithArgs("user@tempmail.example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("addr-1"))
+		mock.ExpectQuery("INSERT INTO emails").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("email-1"))
+		mock.ExpectExec("UPDATE addresses SET first_email_at").
+			WithArgs("addr-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO email_recipients").
+			WithArgs("email-1", "addr-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	newEmail := func() *EmailData {
+		return &EmailData{
+			MessageID:      "<test@example.com>",
+			FromAddr:       "sender@example.com",
+			ToAddr:         "user@tempmail.example.com",
+			RawMessage:     []byte("test"),
+			HasAttachments: true,
+			ReceivedAt:     time.Now(),
+		}
+	}
+
+	// Both attachments go in one multi-row statement
+	batchArgs := []driver.Value{
+		"email-1", "good.txt", "text/plain", int64(4), []byte("good"), false, nil,
+		"email-1", "huge.bin", "application/octet-stream", int64(3), []byte("bad"), false, nil,
+	}
+
+	t.Run("batched", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectEmail(mock)
+		mock.ExpectExec(`INSERT INTO attachments \(.*\) VALUES \(\$1, .*, \$7\), \(\$8, .*, \$14\)$`).
+			WithArgs(batchArgs...).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		if err := db.StoreEmail(newEmail(), attachments); err != nil {
+			t.Fatalf("StoreEmail() error = %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectEmail(mock)
+		mock.ExpectExec("INSERT INTO attachments").
+			WithArgs(batchArgs...).
+			WillReturnError(insertFailure)
+		mock.ExpectRollback()
+
+		err := db.StoreEmail(newEmail(), attachments)
+		if !errors.Is(err, insertFailure) {
+			t.Errorf("StoreEmail() error = %v, want %v", err, insertFailure)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		db.skipFailedAttachments = true
+		expectEmail(mock)
+		// The batch fails, so each attachment is retried on its own
+		mock.ExpectExec("SAVEPOINT attachments").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO attachments").WithArgs(batchArgs...).WillReturnError(insertFailure)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT attachments").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SAVEPOINT attachment").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO attachments").
+			WithArgs("email-1", "good.txt", "text/plain", int64(4), []byte("good"), false, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT attachment").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SAVEPOINT attachment").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO attachments").
+			WithArgs("email-1", "huge.bin", "application/octet-stream", int64(3), []byte("bad"), false, nil).
+			WillReturnError(insertFailure)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT attachment").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		if err := db.StoreEmail(newEmail(), attachments); err != nil {
+			t.Fatalf("StoreEmail() error = %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+	})
+
+	t.Run("skip every attachment", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		db.skipFailedAttachments = true
+		expectEmail(mock)
+		mock.ExpectExec("SAVEPOINT attachments").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO attachments").WillReturnError(insertFailure)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT attachments").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SAVEPOINT attachment").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO attachments").WillReturnError(insertFailure)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT attachment").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE emails SET has_attachments = FALSE").
+			WithArgs("email-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err := db.StoreEmail(newEmail(), attachments[1:]); err != nil {
+			t.Fatalf("StoreEmail() error = %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+	})
+}
+
+func TestStoreEmailDeduplicate(t *testing.T) {
+	tests := []struct {
+		name        string
+		deduplicate bool
+		messageID   string
+		exists      bool // an email with messageID is already linked to the address
+		wantStored  bool
+	}{
+		{"disabled", false, "<post@lists.example>", true, true},
+		{"new Message-ID", true, "<post@lists.example>", false, true},
+		{"redelivery", true, "<post@lists.example>", true, false},
+		{"no Message-ID is never deduplicated", true, "", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			db.deduplicate = tt.deduplicate
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM addresses WHERE email = \\$1 FOR UPDATE").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("addr-1"))
+			if tt.deduplicate && tt.messageID != "" {
+				mock.ExpectQuery(`WHERE e.message_id = \$1 AND er.address_id = \$2`).
+					WithArgs(tt.messageID, "addr-1").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.exists))
+			}
+			if tt.wantStored {
+				mock.ExpectQuery("INSERT INTO emails").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("email-1"))
+				mock.ExpectExec("UPDATE addresses SET first_email_at").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO email_recipients").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			email := &EmailData{MessageID: tt.messageID, ToAddr: "user@tempmail.example.com", ReceivedAt: time.Now()}
+			if err := db.StoreEmail(email, nil); err != nil {
+				t.Fatalf("StoreEmail() error = %v", err)
+			}
+			if email.Duplicate == tt.wantStored {
+				t.Errorf("Duplicate = %v, want %v", email.Duplicate, !tt.wantStored)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreEmailDKIMDetails(t *testing.T) {
+	tests := []struct {
+		name    string
+		details []DKIMSignatureResult
+		want    driver.Value
+	}{
+		{"not checked", nil, nil},
+		{"unsigned", []DKIMSignatureResult{}, "[]"},
+		{"signatures", []DKIMSignatureResult{
+			{Domain: "example.com", Selector: "sel", Algorithm: "rsa-sha256", Valid: true},
+			{Domain: "esp.example", Selector: "s1", Algorithm: "rsa-sha256", Error: "key not found"},
+		}, `[{"domain":"example.com","selector":"sel","algorithm":"rsa-sha256","valid":true},` +
+			`{"domain":"esp.example","selector":"s1","algorithm":"rsa-sha256","valid":false,"error":"key not found"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+
+			// dkim_details is the 35th of the 38 INSERT parameters
+			args := make([]driver.Value, 38)
+			for i := range args {
+				args[i] = sqlmock.AnyArg()
+			}
+			args[34] = tt.want
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM addresses WHERE email = \\$1 FOR UPDATE").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("addr-1"))
+			mock.ExpectQuery("INSERT INTO emails").
+				WithArgs(args...).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("email-1"))
+			mock.ExpectExec("UPDATE addresses SET first_email_at").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("INSERT INTO email_recipients").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			email := &EmailData{
+				MessageID:   "<test@example.com>",
+				FromAddr:    "sender@example.com",
+				ToAddr:      "user@tempmail.example.com",
+				RawMessage:  []byte("test"),
+				DKIMDetails: tt.details,
+				ReceivedAt:  time.Now(),
+			}
+			if err := db.StoreEmail(email, nil); err != nil {
+				t.Fatalf("StoreEmail() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckGreylist(t *testing.T) {
+	for _, passed := range []bool{false, true} {
+		db, mock := newMockDB(t)
+		db.greylistDelay = 5 * time.Minute
+		db.greylistExpiry = 36 * time.Hour
+
+		mock.ExpectQuery(`INSERT INTO greylist .* ON CONFLICT \(client_ip, sender, recipient\) DO UPDATE`).
+			WithArgs("198.51.100.7", "sender@example.com", "user@tempmail.example.com", 300.0, 129600.0).
+			WillReturnRows(sqlmock.NewRows([]string{"passed"}).AddRow(passed))
+
+		got, err := db.CheckGreylist(GreylistTriple{IP: "198.51.100.7", Sender: "Sender@Example.com", Recipient: "user@tempmail.example.com"})
+		if err != nil {
+			t.Fatalf("CheckGreylist() error = %v", err)
+		}
+		if got != passed {
+			t.Errorf("CheckGreylist() = %v, want %v", got, passed)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestCleanupGreylist(t *testing.T) {
+	db, mock := newMockDB(t)
+	db.greylistExpiry = 36 * time.Hour
+
+	mock.ExpectExec(`DELETE FROM greylist`).
+		WithArgs(129600.0).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+
+	deleted, err := db.CleanupGreylist()
+	if err != nil || deleted != 7 {
+		t.Errorf("CleanupGreylist() = %d, %v, want 7", deleted, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnforceEmailLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured int
+		wantLimit  int
+	}{
+		{"configured limit", 3, 3},
+		{"unset falls back to default", 0, defaultMaxEmailsPerAddress},
+		{"negative never disables retention", -1, defaultMaxEmailsPerAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			db.maxEmailsPerAddress = tt.configured
+
+			// Everything past the newest wantLimit emails is deleted
+			mock.ExpectExec(`ORDER BY e.received_at DESC\s+OFFSET \(SELECT COALESCE\(max_emails, \$2\) FROM addresses WHERE id = \$1\)`).
+				WithArgs("addr-1", tt.wantLimit).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+
+			if err := db.EnforceEmailLimit("addr-1"); err != nil {
+				t.Fatalf("EnforceEmailLimit() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestCleanupExpired(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery(`SELECT cleanup_expired_addresses\(\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"cleanup_expired_addresses"}).AddRow(2))
+	mock.ExpectExec(`DELETE FROM emails\s+WHERE received_at < NOW\(\) - make_interval\(secs => \$1\)`).
+		WithArgs(float64(86400)).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+
+	emails, addresses, err := db.CleanupExpired(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("CleanupExpired() error = %v", err)
+	}
+	if emails != 5 || addresses != 2 {
+		t.Errorf("CleanupExpired() = %d emails, %d addresses, want 5, 2", emails, addresses)
+	}
+
+	// Without a lifetime only expired addresses are removed
+	mock.ExpectQuery(`SELECT cleanup_expired_addresses\(\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"cleanup_expired_addresses"}).AddRow(0))
+	if _, _, err := db.CleanupExpired(0); err != nil {
+		t.Fatalf("CleanupExpired(0) error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestEnforceByteLimit(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	// No quota configured: nothing is queried
+	if err := db.EnforceByteLimit("addr-1"); err != nil {
+		t.Fatalf("EnforceByteLimit() without quota error = %v", err)
+	}
+
+	db.maxBytesPerAddress = 5 * 1024 * 1024
+	// Attachment bytes count toward each email, newest emails are kept first
+	mock.ExpectExec(`SELECT SUM\(a.size_bytes\) FROM attachments a WHERE a.email_id = e.id.*`+
+		`OVER \(ORDER BY e.received_at DESC, e.id DESC\).*WHERE running_bytes > \$2`).
+		WithArgs("addr-1", int64(5*1024*1024)).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	if err := db.EnforceByteLimit("addr-1"); err != nil {
+		t.Fatalf("EnforceByteLimit() error = %v", err)
+	}
+
+	// A cancelled context aborts before the delete runs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.EnforceByteLimitContext(ctx, "addr-1"); err == nil {
+		t.Error("EnforceByteLimitContext() with cancelled ctx succeeded")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestIsBlackholeContext(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery(`SELECT COALESCE\(blackhole, FALSE\) FROM addresses WHERE email = \$1`).
+		WithArgs("sink@tempmail.example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"blackhole"}).AddRow(true))
+	blackhole, err := db.IsBlackholeContext(context.Background(), "Sink@tempmail.example.com")
+	if err != nil || !blackhole {
+		t.Fatalf("IsBlackholeContext() = %v, %v, want true", blackhole, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.IsBlackholeContext(ctx, "sink@tempmail.example.com"); err == nil {
+		t.Error("IsBlackholeContext() with cancelled ctx succeeded")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+// latencyDriver is a database/sql driver whose every statement costs one
+// simulated network round trip, for benchmarking statement counts
+type latencyDriver struct{ rtt time.Duration }
+
+type latencyConn struct{ rtt time.Duration }
+
+type latencyStmt struct{ rtt time.Duration }
+
+func (d latencyDriver) Open(string) (driver.Conn, error) { return latencyConn(d), nil }
+
+func (c latencyConn) Prepare(string) (driver.Stmt, error) { return latencyStmt(c), nil }
+func (c latencyConn) Close() error                        { return nil }
+func (c latencyConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c latencyConn) Commit() error                       { return nil }
+func (c latencyConn) Rollback() error                     { return nil }
+
+func (s latencyStmt) Close() error  { return nil }
+func (s latencyStmt) NumInput() int { return -1 }
+func (s latencyStmt) Exec([]driver.Value) (driver.Result, error) {
+	time.Sleep(s.rtt)
+	return driver.RowsAffected(1), nil
+}
+func (s latencyStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("latencyStmt: queries not supported")
+}
+
+func init() {
+	sql.Register("latency", latencyDriver{rtt: 200 * time.Microsecond})
+}
+
+// BenchmarkStoreAttachments compares per-row inserts with the batched insert
+// for a newsletter with 50 inline images
+func BenchmarkStoreAttachments(b *testing.B) {
+	attachments := make([]AttachmentData, 50)
+	for i := range attachments {
+		data := []byte(strings.Repeat("x", 4096))
+		attachments[i] = AttachmentData{
+			Filename:    fmt.Sprintf("image%02d.png", i),
+			ContentType: "image/png",
+			SizeBytes:   int64(len(data)),
+			Data:        data,
+		}
+	}
+
+	conn, err := sql.Open("latency", "")
+	if err != nil {
+		b.Fatalf("Failed to open latency driver: %v", err)
+	}
+	defer conn.Close()
+	db := &DB{conn: conn}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	run := func(b *testing.B, store func(tx *sql.Tx) error) {
+		for i := 0; i < b.N; i++ {
+			tx, err := conn.Begin()
+			if err != nil {
+				b.Fatalf("Begin() error = %v", err)
+			}
+			if err := store(tx); err != nil {
+				b.Fatalf("store error = %v", err)
+			}
+			if err := tx.Commit(); err != nil {
+				b.Fatalf("Commit() error = %v", err)
+			}
+		}
+	}
+
+	b.Run("per-row", func(b *testing.B) {
+		run(b, func(tx *sql.Tx) error {
+			for _, att := range attachments {
+				if _, err := db.storeAttachment(context.Background(), tx, "email-1", att); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	b.Run("batched", func(b *testing.B) {
+		run(b, func(tx *sql.Tx) error {
+			_, err := db.storeAttachments(context.Background(), tx, "email-1", attachments)
+			return err
+		})
+	})
+}
+
+func TestGenerateSimpleToken(t *testing.T) {
+	const n = 1000
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		token := generateSimpleToken()
+		if !strings.HasPrefix(token, "auto_") {
+			t.Fatalf("token %q lacks the auto_ prefix", token)
+		}
+		if len(token) > 64 {
+			t.Fatalf("token %q is longer than addresses.token allows", token)
+		}
+		if strings.Trim(token[len("auto_"):], "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+			t.Fatalf("token %q is not URL-safe", token)
+		}
+		if seen[token] {
+			t.Fatalf("duplicate token %q after %d calls", token, i)
+		}
+		seen[token] = true
+	}
+}
+
+// autoTokenArg matches a token from generateSimpleToken
+type autoTokenArg struct{}
+
+func (autoTokenArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "auto_")
+}
+
+func TestStoreEmailAutoCreateAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  bool
+		wantErr error
+	}{
+		{"unknown address rejected by default", false, ErrAddressNotFound},
+		{"unknown address created", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			db.addressLifetime = 24 * time.Hour
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM addresses WHERE email = \\$1 FOR UPDATE").
+				WithArgs("new@tempmail.example.com").
+				WillReturnError(sql.ErrNoRows)
+			if tt.create {
+				mock.ExpectQuery("INSERT INTO addresses \\(email, token, expires_at\\)").
+					WithArgs("new@tempmail.example.com", autoTokenArg{}, float64(86400)).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("addr-new"))
+				mock.ExpectQuery("INSERT INTO emails").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("email-1"))
+				mock.ExpectExec("UPDATE addresses SET first_email_at").
+					WithArgs("addr-new").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO email_recipients").
+					WithArgs("email-1", "addr-new").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			email := &EmailData{
+				MessageID:     "<test@example.com>",
+				FromAddr:      "sender@example.com",
+				ToAddr:        "New@tempmail.example.com",
+				RawMessage:    []byte("test"),
+				CreateAddress: tt.create,
+				ReceivedAt:    time.Now(),
+			}
+			err := db.StoreEmail(email, nil)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("StoreEmail() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.create && !email.FirstEmail {
+				t.Error("FirstEmail = false, want true for a created address")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
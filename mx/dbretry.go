@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/lib/pq"
+)
+
+// errSMTPStorageUnavailable defers a message the database could not take
+var errSMTPStorageUnavailable = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+	Message:      "Temporary local problem, please try again later",
+}
+
+// defaultMaxRetries applies when database.max_retries is unset
+const defaultMaxRetries = 3
+
+// defaultRetryDelay is the first backoff between attempts; it doubles each retry
+const defaultRetryDelay = 100 * time.Millisecond
+
+// isTransientDBError reports whether err is worth retrying: lost connections,
+// server restarts and serialization conflicts, but never constraint violations,
+// missing addresses or an expired context
+func isTransientDBError(err error) bool {
+	if err == nil || errors.Is(err, ErrAddressNotFound) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		// Class 08 is connection exception
+		return pqErr.Code.Class() == "08"
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.As(err, &netErr)
+}
+
+// withRetry runs op until it succeeds, fails permanently or maxRetries retries
+// are used up, backing off exponentially from delay between attempts
+// Exhausted retries are reported as ErrStorageUnavailable so the sender is
+// told to try again later rather than bounced
+func withRetry(ctx context.Context, maxRetries int, delay time.Duration, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if !isTransientDBError(err) {
+			return err
+		}
+		if attempt >= maxRetries {
+			return fmt.Errorf("%w after %d attempts: %w", ErrStorageUnavailable, attempt+1, err)
+		}
+
+		slog.Warn("Transient database error, retrying",
+			"attempt", attempt+1, "max_attempts", maxRetries+1, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
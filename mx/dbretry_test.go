@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/emersion/go-smtp"
+	"github.com/lib/pq"
+)
+
+func TestIsTransientDBError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"deadlock", &pq.Error{Code: "40P01"}, true},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, true},
+		{"connection failure", &pq.Error{Code: "08006"}, true},
+		{"wrapped bad connection", fmt.Errorf("failed to insert email: %w", driver.ErrBadConn), true},
+		{"connection reset", io.ErrUnexpectedEOF, true},
+		{"foreign key violation", &pq.Error{Code: "23503"}, false},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"address does not exist", fmt.Errorf("failed to get address: %w", ErrAddressNotFound), false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"other", errors.New("value too long"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientDBError(tt.err); got != tt.want {
+				t.Errorf("isTransientDBError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreEmailRetries(t *testing.T) {
+	serialization := &pq.Error{Code: "40001", Message: "could not serialize access"}
+
+	tests := []struct {
+		name      string
+		failures  int
+		failWith  error
+		wantErr   error // nil = stored
+		wantTries int
+	}{
+		{"first attempt succeeds", 0, nil, nil, 1},
+		{"succeeds after transient failures", 2, serialization, nil, 3},
+		{"retries exhausted", 4, serialization, ErrStorageUnavailable, 4},
+		{"constraint violation is not retried", 1, &pq.Error{Code: "23503"}, &pq.Error{}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			db.maxRetries = 3
+			db.retryDelay = time.Millisecond
+
+			tries := 0
+			for ; tries < tt.failures && tries < tt.wantTries; tries++ {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id FROM addresses").WillReturnError(tt.failWith)
+				mock.ExpectRollback()
+			}
+			if tries < tt.wantTries {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id FROM addresses WHERE email = \\$1 FOR UPDATE").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("addr-1"))
+				mock.ExpectQuery("INSERT INTO emails").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("email-1"))
+				mock.ExpectExec("UPDATE addresses SET first_email_at").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO email_recipients").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			email := &EmailData{MessageID: "<retry@example.com>", ToAddr: "user@tempmail.example.com", ReceivedAt: time.Now()}
+			err := db.StoreEmail(email, nil)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("StoreEmail() error = %v, want stored", err)
+				}
+			case *pq.Error:
+				var pqErr *pq.Error
+				if !errors.As(err, &pqErr) || errors.Is(err, ErrStorageUnavailable) {
+					t.Errorf("StoreEmail() error = %v, want the constraint violation unchanged", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("StoreEmail() error = %v, want %v", err, want)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+// unavailableDB fails every store as if retries had run out
+type unavailableDB struct {
+	mockSessionDB
+}
+
+func (d *unavailableDB) StoreEmailContext(ctx context.Context, email *EmailData, attachments []AttachmentData) error {
+	return fmt.Errorf("%w after 4 attempts: %w", ErrStorageUnavailable, driver.ErrBadConn)
+}
+
+func TestSessionDataStorageUnavailable(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 1
+	db := &unavailableDB{mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}}
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, db, nil, cfg.GetDomainMap())
+	s.Mail("sender@example.com", nil)
+	if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+
+	err := s.Data(strings.NewReader("From: sender@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"))
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 451 {
+		t.Errorf("Data() error = %v, want 451 so the sender retries", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// mxLookupTimeout bounds each domain's MX lookup during self-checks
+const mxLookupTimeout = 10 * time.Second
+
+// MXCheckResult reports whether a domain's MX records point at our hostname
+type MXCheckResult struct {
+	Domain string
+	Hosts  []string // MX hosts found, in preference order
+	OK     bool
+	Err    error
+}
+
+// CheckDomainMX verifies that each domain publishes an MX record pointing at hostname
+func CheckDomainMX(ctx context.Context, resolver Resolver, domains []string, hostname string) []MXCheckResult {
+	want := normalizeHostname(hostname)
+	results := make([]MXCheckResult, 0, len(domains))
+
+	for _, domain := range domains {
+		result := MXCheckResult{Domain: domain}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, mxLookupTimeout)
+		records, err := resolver.LookupMX(lookupCtx, domain)
+		cancel()
+
+		if err != nil {
+			result.Err = fmt.Errorf("MX lookup failed: %w", err)
+			results = append(results, result)
+			continue
+		}
+
+		for _, mx := range records {
+			host := normalizeHostname(mx.Host)
+			result.Hosts = append(result.Hosts, host)
+			if host == want {
+				result.OK = true
+			}
+		}
+		if !result.OK {
+			result.Err = fmt.Errorf("no MX record points at %s", want)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// logMXCheck runs the MX self-check and logs a warning for each misconfigured domain
+// Returns true if all domains are correctly configured
+func logMXCheck(ctx context.Context, resolver Resolver, cfg *Config) bool {
+	allOK := true
+	for _, result := range CheckDomainMX(ctx, resolver, cfg.Domains, cfg.Server.Hostname) {
+		if result.OK {
+			slog.Info("MX check passed", "domain", result.Domain, "hosts", result.Hosts)
+			continue
+		}
+		allOK = false
+		slog.Warn("MX check: domain is misconfigured", "domain", result.Domain, "hosts", result.Hosts, "error", result.Err)
+	}
+	return allOK
+}
+
+// startMXSelfCheck runs the MX check now and, if configured, periodically until ctx is done
+func startMXSelfCheck(ctx context.Context, resolver Resolver, cfg *Config) {
+	logMXCheck(ctx, resolver, cfg)
+
+	if cfg.Diagnostics.CheckMXIntervalHours <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.Diagnostics.CheckMXIntervalHours) * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				logMXCheck(ctx, resolver, cfg)
+			}
+		}
+	}()
+}
+
+// normalizeHostname lowercases a hostname and strips the trailing root dot
+func normalizeHostname(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
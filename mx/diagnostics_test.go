@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestCheckDomainMX(t *testing.T) {
+	resolver := &fakeResolver{mx: map[string][]*net.MX{
+		"good.test": {
+			{Host: "backup.other.test.", Pref: 20},
+			{Host: "MAIL.tempmail.test.", Pref: 10},
+		},
+		"bad.test": {
+			{Host: "mx.elsewhere.test.", Pref: 10},
+		},
+	}}
+
+	results := CheckDomainMX(context.Background(), resolver, []string{"good.test", "bad.test", "missing.test"}, "mail.tempmail.test")
+
+	if len(results) != 3 {
+		t.Fatalf("CheckDomainMX() returned %d results, want 3", len(results))
+	}
+
+	tests := []struct {
+		domain string
+		wantOK bool
+	}{
+		{domain: "good.test", wantOK: true},
+		{domain: "bad.test", wantOK: false},
+		{domain: "missing.test", wantOK: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			result := results[i]
+			if result.Domain != tt.domain {
+				t.Fatalf("result domain = %s, want %s", result.Domain, tt.domain)
+			}
+			if result.OK != tt.wantOK {
+				t.Errorf("OK = %v, want %v (err: %v)", result.OK, tt.wantOK, result.Err)
+			}
+			if !tt.wantOK && result.Err == nil {
+				t.Error("misconfigured domain should report an error")
+			}
+		})
+	}
+}
+
+func TestLogMXCheck(t *testing.T) {
+	cfg := &Config{Domains: []string{"good.test"}}
+	cfg.Server.Hostname = "mail.tempmail.test"
+
+	resolver := &fakeResolver{mx: map[string][]*net.MX{
+		"good.test": {{Host: "mail.tempmail.test.", Pref: 10}},
+	}}
+	if !logMXCheck(context.Background(), resolver, cfg) {
+		t.Error("logMXCheck() = false, want true for matching MX")
+	}
+
+	cfg.Domains = append(cfg.Domains, "bad.test")
+	if logMXCheck(context.Background(), resolver, cfg) {
+		t.Error("logMXCheck() = true, want false with a misconfigured domain")
+	}
+}
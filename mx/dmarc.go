@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// DMARC policy dispositions (p= and sp= values)
+const (
+	DMARCPolicyNone       = "none"
+	DMARCPolicyQuarantine = "quarantine"
+	DMARCPolicyReject     = "reject"
+)
+
+// DMARC identifier alignment modes (adkim= and aspf= values)
+const (
+	DMARCAlignmentRelaxed = "r"
+	DMARCAlignmentStrict  = "s"
+)
+
+// DMARCPolicy is a parsed DMARC record (RFC 7489 section 6.3)
+type DMARCPolicy struct {
+	Policy          string // p=: none, quarantine or reject
+	SubdomainPolicy string // sp=, defaults to p=
+	Percent         int    // pct=: share of failing mail the policy applies to, 0-100
+	ADKIM           string // adkim=: r (relaxed) or s (strict)
+	ASPF            string // aspf=: r (relaxed) or s (strict)
+	Domain          string // domain the record was published at (From domain or its organizational domain)
+}
+
+// parseDMARCRecord parses a v=DMARC1 TXT record
+// v= and p= are required; other invalid or unknown tags fall back to their
+// defaults as RFC 7489 section 6.3 asks
+func parseDMARCRecord(record string) (*DMARCPolicy, error) {
+	policy := &DMARCPolicy{
+		Percent: 100,
+		ADKIM:   DMARCAlignmentRelaxed,
+		ASPF:    DMARCAlignmentRelaxed,
+	}
+
+	for i, tag := range strings.Split(record, ";") {
+		name, value, _ := strings.Cut(tag, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if i == 0 {
+			if name != "v" || value != "DMARC1" {
+				return nil, errors.New("DMARC record must start with v=DMARC1")
+			}
+			continue
+		}
+
+		switch name {
+		case "p":
+			value = strings.ToLower(value)
+			if !isDMARCDisposition(value) {
+				return nil, fmt.Errorf("invalid DMARC policy p=%s", value)
+			}
+			policy.Policy = value
+		case "sp":
+			if value = strings.ToLower(value); isDMARCDisposition(value) {
+				policy.SubdomainPolicy = value
+			}
+		case "pct":
+			if pct, err := strconv.Atoi(value); err == nil && pct >= 0 && pct <= 100 {
+				policy.Percent = pct
+			}
+		case "adkim":
+			if value = strings.ToLower(value); value == DMARCAlignmentStrict {
+				policy.ADKIM = value
+			}
+		case "aspf":
+			if value = strings.ToLower(value); value == DMARCAlignmentStrict {
+				policy.ASPF = value
+			}
+		}
+	}
+
+	if policy.Policy == "" {
+		return nil, errors.New("DMARC record has no p= tag")
+	}
+	if policy.SubdomainPolicy == "" {
+		policy.SubdomainPolicy = policy.Policy
+	}
+	return policy, nil
+}
+
+// isDMARCDisposition reports whether value is a valid p= or sp= value
+func isDMARCDisposition(value string) bool {
+	return value == DMARCPolicyNone || value == DMARCPolicyQuarantine || value == DMARCPolicyReject
+}
+
+// EffectivePolicy returns the disposition for mail from fromDomain: sp= when the
+// record was inherited from the organizational domain, p= otherwise
+func (p *DMARCPolicy) EffectivePolicy(fromDomain string) string {
+	if p == nil {
+		return DMARCPolicyNone
+	}
+	if !strings.EqualFold(strings.TrimSuffix(fromDomain, "."), p.Domain) {
+		return p.SubdomainPolicy
+	}
+	return p.Policy
+}
+
+// authAligned reports whether a passing SPF result and any valid DKIM signature
+// are aligned with the From: domain under the policy's aspf= and adkim= modes
+func (p *DMARCPolicy) authAligned(fromDomain, spfResult, spfDomain string, dkimDomains []string) (spf bool, dkim bool) {
+	spf = spfResult == "pass" && dmarcAligned(spfDomain, fromDomain, p.ASPF)
+	for _, signingDomain := range dkimDomains {
+		if dmarcAligned(signingDomain, fromDomain, p.ADKIM) {
+			dkim = true
+			break
+		}
+	}
+	return spf, dkim
+}
+
+// dmarcAligned reports whether an authenticated domain (SPF or DKIM d=) is aligned
+// with the From: domain: an exact match in strict mode, the same organizational
+// domain in relaxed mode
+func dmarcAligned(authDomain, fromDomain, mode string) bool {
+	authDomain = strings.TrimSuffix(strings.ToLower(authDomain), ".")
+	fromDomain = strings.TrimSuffix(strings.ToLower(fromDomain), ".")
+	if authDomain == "" || fromDomain == "" {
+		return false
+	}
+	if authDomain == fromDomain {
+		return true
+	}
+	if mode == DMARCAlignmentStrict {
+		return false
+	}
+	authOrg, fromOrg := getOrganizationalDomain(authDomain), getOrganizationalDomain(fromDomain)
+	return authOrg != "" && authOrg == fromOrg
+}
+
+// headerFromDomain returns the domain of the first From: header address, or ""
+func headerFromDomain(rawMessage []byte) string {
+	// A partially parsed header is still useful, so the error is ignored
+	header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(rawMessage))).ReadMIMEHeader()
+	addrs, err := mail.ParseAddressList(header.Get("From"))
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+	return extractDomain(addrs[0].Address)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseDMARCRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  string
+		want    DMARCPolicy
+		wantErr bool
+	}{
+		{"minimal", "v=DMARC1; p=reject", DMARCPolicy{Policy: "reject", SubdomainPolicy: "reject", Percent: 100, ADKIM: "r", ASPF: "r"}, false},
+		{"all tags", "v=DMARC1; p=quarantine; sp=none; pct=25; adkim=s; aspf=s; rua=mailto:d@example.com",
+			DMARCPolicy{Policy: "quarantine", SubdomainPolicy: "none", Percent: 25, ADKIM: "s", ASPF: "s"}, false},
+		{"case and spacing", "v=DMARC1;P=Reject ;  SP = Quarantine;",
+			DMARCPolicy{Policy: "reject", SubdomainPolicy: "quarantine", Percent: 100, ADKIM: "r", ASPF: "r"}, false},
+		{"invalid optional tags use defaults", "v=DMARC1; p=none; sp=bogus; pct=150; adkim=x; aspf=",
+			DMARCPolicy{Policy: "none", SubdomainPolicy: "none", Percent: 100, ADKIM: "r", ASPF: "r"}, false},
+		{"pct zero", "v=DMARC1; p=reject; pct=0", DMARCPolicy{Policy: "reject", SubdomainPolicy: "reject", Percent: 0, ADKIM: "r", ASPF: "r"}, false},
+		{"missing p", "v=DMARC1; rua=mailto:d@example.com", DMARCPolicy{}, true},
+		{"invalid p", "v=DMARC1; p=drop", DMARCPolicy{}, true},
+		{"v not first", "p=reject; v=DMARC1", DMARCPolicy{}, true},
+		{"wrong version", "v=DMARC2; p=reject", DMARCPolicy{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDMARCRecord(tt.record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDMARCRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("parseDMARCRecord() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDMARCPolicy(t *testing.T) {
+	resolver := &fakeResolver{txt: map[string][]string{
+		"_dmarc.example.com": {"v=DMARC1; p=reject; sp=quarantine; pct=50"},
+		"_dmarc.broken.com":  {"v=DMARC1; p=drop"},
+	}}
+	validator := &Validator{cfg: &Config{}, resolver: resolver}
+
+	tests := []struct {
+		name          string
+		domain        string
+		spfResult     string
+		wantResult    string
+		wantEffective string // "" for no policy
+	}{
+		{"own record", "example.com", "fail", "fail", "reject"},
+		{"inherited by subdomain", "mail.example.com", "fail", "fail", "quarantine"},
+		{"pass keeps policy", "example.com", "pass", "pass", "reject"},
+		{"invalid record", "broken.com", "fail", "none", ""},
+		{"no record", "unpublished.com", "fail", "none", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, policy := validator.validateDMARC(tt.domain, tt.spfResult, tt.domain, nil, nil)
+			if result != tt.wantResult {
+				t.Errorf("validateDMARC() result = %s, want %s", result, tt.wantResult)
+			}
+			if tt.wantEffective == "" {
+				if policy != nil {
+					t.Errorf("validateDMARC() policy = %+v, want nil", policy)
+				}
+				return
+			}
+			if policy == nil {
+				t.Fatal("validateDMARC() policy = nil")
+			}
+			if got := policy.EffectivePolicy(tt.domain); got != tt.wantEffective {
+				t.Errorf("EffectivePolicy(%s) = %s, want %s", tt.domain, got, tt.wantEffective)
+			}
+			if policy.Percent != 50 {
+				t.Errorf("Percent = %d, want 50", policy.Percent)
+			}
+		})
+	}
+}
+
+func TestHeaderFromDomain(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"bare address", "From: user@Example.COM\r\n\r\nBody", "example.com"},
+		{"display name", "From: \"Example\" <news@mail.example.com>\r\n\r\nBody", "mail.example.com"},
+		{"first of several", "From: a@one.example, b@two.example\r\n\r\nBody", "one.example"},
+		{"missing", "Subject: Hi\r\n\r\nBody", ""},
+		{"unparseable", "From: not an address\r\n\r\nBody", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := headerFromDomain([]byte(tt.message)); got != tt.want {
+				t.Errorf("headerFromDomain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
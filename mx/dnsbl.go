@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// DNSBL lookup limits
+const (
+	defaultDNSBLTimeoutSeconds = 2
+	dnsblCacheTTL              = 5 * time.Minute
+	dnsblCacheMax              = 10000 // expired entries are pruned beyond this
+)
+
+// DNSBLChecker looks up client IPs in DNS blocklists (dnsbl.zones)
+// Zones are queried in parallel under one short timeout; failed or slow
+// lookups count as not listed so a broken list cannot stall or block mail
+type DNSBLChecker struct {
+	zones    []string
+	resolver Resolver
+	timeout  time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]dnsblEntry
+}
+
+// dnsblEntry is a cached verdict for one IP
+type dnsblEntry struct {
+	zone    string // listing zone, empty when not listed
+	expires time.Time
+}
+
+// NewDNSBLChecker builds a checker from the dnsbl section
+func NewDNSBLChecker(cfg *Config, resolver Resolver) *DNSBLChecker {
+	timeout := cfg.DNSBL.TimeoutSeconds
+	if timeout <= 0 {
+		timeout = defaultDNSBLTimeoutSeconds
+	}
+	return &DNSBLChecker{
+		zones:    cfg.DNSBL.Zones,
+		resolver: resolver,
+		timeout:  time.Duration(timeout) * time.Second,
+		now:      time.Now,
+		cache:    make(map[string]dnsblEntry),
+	}
+}
+
+// Check returns a 554 naming the zone when ip is listed
+func (d *DNSBLChecker) Check(ip string) error {
+	zone := d.listedIn(ip)
+	if zone == "" {
+		return nil
+	}
+	return &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      fmt.Sprintf("Client host [%s] blocked using %s", ip, zone),
+	}
+}
+
+// listedIn returns the first configured zone listing ip, using the cache
+func (d *DNSBLChecker) listedIn(ip string) string {
+	now := d.now()
+	d.mu.Lock()
+	entry, ok := d.cache[ip]
+	d.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.zone
+	}
+
+	zone := d.lookup(ip)
+
+	d.mu.Lock()
+	if len(d.cache) >= dnsblCacheMax {
+		for key, e := range d.cache {
+			if !now.Before(e.expires) {
+				delete(d.cache, key)
+			}
+		}
+	}
+	d.cache[ip] = dnsblEntry{zone: zone, expires: now.Add(dnsblCacheTTL)}
+	d.mu.Unlock()
+	return zone
+}
+
+// lookup queries every zone for ip; answers are only trusted inside
+// 127.0.0.0/8, excluding the 127.255.255.0/24 codes Spamhaus uses for
+// refused queries (e.g. via a public resolver)
+func (d *DNSBLChecker) lookup(ip string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	reversed, ok := dnsblReverse(ip)
+	if !ok {
+		return ""
+	}
+
+	listed := make([]bool, len(d.zones))
+	var wg sync.WaitGroup
+	for i, zone := range d.zones {
+		name := reversed + "." + zone + "."
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := d.resolver.LookupIPAddr(ctx, name)
+			if err != nil {
+				return
+			}
+			for _, a := range addrs {
+				v4 := a.IP.To4()
+				if v4 != nil && v4[0] == 127 && !(v4[1] == 255 && v4[2] == 255) {
+					listed[i] = true
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, zone := range d.zones {
+		if listed[i] {
+			return zone
+		}
+	}
+	return ""
+}
+
+// dnsblReverse returns ip in DNSBL query order: reversed octets for IPv4,
+// reversed nibbles for IPv6
+func dnsblReverse(ip string) (string, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", false
+	}
+	var labels []string
+	if v4 := parsed.To4(); v4 != nil {
+		for i := len(v4) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(v4[i]))
+		}
+	} else {
+		const hex = "0123456789abcdef"
+		for i := len(parsed) - 1; i >= 0; i-- {
+			labels = append(labels, string(hex[parsed[i]&0x0f]), string(hex[parsed[i]>>4]))
+		}
+	}
+	return strings.Join(labels, "."), true
+}
+
+// checkDNSBL refuses the transaction when the client IP is on a blocklist
+func (s *Session) checkDNSBL() error {
+	if s.dnsbl == nil {
+		return nil
+	}
+	ip := s.getClientIP()
+	if err := s.dnsbl.Check(ip); err != nil {
+		s.logger().Info("REJECTED: Client listed on DNSBL", "client_ip", ip, "error", err)
+		return err
+	}
+	return nil
+}
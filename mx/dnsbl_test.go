@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestDNSBLReverse(t *testing.T) {
+	tests := []struct {
+		ip     string
+		want   string
+		wantOK bool
+	}{
+		{"192.0.2.10", "10.2.0.192", true},
+		{"::ffff:192.0.2.10", "10.2.0.192", true},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2", true},
+		{"not-an-ip", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, ok := dnsblReverse(tt.ip)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("dnsblReverse(%q) = %q, %v; want %q, %v", tt.ip, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDNSBLCheckerCheck(t *testing.T) {
+	resolver := &fakeResolver{ip: map[string][]string{
+		"10.2.0.192.zen.spamhaus.org": {"127.0.0.2"},
+		"20.2.0.192.bl.spamcop.net":   {"127.0.0.2"},
+		"30.2.0.192.zen.spamhaus.org": {"127.255.255.254"}, // query refused, not a listing
+		"40.2.0.192.zen.spamhaus.org": {"192.0.2.1"},       // wildcard outside 127/8
+		"50.2.0.192.zen.spamhaus.org": {"127.0.0.4"},
+		"50.2.0.192.bl.spamcop.net":   {"127.0.0.2"},
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.zen.spamhaus.org": {"127.0.0.3"},
+	}}
+	cfg := &Config{}
+	cfg.DNSBL.Zones = []string{"zen.spamhaus.org", "bl.spamcop.net"}
+	checker := NewDNSBLChecker(cfg, resolver)
+
+	tests := []struct {
+		name     string
+		ip       string
+		wantZone string // empty = not listed
+	}{
+		{"listed on first zone", "192.0.2.10", "zen.spamhaus.org"},
+		{"listed on second zone", "192.0.2.20", "bl.spamcop.net"},
+		{"refused query code", "192.0.2.30", ""},
+		{"answer outside loopback", "192.0.2.40", ""},
+		{"listed on both, config order wins", "192.0.2.50", "zen.spamhaus.org"},
+		{"IPv6 listed", "2001:db8::1", "zen.spamhaus.org"},
+		{"not listed", "198.51.100.7", ""},
+		{"unparseable", "unknown", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checker.Check(tt.ip)
+			if tt.wantZone == "" {
+				if err != nil {
+					t.Errorf("Check(%s) error = %v, want not listed", tt.ip, err)
+				}
+				return
+			}
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != 554 || !strings.Contains(smtpErr.Message, tt.wantZone) {
+				t.Errorf("Check(%s) error = %v, want 554 citing %s", tt.ip, err, tt.wantZone)
+			}
+		})
+	}
+}
+
+// countingResolver counts LookupIPAddr calls and can stall until ctx is done
+type countingResolver struct {
+	fakeResolver
+	lookups atomic.Int32
+	stall   bool
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups.Add(1)
+	if r.stall {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return r.fakeResolver.LookupIPAddr(ctx, host)
+}
+
+func TestDNSBLCheckerCache(t *testing.T) {
+	resolver := &countingResolver{fakeResolver: fakeResolver{ip: map[string][]string{
+		"10.2.0.192.zen.spamhaus.org": {"127.0.0.2"},
+	}}}
+	cfg := &Config{}
+	cfg.DNSBL.Zones = []string{"zen.spamhaus.org"}
+	checker := NewDNSBLChecker(cfg, resolver)
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	checker.now = func() time.Time { return now }
+
+	// Listed and clean verdicts are both cached
+	for _, ip := range []string{"192.0.2.10", "198.51.100.7"} {
+		checker.Check(ip)
+		checker.Check(ip)
+	}
+	if got := resolver.lookups.Load(); got != 2 {
+		t.Errorf("lookups within TTL = %d, want 2", got)
+	}
+
+	now = now.Add(dnsblCacheTTL)
+	if err := checker.Check("192.0.2.10"); err == nil {
+		t.Error("Check() after TTL = nil, want still listed")
+	}
+	if got := resolver.lookups.Load(); got != 3 {
+		t.Errorf("lookups after TTL = %d, want 3", got)
+	}
+}
+
+func TestDNSBLCheckerTimeout(t *testing.T) {
+	resolver := &countingResolver{stall: true}
+	cfg := &Config{}
+	cfg.DNSBL.Zones = []string{"zen.spamhaus.org", "bl.spamcop.net"}
+	checker := NewDNSBLChecker(cfg, resolver)
+	checker.timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if err := checker.Check("192.0.2.10"); err != nil {
+		t.Errorf("Check() with a stalled resolver error = %v, want not listed", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Check() took %v, want about the timeout", elapsed)
+	}
+}
+
+func TestSessionMailDNSBL(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.DNSBL.Zones = []string{"zen.spamhaus.org"}
+	checker := NewDNSBLChecker(cfg, &fakeResolver{ip: map[string][]string{
+		"10.2.0.192.zen.spamhaus.org": {"127.0.0.2"},
+	}})
+
+	tests := []struct {
+		remoteAddr string
+		wantCode   int // 0 = accepted
+	}{
+		{"192.0.2.10:40000", 554},
+		{"198.51.100.7:40000", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			s := NewSession(tt.remoteAddr, "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+			s.dnsbl = checker
+
+			err := s.Mail("sender@example.com", nil)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("Mail() error = %v, want nil", err)
+				}
+				return
+			}
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+				t.Errorf("Mail() error = %v, want %d", err, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Envelope is the canonical record of an SMTP transaction (MAIL FROM / RCPT TO)
+// It is stored separately from the DATA message it delivered
+type Envelope struct {
+	MailFrom   string              `json:"mail_from"`
+	Size       int64               `json:"size,omitempty"`
+	Body       string              `json:"body,omitempty"`
+	UTF8       bool                `json:"smtputf8,omitempty"`
+	RequireTLS bool                `json:"requiretls,omitempty"`
+	Return     string              `json:"ret,omitempty"`
+	EnvelopeID string              `json:"envid,omitempty"`
+	Recipients []EnvelopeRecipient `json:"recipients"`
+	RemoteAddr string              `json:"remote_addr"`
+	Helo       string              `json:"helo"`
+	MailAt     time.Time           `json:"mail_at"`
+	DataAt     *time.Time          `json:"data_at,omitempty"`
+}
+
+// EnvelopeRecipient is an accepted RCPT TO with its DSN parameters
+type EnvelopeRecipient struct {
+	Address           string    `json:"address"`
+	Notify            []string  `json:"notify,omitempty"`
+	OriginalRecipient string    `json:"orcpt,omitempty"`
+	AcceptedAt        time.Time `json:"accepted_at"`
+}
+
+// newEnvelope starts an envelope from a MAIL FROM command
+func newEnvelope(from, remoteAddr, helo string, opts *smtp.MailOptions) *Envelope {
+	env := &Envelope{
+		MailFrom:   from,
+		Recipients: []EnvelopeRecipient{},
+		RemoteAddr: remoteAddr,
+		Helo:       helo,
+		MailAt:     time.Now(),
+	}
+
+	if opts != nil {
+		env.Size = opts.Size
+		env.Body = string(opts.Body)
+		env.UTF8 = opts.UTF8
+		env.RequireTLS = opts.RequireTLS
+		env.Return = string(opts.Return)
+		env.EnvelopeID = opts.EnvelopeID
+	}
+
+	return env
+}
+
+// addRecipient records an accepted RCPT TO
+func (e *Envelope) addRecipient(address string, opts *smtp.RcptOptions) {
+	rcpt := EnvelopeRecipient{
+		Address:    address,
+		AcceptedAt: time.Now(),
+	}
+
+	if opts != nil {
+		for _, notify := range opts.Notify {
+			rcpt.Notify = append(rcpt.Notify, string(notify))
+		}
+		rcpt.OriginalRecipient = opts.OriginalRecipient
+	}
+
+	e.Recipients = append(e.Recipients, rcpt)
+}
+
+// forRecipient returns a copy listing only the given recipient, so a stored
+// copy never reveals the other (possibly Bcc) addresses of the transaction
+func (e *Envelope) forRecipient(address string) *Envelope {
+	env := *e
+	env.Recipients = []EnvelopeRecipient{}
+	for _, rcpt := range e.Recipients {
+		if rcpt.Address == address {
+			env.Recipients = append(env.Recipients, rcpt)
+		}
+	}
+	return &env
+}
+
+// marshal stamps the DATA time and encodes the envelope as JSON
+func (e *Envelope) marshal(dataAt time.Time) ([]byte, error) {
+	e.DataAt = &dataAt
+	return json.Marshal(e)
+}
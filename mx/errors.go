@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors for the failure modes callers branch on
+// Session errors wrap these with details, so match them with errors.Is
+var (
+	ErrConfigInvalid      = errors.New("invalid configuration")
+	ErrInvalidAddress     = errors.New("invalid recipient address")
+	ErrDomainNotAccepted  = errors.New("relay access denied")
+	ErrAddressNotFound    = errors.New("mailbox unavailable")
+	ErrMessageTooLarge    = errors.New("message too large")
+	ErrDomainAddressLimit = errors.New("domain address limit reached")
+	ErrStorageUnavailable = errors.New("storage temporarily unavailable")
+)
+
+// ConfigError reports an invalid configuration setting
+// It matches ErrConfigInvalid with errors.Is
+type ConfigError struct {
+	Field string // dotted YAML path, e.g. "storage.over_cap_action"
+	err   error
+}
+
+// configErrorf creates a ConfigError; the message is prefixed with the field
+func configErrorf(field, format string, args ...any) *ConfigError {
+	return &ConfigError{Field: field, err: fmt.Errorf(format, args...)}
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + " " + e.err.Error()
+}
+
+// Unwrap exposes an underlying cause wrapped with %w, if any
+func (e *ConfigError) Unwrap() error {
+	return errors.Unwrap(e.err)
+}
+
+// Is makes every ConfigError match ErrConfigInvalid
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrConfigInvalid
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigErrorTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		wantField string
+	}{
+		{"missing database url", "domains:\n  - tempmail.test\n", "database.url"},
+		{"no domains", "database:\n  url: postgresql://localhost/tempmail\n", "domains"},
+		{"bad enum", "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\nstorage:\n  over_cap_action: panic\n", "storage.over_cap_action"},
+		{"bad greylist code", "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\ngreylist:\n  response_code: 550\n", "greylist.response_code"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			_, err := LoadConfig(configPath)
+			var cfgErr *ConfigError
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("LoadConfig() error = %v, want *ConfigError", err)
+			}
+			if cfgErr.Field != tt.wantField {
+				t.Errorf("Field = %q, want %q", cfgErr.Field, tt.wantField)
+			}
+			if !errors.Is(err, ErrConfigInvalid) {
+				t.Error("ConfigError should match ErrConfigInvalid")
+			}
+			if !strings.HasPrefix(err.Error(), tt.wantField+" ") {
+				t.Errorf("Error() = %q, want it to start with the field", err.Error())
+			}
+		})
+	}
+}
+
+func TestConfigErrorUnwrap(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "test.yaml")
+	config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\nvalidation:\n  reject_ptr_pattern: \"(unclosed\"\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	_, err := LoadConfig(configPath)
+	var syntaxErr *syntax.Error
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("LoadConfig() error = %v, want wrapped *syntax.Error", err)
+	}
+}
+
+func TestSessionErrorTypes(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 1
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+
+	newSession := func() *Session {
+		s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+		s.Mail("sender@example.com", nil)
+		return s
+	}
+
+	tests := []struct {
+		name string
+		rcpt string
+		want error
+	}{
+		{"invalid address", "not an address", ErrInvalidAddress},
+		{"foreign domain", "user@elsewhere.example", ErrDomainNotAccepted},
+		{"unknown mailbox", "nobody@tempmail.example.com", ErrAddressNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newSession().Rcpt(tt.rcpt, nil)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Rcpt(%q) error = %v, want %v", tt.rcpt, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("message too large", func(t *testing.T) {
+		s := newSession()
+		if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+			t.Fatalf("Rcpt() error = %v", err)
+		}
+		err := s.Data(strings.NewReader(testMessage + strings.Repeat("x", 1024*1024)))
+		if !errors.Is(err, ErrMessageTooLarge) {
+			t.Errorf("Data() error = %v, want ErrMessageTooLarge", err)
+		}
+		if !strings.Contains(err.Error(), "max 1 MB") {
+			t.Errorf("Data() error = %q, want the limit in the message", err.Error())
+		}
+	})
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+)
+
+// GeoInfo is the GeoIP country and ASN of a client IP (zero values when unknown)
+type GeoInfo struct {
+	Country string // ISO 3166-1 alpha-2, e.g. DE
+	ASN     uint32
+}
+
+// String formats the info for connection logs, e.g. "DE AS64500"
+func (g GeoInfo) String() string {
+	switch {
+	case g.Country != "" && g.ASN != 0:
+		return fmt.Sprintf("%s AS%d", g.Country, g.ASN)
+	case g.ASN != 0:
+		return fmt.Sprintf("AS%d", g.ASN)
+	}
+	return g.Country
+}
+
+// GeoIP looks client IPs up in MaxMind (MMDB) country and ASN databases
+// The databases are read into memory once; lookups never touch the disk
+type GeoIP struct {
+	dbs []*mmdbReader
+}
+
+// NewGeoIP opens the given MMDB files, skipping empty paths
+// Country and ASN may come from one combined database or from separate ones
+func NewGeoIP(paths ...string) (*GeoIP, error) {
+	g := &GeoIP{}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		db, err := openMMDB(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+		}
+		g.dbs = append(g.dbs, db)
+	}
+	return g, nil
+}
+
+// Lookup returns what the databases know about ip
+// Lookups fail open: errors and unknown addresses give empty fields
+func (g *GeoIP) Lookup(ip string) GeoInfo {
+	var info GeoInfo
+	parsed := net.ParseIP(ip)
+	if g == nil || parsed == nil {
+		return info
+	}
+
+	for _, db := range g.dbs {
+		record, err := db.lookup(parsed)
+		if err != nil {
+			slog.Warn("GeoIP lookup failed", "ip", ip, "error", err)
+			continue
+		}
+		fields, _ := record.(map[string]any)
+		if info.Country == "" {
+			if country, ok := fields["country"].(map[string]any); ok {
+				info.Country, _ = country["iso_code"].(string)
+			}
+		}
+		if info.ASN == 0 {
+			if asn, ok := fields["autonomous_system_number"].(uint64); ok && asn <= math.MaxUint32 {
+				info.ASN = uint32(asn)
+			}
+		}
+	}
+	return info
+}
+
+// mmdbMetadataMarker precedes the metadata map at the end of an MMDB file
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbReader is a minimal reader for the MaxMind DB format
+// (https://maxmind.github.io/MaxMind-DB/), enough for country and ASN lookups
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint // bits per record: 24, 28 or 32
+	ipVersion  uint
+	treeSize   uint // bytes in the search tree
+	ipv4Start  uint // node reached after the 96 leading zero bits of an IPv4-mapped address
+}
+
+// openMMDB reads an MMDB file into memory
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMMDB(buf)
+}
+
+// parseMMDB validates the metadata and prepares buf for lookups
+func parseMMDB(buf []byte) (*mmdbReader, error) {
+	start := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if start < 0 {
+		return nil, errors.New("not a MaxMind DB file (metadata marker missing)")
+	}
+	metaStart := start + len(mmdbMetadataMarker)
+	meta, _, err := (&mmdbDecoder{data: buf[metaStart:]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid metadata: not a map")
+	}
+
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", ipVersion)
+	}
+
+	r := &mmdbReader{
+		buf:        buf[:start],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  uint(ipVersion),
+	}
+	r.treeSize = r.nodeCount * r.recordSize / 4
+	// The data section starts after the tree and a 16-byte separator
+	if r.treeSize+16 > uint(len(r.buf)) {
+		return nil, errors.New("search tree larger than file")
+	}
+
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.readRecord(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// readRecord returns the left (bit 0) or right (bit 1) record of a tree node
+func (r *mmdbReader) readRecord(node uint, bit uint) uint {
+	offset := node * r.recordSize / 4
+	b := r.buf[offset : offset+r.recordSize/4]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup walks the search tree for ip and decodes its record (nil if not found)
+func (r *mmdbReader) lookup(ip net.IP) (any, error) {
+	node := uint(0)
+	bits := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = r.readRecord(node, bit)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node < r.nodeCount:
+		return nil, errors.New("search tree deeper than the address")
+	}
+
+	offset := node - r.nodeCount - 16
+	decoder := &mmdbDecoder{data: r.buf[r.treeSize+16:]}
+	if offset >= uint(len(decoder.data)) {
+		return nil, errors.New("record points outside the data section")
+	}
+	record, _, err := decoder.decode(offset)
+	return record, err
+}
+
+// MMDB data section field types
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// mmdbMaxDepth bounds nesting so a corrupt file cannot recurse forever
+const mmdbMaxDepth = 32
+
+var errMMDBTruncated = errors.New("truncated data section")
+
+// mmdbDecoder decodes MMDB data section values into Go values
+// Maps become map[string]any, unsigned integers uint64, signed int32 int64
+type mmdbDecoder struct {
+	data  []byte
+	depth int
+}
+
+// decode returns the value at offset and the offset just past it
+func (d *mmdbDecoder) decode(offset uint) (any, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+
+	if offset >= uint(len(d.data)) {
+		return nil, 0, errMMDBTruncated
+	}
+	ctrl := d.data[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+
+	if typ == mmdbExtended {
+		if offset >= uint(len(d.data)) {
+			return nil, 0, errMMDBTruncated
+		}
+		typ = 7 + uint(d.data[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.data)) {
+			return nil, 0, errMMDBTruncated
+		}
+		extra := uint(0)
+		for _, b := range d.data[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errMMDBTruncated
+	}
+	raw := d.data[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case mmdbString:
+		return string(raw), offset, nil
+	case mmdbBytes, mmdbUint128:
+		return append([]byte(nil), raw...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		v := uint64(0)
+		for _, b := range raw {
+			v = v<<8 | uint64(b)
+		}
+		return v, offset, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		v := uint32(0)
+		for _, b := range raw {
+			v = v<<8 | uint32(b)
+		}
+		return int64(int32(v)), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// pointer resolves a pointer field, returning its target and the offset past it
+func (d *mmdbDecoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl>>3)&0x3 + 1
+	if offset+size > uint(len(d.data)) {
+		return 0, 0, errMMDBTruncated
+	}
+	raw := d.data[offset : offset+size]
+
+	target := uint(0)
+	if size < 4 {
+		target = uint(ctrl & 0x7)
+	}
+	for _, b := range raw {
+		target = target<<8 | uint(b)
+	}
+	switch size {
+	case 2:
+		target += 2048
+	case 3:
+		target += 526336
+	}
+	return target, offset + size, nil
+}
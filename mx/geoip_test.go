@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mmdbEncode encodes a value in the MMDB data section format
+// Supports the types a GeoIP record needs: maps, strings and uint32
+func mmdbEncode(buf *bytes.Buffer, value any) {
+	control := func(typ, size int) {
+		if typ > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(typ - 7))
+			return
+		}
+		buf.WriteByte(byte(typ<<5 | size))
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		control(mmdbMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			mmdbEncode(buf, k)
+			mmdbEncode(buf, v[k])
+		}
+	case string:
+		control(mmdbString, len(v))
+		buf.WriteString(v)
+	case uint32:
+		control(mmdbUint32, 4)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+// buildTestMMDB builds an IPv6 MMDB with 24-bit records where network maps to record
+// IPv4 networks are placed under ::/96 as MaxMind does
+func buildTestMMDB(t *testing.T, network string, record map[string]any) []byte {
+	t.Helper()
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := ipnet.IP.To16()
+	ones, _ := ipnet.Mask.Size()
+	if ip4 := ipnet.IP.To4(); ip4 != nil {
+		ip = append(make(net.IP, 12), ip4...)
+		ones += 96
+	}
+
+	// One node per prefix bit; the path leads to the record, every other branch is empty
+	nodeCount := ones
+	dataPointer := nodeCount + 16
+	var tree bytes.Buffer
+	for i := 0; i < ones; i++ {
+		next := i + 1
+		if next == ones {
+			next = dataPointer
+		}
+		records := [2]int{nodeCount, nodeCount}
+		records[ip[i/8]>>(7-uint(i%8))&1] = next
+		for _, r := range records {
+			tree.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, 16))
+	mmdbEncode(&out, record)
+	out.Write(mmdbMetadataMarker)
+	meta := map[string]any{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint32(24),
+		"ip_version":    uint32(6),
+		"database_type": "Test-Country-ASN",
+	}
+	mmdbEncode(&out, meta)
+	return out.Bytes()
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	db := buildTestMMDB(t, "81.2.69.0/24", map[string]any{
+		"country":                  map[string]any{"iso_code": "GB"},
+		"autonomous_system_number": uint32(64500),
+	})
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	geoip, err := NewGeoIP(path, "")
+	if err != nil {
+		t.Fatalf("NewGeoIP() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want GeoInfo
+	}{
+		{"81.2.69.142", GeoInfo{Country: "GB", ASN: 64500}},
+		{"::ffff:81.2.69.1", GeoInfo{Country: "GB", ASN: 64500}},
+		{"81.2.70.1", GeoInfo{}},
+		{"2001:db8::1", GeoInfo{}},
+		{"not an ip", GeoInfo{}},
+	}
+	for _, tt := range tests {
+		if got := geoip.Lookup(tt.ip); got != tt.want {
+			t.Errorf("Lookup(%q) = %+v, want %+v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestGeoIPFailOpen(t *testing.T) {
+	if _, err := NewGeoIP(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("NewGeoIP() with a missing file should fail")
+	}
+
+	corrupt := filepath.Join(t.TempDir(), "corrupt.mmdb")
+	if err := os.WriteFile(corrupt, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGeoIP(corrupt); err == nil {
+		t.Error("NewGeoIP() with a corrupt file should fail")
+	}
+
+	// A disabled lookup returns nothing instead of failing
+	var geoip *GeoIP
+	if got := geoip.Lookup("81.2.69.142"); got != (GeoInfo{}) {
+		t.Errorf("nil GeoIP Lookup() = %+v, want empty", got)
+	}
+}
+
+func TestGeoInfoString(t *testing.T) {
+	tests := []struct {
+		info GeoInfo
+		want string
+	}{
+		{GeoInfo{Country: "DE", ASN: 3320}, "DE AS3320"},
+		{GeoInfo{Country: "DE"}, "DE"},
+		{GeoInfo{ASN: 3320}, "AS3320"},
+		{GeoInfo{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestSessionDataGeoIP(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 1
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	s := NewSession("81.2.69.142:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.geo = GeoInfo{Country: "GB", ASN: 64500}
+
+	s.Mail("sender@example.com", nil)
+	s.Rcpt("user@tempmail.example.com", nil)
+	if err := s.Data(strings.NewReader("From: sender@example.com\r\nSubject: Hi\r\n\r\nHello\r\n")); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	if len(mockDB.stored) != 1 {
+		t.Fatalf("stored %d emails, want 1", len(mockDB.stored))
+	}
+	if got := mockDB.stored[0]; got.ClientCountry != "GB" || got.ClientASN != 64500 {
+		t.Errorf("stored client = %q AS%d, want GB AS64500", got.ClientCountry, got.ClientASN)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Default greylist tempfail response
+const (
+	defaultGreylistCode    = 451
+	defaultGreylistMessage = "Greylisted, please try again later"
+)
+
+// Default greylist timing
+const (
+	defaultGreylistDelayMinutes = 5
+	defaultGreylistExpiryHours  = 36
+)
+
+// greylistCleanupInterval is how often expired triples are purged
+const greylistCleanupInterval = time.Hour
+
+// GreylistTriple identifies a delivery attempt for greylisting
+type GreylistTriple struct {
+	IP        string
+	Sender    string
+	Recipient string
+}
+
+// GreylistChecker decides whether a triple has waited out the greylist delay
+type GreylistChecker interface {
+	CheckGreylist(triple GreylistTriple) (bool, error)
+}
+
+// GreylistCleaner purges triples that were not seen within the expiry
+type GreylistCleaner interface {
+	CleanupGreylist() (int64, error)
+}
+
+// Greylister applies greylisting to recipients, honoring the configured
+// response and bypass lists
+type Greylister struct {
+	checker GreylistChecker
+	cfg     *Config
+}
+
+// NewGreylister creates a greylister backed by checker
+func NewGreylister(cfg *Config, checker GreylistChecker) *Greylister {
+	return &Greylister{checker: checker, cfg: cfg}
+}
+
+// Bypass reports whether the client IP or sender is allowlisted
+// Sender entries are full addresses or domains (matching subdomains too)
+func (g *Greylister) Bypass(ip, sender string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		for _, entry := range g.cfg.Greylist.BypassIPs {
+			if matchIP(parsed, entry) {
+				return true
+			}
+		}
+	}
+
+	sender = strings.ToLower(sender)
+	domain := sender
+	if at := strings.LastIndex(sender, "@"); at >= 0 {
+		domain = sender[at+1:]
+	}
+	for _, entry := range g.cfg.Greylist.BypassSenders {
+		entry = strings.ToLower(entry)
+		if strings.Contains(entry, "@") {
+			if sender == entry {
+				return true
+			}
+		} else if domain == entry || strings.HasSuffix(domain, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns the configured tempfail error when the triple is still greylisted
+func (g *Greylister) Check(triple GreylistTriple) error {
+	if g.Bypass(triple.IP, triple.Sender) {
+		return nil
+	}
+
+	passed, err := g.checker.CheckGreylist(triple)
+	if err != nil {
+		// Fail open: a greylist outage must not block mail
+		slog.Warn("Greylist check failed", "ip", triple.IP, "error", err)
+		return nil
+	}
+	if passed {
+		return nil
+	}
+
+	return g.tempfail()
+}
+
+// tempfail builds the configured greylist response
+func (g *Greylister) tempfail() *smtp.SMTPError {
+	code := g.cfg.Greylist.ResponseCode
+	if code == 0 {
+		code = defaultGreylistCode
+	}
+	message := g.cfg.Greylist.ResponseMessage
+	if message == "" {
+		message = defaultGreylistMessage
+	}
+
+	return &smtp.SMTPError{
+		Code:         code,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      message,
+	}
+}
+
+// startGreylistCleanup purges expired triples every greylistCleanupInterval until ctx is done
+func startGreylistCleanup(ctx context.Context, cleaner GreylistCleaner) {
+	go func() {
+		ticker := time.NewTicker(greylistCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deleted, err := cleaner.CleanupGreylist()
+				if err != nil {
+					slog.Warn("Failed to clean up greylist", "error", err)
+				} else if deleted > 0 {
+					slog.Info("Greylist: Removed expired triples", "deleted", deleted)
+				}
+			}
+		}
+	}()
+}
+
+// validateGreylistConfig checks the greylist timing, response and bypass entries
+func validateGreylistConfig(cfg *Config) error {
+	if cfg.Greylist.DelayMinutes < 0 || cfg.Greylist.ExpiryHours < 0 {
+		return configErrorf("greylist", "delay_minutes and expiry_hours must not be negative")
+	}
+	if cfg.Greylist.DelayMinutes == 0 {
+		cfg.Greylist.DelayMinutes = defaultGreylistDelayMinutes
+	}
+	if cfg.Greylist.ExpiryHours == 0 {
+		cfg.Greylist.ExpiryHours = defaultGreylistExpiryHours
+	}
+	// A triple must outlive the delay or no retry could ever pass
+	if cfg.Greylist.ExpiryHours*60 <= cfg.Greylist.DelayMinutes {
+		return configErrorf("greylist.expiry_hours", "must be longer than delay_minutes")
+	}
+
+	switch cfg.Greylist.ResponseCode {
+	case 0, 450, 451:
+	default:
+		return configErrorf("greylist.response_code", "must be 450 or 451")
+	}
+
+	for _, entry := range cfg.Greylist.BypassIPs {
+		if _, _, err := net.ParseCIDR(entry); err == nil {
+			continue
+		}
+		if net.ParseIP(entry) == nil {
+			return configErrorf("greylist.bypass_ips", "has invalid IP or CIDR %q", entry)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+// fakeGreylist records checks and greylists every unseen triple
+type fakeGreylist struct {
+	seen   map[GreylistTriple]bool
+	checks int
+}
+
+func (f *fakeGreylist) CheckGreylist(triple GreylistTriple) (bool, error) {
+	f.checks++
+	if f.seen == nil {
+		f.seen = make(map[GreylistTriple]bool)
+	}
+	passed := f.seen[triple]
+	f.seen[triple] = true
+	return passed, nil
+}
+
+// newGreylistSession returns a session for user@tempmail.example.com with greylisting
+func newGreylistSession(cfg *Config, checker GreylistChecker) *Session {
+	cfg.Domains = []string{"tempmail.example.com"}
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	s := NewSession("198.51.100.7:40000", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.greylist = NewGreylister(cfg, checker)
+	return s
+}
+
+func TestGreylistResponseCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        int
+		message     string
+		wantCode    int
+		wantMessage string
+	}{
+		{"default", 0, "", 451, defaultGreylistMessage},
+		{"configured 450", 450, "Try again in 5 minutes", 450, "Try again in 5 minutes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Greylist.ResponseCode = tt.code
+			cfg.Greylist.ResponseMessage = tt.message
+			s := newGreylistSession(cfg, &fakeGreylist{})
+
+			s.Mail("sender@example.com", nil)
+			err := s.Rcpt("user@tempmail.example.com", nil)
+
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) {
+				t.Fatalf("Rcpt() error = %v, want SMTP tempfail", err)
+			}
+			if smtpErr.Code != tt.wantCode || smtpErr.Message != tt.wantMessage {
+				t.Errorf("Rcpt() = %d %q, want %d %q", smtpErr.Code, smtpErr.Message, tt.wantCode, tt.wantMessage)
+			}
+
+			// The retry passes
+			if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+				t.Errorf("Rcpt() retry error = %v", err)
+			}
+		})
+	}
+}
+
+func TestGreylistBypass(t *testing.T) {
+	tests := []struct {
+		name    string
+		ips     []string
+		senders []string
+		from    string
+	}{
+		{"sender address", nil, []string{"bounces@esp.example"}, "bounces@esp.example"},
+		{"sender domain", nil, []string{"esp.example"}, "news@esp.example"},
+		{"sender subdomain", nil, []string{"esp.example"}, "x@mail.esp.example"},
+		{"client CIDR", []string{"198.51.100.0/24"}, nil, "anyone@example.com"},
+		{"client IP", []string{"198.51.100.7"}, nil, "anyone@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Greylist.BypassIPs = tt.ips
+			cfg.Greylist.BypassSenders = tt.senders
+			checker := &fakeGreylist{}
+			s := newGreylistSession(cfg, checker)
+
+			s.Mail(tt.from, nil)
+			if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+				t.Fatalf("Rcpt() error = %v, want bypass", err)
+			}
+			if checker.checks != 0 {
+				t.Errorf("bypassed sender consulted the greylist %d times", checker.checks)
+			}
+		})
+	}
+}
+
+func TestGreylistBypassNoMatch(t *testing.T) {
+	cfg := &Config{}
+	cfg.Greylist.BypassSenders = []string{"esp.example"}
+	g := NewGreylister(cfg, &fakeGreylist{})
+
+	if g.Bypass("203.0.113.1", "user@notesp.example") {
+		t.Error("Bypass() matched a domain that only shares a suffix")
+	}
+}
+
+func TestValidateGreylistConfig(t *testing.T) {
+	cfg := &Config{}
+	cfg.Greylist.ResponseCode = 421
+	if err := validateGreylistConfig(cfg); err == nil {
+		t.Error("response_code 421 should be rejected")
+	}
+
+	cfg = &Config{}
+	cfg.Greylist.BypassIPs = []string{"not-an-ip"}
+	if err := validateGreylistConfig(cfg); err == nil {
+		t.Error("invalid bypass IP should be rejected")
+	}
+
+	cfg = &Config{}
+	cfg.Greylist.DelayMinutes = 120
+	cfg.Greylist.ExpiryHours = 1
+	if err := validateGreylistConfig(cfg); err == nil {
+		t.Error("expiry_hours shorter than delay_minutes should be rejected")
+	}
+
+	cfg = &Config{}
+	if err := validateGreylistConfig(cfg); err != nil {
+		t.Fatalf("validateGreylistConfig() error = %v", err)
+	}
+	if cfg.Greylist.DelayMinutes != defaultGreylistDelayMinutes || cfg.Greylist.ExpiryHours != defaultGreylistExpiryHours {
+		t.Errorf("defaults = %d min, %d h", cfg.Greylist.DelayMinutes, cfg.Greylist.ExpiryHours)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout bounds the database ping behind /readyz
+const healthCheckTimeout = 2 * time.Second
+
+// healthDB is the part of DB the readiness probe needs
+type healthDB interface {
+	Healthy(ctx context.Context) error
+}
+
+// newHealthHandler serves the probes: /healthz answers while the process is
+// up, /readyz only while the database answers and the SMTP listener is bound
+// The expvar counters are served alongside at /debug/vars
+func newHealthHandler(db healthDB, listening func() bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !listening() {
+			http.Error(w, "smtp listener not bound", http.StatusServiceUnavailable)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+		if err := db.Healthy(ctx); err != nil {
+			slog.Warn("Readiness check failed", "error", err)
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// startHealthServer binds server.health_port and serves h in the background
+// A bind failure is returned so a misconfigured port is caught at startup
+func startHealthServer(addr string, h http.Handler) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s (server.health_port): %w", addr, err)
+	}
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("Health server stopped", "error", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// fakeHealthDB answers readiness pings with err
+type fakeHealthDB struct{ err error }
+
+func (f fakeHealthDB) Healthy(ctx context.Context) error { return f.err }
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		listening bool
+		dbErr     error
+		wantReady int
+	}{
+		{"ready", true, nil, http.StatusOK},
+		{"listener not bound", false, nil, http.StatusServiceUnavailable},
+		{"database down", true, errors.New("connection refused"), http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHealthHandler(fakeHealthDB{tt.dbErr}, func() bool { return tt.listening })
+
+			// Liveness does not depend on either check
+			for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": tt.wantReady} {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != want {
+					t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthHandlerVars(t *testing.T) {
+	h := newHealthHandler(fakeHealthDB{}, func() bool { return true })
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /debug/vars = %d, want 200", rec.Code)
+	}
+
+	var vars struct {
+		Tempmail map[string]json.RawMessage `json:"tempmail"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("GET /debug/vars is not JSON: %v", err)
+	}
+	if _, ok := vars.Tempmail["attachment_bytes_total"]; !ok {
+		t.Errorf("tempmail.attachment_bytes_total missing from %s", rec.Body.String())
+	}
+}
+
+func TestStartHealthServer(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer taken.Close()
+	if _, err := startHealthServer(taken.Addr().String(), http.NotFoundHandler()); err == nil {
+		t.Error("startHealthServer() on a bound port succeeded")
+	}
+}
+
+func TestSMTPServerListening(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	server, err := NewSMTPServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewSMTPServer() error = %v", err)
+	}
+	// Reserve a free port so the test can reach the server once it is up
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server.server.Addr = free.Addr().String()
+	free.Close()
+	if server.Listening() {
+		t.Fatal("Listening() before Start = true")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+	deadline := time.Now().Add(2 * time.Second)
+	for !server.Listening() {
+		if time.Now().After(deadline) {
+			t.Fatal("Listening() never became true after Start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A greeting means Serve is accepting, so Close will stop it
+	conn, err := net.Dial("tcp", server.server.Addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn.Read(make([]byte, 64))
+	conn.Close()
+
+	server.Close()
+	<-done
+	if server.Listening() {
+		t.Error("Listening() after Close = true")
+	}
+}
+
+func TestDBHealthy(t *testing.T) {
+	conn, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer conn.Close()
+	db := &DB{conn: conn}
+
+	mock.ExpectPing()
+	if err := db.Healthy(context.Background()); err != nil {
+		t.Errorf("Healthy() error = %v", err)
+	}
+
+	mock.ExpectPing().WillReturnError(fmt.Errorf("connection refused"))
+	if err := db.Healthy(context.Background()); err == nil {
+		t.Error("Healthy() succeeded with the database down")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errSMTPBlocklisted answers EHLO/HELO from a blocklisted client
+var errSMTPBlocklisted = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Connection refused: client IP is blocklisted",
+}
+
+// IPFilter refuses client IPs matching the blocklist section
+// An allowlist entry wins over any blocklist entry
+type IPFilter struct {
+	block []string
+	allow []string
+}
+
+// NewIPFilter creates a filter from the blocklist config section
+func NewIPFilter(cfg *Config) *IPFilter {
+	return &IPFilter{block: cfg.Blocklist.IPs, allow: cfg.Blocklist.AllowIPs}
+}
+
+// Blocked reports whether ip is refused, with the blocklist entry that matched
+// Unparseable addresses are let through; other checks still apply to them
+func (f *IPFilter) Blocked(ip string) (string, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", false
+	}
+	for _, entry := range f.allow {
+		if matchIP(parsed, entry) {
+			return "", false
+		}
+	}
+	for _, entry := range f.block {
+		if matchIP(parsed, entry) {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
+// errSMTPSenderBlocked answers MAIL FROM from a blocked sender domain
+var errSMTPSenderBlocked = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Sender domain is blocked",
+}
+
+// senderDomainBlocked returns the blocked_sender_domains entry matching domain
+// Entries are lowercase; "*.example.com" matches subdomains but not example.com itself
+func senderDomainBlocked(domain string, entries []string) (string, bool) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return "", false // null sender
+	}
+	for _, entry := range entries {
+		if suffix, ok := strings.CutPrefix(entry, "*"); ok {
+			if strings.HasSuffix(domain, suffix) {
+				return entry, true
+			}
+		} else if domain == entry {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
+// checkSenderDomain rejects MAIL FROM when the sender domain is blocklisted
+func (s *Session) checkSenderDomain(from string) error {
+	if s.cfg == nil || len(s.cfg.Blocklist.BlockedSenderDomains) == 0 {
+		return nil
+	}
+	if rule, blocked := senderDomainBlocked(extractDomain(from), s.cfg.Blocklist.BlockedSenderDomains); blocked {
+		s.logger().Info("REJECTED: Sender matches blocked_sender_domains", "from", from, "rule", rule)
+		return errSMTPSenderBlocked
+	}
+	return nil
+}
+
+// validateIPFilterConfig checks the blocklist section and lowercases the sender domains
+func validateIPFilterConfig(cfg *Config) error {
+	for _, entry := range cfg.Blocklist.IPs {
+		if _, err := parseCIDROrIP(entry); err != nil {
+			return configErrorf("blocklist.ips", "has invalid IP or CIDR %q", entry)
+		}
+	}
+	for _, entry := range cfg.Blocklist.AllowIPs {
+		if _, err := parseCIDROrIP(entry); err != nil {
+			return configErrorf("blocklist.allow_ips", "has invalid IP or CIDR %q", entry)
+		}
+	}
+
+	for i, entry := range cfg.Blocklist.BlockedSenderDomains {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		name := strings.TrimPrefix(entry, "*.")
+		if name == "" || strings.ContainsAny(name, "@* ") || !strings.Contains(name, ".") {
+			return configErrorf("blocklist.blocked_sender_domains", "has invalid domain %q (use example.com or *.example.com)", entry)
+		}
+		cfg.Blocklist.BlockedSenderDomains[i] = entry
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestIPFilterBlocked(t *testing.T) {
+	cfg := &Config{}
+	cfg.Blocklist.IPs = []string{"203.0.113.0/24", "198.51.100.9", "2001:db8:bad::/48"}
+	cfg.Blocklist.AllowIPs = []string{"203.0.113.25"}
+	filter := NewIPFilter(cfg)
+
+	tests := []struct {
+		ip       string
+		wantRule string
+		blocked  bool
+	}{
+		{"203.0.113.7", "203.0.113.0/24", true},
+		{"203.0.113.25", "", false}, // allowlist wins
+		{"198.51.100.9", "198.51.100.9", true},
+		{"::ffff:198.51.100.9", "198.51.100.9", true},
+		{"198.51.100.10", "", false},
+		{"2001:db8:bad:1::1", "2001:db8:bad::/48", true},
+		{"2001:db8:600d::1", "", false},
+		{"not an ip", "", false},
+	}
+	for _, tt := range tests {
+		rule, blocked := filter.Blocked(tt.ip)
+		if rule != tt.wantRule || blocked != tt.blocked {
+			t.Errorf("Blocked(%q) = %q, %v, want %q, %v", tt.ip, rule, blocked, tt.wantRule, tt.blocked)
+		}
+	}
+}
+
+func TestValidateIPFilterConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   []string
+		allow   []string
+		senders []string
+		wantErr bool
+	}{
+		{"valid", []string{"203.0.113.0/24", "2001:db8::1"}, []string{"203.0.113.25"}, []string{"*.spammy.com"}, false},
+		{"invalid blocklist entry", []string{"203.0.113.0/33"}, nil, nil, true},
+		{"invalid allowlist entry", nil, []string{"example.com"}, nil, true},
+		{"wildcard inside sender domain", nil, nil, []string{"*.spammy.*"}, true},
+		{"sender address instead of domain", nil, nil, []string{"user@spammy.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Blocklist.IPs = tt.block
+			cfg.Blocklist.AllowIPs = tt.allow
+			cfg.Blocklist.BlockedSenderDomains = tt.senders
+			if err := validateIPFilterConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateIPFilterConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackendBlocklist(t *testing.T) {
+	tests := []struct {
+		name     string
+		allow    []string
+		wantCode int
+	}{
+		{"blocklisted", nil, 550},
+		{"allowlisted", []string{"127.0.0.1"}, 250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Blocklist.IPs = []string{"127.0.0.0/8"}
+			cfg.Blocklist.AllowIPs = tt.allow
+
+			backend := NewBackend(cfg, nil, nil)
+			backend.ipFilter = NewIPFilter(cfg)
+			if _, code := dialEHLO(t, serveBackend(t, backend)); code != tt.wantCode {
+				t.Errorf("EHLO = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSenderDomainBlocked(t *testing.T) {
+	cfg := &Config{}
+	cfg.Blocklist.BlockedSenderDomains = []string{"Spammy.com.", "*.spammy.com", "bounces.tempmail.example"}
+	if err := validateIPFilterConfig(cfg); err != nil {
+		t.Fatalf("validateIPFilterConfig() error = %v", err)
+	}
+	entries := cfg.Blocklist.BlockedSenderDomains
+
+	tests := []struct {
+		domain   string
+		wantRule string
+		blocked  bool
+	}{
+		{"spammy.com", "spammy.com", true},
+		{"SPAMMY.COM", "spammy.com", true},
+		{"mail.spammy.com", "*.spammy.com", true},
+		{"a.b.Spammy.com", "*.spammy.com", true},
+		{"notspammy.com", "", false},
+		{"spammy.com.evil.example", "", false},
+		{"bounces.tempmail.example", "bounces.tempmail.example", true},
+		{"x.bounces.tempmail.example", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		rule, blocked := senderDomainBlocked(tt.domain, entries)
+		if rule != tt.wantRule || blocked != tt.blocked {
+			t.Errorf("senderDomainBlocked(%q) = %q, %v, want %q, %v", tt.domain, rule, blocked, tt.wantRule, tt.blocked)
+		}
+	}
+}
+
+func TestSessionBlockedSenderDomain(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Blocklist.BlockedSenderDomains = []string{"spammy.com", "*.spammy.com"}
+
+	tests := []struct {
+		from    string
+		blocked bool
+	}{
+		{"offers@spammy.com", true},
+		{"offers@news.Spammy.com", true},
+		{"friend@example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		s := NewSession("192.0.2.1:12345", "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+		err := s.Mail(tt.from, nil)
+		var smtpErr *smtp.SMTPError
+		if tt.blocked && (!errors.As(err, &smtpErr) || smtpErr.Code != 550) {
+			t.Errorf("Mail(%q) error = %v, want 550", tt.from, err)
+		}
+		if !tt.blocked && err != nil {
+			t.Errorf("Mail(%q) error = %v, want accepted", tt.from, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log output formats (logging.format)
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// parseLogLevel maps logging.level to a slog level; empty means info
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown level %q", level)
+}
+
+// newLogger builds the process logger from the logging section, writing to w
+// Installed with slog.SetDefault it also carries plain log.Printf output
+func newLogger(w io.Writer, cfg *Config) *slog.Logger {
+	level, err := parseLogLevel(cfg.Logging.Level)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if strings.ToLower(cfg.Logging.Format) == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
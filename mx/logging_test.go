@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		level   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"", slog.LevelInfo, false},
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"warning", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			got, err := parseLogLevel(tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLogLevel(%q) error = %v, wantErr %v", tt.level, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseLogLevel(%q) = %v, want %v", tt.level, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLoggerFormatAndLevel(t *testing.T) {
+	cfg := &Config{}
+	cfg.Logging.Level = "warn"
+	cfg.Logging.Format = "json"
+
+	var buf bytes.Buffer
+	logger := newLogger(&buf, cfg)
+	logger.Info("dropped")
+	logger.Warn("kept", "remote_addr", "192.0.2.1:25")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1 (info filtered out): %q", len(lines), buf.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	if entry["msg"] != "kept" || entry["remote_addr"] != "192.0.2.1:25" {
+		t.Errorf("entry = %v, want msg=kept remote_addr=192.0.2.1:25", entry)
+	}
+}
+
+// TestSessionLogsStructuredFields checks session logs carry the client and
+// envelope as attributes rather than inside the message text
+func TestSessionLogsStructuredFields(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Logging.Format = "json"
+
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(newLogger(&buf, cfg))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	s := NewSession("192.0.2.7:40000", "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+	s.Mail("sender@example.com", nil)
+	s.Rcpt("nobody@tempmail.example.com", nil)
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line is not JSON: %q", line)
+		}
+		if entry["remote_addr"] != "192.0.2.7:40000" {
+			t.Errorf("entry %v lacks remote_addr", entry)
+		}
+		if entry["msg"] == "REJECTED: Address does not exist" {
+			found = true
+			if entry["to"] != "nobody@tempmail.example.com" {
+				t.Errorf("rejection to = %v, want nobody@tempmail.example.com", entry["to"])
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no rejection entry in %q", buf.String())
+	}
+}
+
+func TestSessionIDTagsLogsAndStoredEmail(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Logging.Format = "json"
+
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(newLogger(&buf, cfg))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	s := NewSession("192.0.2.7:40000", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	other := NewSession("192.0.2.7:40000", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	if s.id == "" || s.id == other.id {
+		t.Fatalf("session IDs %q and %q, want distinct non-empty IDs", s.id, other.id)
+	}
+
+	s.Mail("sender@example.com", nil)
+	s.Rcpt("user@tempmail.example.com", nil)
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	if len(mockDB.stored) != 1 || mockDB.stored[0].SessionID != s.id {
+		t.Fatalf("stored %d emails with session ID %q, want 1 with %q", len(mockDB.stored), mockDB.stored[0].SessionID, s.id)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line is not JSON: %q", line)
+		}
+		if entry["session_id"] != s.id {
+			t.Errorf("entry %v lacks session_id %q", entry, s.id)
+		}
+	}
+}
+
+func TestSessionPolicyRejectionLogsSessionID(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Logging.Format = "json"
+	cfg.Blocklist.BlockedSenderDomains = []string{"spam.example"}
+
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(newLogger(&buf, cfg))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	s := NewSession("192.0.2.7:40000", "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+	if err := s.Mail("sender@spam.example", nil); err == nil {
+		t.Fatal("Mail() from a blocked domain succeeded")
+	}
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line is not JSON: %q", line)
+		}
+		if entry["msg"] == "REJECTED: Sender matches blocked_sender_domains" {
+			found = true
+			if entry["session_id"] != s.id || entry["from"] != "sender@spam.example" {
+				t.Errorf("rejection entry %v, want session_id %q and from", entry, s.id)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no rejection entry in %q", buf.String())
+	}
+}
+
+// lockedBuffer is a log sink safe to share with background goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStoreEmailLogsSessionID(t *testing.T) {
+	cfg := &Config{}
+	cfg.Logging.Format = "json"
+
+	var buf lockedBuffer
+	orig := slog.Default()
+	slog.SetDefault(newLogger(&buf, cfg))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM addresses WHERE email = \\$1 FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("INSERT INTO addresses").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("addr-1"))
+	mock.ExpectQuery("INSERT INTO emails").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("email-1"))
+	mock.ExpectExec("UPDATE addresses SET first_email_at").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_recipients").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	email := &EmailData{
+		MessageID:     "<test@example.com>",
+		ToAddr:        "user@tempmail.example.com",
+		CreateAddress: true,
+		SessionID:     "sess-1",
+		RawMessage:    []byte("test"),
+		ReceivedAt:    time.Now(),
+	}
+	if err := db.StoreEmail(email, nil); err != nil {
+		t.Fatalf("StoreEmail() error = %v", err)
+	}
+
+	want := map[string]bool{"Created address": false, "Stored email": false}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line is not JSON: %q", line)
+		}
+		msg, _ := entry["msg"].(string)
+		if _, ok := want[msg]; !ok {
+			continue
+		}
+		want[msg] = true
+		if entry["session_id"] != "sess-1" {
+			t.Errorf("%q entry %v lacks session_id sess-1", msg, entry)
+		}
+	}
+	for msg, seen := range want {
+		if !seen {
+			t.Errorf("no %q entry in %q", msg, buf.String())
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+)
+
+// logSampler returns a value in [0, 1) compared against logging.sample_rate
+var logSampler = rand.Float64
+
+// sampleConnection decides whether a new connection's routine logs are kept
+// The decision is per connection so a sampled session's trail stays complete
+func (c *Config) sampleConnection() bool {
+	if c == nil || c.Logging.SampleRate <= 0 || c.Logging.SampleRate >= 1 {
+		return true
+	}
+	return logSampler() < c.Logging.SampleRate
+}
+
+// info writes routine session logs (commands, accepted mail) for sampled
+// connections only; rejections and errors go through logger and are always kept
+func (s *Session) info(msg string, args ...any) {
+	if s.sampled {
+		s.logger().Info(msg, args...)
+	}
+}
+
+// logger returns the session's logger, tagged with its session_id and the
+// client's remote_addr
+func (s *Session) logger() *slog.Logger {
+	if s.log == nil {
+		s.log = slog.Default().With("session_id", s.id, "remote_addr", s.remoteAddr)
+	}
+	return s.log
+}
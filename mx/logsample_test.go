@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureLog redirects the standard logger for the duration of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestLogSamplingKeepsErrors(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Logging.SampleRate = 0.1
+
+	// Deterministic sampler: 1 in 10 draws falls under the rate
+	draws := 0
+	orig := logSampler
+	t.Cleanup(func() { logSampler = orig })
+	logSampler = func() float64 {
+		draws++
+		if draws%10 == 0 {
+			return 0.05
+		}
+		return 0.5
+	}
+
+	buf := captureLog(t)
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	const connections = 100
+	for i := 0; i < connections; i++ {
+		s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+		s.Mail("sender@example.com", nil)
+		s.Rcpt("nobody@tempmail.example.com", nil) // rejected: always logged
+		s.Rcpt("user@tempmail.example.com", nil)
+		if err := s.Data(strings.NewReader(testMessage)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+	}
+
+	out := buf.String()
+	if got := strings.Count(out, "MAIL FROM "); got != connections/10 {
+		t.Errorf("routine MAIL FROM lines = %d, want %d", got, connections/10)
+	}
+	if got := strings.Count(out, "SUCCESS:"); got != connections/10 {
+		t.Errorf("routine SUCCESS lines = %d, want %d", got, connections/10)
+	}
+	if got := strings.Count(out, "REJECTED: Address does not exist"); got != connections {
+		t.Errorf("rejection lines = %d, want %d (never sampled)", got, connections)
+	}
+	if len(mockDB.stored) != connections {
+		t.Errorf("stored %d messages, want %d: sampling must not affect delivery", len(mockDB.stored), connections)
+	}
+}
+
+func TestSampleConnection(t *testing.T) {
+	orig := logSampler
+	t.Cleanup(func() { logSampler = orig })
+	logSampler = func() float64 { return 0.99 }
+
+	tests := []struct {
+		rate float64
+		want bool
+	}{
+		{0, true}, // unset keeps everything
+		{1, true},
+		{0.5, false},
+	}
+	for _, tt := range tests {
+		cfg := &Config{}
+		cfg.Logging.SampleRate = tt.rate
+		if got := cfg.sampleConnection(); got != tt.want {
+			t.Errorf("sampleConnection() at rate %v = %v, want %v", tt.rate, got, tt.want)
+		}
+	}
+}
@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 )
 
 func main() {
+	selfTest := flag.Bool("selftest", false, "verify database, migrations, TLS and DNS, then exit (non-zero on failure)")
+	migrationsDir := flag.String("migrations", os.Getenv("MIGRATIONS_DIR"), "migrations directory dry-run by -selftest")
+	flag.Parse()
+
 	log.Println("Tempmail Server MX Server starting...")
 
 	// Get config path from environment or use default
@@ -28,22 +39,60 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// From here on log.Printf output also goes through the configured handler
+	slog.SetDefault(newLogger(os.Stderr, cfg))
+
+	if *selfTest {
+		os.Exit(runSelfTest(cfg, *migrationsDir))
+	}
+
 	log.Printf("Configuration loaded:")
 	log.Printf("  Domains: %v", cfg.Domains)
 	log.Printf("  MX Port: %d", cfg.Server.MXPort)
 	log.Printf("  Hostname: %s", cfg.Server.Hostname)
 	log.Printf("  Max message size: %d MB", cfg.Server.MaxMsgSizeMB)
+	log.Printf("  DB pool: %d open, %d idle, %d min lifetime",
+		cfg.Database.MaxOpenConns, cfg.Database.MaxIdleConns, cfg.Database.ConnMaxLifetimeMinutes)
 	log.Printf("  Validation - DKIM: %v, SPF: %v, DMARC: %v",
 		cfg.Validation.CheckDKIM, cfg.Validation.CheckSPF, cfg.Validation.CheckDMARC)
 
 	// Connect to database
-	db, err := NewDB(cfg.Database.URL, cfg.Database.PoolSize)
+	db, err := NewDB(cfg.Database.URL, cfg.DBPool())
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
+	db.skipFailedAttachments = cfg.Storage.AttachmentFailure == AttachmentFailureSkip
+	db.maxEmailsPerAddress = cfg.Tempmail.MaxEmailsPerAddress
+	db.maxBytesPerAddress = cfg.Tempmail.MaxBytesPerAddress
+	db.deduplicate = cfg.Tempmail.Deduplicate
+	db.addressLifetime = time.Duration(cfg.Tempmail.AddressLifetimeHours) * time.Hour
+	db.queryTimeout = cfg.QueryTimeout()
+	db.maxRetries = cfg.Database.MaxRetries
+	db.greylistDelay = time.Duration(cfg.Greylist.DelayMinutes) * time.Minute
+	db.greylistExpiry = time.Duration(cfg.Greylist.ExpiryHours) * time.Hour
 	log.Println("Database connection established")
 
+	if db.attachmentStore, err = NewStorage(cfg); err != nil {
+		log.Fatalf("Failed to set up attachment storage: %v", err)
+	}
+	log.Printf("Attachment storage: %s", cfg.Storage.Backend)
+
+	// Optionally verify each domain's MX points at us
+	diagCtx, cancelDiag := context.WithCancel(context.Background())
+	defer cancelDiag()
+	if cfg.Diagnostics.CheckMX {
+		startMXSelfCheck(diagCtx, defaultResolver(), cfg)
+	}
+
+	// Delete expired addresses and emails past their lifetime
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	cleanupDone := startExpiryCleanup(cleanupCtx, db,
+		time.Duration(cfg.Tempmail.AddressLifetimeHours)*time.Hour,
+		time.Duration(cfg.Tempmail.CleanupIntervalHours)*time.Hour)
+	log.Printf("Expiry cleanup every %dh (lifetime %dh)", cfg.Tempmail.CleanupIntervalHours, cfg.Tempmail.AddressLifetimeHours)
+
 	// Create SMTP server
 	server, err := NewSMTPServer(cfg, db)
 	if err != nil {
@@ -58,19 +107,57 @@ func main() {
 		}
 	}()
 
+	// Liveness and readiness probes
+	var health *http.Server
+	if cfg.Server.HealthPort > 0 {
+		health, err = startHealthServer(fmt.Sprintf(":%d", cfg.Server.HealthPort), newHealthHandler(db, server.Listening))
+		if err != nil {
+			log.Fatalf("Failed to start health server: %v", err)
+		}
+		log.Printf("Health probes on :%d (/healthz, /readyz)", cfg.Server.HealthPort)
+	}
+
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// SIGHUP re-reads the configuration and the TLS certificate
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
 	log.Println("Tempmail Server MX Server is ready to receive emails")
 
-	select {
-	case err := <-errChan:
-		log.Fatalf("Server error: %v", err)
-	case sig := <-sigChan:
-		log.Printf("Received signal %v, shutting down gracefully...", sig)
-		if err := server.Close(); err != nil {
-			log.Printf("Error closing server: %v", err)
+	for running := true; running; {
+		select {
+		case err := <-errChan:
+			var bindErr *bindError
+			if errors.As(err, &bindErr) {
+				log.Fatalf("Failed to start SMTP listener: %v", err)
+			}
+			log.Fatalf("Server error: %v", err)
+		case <-hupChan:
+			log.Println("Received SIGHUP, reloading configuration and TLS certificate")
+			if err := server.Reload(configPath); err != nil {
+				log.Printf("Error reloading configuration: %v", err)
+			}
+			if err := server.ReloadCertificate(); err != nil {
+				log.Printf("TLS certificate reload refused, keeping current: %v", err)
+			}
+		case sig := <-sigChan:
+			log.Printf("Received signal %v, shutting down gracefully...", sig)
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGrace())
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Error shutting down server: %v", err)
+			}
+			if health != nil {
+				if err := health.Shutdown(shutdownCtx); err != nil {
+					log.Printf("Error shutting down health server: %v", err)
+				}
+			}
+			cancel()
+			stopCleanup()
+			<-cleanupDone
+			running = false
 		}
 	}
 
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+)
+
+// Process-wide counters, published through expvar under "tempmail"
+var (
+	metrics = expvar.NewMap("tempmail")
+
+	// metricAttachmentBytes sums decoded attachment bytes over all messages
+	metricAttachmentBytes = new(expvar.Int)
+	// metricAttachmentMaxTotal is the largest per-message attachment total seen
+	metricAttachmentMaxTotal = new(expvar.Int)
+	// metricAttachmentOverLimit counts messages over max_total_attachment_size_mb
+	metricAttachmentOverLimit = new(expvar.Int)
+
+	// metricAddressesByDomain holds the active address count of each domain
+	metricAddressesByDomain = new(expvar.Map).Init()
+
+	// metricStorageUsedBytes is the stored total from the last storage refresh
+	metricStorageUsedBytes = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("attachment_bytes_total", metricAttachmentBytes)
+	metrics.Set("attachment_message_max_bytes", metricAttachmentMaxTotal)
+	metrics.Set("attachment_over_limit_total", metricAttachmentOverLimit)
+	metrics.Set("addresses_by_domain", metricAddressesByDomain)
+	metrics.Set("storage_used_bytes", metricStorageUsedBytes)
+}
+
+// maxMu serializes observeMax's read-compare-set
+var maxMu sync.Mutex
+
+// observeMax raises v to n if n is larger
+func observeMax(v *expvar.Int, n int64) {
+	maxMu.Lock()
+	defer maxMu.Unlock()
+	if n > v.Value() {
+		v.Set(n)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Event types emitted after an email is stored
+const (
+	EventEmailReceived     = "email.received"
+	EventAddressFirstEmail = "address.first_email"
+)
+
+// Event describes a stored email for downstream consumers
+type Event struct {
+	Type           string    `json:"type"`
+	EmailID        string    `json:"email_id,omitempty"`
+	Recipient      string    `json:"recipient"`
+	From           string    `json:"from"`
+	Subject        string    `json:"subject"`
+	MessageID      string    `json:"message_id"`
+	HasAttachments bool      `json:"has_attachments"`
+	RawSHA256      string    `json:"raw_message_sha256,omitempty"`
+	SessionID      string    `json:"session_id,omitempty"` // MX session that delivered the email
+	ReceivedAt     time.Time `json:"received_at"`
+
+	// Content filter verdict, separate from SPF/DKIM/DMARC (nil when scoring is off)
+	Spam *SpamVerdict `json:"spam,omitempty"`
+
+	// Set on email.batch events only
+	Count      int      `json:"count,omitempty"`
+	MessageIDs []string `json:"message_ids,omitempty"`
+}
+
+// Notifier delivers events about stored emails
+// Implementations must not block the SMTP session
+type Notifier interface {
+	Notify(event *Event)
+}
+
+// logNotifier writes events to the log (used when no external notifier is configured)
+type logNotifier struct{}
+
+// Notify logs the event
+func (logNotifier) Notify(event *Event) {
+	slog.Info("EVENT "+event.Type, "session_id", event.SessionID, "to", event.Recipient,
+		"from", event.From, "message_id", event.MessageID)
+}
+
+// silentNotifier drops events for domains configured with silent: true
+type silentNotifier struct {
+	next   Notifier
+	config func() *Config // current config, so reloads take effect
+}
+
+// newSilentNotifier wraps next so silent domains emit nothing
+func newSilentNotifier(next Notifier, config func() *Config) *silentNotifier {
+	return &silentNotifier{next: next, config: config}
+}
+
+// Notify forwards the event unless its recipient domain is silent
+func (n *silentNotifier) Notify(event *Event) {
+	if n.config().GetDomainConfig(addressDomain(event.Recipient)).Silent {
+		slog.Info("EVENT "+event.Type+" suppressed for silent domain", "session_id", event.SessionID, "to", event.Recipient)
+		return
+	}
+	n.next.Notify(event)
+}
+
+// newEvent builds an event of the given type from stored email data
+func newEvent(eventType string, email *EmailData) *Event {
+	return &Event{
+		Type:           eventType,
+		EmailID:        email.ID,
+		Recipient:      email.ToAddr,
+		From:           email.FromAddr,
+		Subject:        email.Subject,
+		MessageID:      email.MessageID,
+		HasAttachments: email.HasAttachments,
+		RawSHA256:      email.RawSHA256,
+		SessionID:      email.SessionID,
+		ReceivedAt:     email.ReceivedAt,
+		Spam:           email.Spam,
+	}
+}
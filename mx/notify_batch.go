@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// EventEmailBatch summarizes several emails to one recipient within the batch window
+const EventEmailBatch = "email.batch"
+
+// batchingNotifier coalesces email.received events per recipient
+// The first email to a recipient opens a window; every email arriving before it
+// closes is delivered in one email.batch event (a lone email is passed through as-is)
+type batchingNotifier struct {
+	next   Notifier
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingBatch
+}
+
+// pendingBatch holds the events collected for one recipient
+type pendingBatch struct {
+	events []*Event
+	timer  *time.Timer
+}
+
+// newBatchingNotifier wraps next with per-recipient batching
+func newBatchingNotifier(next Notifier, window time.Duration) *batchingNotifier {
+	return &batchingNotifier{
+		next:    next,
+		window:  window,
+		pending: make(map[string]*pendingBatch),
+	}
+}
+
+// Notify queues email.received events and forwards everything else immediately
+func (n *batchingNotifier) Notify(event *Event) {
+	if event.Type != EventEmailReceived {
+		n.next.Notify(event)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	batch, ok := n.pending[event.Recipient]
+	if !ok {
+		batch = &pendingBatch{}
+		n.pending[event.Recipient] = batch
+		recipient := event.Recipient
+		batch.timer = time.AfterFunc(n.window, func() { n.flush(recipient) })
+	}
+	batch.events = append(batch.events, event)
+}
+
+// flush delivers the pending batch for a recipient
+func (n *batchingNotifier) flush(recipient string) {
+	n.mu.Lock()
+	batch, ok := n.pending[recipient]
+	delete(n.pending, recipient)
+	n.mu.Unlock()
+
+	if ok {
+		n.next.Notify(batchEvent(batch.events))
+	}
+}
+
+// Close stops pending timers and delivers every queued batch
+func (n *batchingNotifier) Close() {
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = make(map[string]*pendingBatch)
+	n.mu.Unlock()
+
+	for _, batch := range pending {
+		batch.timer.Stop()
+		n.next.Notify(batchEvent(batch.events))
+	}
+}
+
+// batchEvent merges queued events into one, newest email first in the summary
+func batchEvent(events []*Event) *Event {
+	if len(events) == 1 {
+		return events[0]
+	}
+
+	latest := events[len(events)-1]
+	batch := &Event{
+		Type:       EventEmailBatch,
+		Recipient:  latest.Recipient,
+		From:       latest.From,
+		Subject:    latest.Subject,
+		MessageID:  latest.MessageID,
+		ReceivedAt: latest.ReceivedAt,
+		Count:      len(events),
+		MessageIDs: make([]string, 0, len(events)),
+	}
+	for _, e := range events {
+		batch.MessageIDs = append(batch.MessageIDs, e.MessageID)
+		batch.HasAttachments = batch.HasAttachments || e.HasAttachments
+	}
+	return batch
+}
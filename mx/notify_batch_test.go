@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncNotifier records events from multiple goroutines
+type syncNotifier struct {
+	mu     sync.Mutex
+	events []*Event
+	ch     chan *Event
+}
+
+func newSyncNotifier() *syncNotifier {
+	return &syncNotifier{ch: make(chan *Event, 16)}
+}
+
+func (n *syncNotifier) Notify(event *Event) {
+	n.mu.Lock()
+	n.events = append(n.events, event)
+	n.mu.Unlock()
+	n.ch <- event
+}
+
+func (n *syncNotifier) wait(t *testing.T) *Event {
+	t.Helper()
+	select {
+	case event := <-n.ch:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+func TestBatchingNotifierCoalesces(t *testing.T) {
+	next := newSyncNotifier()
+	n := newBatchingNotifier(next, 50*time.Millisecond)
+
+	for i := 1; i <= 5; i++ {
+		n.Notify(&Event{
+			Type:      EventEmailReceived,
+			Recipient: "user@tempmail.example.com",
+			MessageID: fmt.Sprintf("<%d@example.com>", i),
+		})
+	}
+
+	event := next.wait(t)
+	if event.Type != EventEmailBatch || event.Count != 5 {
+		t.Fatalf("got %s with count %d, want email.batch with count 5", event.Type, event.Count)
+	}
+	for i, id := range event.MessageIDs {
+		if want := fmt.Sprintf("<%d@example.com>", i+1); id != want {
+			t.Errorf("MessageIDs[%d] = %s, want %s", i, id, want)
+		}
+	}
+
+	// Window has closed; nothing else is pending
+	select {
+	case extra := <-next.ch:
+		t.Errorf("unexpected extra event %+v", extra)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBatchingNotifierSingleAndPassthrough(t *testing.T) {
+	next := newSyncNotifier()
+	n := newBatchingNotifier(next, 50*time.Millisecond)
+
+	// Non-received events are not delayed
+	n.Notify(&Event{Type: EventAddressFirstEmail, Recipient: "a@tempmail.example.com"})
+	if event := next.wait(t); event.Type != EventAddressFirstEmail {
+		t.Fatalf("got %s, want passthrough of address.first_email", event.Type)
+	}
+
+	// Separate recipients batch independently; a lone email is delivered unchanged
+	n.Notify(&Event{Type: EventEmailReceived, Recipient: "a@tempmail.example.com", MessageID: "<a@x>"})
+	n.Notify(&Event{Type: EventEmailReceived, Recipient: "b@tempmail.example.com", MessageID: "<b@x>"})
+
+	got := map[string]*Event{}
+	for i := 0; i < 2; i++ {
+		event := next.wait(t)
+		got[event.Recipient] = event
+	}
+	for _, recipient := range []string{"a@tempmail.example.com", "b@tempmail.example.com"} {
+		if event := got[recipient]; event == nil || event.Type != EventEmailReceived || event.Count != 0 {
+			t.Errorf("event for %s = %+v, want single email.received", recipient, event)
+		}
+	}
+}
+
+func TestBatchingNotifierCloseFlushes(t *testing.T) {
+	next := newSyncNotifier()
+	n := newBatchingNotifier(next, time.Hour)
+
+	n.Notify(&Event{Type: EventEmailReceived, Recipient: "a@tempmail.example.com", MessageID: "<1@x>"})
+	n.Notify(&Event{Type: EventEmailReceived, Recipient: "a@tempmail.example.com", MessageID: "<2@x>"})
+	n.Close()
+
+	if event := next.wait(t); event.Count != 2 {
+		t.Errorf("Close() delivered count %d, want 2", event.Count)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsPublisher publishes with PUB over the NATS client protocol
+// PUB is not acknowledged; a server -ERR or a dropped connection is noticed
+// by the reader and the next Publish reconnects
+type natsPublisher struct {
+	addr    string
+	connect natsConnect
+
+	mu   sync.Mutex // guards conn and err; the reader also writes PONGs
+	conn net.Conn
+	err  error // why the current connection is unusable, set by the reader
+}
+
+// natsConnect is the CONNECT options sent after the server's INFO
+type natsConnect struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+// newNATSPublisher parses nats://[user:password@|token@]host[:port]
+func newNATSPublisher(rawURL string) (*natsPublisher, error) {
+	u, err := parseNotifyURL(rawURL, "nats", "4222")
+	if err != nil {
+		return nil, configErrorf("notify.url", "%v", err)
+	}
+	p := &natsPublisher{
+		addr:    u.Host,
+		connect: natsConnect{Name: "tempmail-mx", Lang: "go", Version: "1.0"},
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			p.connect.User, p.connect.Pass = u.User.Username(), pass
+		} else {
+			p.connect.AuthToken = u.User.Username()
+		}
+	}
+	return p, nil
+}
+
+// Publish sends PUB subject payload, reconnecting first if needed
+func (p *natsPublisher) Publish(subject string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil || p.err != nil {
+		p.closeLocked()
+		if err := p.dial(); err != nil {
+			return err
+		}
+	}
+
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(payload), payload)
+	p.conn.SetWriteDeadline(time.Now().Add(notifyDialTimeout))
+	if _, err := p.conn.Write([]byte(msg)); err != nil {
+		p.closeLocked()
+		return fmt.Errorf("nats: %w", err)
+	}
+	return nil
+}
+
+// Close drops the connection
+func (p *natsPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closeLocked()
+}
+
+func (p *natsPublisher) closeLocked() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.err = nil, nil
+	return err
+}
+
+// dial connects and completes the INFO / CONNECT / PING handshake
+func (p *natsPublisher) dial() error {
+	conn, err := net.DialTimeout("tcp", p.addr, notifyDialTimeout)
+	if err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(notifyDialTimeout))
+	r := bufio.NewReader(conn)
+
+	fail := func(err error) error {
+		conn.Close()
+		return fmt.Errorf("nats: %w", err)
+	}
+
+	line, err := readNATSLine(r)
+	if err != nil {
+		return fail(err)
+	}
+	info, ok := strings.CutPrefix(line, "INFO ")
+	if !ok {
+		return fail(fmt.Errorf("expected INFO, got %q", line))
+	}
+	var serverInfo struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(info), &serverInfo); err != nil {
+		return fail(fmt.Errorf("bad INFO: %w", err))
+	}
+	if serverInfo.TLSRequired {
+		return fail(errors.New("server requires TLS, which is not supported"))
+	}
+
+	opts, err := json.Marshal(p.connect)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", opts); err != nil {
+		return fail(err)
+	}
+	// The PONG confirms CONNECT was accepted (auth errors arrive as -ERR)
+	for {
+		line, err := readNATSLine(r)
+		if err != nil {
+			return fail(err)
+		}
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return fail(errors.New(line))
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	p.conn, p.err = conn, nil
+	go p.readLoop(conn, r)
+	return nil
+}
+
+// readLoop answers server PINGs and records why the connection ended
+func (p *natsPublisher) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := readNATSLine(r)
+		if err == nil && strings.HasPrefix(line, "-ERR") {
+			err = errors.New(line)
+		}
+		p.mu.Lock()
+		if p.conn != conn {
+			// Replaced or closed by Publish
+			p.mu.Unlock()
+			return
+		}
+		if err != nil {
+			p.err = err
+			p.mu.Unlock()
+			return
+		}
+		if line == "PING" {
+			conn.SetWriteDeadline(time.Now().Add(notifyDialTimeout))
+			conn.Write([]byte("PONG\r\n"))
+		}
+		p.mu.Unlock()
+	}
+}
+
+func readNATSLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
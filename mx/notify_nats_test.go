@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS is a NATS server speaking just enough of the protocol for PUB
+type fakeNATS struct {
+	addr     string
+	info     string
+	connects chan natsConnect
+	pubs     chan string // "subject payload"
+	pongs    chan struct{}
+	conns    chan net.Conn
+}
+
+// startFakeNATS accepts connections whose CONNECT passes auth
+func startFakeNATS(t *testing.T, info string, auth func(natsConnect) bool) *fakeNATS {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeNATS{
+		addr:     l.Addr().String(),
+		info:     info,
+		connects: make(chan natsConnect, 4),
+		pubs:     make(chan string, 4),
+		pongs:    make(chan struct{}, 4),
+		conns:    make(chan net.Conn, 4),
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c, auth)
+		}
+	}()
+	return f
+}
+
+func (f *fakeNATS) serve(c net.Conn, auth func(natsConnect) bool) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	c.Write([]byte("INFO " + f.info + "\r\n"))
+	for {
+		line, err := readNATSLine(r)
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			var opts natsConnect
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &opts)
+			f.connects <- opts
+			if !auth(opts) {
+				c.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+				return
+			}
+		case line == "PING":
+			c.Write([]byte("PONG\r\n"))
+			f.conns <- c
+		case line == "PONG":
+			f.pongs <- struct{}{}
+		case strings.HasPrefix(line, "PUB "):
+			payload, err := readNATSLine(r)
+			if err != nil {
+				return
+			}
+			f.pubs <- strings.Fields(line)[1] + " " + payload
+		}
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	authorized := func(opts natsConnect) bool {
+		return (opts.User == "mx" && opts.Pass == "s3cret") || opts.AuthToken == "t0ken"
+	}
+
+	tests := []struct {
+		name    string
+		info    string
+		creds   string
+		wantErr string
+	}{
+		{"user and password", `{"server_id":"fake"}`, "mx:s3cret@", ""},
+		{"token", `{"server_id":"fake"}`, "t0ken@", ""},
+		{"wrong password", `{"server_id":"fake"}`, "mx:wrong@", "Authorization Violation"},
+		{"TLS required", `{"server_id":"fake","tls_required":true}`, "t0ken@", "requires TLS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := startFakeNATS(t, tt.info, authorized)
+			p, err := newNATSPublisher("nats://" + tt.creds + f.addr)
+			if err != nil {
+				t.Fatalf("newNATSPublisher() error = %v", err)
+			}
+			defer p.Close()
+
+			err = p.Publish("tempmail.events", []byte(`{"email_id":"1"}`))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Publish() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			if got := <-f.connects; got.Verbose {
+				t.Error("CONNECT asked for verbose +OK acknowledgements")
+			}
+			if got := <-f.pubs; got != `tempmail.events {"email_id":"1"}` {
+				t.Errorf("PUB = %q", got)
+			}
+		})
+	}
+}
+
+func TestNATSPublisherPingAndReconnect(t *testing.T) {
+	f := startFakeNATS(t, `{"server_id":"fake"}`, func(natsConnect) bool { return true })
+	p, err := newNATSPublisher("nats://" + f.addr)
+	if err != nil {
+		t.Fatalf("newNATSPublisher() error = %v", err)
+	}
+	defer p.Close()
+
+	if err := p.Publish("tempmail.events", []byte("1")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	<-f.pubs
+	conn := <-f.conns
+
+	// Server keepalive PINGs are answered so the connection stays open
+	conn.Write([]byte("PING\r\n"))
+	select {
+	case <-f.pongs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server PING was not answered")
+	}
+
+	// After the server drops the connection the next publish reconnects
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.mu.Lock()
+		broken := p.err != nil
+		p.mu.Unlock()
+		if broken {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dropped connection was not noticed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := p.Publish("tempmail.events", []byte("2")); err != nil {
+		t.Fatalf("Publish() after drop error = %v", err)
+	}
+	if got := <-f.pubs; got != "tempmail.events 2" {
+		t.Errorf("PUB after reconnect = %q", got)
+	}
+}
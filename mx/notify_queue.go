@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Message queue backends (notify.backend)
+const (
+	NotifyBackendRedis = "redis" // PUBLISH to a Redis channel
+	NotifyBackendNATS  = "nats"  // PUB to a NATS subject
+)
+
+// Queue publishing limits
+const (
+	defaultNotifyChannel = "tempmail.events"
+	notifyQueueSize      = 1000
+	notifyDialTimeout    = 5 * time.Second
+)
+
+// Publisher sends a payload to a channel or subject on a message queue
+// Implementations keep one connection and reconnect after a failure
+type Publisher interface {
+	Publish(channel string, payload []byte) error
+	Close() error
+}
+
+// NewPublisher returns the publisher for notify.backend, nil when unset
+// A new backend only needs a Publisher and a case here
+func NewPublisher(cfg *Config) (Publisher, error) {
+	switch cfg.Notify.Backend {
+	case "":
+		return nil, nil
+	case NotifyBackendRedis:
+		return newRedisPublisher(cfg.Notify.URL)
+	case NotifyBackendNATS:
+		return newNATSPublisher(cfg.Notify.URL)
+	}
+	return nil, configErrorf("notify.backend", "must be redis or nats")
+}
+
+// queueEvent is the message published per event; consumers fetch the email by ID
+type queueEvent struct {
+	Type      string `json:"type"`
+	EmailID   string `json:"email_id"`
+	Recipient string `json:"recipient"`
+	MessageID string `json:"message_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// queueNotifier publishes each event to a message queue from a background
+// worker, so a slow or unreachable broker never holds up the SMTP session;
+// failures are logged and the event dropped
+type queueNotifier struct {
+	pub     Publisher
+	channel string
+
+	queue     chan *Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newQueueNotifier starts publishing to channel through pub
+func newQueueNotifier(pub Publisher, channel string) *queueNotifier {
+	n := &queueNotifier{
+		pub:     pub,
+		channel: channel,
+		queue:   make(chan *Event, notifyQueueSize),
+		done:    make(chan struct{}),
+	}
+	go n.worker()
+	return n
+}
+
+// Notify queues the event for publishing
+func (n *queueNotifier) Notify(event *Event) {
+	select {
+	case n.queue <- event:
+	default:
+		slog.Warn("Queue notifier full, event dropped", "session_id", event.SessionID,
+			"type", event.Type, "to", event.Recipient, "email_id", event.EmailID)
+	}
+}
+
+// Close publishes the queued events, then closes the connection
+func (n *queueNotifier) Close() {
+	n.closeOnce.Do(func() { close(n.queue) })
+	<-n.done
+}
+
+func (n *queueNotifier) worker() {
+	defer close(n.done)
+	defer n.pub.Close()
+	for event := range n.queue {
+		payload, err := json.Marshal(queueEvent{
+			Type:      event.Type,
+			EmailID:   event.EmailID,
+			Recipient: event.Recipient,
+			MessageID: event.MessageID,
+			SessionID: event.SessionID,
+		})
+		if err != nil {
+			slog.Error("Queue event not encoded", "session_id", event.SessionID, "error", err)
+			continue
+		}
+		if err := n.pub.Publish(n.channel, payload); err != nil {
+			slog.Error("Queue publish failed, event dropped", "session_id", event.SessionID,
+				"type", event.Type, "to", event.Recipient, "email_id", event.EmailID, "error", err)
+		}
+	}
+}
+
+// multiNotifier fans each event out to several notifiers (e.g. webhook and queue)
+type multiNotifier []Notifier
+
+// Notify forwards the event to every notifier
+func (m multiNotifier) Notify(event *Event) {
+	for _, n := range m {
+		n.Notify(event)
+	}
+}
+
+// parseNotifyURL checks a broker URL's scheme and fills in the default port
+func parseNotifyURL(raw, scheme, defaultPort string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != scheme || u.Hostname() == "" {
+		return nil, fmt.Errorf("must be a %s://host[:port] URL", scheme)
+	}
+	if u.Port() == "" {
+		u.Host += ":" + defaultPort
+	}
+	return u, nil
+}
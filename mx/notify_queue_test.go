@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePublisher records payloads, failing with err when set
+type fakePublisher struct {
+	mu       sync.Mutex
+	channels []string
+	payloads [][]byte
+	err      error
+	block    chan struct{} // when set, Publish waits for it to close
+	closed   bool
+}
+
+func (p *fakePublisher) Publish(channel string, payload []byte) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.channels = append(p.channels, channel)
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func TestQueueNotifierPublishes(t *testing.T) {
+	pub := &fakePublisher{}
+	n := newQueueNotifier(pub, "tempmail.events")
+	n.Notify(newEvent(EventEmailReceived, &EmailData{
+		ID:        "email-1",
+		ToAddr:    "user@tempmail.example.com",
+		FromAddr:  "sender@example.com",
+		Subject:   "Not published",
+		MessageID: "<abc@example.com>",
+		SessionID: "a1b2c3",
+	}))
+	n.Close()
+
+	if len(pub.payloads) != 1 || pub.channels[0] != "tempmail.events" {
+		t.Fatalf("published %d events to %v, want 1 to tempmail.events", len(pub.payloads), pub.channels)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(pub.payloads[0], &got); err != nil {
+		t.Fatalf("payload %q: %v", pub.payloads[0], err)
+	}
+	want := map[string]any{
+		"type":       EventEmailReceived,
+		"email_id":   "email-1",
+		"recipient":  "user@tempmail.example.com",
+		"message_id": "<abc@example.com>",
+		"session_id": "a1b2c3",
+	}
+	if len(got) != len(want) {
+		t.Errorf("payload = %v, want only %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("payload[%q] = %v, want %v", key, got[key], value)
+		}
+	}
+	if !pub.closed {
+		t.Error("Close() did not close the publisher")
+	}
+}
+
+func TestQueueNotifierFailuresDoNotBlock(t *testing.T) {
+	tests := []struct {
+		name string
+		pub  *fakePublisher
+	}{
+		{"publish error", &fakePublisher{err: errors.New("connection refused")}},
+		{"stalled broker", &fakePublisher{block: make(chan struct{})}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newQueueNotifier(tt.pub, "tempmail.events")
+			done := make(chan struct{})
+			go func() {
+				for range notifyQueueSize + 10 {
+					n.Notify(&Event{Type: EventEmailReceived})
+				}
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Error("Notify blocked on a failing publisher")
+			}
+			if tt.pub.block != nil {
+				close(tt.pub.block)
+			}
+			n.Close()
+		})
+	}
+}
+
+func TestMultiNotifier(t *testing.T) {
+	a, b := newSyncNotifier(), newSyncNotifier()
+	multiNotifier{a, b}.Notify(&Event{Type: EventEmailReceived, Recipient: "user@tempmail.example.com"})
+	for _, n := range []*syncNotifier{a, b} {
+		if event := n.wait(t); event.Recipient != "user@tempmail.example.com" {
+			t.Errorf("Recipient = %q, want user@tempmail.example.com", event.Recipient)
+		}
+	}
+}
+
+func TestNewPublisher(t *testing.T) {
+	tests := []struct {
+		backend string
+		url     string
+		wantNil bool
+		wantErr bool
+	}{
+		{"", "", true, false},
+		{NotifyBackendRedis, "redis://:pw@redis.internal", false, false},
+		{NotifyBackendNATS, "nats://nats.internal:4222", false, false},
+		{NotifyBackendRedis, "nats://nats.internal", false, true},
+		{NotifyBackendNATS, "", false, true},
+		{"kafka", "kafka://broker", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backend+" "+tt.url, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Notify.Backend = tt.backend
+			cfg.Notify.URL = tt.url
+			pub, err := NewPublisher(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewPublisher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrConfigInvalid) {
+					t.Errorf("error %v is not a config error", err)
+				}
+				return
+			}
+			if (pub == nil) != tt.wantNil {
+				t.Errorf("NewPublisher() = %v, want nil %v", pub, tt.wantNil)
+			}
+		})
+	}
+}
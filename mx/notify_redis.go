@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// redisPublisher publishes with PUBLISH over the Redis protocol (RESP)
+// It connects on first use and again after any error; like every Publisher
+// it is driven by a single queueNotifier worker
+type redisPublisher struct {
+	addr     string
+	username string
+	password string
+
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisPublisher parses redis://[[user]:password@]host[:port]; the database
+// number is ignored since Redis channels are shared across databases
+func newRedisPublisher(rawURL string) (*redisPublisher, error) {
+	u, err := parseNotifyURL(rawURL, "redis", "6379")
+	if err != nil {
+		return nil, configErrorf("notify.url", "%v", err)
+	}
+	p := &redisPublisher{addr: u.Host}
+	if u.User != nil {
+		p.username = u.User.Username()
+		p.password, _ = u.User.Password()
+	}
+	return p, nil
+}
+
+// Publish sends PUBLISH channel payload; zero subscribers is not an error
+// A connection the server closed while idle is retried once on a new one
+func (p *redisPublisher) Publish(channel string, payload []byte) error {
+	reused := p.conn != nil
+	err := p.publish(channel, payload)
+	if err != nil && reused {
+		err = p.publish(channel, payload)
+	}
+	return err
+}
+
+func (p *redisPublisher) publish(channel string, payload []byte) error {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	if _, err := p.command("PUBLISH", channel, string(payload)); err != nil {
+		p.Close()
+		return err
+	}
+	return nil
+}
+
+// Close drops the connection; the next Publish reconnects
+func (p *redisPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.r = nil, nil
+	return err
+}
+
+func (p *redisPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, notifyDialTimeout)
+	if err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	p.conn, p.r = conn, bufio.NewReader(conn)
+
+	if p.password != "" {
+		args := []string{"AUTH", p.password}
+		if p.username != "" {
+			args = []string{"AUTH", p.username, p.password}
+		}
+		if _, err := p.command(args...); err != nil {
+			p.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// command sends args as a RESP array and returns the reply line
+func (p *redisPublisher) command(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	p.conn.SetDeadline(time.Now().Add(notifyDialTimeout))
+	if _, err := p.conn.Write([]byte(b.String())); err != nil {
+		return "", fmt.Errorf("redis: %w", err)
+	}
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("redis: %w", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "-") {
+		return "", fmt.Errorf("redis: %s", line[1:])
+	}
+	return line, nil
+}
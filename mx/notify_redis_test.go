@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readRESPCommand reads one client command sent as a RESP array
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, fmt.Errorf("bad array header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil { // $len
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+// startFakeRedis serves each connection with reply, sending every command
+// received on the returned channel; dropAfter closes a connection after
+// that many commands (0 = never)
+func startFakeRedis(t *testing.T, reply func(args []string) string, dropAfter int) (string, <-chan []string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	commands := make(chan []string, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for served := 1; ; served++ {
+					args, err := readRESPCommand(r)
+					if err != nil {
+						return
+					}
+					commands <- args
+					c.Write([]byte(reply(args) + "\r\n"))
+					if served == dropAfter {
+						return
+					}
+				}
+			}(c)
+		}
+	}()
+	return l.Addr().String(), commands
+}
+
+func TestRedisPublisher(t *testing.T) {
+	addr, commands := startFakeRedis(t, func(args []string) string {
+		if args[0] == "AUTH" && args[len(args)-1] != "s3cret" {
+			return "-WRONGPASS invalid username-password pair"
+		}
+		return ":1"
+	}, 2)
+
+	p, err := newRedisPublisher("redis://:s3cret@" + addr + "/0")
+	if err != nil {
+		t.Fatalf("newRedisPublisher() error = %v", err)
+	}
+	defer p.Close()
+
+	// Each connection serves AUTH and one PUBLISH, so the second publish
+	// has to notice the dropped connection and reconnect
+	for _, payload := range []string{`{"email_id":"1"}`, `{"email_id":"2"}`} {
+		if err := p.Publish("tempmail.events", []byte(payload)); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+		if got := <-commands; strings.Join(got, " ") != "AUTH s3cret" {
+			t.Errorf("first command = %q, want AUTH s3cret", got)
+		}
+		if got := <-commands; strings.Join(got, " ") != "PUBLISH tempmail.events "+payload {
+			t.Errorf("command = %q, want PUBLISH tempmail.events %s", got, payload)
+		}
+	}
+
+	bad, err := newRedisPublisher("redis://:wrong@" + addr)
+	if err != nil {
+		t.Fatalf("newRedisPublisher() error = %v", err)
+	}
+	if err := bad.Publish("tempmail.events", []byte("{}")); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Publish() with a wrong password error = %v, want WRONGPASS", err)
+	}
+}
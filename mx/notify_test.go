@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSilentDomainSuppressesEvents(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com", "new.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.DomainsConfig = map[string]DomainConfig{"new.example.com": {Silent: true}}
+
+	mockDB := &mockSessionDB{addresses: map[string]bool{
+		"user@tempmail.example.com": true,
+		"user@new.example.com":      true,
+	}}
+	recorder := &recordingNotifier{}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.notifier = newSilentNotifier(recorder, func() *Config { return cfg })
+	s.Mail("sender@example.com", nil)
+	s.Rcpt("user@tempmail.example.com", nil)
+	s.Rcpt("user@new.example.com", nil)
+
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	// Both copies are stored; only the non-silent domain produces events
+	if len(mockDB.stored) != 2 {
+		t.Fatalf("stored %d copies, want 2", len(mockDB.stored))
+	}
+	for _, event := range recorder.events {
+		if event.Recipient == "user@new.example.com" {
+			t.Errorf("event %s emitted for silent domain", event.Type)
+		}
+	}
+	if got := recorder.countEvents(EventEmailReceived); got != 1 {
+		t.Errorf("email.received events = %d, want 1", got)
+	}
+}
+
+func TestSilentNotifierFollowsReload(t *testing.T) {
+	cfg := &Config{Domains: []string{"new.example.com"}}
+	cfg.DomainsConfig = map[string]DomainConfig{"new.example.com": {Silent: true}}
+	recorder := &recordingNotifier{}
+	n := newSilentNotifier(recorder, func() *Config { return cfg })
+
+	n.Notify(&Event{Type: EventEmailReceived, Recipient: "user@New.Example.com"})
+	if len(recorder.events) != 0 {
+		t.Fatalf("silent domain emitted %d events, want 0", len(recorder.events))
+	}
+
+	// Once the domain is validated and the flag removed, events flow
+	cfg = &Config{Domains: []string{"new.example.com"}}
+	n.Notify(&Event{Type: EventEmailReceived, Recipient: "user@new.example.com"})
+	if len(recorder.events) != 1 {
+		t.Errorf("events after reload = %d, want 1", len(recorder.events))
+	}
+}
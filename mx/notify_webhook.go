@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhook delivery limits
+const (
+	defaultWebhookTimeoutSeconds = 10
+	webhookQueueSize             = 1000
+	webhookWorkers               = 4
+	webhookMaxAttempts           = 3
+	webhookRetryDelay            = 2 * time.Second // doubled after each failed attempt
+)
+
+// Webhook request headers
+const (
+	WebhookSignatureHeader = "X-Tempmail-Signature" // "sha256=" + hex HMAC-SHA256 of the body
+	WebhookEventHeader     = "X-Tempmail-Event"
+)
+
+// webhookNotifier POSTs each event as JSON to webhooks.url
+// Events are queued and sent by background workers so the SMTP session never
+// waits on the receiver; a full queue drops the event rather than block
+type webhookNotifier struct {
+	url        string
+	secret     []byte
+	client     *http.Client
+	retryDelay time.Duration
+
+	queue     chan *Event
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// newWebhookNotifier starts the delivery workers
+func newWebhookNotifier(url, secret string, timeout time.Duration) *webhookNotifier {
+	n := &webhookNotifier{
+		url:        url,
+		secret:     []byte(secret),
+		client:     &http.Client{Timeout: timeout},
+		retryDelay: webhookRetryDelay,
+		queue:      make(chan *Event, webhookQueueSize),
+	}
+	for range webhookWorkers {
+		n.wg.Add(1)
+		go n.worker()
+	}
+	return n
+}
+
+// Notify queues the event for delivery
+func (n *webhookNotifier) Notify(event *Event) {
+	select {
+	case n.queue <- event:
+	default:
+		slog.Warn("Webhook queue full, event dropped", "session_id", event.SessionID,
+			"type", event.Type, "to", event.Recipient, "message_id", event.MessageID)
+	}
+}
+
+// Close delivers the queued events and stops the workers
+func (n *webhookNotifier) Close() {
+	n.closeOnce.Do(func() { close(n.queue) })
+	n.wg.Wait()
+}
+
+func (n *webhookNotifier) worker() {
+	defer n.wg.Done()
+	for event := range n.queue {
+		n.deliver(event)
+	}
+}
+
+// deliver sends event, retrying network errors, 429 and 5xx responses
+func (n *webhookNotifier) deliver(event *Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Webhook event not encoded", "session_id", event.SessionID, "error", err)
+		return
+	}
+
+	delay := n.retryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(event.Type, body)
+		if err == nil {
+			slog.Info("EVENT "+event.Type+" delivered", "session_id", event.SessionID,
+				"to", event.Recipient, "message_id", event.MessageID)
+			return
+		}
+		if !retry || attempt == webhookMaxAttempts {
+			slog.Error("Webhook delivery failed", "session_id", event.SessionID, "type", event.Type,
+				"to", event.Recipient, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Warn("Webhook delivery failed, retrying", "session_id", event.SessionID,
+			"attempt", attempt, "retry_in", delay, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth retrying
+func (n *webhookNotifier) post(eventType string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, eventType)
+	req.Header.Set(WebhookSignatureHeader, signWebhook(n.secret, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// signWebhook returns the signature header value for body
+// Receivers recompute it with the shared secret and compare in constant time
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestWebhook returns a notifier posting to url with retries sped up
+func newTestWebhook(url string) *webhookNotifier {
+	n := newWebhookNotifier(url, "s3cret", time.Second)
+	n.retryDelay = time.Millisecond
+	return n
+}
+
+func TestWebhookNotifierDelivers(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		body      []byte
+		signature string
+		eventType string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(WebhookSignatureHeader)
+		eventType = r.Header.Get(WebhookEventHeader)
+	}))
+	defer srv.Close()
+
+	received := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+	n := newTestWebhook(srv.URL)
+	n.Notify(newEvent(EventEmailReceived, &EmailData{
+		ToAddr:         "user@tempmail.example.com",
+		FromAddr:       "sender@example.com",
+		Subject:        "Hello",
+		MessageID:      "<abc@example.com>",
+		HasAttachments: true,
+		ReceivedAt:     received,
+	}))
+	n.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := signWebhook([]byte("s3cret"), body); !hmac.Equal([]byte(signature), []byte(want)) {
+		t.Errorf("%s = %q, want %q", WebhookSignatureHeader, signature, want)
+	}
+	if eventType != EventEmailReceived {
+		t.Errorf("%s = %q, want %q", WebhookEventHeader, eventType, EventEmailReceived)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("payload %q: %v", body, err)
+	}
+	want := map[string]any{
+		"type":            EventEmailReceived,
+		"recipient":       "user@tempmail.example.com",
+		"from":            "sender@example.com",
+		"subject":         "Hello",
+		"message_id":      "<abc@example.com>",
+		"has_attachments": true,
+		"received_at":     received.Format(time.RFC3339),
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("payload[%q] = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func TestWebhookNotifierRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // response per attempt; the last repeats
+		wantAttempts int32
+	}{
+		{"success", []int{200}, 1},
+		{"server error then success", []int{503, 200}, 2},
+		{"rate limited then success", []int{429, 204}, 2},
+		{"client error not retried", []int{400}, 1},
+		{"gives up after max attempts", []int{500}, webhookMaxAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := int(attempts.Add(1)) - 1
+				w.WriteHeader(tt.statuses[min(i, len(tt.statuses)-1)])
+			}))
+			defer srv.Close()
+
+			n := newTestWebhook(srv.URL)
+			n.Notify(&Event{Type: EventEmailReceived, Recipient: "user@tempmail.example.com"})
+			n.Close()
+
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifierDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	n := newTestWebhook(srv.URL)
+
+	// Workers are stuck on the receiver; the queue fills, then events are dropped
+	done := make(chan struct{})
+	go func() {
+		for range webhookQueueSize + webhookWorkers + 10 {
+			n.Notify(&Event{Type: EventEmailReceived})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("Notify blocked on a slow receiver")
+	}
+
+	close(release)
+	n.Close()
+}
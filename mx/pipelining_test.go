@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// testBackend serves sessions backed by a mock database
+type testBackend struct {
+	cfg *Config
+	db  *mockSessionDB
+}
+
+func (b *testBackend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return NewSession(c.Conn().RemoteAddr().String(), c.Hostname(), b.cfg, b.db, nil, b.cfg.GetDomainMap()), nil
+}
+
+// startTestSMTPServer runs an SMTP server on a loopback port and returns a client connection
+func startTestSMTPServer(t *testing.T, db *mockSessionDB) *textproto.Conn {
+	t.Helper()
+
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	s := smtp.NewServer(&testBackend{cfg: cfg, db: db})
+	s.Domain = "mx.test"
+	s.AuthDisabled = true
+	s.ReadTimeout = 5 * time.Second
+	s.WriteTimeout = 5 * time.Second
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := textproto.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatalf("greeting: %v", err)
+	}
+	if err := conn.PrintfLine("EHLO client.test"); err != nil {
+		t.Fatalf("EHLO: %v", err)
+	}
+	_, msg, err := conn.ReadResponse(250)
+	if err != nil {
+		t.Fatalf("EHLO response: %v", err)
+	}
+	if !strings.Contains(msg, "PIPELINING") {
+		t.Fatalf("server does not advertise PIPELINING: %q", msg)
+	}
+	return conn
+}
+
+// pipeline sends all commands in one write and returns the response codes in order
+func pipeline(t *testing.T, conn *textproto.Conn, commands ...string) []int {
+	t.Helper()
+
+	if _, err := conn.W.WriteString(strings.Join(commands, "\r\n") + "\r\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := conn.W.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	codes := make([]int, 0, len(commands))
+	for range commands {
+		code, _, err := conn.ReadResponse(0)
+		if err != nil && code == 0 {
+			t.Fatalf("read response: %v", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func TestPipelinedTransaction(t *testing.T) {
+	db := &mockSessionDB{addresses: map[string]bool{
+		"one@tempmail.example.com": true,
+		"two@tempmail.example.com": true,
+	}}
+	conn := startTestSMTPServer(t, db)
+
+	// One bad recipient in the middle must not affect the others
+	codes := pipeline(t, conn,
+		"MAIL FROM:<sender@example.com>",
+		"RCPT TO:<one@tempmail.example.com>",
+		"RCPT TO:<missing@tempmail.example.com>",
+		"RCPT TO:<two@tempmail.example.com>",
+		"DATA",
+	)
+	// 0 = any rejection
+	want := []int{250, 250, 0, 250, 354}
+	for i := range want {
+		if codes[i] != want[i] && (want[i] != 0 || codes[i] < 400) {
+			t.Fatalf("pipelined responses = %v, want %v", codes, want)
+		}
+	}
+
+	conn.PrintfLine("Subject: pipelined\r\n\r\nhello\r\n.")
+	if _, _, err := conn.ReadResponse(250); err != nil {
+		t.Fatalf("end of DATA: %v", err)
+	}
+	if len(db.stored) != 2 {
+		t.Errorf("stored %d copies, want 2", len(db.stored))
+	}
+}
+
+func TestPipelinedOutOfOrder(t *testing.T) {
+	db := &mockSessionDB{addresses: map[string]bool{"one@tempmail.example.com": true}}
+	conn := startTestSMTPServer(t, db)
+
+	codes := pipeline(t, conn,
+		"RCPT TO:<one@tempmail.example.com>", // before MAIL
+		"DATA",                               // before RCPT
+		"MAIL FROM:<not an address>",         // malformed: transaction not started
+		"RCPT TO:<one@tempmail.example.com>", // still no MAIL
+		"MAIL FROM:<sender@example.com>",
+		"DATA", // MAIL but no RCPT
+		"RSET",
+		"RCPT TO:<one@tempmail.example.com>", // RSET cleared MAIL
+	)
+	want := []int{502, 502, 501, 502, 250, 502, 250, 502}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("pipelined responses = %v, want %v", codes, want)
+		}
+	}
+	if len(db.stored) != 0 {
+		t.Errorf("stored %d emails, want 0", len(db.stored))
+	}
+}
+
+func TestSessionHandlersOutOfOrder(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	db := &mockSessionDB{addresses: map[string]bool{"one@tempmail.example.com": true}}
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, db, nil, cfg.GetDomainMap())
+
+	// Called directly, handlers must not panic and DATA must not store
+	if err := s.Data(strings.NewReader(testMessage)); err == nil {
+		t.Error("Data() without recipients should fail")
+	}
+	if err := s.Rcpt("one@tempmail.example.com", nil); err != nil {
+		t.Errorf("Rcpt() before Mail() error = %v", err)
+	}
+	s.Reset()
+	s.Reset()
+	if len(db.stored) != 0 {
+		t.Errorf("stored %d emails, want 0", len(db.stored))
+	}
+}
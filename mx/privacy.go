@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// defaultTrackerDomains are well-known open-tracking hosts (subdomains match too)
+var defaultTrackerDomains = []string{
+	"google-analytics.com",
+	"mailtrack.io",
+	"list-manage.com",
+	"ct.sendgrid.net",
+	"mandrillapp.com",
+	"pixel.wp.com",
+	"mixpanel.com",
+}
+
+// stripTrackers removes open-tracking images from HTML: 1x1 (or 0x0) remote
+// images and images served from tracker domains. cid: inline images are kept.
+// Returns the rewritten HTML and the number of images removed; the input is
+// returned unchanged when nothing was removed or it cannot be parsed
+func stripTrackers(body string, trackerDomains []string) (string, int) {
+	return removeImages(body, func(img *html.Node) bool {
+		return isTrackingImage(img, trackerDomains)
+	})
+}
+
+// proxyRemoteImages rewrites remote image URLs to go through proxyBase, which
+// gets the original URL appended query-escaped (e.g. "https://proxy.example/img?url=")
+// Covered are <img src/srcset>, <source srcset> (as in <picture>), background
+// attributes and CSS url(...) in style attributes and <style> elements
+// cid: and data: images are kept as-is
+// Returns the rewritten HTML and the number of URLs changed; the input is
+// returned unchanged when nothing was changed or it cannot be parsed
+func proxyRemoteImages(body, proxyBase string) (string, int) {
+	lower := strings.ToLower(body)
+	if !strings.Contains(lower, "<img") && !strings.Contains(lower, "srcset") &&
+		!strings.Contains(lower, "background") && !strings.Contains(lower, "url(") {
+		return body, 0
+	}
+
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return body, 0
+	}
+
+	changed := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for i := range n.Attr {
+				attr := &n.Attr[i]
+				var value string
+				var count int
+				switch strings.ToLower(attr.Key) {
+				case "src":
+					if n.DataAtom != atom.Img {
+						continue
+					}
+					value, count = proxyImageURL(attr.Val, proxyBase)
+				case "srcset":
+					value, count = proxySrcset(attr.Val, proxyBase)
+				case "background":
+					value, count = proxyImageURL(attr.Val, proxyBase)
+				case "style":
+					value, count = proxyCSSURLs(attr.Val, proxyBase)
+				default:
+					continue
+				}
+				if count > 0 {
+					attr.Val = value
+					changed += count
+				}
+			}
+			if n.DataAtom == atom.Style {
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					if c.Type != html.TextNode {
+						continue
+					}
+					if value, count := proxyCSSURLs(c.Data, proxyBase); count > 0 {
+						c.Data = value
+						changed += count
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if changed == 0 {
+		return body, 0
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return body, 0
+	}
+	return buf.String(), changed
+}
+
+// proxyImageURL returns raw rewritten through proxyBase and 1, or raw and 0
+// when it is not a remote http(s) URL or already goes through the proxy
+func proxyImageURL(raw, proxyBase string) (string, int) {
+	u := parseRemoteURL(raw)
+	if u == nil || strings.HasPrefix(u.String(), proxyBase) {
+		return raw, 0
+	}
+	return proxyBase + url.QueryEscape(u.String()), 1
+}
+
+// proxySrcset rewrites each candidate URL of a srcset ("a.png 1x, b.png 2x"),
+// keeping the width/density descriptors
+// As in the HTML spec a URL runs to the next whitespace, so commas inside it
+// (common with image CDNs) do not split the candidate
+func proxySrcset(srcset, proxyBase string) (string, int) {
+	var candidates []string
+	changed := 0
+	rest := srcset
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n\f,")
+		if rest == "" {
+			break
+		}
+		end := strings.IndexAny(rest, " \t\r\n\f")
+		if end < 0 {
+			end = len(rest)
+		}
+		candidateURL, descriptor := rest[:end], ""
+		rest = rest[end:]
+		if trimmed := strings.TrimRight(candidateURL, ","); trimmed != candidateURL {
+			candidateURL = trimmed // a trailing comma ends the candidate
+		} else {
+			descriptor, rest, _ = strings.Cut(rest, ",")
+			descriptor = strings.TrimSpace(descriptor)
+		}
+
+		rewritten, n := proxyImageURL(candidateURL, proxyBase)
+		changed += n
+		if descriptor != "" {
+			rewritten += " " + descriptor
+		}
+		candidates = append(candidates, rewritten)
+	}
+	if changed == 0 {
+		return srcset, 0
+	}
+	return strings.Join(candidates, ", "), changed
+}
+
+// cssURLPattern matches a CSS url(...) token, quoted or not
+var cssURLPattern = regexp.MustCompile(`(?i)url\(\s*("[^"]*"|'[^']*'|[^)"'\s]*)\s*\)`)
+
+// proxyCSSURLs rewrites the remote url(...) references in CSS text, such as
+// background-image in a style attribute or <style> element
+func proxyCSSURLs(css, proxyBase string) (string, int) {
+	if !strings.Contains(strings.ToLower(css), "url(") {
+		return css, 0
+	}
+	changed := 0
+	out := cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		raw := cssURLPattern.FindStringSubmatch(match)[1]
+		rewritten, n := proxyImageURL(strings.Trim(raw, `"'`), proxyBase)
+		if n == 0 {
+			return match
+		}
+		changed++
+		return `url("` + rewritten + `")`
+	})
+	return out, changed
+}
+
+// removeImages drops every <img> in the HTML for which remove returns true
+// Returns the re-rendered HTML and the number of images removed; the input
+// is returned unchanged when nothing was removed or it cannot be parsed
+func removeImages(body string, remove func(img *html.Node) bool) (string, int) {
+	if !strings.Contains(strings.ToLower(body), "<img") {
+		return body, 0
+	}
+
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return body, 0
+	}
+
+	var images []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Img {
+			images = append(images, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	removed := 0
+	for _, img := range images {
+		if remove(img) {
+			img.Parent.RemoveChild(img)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return body, 0
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return body, 0
+	}
+	return buf.String(), removed
+}
+
+// remoteImageURL returns the http(s) source of an <img>, or nil for
+// cid:, data: and relative sources, which never reach a remote server
+func remoteImageURL(img *html.Node) *url.URL {
+	return parseRemoteURL(getAttr(img, "src"))
+}
+
+// parseRemoteURL parses an image reference, returning nil unless it is an
+// absolute or protocol-relative http(s) URL
+func parseRemoteURL(raw string) *url.URL {
+	src := strings.TrimSpace(raw)
+	if strings.HasPrefix(src, "//") {
+		src = "https:" + src
+	}
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil
+	}
+	return u
+}
+
+// isTrackingImage reports whether an <img> looks like an open-tracking pixel
+func isTrackingImage(n *html.Node, trackerDomains []string) bool {
+	u := remoteImageURL(n)
+	if u == nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range trackerDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	width, height := imageDimension(n, "width"), imageDimension(n, "height")
+	return (width == "0" || width == "1") && (height == "0" || height == "1")
+}
+
+// imageDimension returns an image width/height from its attribute or inline style
+func imageDimension(n *html.Node, name string) string {
+	if v := strings.TrimSpace(getAttr(n, name)); v != "" {
+		return strings.TrimSuffix(strings.ToLower(v), "px")
+	}
+
+	for _, decl := range strings.Split(getAttr(n, "style"), ";") {
+		prop, value, ok := strings.Cut(decl, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(prop), name) {
+			return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "px")
+		}
+	}
+	return ""
+}
+
+// getAttr returns the value of an HTML attribute, or ""
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// trackerDomains returns the configured tracker list, falling back to the defaults
+func (c *Config) trackerDomains() []string {
+	if len(c.Privacy.TrackerDomains) > 0 {
+		return c.Privacy.TrackerDomains
+	}
+	return defaultTrackerDomains
+}
+
+// Received header IP redaction modes (privacy.redact_received_ips)
+const (
+	RedactIPsOff    = "off"
+	RedactIPsMask   = "mask"
+	RedactIPsRemove = "remove"
+)
+
+// redactReceivedIPs hides the IP addresses in a message's Received headers,
+// including folded continuation lines; the rest of the message is untouched
+func redactReceivedIPs(rawMessage []byte, mode string) []byte {
+	if mode != RedactIPsMask && mode != RedactIPsRemove {
+		return rawMessage
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(rawMessage))
+	inReceived := false
+	rest := rawMessage
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break // the blank line ends the header
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			inReceived = len(line) >= 9 && bytes.EqualFold(line[:9], []byte("Received:"))
+		}
+		if inReceived {
+			line = []byte(redactIPs(string(line), mode))
+		}
+		out.Write(line)
+		rest = rest[end:]
+	}
+	out.Write(rest)
+	return out.Bytes()
+}
+
+// redactIPs hides every IPv4 or IPv6 address in text, including address
+// literals like [IPv6:2001:db8::1] and IPv4 host:port pairs
+func redactIPs(text, mode string) string {
+	if mode != RedactIPsMask && mode != RedactIPsRemove {
+		return text
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		if !isAddressTokenByte(text[i]) {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(text) && isAddressTokenByte(text[j]) {
+			j++
+		}
+		out.WriteString(redactIPToken(text[i:j], mode))
+		i = j
+	}
+	return out.String()
+}
+
+// isAddressTokenByte reports whether c can be part of an address token
+func isAddressTokenByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '.' || c == ':'
+}
+
+// redactIPToken hides token if it is an IP address; other tokens are returned as-is
+func redactIPToken(token, mode string) string {
+	var prefix string
+	if len(token) > 5 && strings.EqualFold(token[:5], "IPv6:") {
+		prefix, token = token[:5], token[5:]
+	}
+	// A trailing dot ends a sentence, not the address
+	address := strings.TrimRight(token, ".")
+	suffix := token[len(address):]
+
+	if ip := net.ParseIP(address); ip != nil {
+		return prefix + redactIP(ip, mode) + suffix
+	}
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+			return prefix + redactIP(ip, mode) + ":" + port + suffix
+		}
+	}
+	return prefix + token
+}
+
+// redactIP masks ip down to its network (/24 or /48) or replaces it entirely
+func redactIP(ip net.IP, mode string) string {
+	if mode == RedactIPsRemove {
+		return "redacted"
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
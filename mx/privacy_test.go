@@ -0,0 +1,206 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripTrackers(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantRemoved int
+		wantGone    []string
+		wantKept    []string
+	}{
+		{
+			name:        "1x1 remote pixel removed, real image kept",
+			body:        `<p>Hello</p><img src="https://news.example/open?id=42" width="1" height="1"><img src="https://news.example/banner.png" width="600" height="200">`,
+			wantRemoved: 1,
+			wantGone:    []string{"open?id=42"},
+			wantKept:    []string{"banner.png", "Hello"},
+		},
+		{
+			name:        "pixel sized with inline style",
+			body:        `<img src="http://news.example/o.gif" style="width: 1px; height: 1px; border: 0">`,
+			wantRemoved: 1,
+			wantGone:    []string{"o.gif"},
+		},
+		{
+			name:        "known tracker domain at any size",
+			body:        `<img src="https://u123.ct.sendgrid.net/wf/open?upn=abc"><img src="https://cdn.example/logo.png">`,
+			wantRemoved: 1,
+			wantGone:    []string{"sendgrid"},
+			wantKept:    []string{"logo.png"},
+		},
+		{
+			name:        "cid inline image kept even at 1x1",
+			body:        `<img src="cid:spacer@example" width="1" height="1"><p>Body</p>`,
+			wantRemoved: 0,
+			wantKept:    []string{"cid:spacer@example"},
+		},
+		{
+			name:        "no images untouched",
+			body:        `<p>Plain HTML</p>`,
+			wantRemoved: 0,
+			wantKept:    []string{"<p>Plain HTML</p>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed := stripTrackers(tt.body, defaultTrackerDomains)
+			if removed != tt.wantRemoved {
+				t.Errorf("stripTrackers() removed %d, want %d", removed, tt.wantRemoved)
+			}
+			if removed == 0 && got != tt.body {
+				t.Errorf("stripTrackers() changed HTML without removing anything: %q", got)
+			}
+			for _, s := range tt.wantGone {
+				if strings.Contains(got, s) {
+					t.Errorf("stripTrackers() kept %q: %s", s, got)
+				}
+			}
+			for _, s := range tt.wantKept {
+				if !strings.Contains(got, s) {
+					t.Errorf("stripTrackers() dropped %q: %s", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyRemoteImages(t *testing.T) {
+	const base = "https://proxy.example/img?url="
+
+	body := `<p>Hi</p>` +
+		`<img src="https://cdn.example/a.png?x=1&y=2">` +
+		`<img src="//cdn.example/b.png">` +
+		`<img src="cid:logo@example">` +
+		`<img src="data:image/png;base64,AAAA">`
+
+	got, changed := proxyRemoteImages(body, base)
+	if changed != 2 {
+		t.Errorf("proxyRemoteImages() changed %d images, want 2", changed)
+	}
+
+	wantRewritten := []string{
+		`src="https://proxy.example/img?url=https%3A%2F%2Fcdn.example%2Fa.png%3Fx%3D1%26y%3D2"`,
+		`src="https://proxy.example/img?url=https%3A%2F%2Fcdn.example%2Fb.png"`,
+	}
+	for _, want := range wantRewritten {
+		if !strings.Contains(got, want) {
+			t.Errorf("proxyRemoteImages() missing %s in %s", want, got)
+		}
+	}
+	for _, kept := range []string{`src="cid:logo@example"`, `src="data:image/png;base64,AAAA"`} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("proxyRemoteImages() altered %s: %s", kept, got)
+		}
+	}
+
+	// Already proxied URLs are not wrapped twice
+	again, changed := proxyRemoteImages(got, base)
+	if changed != 0 || again != got {
+		t.Errorf("proxyRemoteImages() re-proxied %d images", changed)
+	}
+}
+
+func TestProxyRemoteImagesOtherReferences(t *testing.T) {
+	const base = "https://proxy.example/img?url="
+
+	body := `<html><head><style>.hero { background: url('https://cdn.example/hero.jpg') }</style></head><body>` +
+		`<picture><source srcset="https://cdn.example/c.webp 1x, https://cdn.example/c@2x.webp 2x">` +
+		`<img src="cid:fallback@example" srcset="https://img.example/w_100,h_50/d.png 100w, cid:e@example 200w"></picture>` +
+		`<table background="https://cdn.example/bg.gif"><tr><td style="background-image: url(//cdn.example/td.png)">x</td></tr></table>` +
+		`<div style="background: url(&quot;data:image/png;base64,AAAA&quot;)">y</div>` +
+		`</body></html>`
+
+	got, changed := proxyRemoteImages(body, base)
+	if changed != 6 {
+		t.Errorf("proxyRemoteImages() changed %d URLs, want 6: %s", changed, got)
+	}
+
+	for _, want := range []string{
+		`url("https://proxy.example/img?url=https%3A%2F%2Fcdn.example%2Fhero.jpg")`,
+		`https://proxy.example/img?url=https%3A%2F%2Fcdn.example%2Fc.webp 1x, https://proxy.example/img?url=https%3A%2F%2Fcdn.example%2Fc%402x.webp 2x`,
+		`https://proxy.example/img?url=https%3A%2F%2Fimg.example%2Fw_100%2Ch_50%2Fd.png 100w, cid:e@example 200w`,
+		`background="https://proxy.example/img?url=https%3A%2F%2Fcdn.example%2Fbg.gif"`,
+		`url(&#34;https://proxy.example/img?url=https%3A%2F%2Fcdn.example%2Ftd.png&#34;)`,
+		`src="cid:fallback@example"`,
+		`data:image/png;base64,AAAA`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("proxyRemoteImages() missing %s in %s", want, got)
+		}
+	}
+	if strings.Contains(got, `"https://cdn.example`) || strings.Contains(got, `'https://cdn.example`) {
+		t.Errorf("proxyRemoteImages() left a direct image URL: %s", got)
+	}
+}
+
+func TestProxyRemoteImagesCidOnly(t *testing.T) {
+	body := `<img src="cid:part1@example">`
+	if got, changed := proxyRemoteImages(body, "https://proxy.example/?u="); changed != 0 || got != body {
+		t.Errorf("proxyRemoteImages() = %q, %d; want unchanged", got, changed)
+	}
+}
+
+func TestRedactIPs(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantMask string
+		wantDrop string
+	}{
+		{"ipv4 literal", "from mail.example.com ([203.0.113.7]) by mx",
+			"from mail.example.com ([203.0.113.0]) by mx", "from mail.example.com ([redacted]) by mx"},
+		{"ipv6 literal", "from client ([IPv6:2001:db8:1234:5678::1])",
+			"from client ([IPv6:2001:db8:1234::])", "from client ([IPv6:redacted])"},
+		{"host and port", "(203.0.113.7:52344)", "(203.0.113.0:52344)", "(redacted:52344)"},
+		{"sentence end", "sent by 198.51.100.20.", "sent by 198.51.100.0.", "sent by redacted."},
+		{"no address", "by mx.example.com with ESMTPS id 4Zx; Mon, 02 Jan 2006 15:04:05 -0700",
+			"by mx.example.com with ESMTPS id 4Zx; Mon, 02 Jan 2006 15:04:05 -0700",
+			"by mx.example.com with ESMTPS id 4Zx; Mon, 02 Jan 2006 15:04:05 -0700"},
+		{"hostname with digits", "from host-203-0-113-7.isp.example", "from host-203-0-113-7.isp.example", "from host-203-0-113-7.isp.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactIPs(tt.text, RedactIPsMask); got != tt.wantMask {
+				t.Errorf("redactIPs(mask) = %q, want %q", got, tt.wantMask)
+			}
+			if got := redactIPs(tt.text, RedactIPsRemove); got != tt.wantDrop {
+				t.Errorf("redactIPs(remove) = %q, want %q", got, tt.wantDrop)
+			}
+			if got := redactIPs(tt.text, RedactIPsOff); got != tt.text {
+				t.Errorf("redactIPs(off) = %q, want unchanged", got)
+			}
+		})
+	}
+}
+
+func TestRedactReceivedIPs(t *testing.T) {
+	message := "Received: from relay.example.net (relay.example.net [198.51.100.20])\r\n" +
+		"\tby mx.example.com; Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+		"Received: from client ([203.0.113.7])\r\n" +
+		"X-Originating-Host: 192.0.2.1\r\n" +
+		"Subject: Meet at 10.0.0.1\r\n" +
+		"\r\n" +
+		"Received: from 203.0.113.7 is quoted in the body\r\n"
+
+	want := "Received: from relay.example.net (relay.example.net [198.51.100.0])\r\n" +
+		"\tby mx.example.com; Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+		"Received: from client ([203.0.113.0])\r\n" +
+		"X-Originating-Host: 192.0.2.1\r\n" +
+		"Subject: Meet at 10.0.0.1\r\n" +
+		"\r\n" +
+		"Received: from 203.0.113.7 is quoted in the body\r\n"
+
+	if got := string(redactReceivedIPs([]byte(message), RedactIPsMask)); got != want {
+		t.Errorf("redactReceivedIPs() =\n%s\nwant\n%s", got, want)
+	}
+	if got := redactReceivedIPs([]byte(message), RedactIPsOff); string(got) != message {
+		t.Error("redactReceivedIPs(off) changed the message")
+	}
+}
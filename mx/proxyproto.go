@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a new connection may take to send its
+// PROXY protocol header
+const proxyHeaderTimeout = 10 * time.Second
+
+// PROXY protocol v1 lines are at most 107 bytes including CRLF
+const proxyV1MaxLen = 107
+
+// proxyV2Signature opens every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxyListener reads the PROXY protocol header (v1 or v2) a load balancer
+// sends ahead of each connection, so sessions see the real client address
+// (server.proxy_protocol); connections without a valid header are closed
+// Only peers in server.proxy_trusted_cidrs may send one; anyone else could
+// claim any address, so their connections are closed unread
+// Headers are read off the accept path so one slow client cannot stall others
+type proxyListener struct {
+	net.Listener
+	timeout time.Duration
+	trusted []string // IPs/CIDRs of the load balancers
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newProxyListener wraps l, giving each connection from a trusted peer
+// timeout to send its header
+func newProxyListener(l net.Listener, timeout time.Duration, trusted []string) *proxyListener {
+	pl := &proxyListener{
+		Listener: l,
+		timeout:  timeout,
+		trusted:  trusted,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		closed:   make(chan struct{}),
+	}
+	go pl.acceptLoop()
+	return pl
+}
+
+func (pl *proxyListener) acceptLoop() {
+	for {
+		c, err := pl.Listener.Accept()
+		if err != nil {
+			select {
+			case pl.errs <- err:
+			case <-pl.closed:
+				return
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		go pl.handshake(c)
+	}
+}
+
+// handshake reads c's header and queues the connection for Accept
+func (pl *proxyListener) handshake(c net.Conn) {
+	if !pl.trustedPeer(c.RemoteAddr()) {
+		slog.Info("REJECTED: PROXY protocol from untrusted peer", "peer_addr", c.RemoteAddr().String())
+		c.Close()
+		return
+	}
+	pc, err := readProxyHeader(c, pl.timeout)
+	if err != nil {
+		slog.Info("REJECTED: PROXY protocol header", "peer_addr", c.RemoteAddr().String(), "error", err)
+		c.Close()
+		return
+	}
+	select {
+	case pl.conns <- pc:
+	case <-pl.closed:
+		pc.Close()
+	}
+}
+
+// trustedPeer reports whether addr is one of the configured load balancers
+func (pl *proxyListener) trustedPeer(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, entry := range pl.trusted {
+		if matchIP(tcp.IP, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept returns the next connection whose header has been read
+func (pl *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-pl.conns:
+		return c, nil
+	case err := <-pl.errs:
+		return nil, err
+	case <-pl.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting; connections still sending their header are dropped
+func (pl *proxyListener) Close() error {
+	pl.closeOnce.Do(func() { close(pl.closed) })
+	return pl.Listener.Close()
+}
+
+// proxyConn is a connection whose remote address came from a PROXY header
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader // holds any bytes the client sent after the header
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// RemoteAddr returns the client address carried in the header
+func (c *proxyConn) RemoteAddr() net.Addr { return c.remote }
+
+// readProxyHeader consumes the PROXY header at the start of c
+// LOCAL (v2) and UNKNOWN (v1) headers keep the connection's own address
+func readProxyHeader(c net.Conn, timeout time.Duration) (*proxyConn, error) {
+	if timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(timeout))
+		defer c.SetReadDeadline(time.Time{})
+	}
+
+	r := bufio.NewReaderSize(c, 256)
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.Equal(sig, proxyV2Signature):
+		remote, err = readProxyV2(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		remote, err = readProxyV1(r)
+	default:
+		return nil, fmt.Errorf("%w: missing", errProxyHeader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		remote = c.RemoteAddr()
+	}
+	return &proxyConn{Conn: c, r: r, remote: remote}, nil
+}
+
+// readProxyV1 parses "PROXY TCP4|TCP6 <src> <dst> <sport> <dport>\r\n"
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 line not terminated within %d bytes", errProxyHeader, proxyV1MaxLen)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: v1 expects 6 fields, got %d", errProxyHeader, len(fields))
+	}
+
+	src := net.ParseIP(fields[2])
+	dst := net.ParseIP(fields[3])
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("%w: v1 invalid address", errProxyHeader)
+	}
+	switch fields[1] {
+	case "TCP4":
+		if src.To4() == nil || dst.To4() == nil {
+			return nil, fmt.Errorf("%w: v1 TCP4 with non-IPv4 address", errProxyHeader)
+		}
+	case "TCP6":
+		if src.To4() != nil || dst.To4() != nil {
+			return nil, fmt.Errorf("%w: v1 TCP6 with non-IPv6 address", errProxyHeader)
+		}
+	default:
+		return nil, fmt.Errorf("%w: v1 unknown protocol %q", errProxyHeader, fields[1])
+	}
+
+	port, err := parseProxyPort(fields[4])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseProxyPort(fields[5]); err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: src, Port: port}, nil
+}
+
+// parseProxyPort accepts a decimal port without leading zeros
+func parseProxyPort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("%w: v1 invalid port %q", errProxyHeader, s)
+	}
+	return port, nil
+}
+
+// readProxyV2 parses the binary header: signature, version/command,
+// family/protocol, address length, then the addresses
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: v2 unsupported version %d", errProxyHeader, hdr[12]>>4)
+	}
+	command := hdr[12] & 0x0f
+	family, proto := hdr[13]>>4, hdr[13]&0x0f
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+	}
+
+	switch command {
+	case 0x0: // LOCAL: health checks from the balancer itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: v2 unknown command %d", errProxyHeader, command)
+	}
+
+	const (
+		afUnspec = 0x0
+		afInet   = 0x1
+		afInet6  = 0x2
+		afUnix   = 0x3
+		stream   = 0x1
+	)
+	switch family {
+	case afInet:
+		if proto != stream || len(body) < 12 {
+			return nil, fmt.Errorf("%w: v2 malformed IPv4 addresses", errProxyHeader)
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case afInet6:
+		if proto != stream || len(body) < 36 {
+			return nil, fmt.Errorf("%w: v2 malformed IPv6 addresses", errProxyHeader)
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	case afUnspec, afUnix:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w: v2 unknown address family %d", errProxyHeader, family)
+}
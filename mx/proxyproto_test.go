@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// proxyV2Header builds a v2 PROXY header for a TCP connection from src
+func proxyV2Header(command, family byte, src, dst net.IP, sport, dport uint16) []byte {
+	var body []byte
+	body = append(body, src...)
+	body = append(body, dst...)
+	body = binary.BigEndian.AppendUint16(body, sport)
+	body = binary.BigEndian.AppendUint16(body, dport)
+
+	hdr := append([]byte{}, proxyV2Signature...)
+	hdr = append(hdr, 0x20|command, family<<4|0x1)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+	return append(hdr, body...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantAddr string // empty keeps the connection's own address
+		wantErr  bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 203.0.113.7 10.0.0.5 40000 25\r\n", "203.0.113.7:40000", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::7 2001:db8::1 40000 25\r\n", "[2001:db8::7]:40000", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", false},
+		{"v2 IPv4", string(proxyV2Header(0x1, 0x1, net.ParseIP("203.0.113.7").To4(), net.ParseIP("10.0.0.5").To4(), 40000, 25)), "203.0.113.7:40000", false},
+		{"v2 IPv6", string(proxyV2Header(0x1, 0x2, net.ParseIP("2001:db8::7"), net.ParseIP("2001:db8::1"), 40000, 25)), "[2001:db8::7]:40000", false},
+		{"v2 LOCAL", string(proxyV2Header(0x0, 0x1, net.ParseIP("203.0.113.7").To4(), net.ParseIP("10.0.0.5").To4(), 40000, 25)), "", false},
+		{"no header", "EHLO client.example.com\r\n", "", true},
+		{"v1 missing CRLF", "PROXY TCP4 203.0.113.7 10.0.0.5 40000 25\n", "", true},
+		{"v1 too few fields", "PROXY TCP4 203.0.113.7 10.0.0.5 40000\r\n", "", true},
+		{"v1 bad address", "PROXY TCP4 203.0.113.999 10.0.0.5 40000 25\r\n", "", true},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::7 10.0.0.5 40000 25\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 203.0.113.7 10.0.0.5 70000 25\r\n", "", true},
+		{"v1 unknown protocol", "PROXY UDP4 203.0.113.7 10.0.0.5 40000 25\r\n", "", true},
+		{"v2 truncated addresses", string(proxyV2Header(0x1, 0x2, net.ParseIP("203.0.113.7").To4(), net.ParseIP("10.0.0.5").To4(), 40000, 25)), "", true},
+		{"v2 unknown command", string(proxyV2Header(0x5, 0x1, net.ParseIP("203.0.113.7").To4(), net.ParseIP("10.0.0.5").To4(), 40000, 25)), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go func() {
+				client.Write([]byte(tt.header + "EHLO client.example.com\r\n"))
+			}()
+
+			pc, err := readProxyHeader(server, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readProxyHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, errProxyHeader) {
+					t.Errorf("error %v does not wrap errProxyHeader", err)
+				}
+				return
+			}
+
+			want := tt.wantAddr
+			if want == "" {
+				want = server.RemoteAddr().String()
+			}
+			if got := pc.RemoteAddr().String(); got != want {
+				t.Errorf("RemoteAddr() = %s, want %s", got, want)
+			}
+
+			// Bytes after the header reach the SMTP layer untouched
+			buf := make([]byte, len("EHLO"))
+			if _, err := io.ReadFull(pc, buf); err != nil || string(buf) != "EHLO" {
+				t.Errorf("first bytes after header = %q, %v; want EHLO", buf, err)
+			}
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	l := newProxyListener(inner, time.Second, []string{"127.0.0.0/8"})
+	defer l.Close()
+
+	// A client that never sends a header must not hold up the next one
+	stalled, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer stalled.Close()
+
+	malformed, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer malformed.Close()
+	malformed.Write([]byte("HELLO THERE\r\n"))
+
+	good, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer good.Close()
+	good.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.5 40000 25\r\n"))
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+		if got := c.RemoteAddr().String(); got != "203.0.113.7:40000" {
+			t.Errorf("accepted RemoteAddr() = %s, want 203.0.113.7:40000", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection with a valid header was not accepted")
+	}
+
+	// The malformed connection is closed by the server
+	malformed.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := malformed.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read on malformed connection = %v, want EOF", err)
+	}
+
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() after Close = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestProxyListenerUntrustedPeer(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	l := newProxyListener(inner, time.Second, []string{"192.0.2.0/24"})
+	defer l.Close()
+
+	// A valid header is still refused when the peer is not a trusted load balancer
+	c, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+	c.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.5 40000 25\r\n"))
+
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read on untrusted connection = %v, want EOF", err)
+	}
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	select {
+	case c := <-accepted:
+		c.Close()
+		t.Error("connection from an untrusted peer was accepted")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// defaultPTRTimeoutSeconds bounds the reverse DNS lookup
+const defaultPTRTimeoutSeconds = 5
+
+// PTRChecker rejects clients whose reverse DNS looks like a generic or
+// dynamic-IP hostname, a common botnet heuristic
+type PTRChecker struct {
+	pattern    *regexp.Regexp
+	resolver   Resolver
+	timeout    time.Duration
+	failClosed bool
+}
+
+// NewPTRChecker builds a checker from validation.reject_ptr_pattern
+func NewPTRChecker(cfg *Config, resolver Resolver) (*PTRChecker, error) {
+	pattern, err := regexp.Compile(cfg.Validation.RejectPTRPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reject_ptr_pattern: %w", err)
+	}
+
+	timeout := cfg.Validation.PTRTimeoutSeconds
+	if timeout <= 0 {
+		timeout = defaultPTRTimeoutSeconds
+	}
+	return &PTRChecker{
+		pattern:    pattern,
+		resolver:   resolver,
+		timeout:    time.Duration(timeout) * time.Second,
+		failClosed: cfg.Validation.PTRFailClosed,
+	}, nil
+}
+
+// Check returns an SMTP error when ip's PTR matches the pattern
+// Lookup failures pass unless ptr_fail_closed is set
+func (p *PTRChecker) Check(ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	names, err := p.resolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		if !p.failClosed {
+			return nil
+		}
+		slog.Info("PTR: No reverse DNS, refusing", "ip", ip, "error", err)
+		return &smtp.SMTPError{
+			Code:         450,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 25},
+			Message:      "Reverse DNS lookup failed",
+		}
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if p.pattern.MatchString(name) {
+			slog.Info("PTR: Generic hostname, refusing", "ip", ip, "ptr", name)
+			return &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 7, 25},
+				Message:      fmt.Sprintf("Generic reverse DNS %s not accepted, use your provider's relay", name),
+			}
+		}
+	}
+	return nil
+}
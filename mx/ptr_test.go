@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+const testGenericPTRPattern = `(^|[.-])(dynamic|dyn|dhcp|pool)[.-]|\d+[.-]\d+[.-]\d+[.-]\d+`
+
+func TestPTRCheckerCheck(t *testing.T) {
+	resolver := &fakeResolver{ptr: map[string][]string{
+		"192.0.2.10":  {"mail.sender.example."},
+		"192.0.2.20":  {"host-192-0-2-20.dynamic.isp.example."},
+		"192.0.2.30":  {"c-192.0.2.30.pool.isp.example."},
+		"192.0.2.40":  {},
+		"2001:db8::1": {"mx1.sender.example."},
+	}}
+
+	tests := []struct {
+		name       string
+		ip         string
+		failClosed bool
+		wantCode   int // 0 = accepted
+	}{
+		{"named mail host", "192.0.2.10", false, 0},
+		{"generic dynamic PTR", "192.0.2.20", false, 550},
+		{"IP in hostname", "192.0.2.30", false, 550},
+		{"IPv6 named host", "2001:db8::1", false, 0},
+		{"no PTR fails open", "198.51.100.1", false, 0},
+		{"no PTR fails closed", "198.51.100.1", true, 450},
+		{"empty PTR fails closed", "192.0.2.40", true, 450},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Validation.RejectPTRPattern = testGenericPTRPattern
+			cfg.Validation.PTRFailClosed = tt.failClosed
+
+			checker, err := NewPTRChecker(cfg, resolver)
+			if err != nil {
+				t.Fatalf("NewPTRChecker() error = %v", err)
+			}
+
+			err = checker.Check(tt.ip)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("Check(%s) error = %v, want accepted", tt.ip, err)
+				}
+				return
+			}
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+				t.Errorf("Check(%s) error = %v, want %d", tt.ip, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNewPTRCheckerInvalidPattern(t *testing.T) {
+	cfg := &Config{}
+	cfg.Validation.RejectPTRPattern = "(unclosed"
+	if _, err := NewPTRChecker(cfg, &fakeResolver{}); err == nil {
+		t.Error("NewPTRChecker() should reject an invalid pattern")
+	}
+}
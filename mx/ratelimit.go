@@ -0,0 +1,195 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// rateLimitPruneInterval is how often idle buckets are dropped
+const rateLimitPruneInterval = time.Minute
+
+// tokenBucket tracks one IP's message allowance
+type tokenBucket struct {
+	tokens float64
+	rate   float64 // refill rate at the last update, for prune
+	last   time.Time
+}
+
+// bucketCapacity is the burst for rate: one minute of it, but never less
+// than one message, which a rate under 1/min could otherwise never reach
+func bucketCapacity(rate float64) float64 {
+	return max(rate, 1)
+}
+
+// RateLimiter is a per-IP token bucket whose refill rate follows the IP's
+// reputation: -1 gets the minimum rate, 0 the base rate and 1 the maximum.
+// The bucket holds one minute of the effective rate, so trusted senders can burst
+type RateLimiter struct {
+	base, min, max float64 // messages per minute
+	reputation     ReputationSource
+	now            func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// NewRateLimiter creates a limiter from the ratelimit config section
+// reputation may be nil, in which case every IP gets the base rate
+func NewRateLimiter(cfg *Config, reputation ReputationSource) *RateLimiter {
+	return &RateLimiter{
+		base:       cfg.RateLimit.MessagesPerMinute,
+		min:        cfg.RateLimit.MinMessagesPerMinute,
+		max:        cfg.RateLimit.MaxMessagesPerMinute,
+		reputation: reputation,
+		now:        time.Now,
+		buckets:    make(map[string]*tokenBucket),
+	}
+}
+
+// Rate returns the effective messages per minute for ip
+func (l *RateLimiter) Rate(ip string) float64 {
+	if l.reputation == nil {
+		return l.base
+	}
+
+	score := l.reputation.Score(ip)
+	if score >= 0 {
+		return l.base + score*(l.max-l.base)
+	}
+	return l.base + score*(l.base-l.min)
+}
+
+// Allow consumes one message token for ip, reporting false when none is left
+func (l *RateLimiter) Allow(ip string) bool {
+	rate := l.Rate(ip)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	capacity := bucketCapacity(rate)
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[ip] = bucket
+	}
+
+	// Refill at the current rate; a reputation drop also shrinks the burst
+	bucket.tokens += now.Sub(bucket.last).Minutes() * rate
+	if bucket.tokens > capacity {
+		bucket.tokens = capacity
+	}
+	bucket.rate = rate
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune drops buckets idle long enough to have refilled completely
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < rateLimitPruneInterval {
+		return
+	}
+	l.lastPrune = now
+
+	for ip, bucket := range l.buckets {
+		idle := now.Sub(bucket.last)
+		if idle >= rateLimitPruneInterval && bucket.tokens+idle.Minutes()*bucket.rate >= bucketCapacity(bucket.rate) {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// ConnLimiter caps the concurrent SMTP sessions of each client IP
+// Slots are keyed by connection, so a client repeating EHLO keeps its one slot
+type ConnLimiter struct {
+	max int
+
+	mu    sync.Mutex
+	conns map[string]map[*smtp.Conn]struct{}
+}
+
+// NewConnLimiter creates a limiter allowing max sessions per IP
+func NewConnLimiter(max int) *ConnLimiter {
+	return &ConnLimiter{max: max, conns: make(map[string]map[*smtp.Conn]struct{})}
+}
+
+// Acquire takes a session slot for conn, reporting false when ip has none left
+func (l *ConnLimiter) Acquire(ip string, conn *smtp.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active := l.conns[ip]
+	if _, ok := active[conn]; ok {
+		return true
+	}
+	if len(active) >= l.max {
+		return false
+	}
+	if active == nil {
+		active = make(map[*smtp.Conn]struct{})
+		l.conns[ip] = active
+	}
+	active[conn] = struct{}{}
+	return true
+}
+
+// Release frees conn's slot; releasing twice is harmless
+func (l *ConnLimiter) Release(ip string, conn *smtp.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.conns[ip], conn)
+	if len(l.conns[ip]) == 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// Active returns the number of sessions ip holds
+func (l *ConnLimiter) Active(ip string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.conns[ip])
+}
+
+// errSMTPTooManyConnections answers EHLO/HELO; 421 is the only 4xx RFC 5321 allows there
+var errSMTPTooManyConnections = &smtp.SMTPError{
+	Code:         421,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Too many connections from your IP, try again later",
+}
+
+// checkRateLimit tempfails MAIL FROM when the client IP is over its message rate
+func (s *Session) checkRateLimit() error {
+	if s.ratelimit == nil {
+		return nil
+	}
+
+	ip := s.getClientIP()
+	if s.ratelimit.Allow(ip) {
+		return nil
+	}
+
+	s.logger().Info("DEFERRED: Rate limit exceeded", "ip", ip, "rate_per_min", s.ratelimit.Rate(ip))
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      "Rate limit exceeded, try again later",
+	}
+}
+
+// adjustReputation records client behavior when a reputation store is configured
+func (s *Session) adjustReputation(delta float64) {
+	if s.reputation != nil {
+		s.reputation.Adjust(s.getClientIP(), delta)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// newTestRateLimiter returns a limiter with a controllable clock
+func newTestRateLimiter(base, min, max float64, reputation ReputationSource) (*RateLimiter, *time.Time) {
+	cfg := &Config{}
+	cfg.RateLimit.MessagesPerMinute = base
+	cfg.RateLimit.MinMessagesPerMinute = min
+	cfg.RateLimit.MaxMessagesPerMinute = max
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(cfg, reputation)
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+// allowed counts how many messages ip may send right now
+func allowed(l *RateLimiter, ip string) int {
+	n := 0
+	for l.Allow(ip) {
+		n++
+	}
+	return n
+}
+
+func TestRateLimiterRate(t *testing.T) {
+	reputation := NewReputationStore()
+	limiter, _ := newTestRateLimiter(10, 2, 50, reputation)
+
+	tests := []struct {
+		score float64
+		want  float64
+	}{
+		{0, 10},
+		{1, 50},
+		{0.5, 30},
+		{-1, 2},
+		{-0.5, 6},
+	}
+
+	for _, tt := range tests {
+		reputation.scores["192.0.2.1"] = reputationEntry{score: tt.score, last: reputation.now()}
+		if got := limiter.Rate("192.0.2.1"); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Rate() with score %.1f = %.2f, want %.2f", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterReputationChange(t *testing.T) {
+	reputation := NewReputationStore()
+	limiter, now := newTestRateLimiter(10, 2, 50, reputation)
+
+	// Unknown IPs burst up to the base rate
+	if got := allowed(limiter, "192.0.2.1"); got != 10 {
+		t.Errorf("neutral IP allowed %d messages, want 10", got)
+	}
+
+	// Good behavior raises the refill rate and the burst
+	reputation.Adjust("192.0.2.1", 1)
+	*now = now.Add(time.Minute)
+	if got := allowed(limiter, "192.0.2.1"); got != 50 {
+		t.Errorf("trusted IP allowed %d messages after a minute, want 50", got)
+	}
+
+	// A flagged IP refills slowly
+	reputation.Adjust("192.0.2.1", -2)
+	*now = now.Add(30 * time.Second)
+	if got := allowed(limiter, "192.0.2.1"); got != 1 {
+		t.Errorf("flagged IP allowed %d messages after 30s, want 1", got)
+	}
+
+	// Other IPs are unaffected
+	if got := allowed(limiter, "192.0.2.2"); got != 10 {
+		t.Errorf("other IP allowed %d messages, want 10", got)
+	}
+}
+
+func TestReputationStoreClamp(t *testing.T) {
+	r := NewReputationStore()
+	r.Adjust("192.0.2.1", 5)
+	if got := r.Score("192.0.2.1"); got != 1 {
+		t.Errorf("Score() = %v, want 1", got)
+	}
+	r.Adjust("192.0.2.1", -5)
+	if got := r.Score("192.0.2.1"); got != -1 {
+		t.Errorf("Score() = %v, want -1", got)
+	}
+}
+
+func TestRateLimiterSlowRate(t *testing.T) {
+	// Under one message a minute the bucket must still hold a whole token
+	limiter, now := newTestRateLimiter(0.5, 0.5, 0.5, nil)
+
+	if got := allowed(limiter, "192.0.2.1"); got != 1 {
+		t.Fatalf("allowed %d messages, want 1", got)
+	}
+
+	// Idle for a minute, the bucket is half full and must not be pruned as full
+	*now = now.Add(time.Minute)
+	if got := allowed(limiter, "192.0.2.1"); got != 0 {
+		t.Errorf("allowed %d messages after a minute, want 0", got)
+	}
+	*now = now.Add(time.Minute)
+	if got := allowed(limiter, "192.0.2.1"); got != 1 {
+		t.Errorf("allowed %d messages after two minutes, want 1", got)
+	}
+}
+
+func TestReputationStoreExpiry(t *testing.T) {
+	r := NewReputationStore()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	r.Adjust("192.0.2.1", -1)
+	if got := r.Score("192.0.2.1"); got != -1 {
+		t.Fatalf("Score() = %v, want -1", got)
+	}
+
+	// An idle IP is forgotten and its entry pruned on the next update
+	now = now.Add(reputationIdleTTL)
+	if got := r.Score("192.0.2.1"); got != 0 {
+		t.Errorf("Score() after the TTL = %v, want 0", got)
+	}
+	r.Adjust("192.0.2.2", 0.5)
+	if _, ok := r.scores["192.0.2.1"]; ok {
+		t.Error("expired score should be pruned")
+	}
+	if len(r.scores) != 1 {
+		t.Errorf("%d scores kept, want 1", len(r.scores))
+	}
+}
+
+func TestSessionRateLimit(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	limiter, _ := newTestRateLimiter(2, 1, 4, NewReputationStore())
+
+	s := NewSession("192.0.2.1:12345", "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+	s.ratelimit = limiter
+
+	for i := 0; i < 2; i++ {
+		if err := s.Mail("sender@example.com", nil); err != nil {
+			t.Fatalf("Mail() #%d error = %v", i+1, err)
+		}
+	}
+
+	err := s.Mail("sender@example.com", nil)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 451 {
+		t.Fatalf("Mail() over limit error = %v, want 451", err)
+	}
+}
+
+func TestLoadConfigRateLimitBounds(t *testing.T) {
+	tests := []struct {
+		name          string
+		base, min, mx float64
+		wantMin       float64
+		wantMax       float64
+		wantErr       bool
+	}{
+		{"disabled", 0, 0, 0, 0, 0, false},
+		{"bounds default to base", 10, 0, 0, 10, 10, false},
+		{"explicit bounds", 10, 2, 50, 2, 50, false},
+		{"min above base", 10, 20, 50, 0, 0, true},
+		{"max below base", 10, 2, 5, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.RateLimit.MessagesPerMinute = tt.base
+			cfg.RateLimit.MinMessagesPerMinute = tt.min
+			cfg.RateLimit.MaxMessagesPerMinute = tt.mx
+
+			err := validateRateLimitConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateRateLimitConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (cfg.RateLimit.MinMessagesPerMinute != tt.wantMin || cfg.RateLimit.MaxMessagesPerMinute != tt.wantMax) {
+				t.Errorf("bounds = (%v, %v), want (%v, %v)",
+					cfg.RateLimit.MinMessagesPerMinute, cfg.RateLimit.MaxMessagesPerMinute, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	limiter := NewConnLimiter(2)
+	a, b, c := &smtp.Conn{}, &smtp.Conn{}, &smtp.Conn{}
+
+	if !limiter.Acquire("192.0.2.1", a) || !limiter.Acquire("192.0.2.1", b) {
+		t.Fatal("Acquire() refused a session under the limit")
+	}
+	if !limiter.Acquire("192.0.2.1", a) {
+		t.Error("Acquire() refused a repeated EHLO on a counted connection")
+	}
+	if limiter.Acquire("192.0.2.1", c) {
+		t.Error("Acquire() allowed a third session")
+	}
+	if !limiter.Acquire("192.0.2.2", c) {
+		t.Error("Acquire() limited another IP")
+	}
+
+	limiter.Release("192.0.2.1", a)
+	limiter.Release("192.0.2.1", a)
+	if got := limiter.Active("192.0.2.1"); got != 1 {
+		t.Errorf("Active() after release = %d, want 1", got)
+	}
+	if !limiter.Acquire("192.0.2.1", c) {
+		t.Error("Acquire() refused a session after a slot was released")
+	}
+}
+
+// serveBackend runs an SMTP server for backend on a loopback port and returns its address
+func serveBackend(t *testing.T, backend *Backend) string {
+	t.Helper()
+	s := smtp.NewServer(backend)
+	s.Domain = "mx.test"
+	s.AuthDisabled = true
+	s.ReadTimeout = 5 * time.Second
+	s.WriteTimeout = 5 * time.Second
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// dialEHLO connects to addr and returns the connection with the EHLO reply code
+func dialEHLO(t *testing.T, addr string) (*textproto.Conn, int) {
+	t.Helper()
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatalf("greeting: %v", err)
+	}
+	if err := conn.PrintfLine("EHLO client.test"); err != nil {
+		t.Fatalf("EHLO: %v", err)
+	}
+	code, _, err := conn.ReadResponse(0)
+	if code == 0 {
+		t.Fatalf("EHLO response: %v", err)
+	}
+	return conn, code
+}
+
+func TestBackendConnectionLimitBurst(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.RateLimit.MaxConnectionsPerIP = 3
+
+	backend := NewBackend(cfg, nil, nil)
+	backend.connLimit = NewConnLimiter(cfg.RateLimit.MaxConnectionsPerIP)
+	addr := serveBackend(t, backend)
+
+	// A burst of connections: the first three get sessions, the rest are deferred
+	var conns []*textproto.Conn
+	for i := 0; i < 6; i++ {
+		conn, code := dialEHLO(t, addr)
+		want := 250
+		if i >= 3 {
+			want = 421
+		}
+		if code != want {
+			t.Errorf("connection %d: EHLO = %d, want %d", i+1, code, want)
+		}
+		conns = append(conns, conn)
+	}
+
+	// Closing a session frees its slot
+	if err := conns[0].PrintfLine("QUIT"); err != nil {
+		t.Fatalf("QUIT: %v", err)
+	}
+	conns[0].ReadResponse(221)
+	deadline := time.Now().Add(2 * time.Second)
+	for backend.connLimit.Active("127.0.0.1") >= 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, code := dialEHLO(t, addr); code != 250 {
+		t.Errorf("EHLO after a session closed = %d, want 250", code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Recipient overflow actions (recipients.overflow)
+const (
+	OverflowReject = "reject"
+	OverflowDrop   = "drop"
+)
+
+// RecipientPolicy bundles the fan-out limits for one message
+// Limits are applied in order: server.max_recipients at RCPT, then max_stored_copies
+// and max_stored_mb at DATA; the tightest storage limit wins
+type RecipientPolicy struct {
+	MaxRecipients   int   // accepted RCPTs per transaction (0 = unlimited)
+	MaxStoredCopies int   // stored copies per message (0 = unlimited)
+	MaxStoredBytes  int64 // size x copies per message (0 = unlimited)
+	Overflow        string
+}
+
+// newRecipientPolicy builds the policy from config
+func newRecipientPolicy(cfg *Config) RecipientPolicy {
+	if cfg == nil {
+		return RecipientPolicy{Overflow: OverflowReject}
+	}
+
+	overflow := cfg.Recipients.Overflow
+	if overflow == "" {
+		overflow = OverflowReject
+	}
+	return RecipientPolicy{
+		MaxRecipients:   cfg.MaxRecipients(),
+		MaxStoredCopies: cfg.Recipients.MaxStoredCopies,
+		MaxStoredBytes:  int64(cfg.Recipients.MaxStoredMB) * 1024 * 1024,
+		Overflow:        overflow,
+	}
+}
+
+// allowRcpt checks max_recipients given the recipients already accepted
+// With the drop action the RCPT is acknowledged but ok is false
+func (p RecipientPolicy) allowRcpt(accepted int) (ok bool, err error) {
+	if p.MaxRecipients == 0 || accepted < p.MaxRecipients {
+		return true, nil
+	}
+
+	if p.Overflow == OverflowDrop {
+		return false, nil
+	}
+	return false, &smtp.SMTPError{
+		Code:         452,
+		EnhancedCode: smtp.EnhancedCode{4, 5, 3},
+		Message:      fmt.Sprintf("Too many recipients (max %d)", p.MaxRecipients),
+	}
+}
+
+// storeLimit returns how many copies of a message of the given size may be
+// stored and which limit set it ("" when unlimited)
+func (p RecipientPolicy) storeLimit(size int64) (int, string) {
+	limit, reason := -1, ""
+	if p.MaxStoredCopies > 0 {
+		limit, reason = p.MaxStoredCopies, "max_stored_copies"
+	}
+	if p.MaxStoredBytes > 0 && size > 0 {
+		if byBytes := int(p.MaxStoredBytes / size); limit < 0 || byBytes < limit {
+			limit, reason = byBytes, "max_stored_mb"
+		}
+	}
+	return limit, reason
+}
+
+// applyStorage trims recipients to the storage limits
+// Returns the recipients to store, or an error when the overflow action is reject
+func (p RecipientPolicy) applyStorage(logger *slog.Logger, recipients []string, size int64) ([]string, error) {
+	limit, reason := p.storeLimit(size)
+	if limit < 0 || len(recipients) <= limit {
+		return recipients, nil
+	}
+
+	if p.Overflow == OverflowDrop {
+		logger.Info("POLICY: Storage limit reached, dropping copies", "limit", reason, "allowed", limit, "recipients", len(recipients), "dropped", recipients[limit:])
+		return recipients[:limit], nil
+	}
+
+	logger.Info("REJECTED: Storage limit reached", "limit", reason, "allowed", limit, "recipients", len(recipients))
+	return nil, &smtp.SMTPError{
+		Code:         552,
+		EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+		Message:      fmt.Sprintf("Message fan-out exceeds storage policy (%s)", reason),
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestRecipientPolicyAllowRcpt(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RecipientPolicy
+		accepted int
+		wantOK   bool
+		wantCode int
+	}{
+		{"unlimited", RecipientPolicy{Overflow: OverflowReject}, 1000, true, 0},
+		{"below limit", RecipientPolicy{MaxRecipients: 3, Overflow: OverflowReject}, 2, true, 0},
+		{"at limit reject", RecipientPolicy{MaxRecipients: 3, Overflow: OverflowReject}, 3, false, 452},
+		{"at limit drop", RecipientPolicy{MaxRecipients: 3, Overflow: OverflowDrop}, 3, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := tt.policy.allowRcpt(tt.accepted)
+			if ok != tt.wantOK {
+				t.Errorf("allowRcpt(%d) ok = %v, want %v", tt.accepted, ok, tt.wantOK)
+			}
+
+			var smtpErr *smtp.SMTPError
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("allowRcpt(%d) error = %v, want nil", tt.accepted, err)
+				}
+			} else if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+				t.Errorf("allowRcpt(%d) error = %v, want %d", tt.accepted, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRecipientPolicyStoreLimit(t *testing.T) {
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name       string
+		policy     RecipientPolicy
+		size       int64
+		wantLimit  int
+		wantReason string
+	}{
+		{"unlimited", RecipientPolicy{}, mb, -1, ""},
+		{"copies only", RecipientPolicy{MaxStoredCopies: 5}, mb, 5, "max_stored_copies"},
+		{"bytes only", RecipientPolicy{MaxStoredBytes: 10 * mb}, 2 * mb, 5, "max_stored_mb"},
+		{"bytes tighter than copies", RecipientPolicy{MaxStoredCopies: 5, MaxStoredBytes: 4 * mb}, 2 * mb, 2, "max_stored_mb"},
+		{"copies tighter than bytes", RecipientPolicy{MaxStoredCopies: 2, MaxStoredBytes: 100 * mb}, 2 * mb, 2, "max_stored_copies"},
+		{"message bigger than byte cap", RecipientPolicy{MaxStoredBytes: mb}, 2 * mb, 0, "max_stored_mb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, reason := tt.policy.storeLimit(tt.size)
+			if limit != tt.wantLimit || reason != tt.wantReason {
+				t.Errorf("storeLimit() = (%d, %q), want (%d, %q)", limit, reason, tt.wantLimit, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRecipientPolicyApplyStorage(t *testing.T) {
+	recipients := []string{"a@t.test", "b@t.test", "c@t.test"}
+
+	// Exactly at the limit is allowed under either action
+	for _, overflow := range []string{OverflowReject, OverflowDrop} {
+		policy := RecipientPolicy{MaxStoredCopies: 3, Overflow: overflow}
+		got, err := policy.applyStorage(slog.Default(), recipients, 100)
+		if err != nil || len(got) != 3 {
+			t.Errorf("%s at limit: got %v, %v", overflow, got, err)
+		}
+	}
+
+	drop := RecipientPolicy{MaxStoredCopies: 2, Overflow: OverflowDrop}
+	got, err := drop.applyStorage(slog.Default(), recipients, 100)
+	if err != nil || strings.Join(got, ",") != "a@t.test,b@t.test" {
+		t.Errorf("drop over limit: got %v, %v", got, err)
+	}
+
+	reject := RecipientPolicy{MaxStoredCopies: 2, Overflow: OverflowReject}
+	_, err = reject.applyStorage(slog.Default(), recipients, 100)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 552 || !strings.Contains(smtpErr.Message, "max_stored_copies") {
+		t.Errorf("reject over limit: error = %v, want 552 naming max_stored_copies", err)
+	}
+}
+
+func TestSessionRecipientPolicyDrop(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Server.MaxRecipients = 3
+	cfg.Recipients.MaxStoredCopies = 2
+	cfg.Recipients.Overflow = OverflowDrop
+
+	mockDB := &mockSessionDB{addresses: map[string]bool{}}
+	for i := 1; i <= 4; i++ {
+		mockDB.addresses[fmt.Sprintf("user%d@tempmail.example.com", i)] = true
+	}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.Mail("sender@example.com", nil)
+	for i := 1; i <= 4; i++ {
+		if err := s.Rcpt(fmt.Sprintf("user%d@tempmail.example.com", i), nil); err != nil {
+			t.Fatalf("Rcpt(user%d) error = %v, want drop to acknowledge", i, err)
+		}
+	}
+	if len(s.to) != 3 {
+		t.Errorf("accepted %d recipients, want max_recipients=3", len(s.to))
+	}
+
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	if len(mockDB.stored) != 2 {
+		t.Errorf("stored %d copies, want max_stored_copies=2", len(mockDB.stored))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Reputation score adjustments for observed client behavior
+const (
+	reputationDelivered    = 0.05 // message accepted and stored
+	reputationUnknownRcpt  = -0.1 // RCPT for a mailbox that doesn't exist
+	reputationMaxMagnitude = 1.0
+)
+
+// reputationIdleTTL is how long an IP's score is kept without new activity;
+// an IP seen again after that starts over as unknown
+const reputationIdleTTL = 24 * time.Hour
+
+// reputationPruneInterval is how often expired scores are dropped
+const reputationPruneInterval = time.Hour
+
+// ReputationSource reports a client IP's reputation in [-1, 1], 0 when unknown
+type ReputationSource interface {
+	Score(ip string) float64
+}
+
+// reputationEntry is one IP's score and when it last changed
+type reputationEntry struct {
+	score float64
+	last  time.Time
+}
+
+// ReputationStore keeps in-memory per-IP reputation scores
+type ReputationStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	scores    map[string]reputationEntry
+	lastPrune time.Time
+}
+
+// NewReputationStore creates an empty reputation store
+func NewReputationStore() *ReputationStore {
+	return &ReputationStore{now: time.Now, scores: make(map[string]reputationEntry)}
+}
+
+// Score returns the reputation for ip
+func (r *ReputationStore) Score(ip string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.scores[ip]
+	if !ok || r.now().Sub(entry.last) >= reputationIdleTTL {
+		return 0
+	}
+	return entry.score
+}
+
+// Adjust moves the reputation for ip by delta, clamped to [-1, 1]
+func (r *ReputationStore) Adjust(ip string, delta float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.prune(now)
+
+	entry := r.scores[ip]
+	if now.Sub(entry.last) >= reputationIdleTTL {
+		entry.score = 0
+	}
+	score := entry.score + delta
+	if score > reputationMaxMagnitude {
+		score = reputationMaxMagnitude
+	}
+	if score < -reputationMaxMagnitude {
+		score = -reputationMaxMagnitude
+	}
+	r.scores[ip] = reputationEntry{score: score, last: now}
+}
+
+// prune drops scores idle for longer than reputationIdleTTL
+func (r *ReputationStore) prune(now time.Time) {
+	if now.Sub(r.lastPrune) < reputationPruneInterval {
+		return
+	}
+	r.lastPrune = now
+
+	for ip, entry := range r.scores {
+		if now.Sub(entry.last) >= reputationIdleTTL {
+			delete(r.scores, ip)
+		}
+	}
+}
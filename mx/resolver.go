@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"net"
+)
+
+// Resolver abstracts the DNS lookups used by the MX server
+// *net.Resolver satisfies it; tests inject fakes so they don't depend on live DNS
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// defaultResolver returns the system resolver
+func defaultResolver() Resolver {
+	return net.DefaultResolver
+}
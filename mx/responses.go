@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Rejection categories whose text can be replaced via responses.<category>
+// Only the human-readable text changes; codes stay standards-compliant
+const (
+	ResponseMalformedSender      = "malformed_sender"
+	ResponseTLSRequired          = "tls_required"
+	ResponseRateLimited          = "rate_limited"
+	ResponseStorageFull          = "storage_full"
+	ResponseMessageTooLarge      = "message_too_large"
+	ResponseInvalidAddress       = "invalid_address"
+	ResponseDomainNotAccepted    = "domain_not_accepted"
+	ResponseDomainNotAccepting   = "domain_not_accepting"
+	ResponseUnknownRecipient     = "unknown_recipient"
+	ResponseTooManyRecipients    = "too_many_recipients"
+	ResponseNoValidRecipients    = "no_valid_recipients"
+	ResponseFanOutExceeded       = "fan_out_exceeded"
+	ResponseSuspiciousAttachment = "suspicious_attachment"
+	ResponseAttachmentsTooLarge  = "attachments_too_large"
+	ResponseRecipientMismatch    = "recipient_mismatch"
+	ResponseReverseDNS           = "reverse_dns"
+	ResponseUnauthenticated      = "unauthenticated"
+	ResponseDKIMMisaligned       = "dkim_misaligned"
+	ResponseDMARCReject          = "dmarc_reject"
+	ResponseTooManyConnections   = "too_many_connections"
+	ResponseBlocklisted          = "blocklisted"
+	ResponseSenderBlocked        = "sender_blocked"
+	ResponseStorageUnavailable   = "storage_unavailable"
+	ResponseDNSBLListed          = "dnsbl_listed"
+)
+
+// responseCategories lists every category; the value is the status go-smtp sends
+// for categories reported as plain errors, which a custom text must keep
+var responseCategories = map[string]*smtp.SMTPError{
+	ResponseMalformedSender:      nil,
+	ResponseTLSRequired:          nil,
+	ResponseRateLimited:          nil,
+	ResponseStorageFull:          nil,
+	ResponseMessageTooLarge:      {Code: 554, EnhancedCode: smtp.EnhancedCode{5, 0, 0}},
+	ResponseInvalidAddress:       {Code: 451, EnhancedCode: smtp.EnhancedCode{4, 0, 0}},
+	ResponseDomainNotAccepted:    {Code: 451, EnhancedCode: smtp.EnhancedCode{4, 0, 0}},
+	ResponseDomainNotAccepting:   nil,
+	ResponseUnknownRecipient:     {Code: 451, EnhancedCode: smtp.EnhancedCode{4, 0, 0}},
+	ResponseTooManyRecipients:    nil,
+	ResponseNoValidRecipients:    nil,
+	ResponseFanOutExceeded:       nil,
+	ResponseSuspiciousAttachment: nil,
+	ResponseAttachmentsTooLarge:  nil,
+	ResponseRecipientMismatch:    nil,
+	ResponseReverseDNS:           nil,
+	ResponseUnauthenticated:      nil,
+	ResponseDKIMMisaligned:       nil,
+	ResponseDMARCReject:          nil,
+	ResponseTooManyConnections:   nil,
+	ResponseBlocklisted:          nil,
+	ResponseSenderBlocked:        nil,
+	ResponseStorageUnavailable:   nil,
+	ResponseDNSBLListed:          nil,
+}
+
+// validateResponses checks that every responses key is a known single-line category
+func validateResponses(cfg *Config) error {
+	for category, text := range cfg.Responses {
+		if _, ok := responseCategories[category]; !ok {
+			known := make([]string, 0, len(responseCategories))
+			for name := range responseCategories {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return configErrorf("responses."+category, "is not a rejection category (known: %s)", strings.Join(known, ", "))
+		}
+		if strings.ContainsAny(text, "\r\n") {
+			return configErrorf("responses."+category, "must be a single line")
+		}
+	}
+	return nil
+}
+
+// customResponse replaces err's text with responses.<category> when configured,
+// keeping the SMTP codes the client would otherwise have received
+func customResponse(cfg *Config, category string, err error) error {
+	if err == nil || cfg == nil {
+		return err
+	}
+	text := strings.TrimSpace(cfg.Responses[category])
+	if text == "" {
+		return err
+	}
+
+	if smtpErr, ok := err.(*smtp.SMTPError); ok {
+		return &smtp.SMTPError{Code: smtpErr.Code, EnhancedCode: smtpErr.EnhancedCode, Message: text}
+	}
+	if status := responseCategories[category]; status != nil {
+		return &smtp.SMTPError{Code: status.Code, EnhancedCode: status.EnhancedCode, Message: text}
+	}
+	return err
+}
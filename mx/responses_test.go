@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestCustomResponse(t *testing.T) {
+	cfg := &Config{Responses: map[string]string{
+		ResponseUnknownRecipient:     "No such inbox, see https://example.com/help",
+		ResponseSuspiciousAttachment: "Attachments like this are not accepted here",
+	}}
+
+	tests := []struct {
+		name     string
+		category string
+		err      error
+		wantCode int
+		wantText string
+		wantSame bool // err returned unchanged
+	}{
+		{"configured smtp error keeps codes", ResponseSuspiciousAttachment,
+			&smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 7, 1}, Message: "default"},
+			550, "Attachments like this are not accepted here", false},
+		{"configured plain error keeps go-smtp status", ResponseUnknownRecipient, ErrAddressNotFound,
+			451, "No such inbox, see https://example.com/help", false},
+		{"unconfigured category falls back", ResponseRateLimited, ErrAddressNotFound, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := customResponse(cfg, tt.category, tt.err)
+			if tt.wantSame {
+				if got != tt.err {
+					t.Errorf("customResponse() = %v, want unchanged %v", got, tt.err)
+				}
+				return
+			}
+			var smtpErr *smtp.SMTPError
+			if !errors.As(got, &smtpErr) {
+				t.Fatalf("customResponse() = %v, want SMTPError", got)
+			}
+			if smtpErr.Code != tt.wantCode || smtpErr.Message != tt.wantText {
+				t.Errorf("customResponse() = %d %q, want %d %q", smtpErr.Code, smtpErr.Message, tt.wantCode, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestSessionCustomResponses(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Responses = map[string]string{ResponseUnknownRecipient: "Inbox expired or never existed"}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+	s.Mail("sender@example.com", nil)
+
+	var smtpErr *smtp.SMTPError
+	err := s.Rcpt("nobody@tempmail.example.com", nil)
+	if !errors.As(err, &smtpErr) || smtpErr.Message != "Inbox expired or never existed" {
+		t.Fatalf("Rcpt() unknown recipient = %v, want custom text", err)
+	}
+	if smtpErr.Code != 451 || smtpErr.EnhancedCode != (smtp.EnhancedCode{4, 0, 0}) {
+		t.Errorf("Rcpt() status = %d %v, want 451 4.0.0", smtpErr.Code, smtpErr.EnhancedCode)
+	}
+
+	// Categories without custom text keep the default
+	err = s.Rcpt("user@elsewhere.example", nil)
+	if !errors.Is(err, ErrDomainNotAccepted) {
+		t.Errorf("Rcpt() foreign domain = %v, want ErrDomainNotAccepted", err)
+	}
+}
+
+func TestLoadConfigResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses string
+		wantErr   bool
+	}{
+		{"known category", "  unknown_recipient: \"No such inbox\"\n", false},
+		{"unknown category", "  no_such_category: \"text\"\n", true},
+		{"multi-line text", "  rate_limited: \"slow\\r\\ndown\"\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "test.yaml")
+			config := "domains:\n  - tempmail.test\ndatabase:\n  url: postgresql://localhost/tempmail\nresponses:\n" + tt.responses
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			_, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrConfigInvalid) {
+				t.Errorf("LoadConfig() error = %v, want ErrConfigInvalid", err)
+			}
+		})
+	}
+}
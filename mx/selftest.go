@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// selfTestTimeout bounds each network-facing self-test check
+const selfTestTimeout = 10 * time.Second
+
+// SelfTestStatus is the outcome of a single self-test check
+type SelfTestStatus string
+
+const (
+	SelfTestPass SelfTestStatus = "PASS"
+	SelfTestFail SelfTestStatus = "FAIL"
+	SelfTestSkip SelfTestStatus = "SKIP"
+)
+
+// SelfTestResult reports one self-test check
+type SelfTestResult struct {
+	Name   string
+	Status SelfTestStatus
+	Detail string
+}
+
+// SelfTestDB is the database access the self-test needs
+// *DB satisfies it; tests inject fakes
+type SelfTestDB interface {
+	PingContext(ctx context.Context) error
+	DryRunMigrations(ctx context.Context, migrations []Migration) error
+}
+
+// SelfTest verifies a deployment before it takes traffic
+type SelfTest struct {
+	cfg           *Config
+	db            SelfTestDB // nil when the connection could not be opened
+	dbErr         error
+	resolver      Resolver
+	migrationsDir string
+	now           func() time.Time
+}
+
+// Run performs every check and returns the results in order
+func (st *SelfTest) Run(ctx context.Context) []SelfTestResult {
+	results := []SelfTestResult{
+		st.checkDatabase(ctx),
+		st.checkMigrations(ctx),
+		st.checkTLS(),
+		st.checkDNS(ctx),
+	}
+	return append(results, st.checkMX(ctx)...)
+}
+
+// checkDatabase pings the database
+func (st *SelfTest) checkDatabase(ctx context.Context) SelfTestResult {
+	result := SelfTestResult{Name: "database"}
+	if st.db == nil {
+		return result.fail(st.dbErr)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	if err := st.db.PingContext(ctx); err != nil {
+		return result.fail(err)
+	}
+	return result.pass("connected")
+}
+
+// checkMigrations applies every migration inside a transaction that is rolled back
+func (st *SelfTest) checkMigrations(ctx context.Context) SelfTestResult {
+	result := SelfTestResult{Name: "migrations"}
+	if st.migrationsDir == "" {
+		return result.skip("no migrations directory configured")
+	}
+
+	files, err := filepath.Glob(filepath.Join(st.migrationsDir, "*.sql"))
+	if err != nil {
+		return result.fail(err)
+	}
+	if len(files) == 0 {
+		return result.skip(fmt.Sprintf("no migrations found in %s", st.migrationsDir))
+	}
+	if st.db == nil {
+		return result.skip("database unavailable")
+	}
+	sort.Strings(files)
+
+	migrations := make([]Migration, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return result.fail(err)
+		}
+		migrations = append(migrations, Migration{Name: filepath.Base(file), SQL: string(data)})
+	}
+
+	if err := st.db.DryRunMigrations(ctx, migrations); err != nil {
+		return result.fail(err)
+	}
+	return result.pass(fmt.Sprintf("%d migrations apply cleanly (rolled back)", len(migrations)))
+}
+
+// checkTLS loads the certificate and key and checks the validity window
+func (st *SelfTest) checkTLS() SelfTestResult {
+	result := SelfTestResult{Name: "tls"}
+	if !st.cfg.TLS.Enabled {
+		return result.skip("TLS disabled")
+	}
+
+	pair, err := tls.LoadX509KeyPair(st.cfg.TLS.CertFile, st.cfg.TLS.KeyFile)
+	if err != nil {
+		return result.fail(err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return result.fail(err)
+	}
+
+	now := st.now()
+	switch {
+	case now.Before(leaf.NotBefore):
+		return result.fail(fmt.Errorf("certificate not valid until %s", leaf.NotBefore.Format(time.RFC3339)))
+	case now.After(leaf.NotAfter):
+		return result.fail(fmt.Errorf("certificate expired %s", leaf.NotAfter.Format(time.RFC3339)))
+	}
+	days := int(leaf.NotAfter.Sub(now).Hours() / 24)
+	return result.pass(fmt.Sprintf("%s valid for %d more days", leaf.Subject.CommonName, days))
+}
+
+// checkDNS confirms the resolver answers; NXDOMAIN still proves resolution works
+func (st *SelfTest) checkDNS(ctx context.Context) SelfTestResult {
+	result := SelfTestResult{Name: "dns"}
+
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	_, err := st.resolver.LookupMX(ctx, st.cfg.Domains[0])
+
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return result.fail(err)
+	}
+	return result.pass("resolver responding")
+}
+
+// checkMX reuses the MX diagnostics for every configured domain
+func (st *SelfTest) checkMX(ctx context.Context) []SelfTestResult {
+	var results []SelfTestResult
+	for _, mx := range CheckDomainMX(ctx, st.resolver, st.cfg.Domains, st.cfg.Server.Hostname) {
+		result := SelfTestResult{Name: "mx " + mx.Domain}
+		if mx.OK {
+			results = append(results, result.pass(fmt.Sprintf("%v", mx.Hosts)))
+		} else {
+			results = append(results, result.fail(mx.Err))
+		}
+	}
+	return results
+}
+
+func (r SelfTestResult) pass(detail string) SelfTestResult {
+	r.Status, r.Detail = SelfTestPass, detail
+	return r
+}
+
+func (r SelfTestResult) fail(err error) SelfTestResult {
+	r.Status, r.Detail = SelfTestFail, err.Error()
+	return r
+}
+
+func (r SelfTestResult) skip(detail string) SelfTestResult {
+	r.Status, r.Detail = SelfTestSkip, detail
+	return r
+}
+
+// reportSelfTest logs a summary and reports whether every check passed or was skipped
+func reportSelfTest(results []SelfTestResult) bool {
+	failed := 0
+	for _, r := range results {
+		log.Printf("  [%s] %-12s %s", r.Status, r.Name, r.Detail)
+		if r.Status == SelfTestFail {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		log.Printf("Self-test FAILED: %d of %d checks failed", failed, len(results))
+		return false
+	}
+	log.Printf("Self-test passed (%d checks)", len(results))
+	return true
+}
+
+// runSelfTest connects to the database and runs all checks, returning the process exit code
+func runSelfTest(cfg *Config, migrationsDir string) int {
+	log.Println("Running self-test...")
+
+	st := &SelfTest{
+		cfg:           cfg,
+		resolver:      defaultResolver(),
+		migrationsDir: migrationsDir,
+		now:           time.Now,
+	}
+
+	db, err := NewDB(cfg.Database.URL, cfg.DBPool())
+	if err != nil {
+		st.dbErr = err
+	} else {
+		defer db.Close()
+		st.db = db
+	}
+
+	if !reportSelfTest(st.Run(context.Background())) {
+		return 1
+	}
+	return 0
+}
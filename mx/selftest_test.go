@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeSelfTestDB records migrations and returns canned errors
+type fakeSelfTestDB struct {
+	pingErr    error
+	migrateErr error
+	migrations []Migration
+}
+
+func (f *fakeSelfTestDB) PingContext(ctx context.Context) error {
+	return f.pingErr
+}
+
+func (f *fakeSelfTestDB) DryRunMigrations(ctx context.Context, migrations []Migration) error {
+	f.migrations = migrations
+	return f.migrateErr
+}
+
+// newTestSelfTest returns a self-test whose checks all pass
+func newTestSelfTest(t *testing.T) (*SelfTest, *fakeSelfTestDB) {
+	t.Helper()
+
+	certFile, keyFile := writeTestCert(t)
+	cfg := &Config{Domains: []string{"tempmail.test"}}
+	cfg.Server.Hostname = "mail.tempmail.test"
+	cfg.TLS.Enabled = true
+	cfg.TLS.CertFile = certFile
+	cfg.TLS.KeyFile = keyFile
+
+	dir := t.TempDir()
+	for name, sql := range map[string]string{
+		"002_second.sql": "ALTER TABLE emails ADD COLUMN b TEXT;",
+		"001_first.sql":  "ALTER TABLE emails ADD COLUMN a TEXT;",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(sql), 0644); err != nil {
+			t.Fatalf("Failed to write migration: %v", err)
+		}
+	}
+
+	db := &fakeSelfTestDB{}
+	return &SelfTest{
+		cfg: cfg,
+		db:  db,
+		resolver: &fakeResolver{mx: map[string][]*net.MX{
+			"tempmail.test": {{Host: "mail.tempmail.test.", Pref: 10}},
+		}},
+		migrationsDir: dir,
+		now:           time.Now,
+	}, db
+}
+
+// statuses maps check names to their status
+func statuses(results []SelfTestResult) map[string]SelfTestStatus {
+	m := make(map[string]SelfTestStatus, len(results))
+	for _, r := range results {
+		m[r.Name] = r.Status
+	}
+	return m
+}
+
+func TestSelfTestPass(t *testing.T) {
+	st, db := newTestSelfTest(t)
+
+	results := st.Run(context.Background())
+	for _, r := range results {
+		if r.Status != SelfTestPass {
+			t.Errorf("%s = %s (%s), want PASS", r.Name, r.Status, r.Detail)
+		}
+	}
+	if !reportSelfTest(results) {
+		t.Error("reportSelfTest() = false, want true")
+	}
+
+	if len(db.migrations) != 2 || db.migrations[0].Name != "001_first.sql" {
+		t.Errorf("migrations = %+v, want both files in order", db.migrations)
+	}
+}
+
+func TestSelfTestFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(st *SelfTest, db *fakeSelfTestDB)
+		wantCheck string
+		want      SelfTestStatus
+	}{
+		{
+			name:      "database unreachable",
+			setup:     func(st *SelfTest, db *fakeSelfTestDB) { db.pingErr = errors.New("connection refused") },
+			wantCheck: "database",
+			want:      SelfTestFail,
+		},
+		{
+			name:      "no database connection",
+			setup:     func(st *SelfTest, db *fakeSelfTestDB) { st.db, st.dbErr = nil, errors.New("dial failed") },
+			wantCheck: "database",
+			want:      SelfTestFail,
+		},
+		{
+			name:      "migration fails",
+			setup:     func(st *SelfTest, db *fakeSelfTestDB) { db.migrateErr = errors.New("syntax error") },
+			wantCheck: "migrations",
+			want:      SelfTestFail,
+		},
+		{
+			name:      "no migrations directory",
+			setup:     func(st *SelfTest, db *fakeSelfTestDB) { st.migrationsDir = "" },
+			wantCheck: "migrations",
+			want:      SelfTestSkip,
+		},
+		{
+			name: "certificate expired",
+			setup: func(st *SelfTest, db *fakeSelfTestDB) {
+				st.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+			},
+			wantCheck: "tls",
+			want:      SelfTestFail,
+		},
+		{
+			name:      "certificate missing",
+			setup:     func(st *SelfTest, db *fakeSelfTestDB) { st.cfg.TLS.CertFile = "/nonexistent/cert.pem" },
+			wantCheck: "tls",
+			want:      SelfTestFail,
+		},
+		{
+			name: "MX points elsewhere",
+			setup: func(st *SelfTest, db *fakeSelfTestDB) {
+				st.resolver = &fakeResolver{mx: map[string][]*net.MX{"tempmail.test": {{Host: "mx.other.example.", Pref: 10}}}}
+			},
+			wantCheck: "mx tempmail.test",
+			want:      SelfTestFail,
+		},
+		{
+			name: "NXDOMAIN still proves DNS works",
+			setup: func(st *SelfTest, db *fakeSelfTestDB) {
+				st.resolver = &fakeResolver{}
+			},
+			wantCheck: "dns",
+			want:      SelfTestPass,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, db := newTestSelfTest(t)
+			tt.setup(st, db)
+
+			results := st.Run(context.Background())
+			if got := statuses(results)[tt.wantCheck]; got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.wantCheck, got, tt.want)
+			}
+
+			wantOK := true
+			for _, r := range results {
+				if r.Status == SelfTestFail {
+					wantOK = false
+				}
+			}
+			if got := reportSelfTest(results); got != wantOK {
+				t.Errorf("reportSelfTest() = %v, want %v", got, wantOK)
+			}
+		})
+	}
+}
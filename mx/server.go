@@ -1,20 +1,45 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/emersion/go-smtp"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Backend implements SMTP server backend
 type Backend struct {
-	cfg       *Config
-	db        *DB
-	validator *Validator
-	domains   map[string]bool
+	mu         sync.RWMutex // guards cfg and what is built from it across reloads
+	cfg        *Config
+	db         *DB
+	validator  *Validator
+	domains    map[string]DomainConfig
+	storage    *StorageMonitor
+	notifier   Notifier
+	ratelimit  *RateLimiter
+	reputation *ReputationStore
+	ptr        *PTRChecker
+	dnsbl      *DNSBLChecker
+	tlsPolicy  *TLSPolicy
+	spool      *Spool
+	geoip      *GeoIP
+	greylist   *Greylister
+	connLimit  *ConnLimiter
+	ipFilter   *IPFilter
+
+	connsMu sync.Mutex
+	conns   map[*smtp.Conn]struct{} // open sessions, closed when a shutdown times out
 }
 
 // NewBackend creates a new SMTP backend
@@ -24,6 +49,110 @@ func NewBackend(cfg *Config, db *DB, validator *Validator) *Backend {
 		db:        db,
 		validator: validator,
 		domains:   cfg.GetDomainMap(),
+		notifier:  logNotifier{},
+	}
+}
+
+// trackConn records an open session's connection
+func (bkd *Backend) trackConn(c *smtp.Conn) {
+	bkd.connsMu.Lock()
+	defer bkd.connsMu.Unlock()
+	if bkd.conns == nil {
+		bkd.conns = make(map[*smtp.Conn]struct{})
+	}
+	bkd.conns[c] = struct{}{}
+}
+
+// untrackConn forgets a connection once its session has logged out
+func (bkd *Backend) untrackConn(c *smtp.Conn) {
+	bkd.connsMu.Lock()
+	defer bkd.connsMu.Unlock()
+	delete(bkd.conns, c)
+}
+
+// closeConns closes every open session's connection and returns how many there were
+func (bkd *Backend) closeConns() int {
+	bkd.connsMu.Lock()
+	defer bkd.connsMu.Unlock()
+	for c := range bkd.conns {
+		c.Conn().Close()
+	}
+	return len(bkd.conns)
+}
+
+// config returns the current configuration
+func (bkd *Backend) config() *Config {
+	bkd.mu.RLock()
+	defer bkd.mu.RUnlock()
+	return bkd.cfg
+}
+
+// Reload swaps in cfg, with the validator, blocklist and TLS policy built
+// from it, for sessions started afterwards; open sessions keep their view
+// An empty domain set would silently reject every RCPT, so it is refused and
+// the current configuration is kept
+func (bkd *Backend) Reload(cfg *Config) error {
+	domains := cfg.GetDomainMap()
+	if len(domains) == 0 {
+		return fmt.Errorf("reload refused, keeping current configuration: %w",
+			configErrorf("domains", "must list at least one domain"))
+	}
+	var tlsPolicy *TLSPolicy
+	if len(cfg.TLS.RequireByNetwork) > 0 {
+		policy, err := NewTLSPolicy(cfg.TLS.RequireByNetwork)
+		if err != nil {
+			return fmt.Errorf("reload refused, keeping current configuration: %w", err)
+		}
+		tlsPolicy = policy
+	}
+	var ipFilter *IPFilter
+	if len(cfg.Blocklist.IPs) > 0 {
+		ipFilter = NewIPFilter(cfg)
+	}
+
+	for _, key := range keepRestartOnly(bkd.config(), cfg) {
+		slog.Warn("Setting changed but requires a restart, ignored on reload", "setting", key)
+	}
+
+	bkd.mu.Lock()
+	bkd.cfg = cfg
+	bkd.domains = domains
+	bkd.validator = newValidatorFor(cfg)
+	bkd.ipFilter = ipFilter
+	bkd.tlsPolicy = tlsPolicy
+	bkd.mu.Unlock()
+	slog.Info("Configuration reloaded", "domains", cfg.Domains)
+	return nil
+}
+
+// keepRestartOnly copies settings fixed at startup (listeners, go-smtp limits, TLS files,
+// database, spool) from running into cfg, returning the keys that differed
+func keepRestartOnly(running, cfg *Config) []string {
+	var ignored []string
+	keepSetting(&ignored, "server.mx_port", running.Server.MXPort, &cfg.Server.MXPort)
+	keepSetting(&ignored, "server.hostname", running.Server.Hostname, &cfg.Server.Hostname)
+	keepSetting(&ignored, "server.proxy_protocol", running.Server.ProxyProtocol, &cfg.Server.ProxyProtocol)
+	if !slices.Equal(running.Server.ProxyTrustedCIDRs, cfg.Server.ProxyTrustedCIDRs) {
+		ignored = append(ignored, "server.proxy_trusted_cidrs")
+		cfg.Server.ProxyTrustedCIDRs = running.Server.ProxyTrustedCIDRs
+	}
+	keepSetting(&ignored, "server.read_timeout_seconds", running.Server.ReadTimeoutSeconds, &cfg.Server.ReadTimeoutSeconds)
+	keepSetting(&ignored, "server.write_timeout_seconds", running.Server.WriteTimeoutSeconds, &cfg.Server.WriteTimeoutSeconds)
+	keepSetting(&ignored, "server.max_recipients", running.Server.MaxRecipients, &cfg.Server.MaxRecipients)
+	keepSetting(&ignored, "recipients.overflow", running.Recipients.Overflow, &cfg.Recipients.Overflow)
+	keepSetting(&ignored, "tls.enabled", running.TLS.Enabled, &cfg.TLS.Enabled)
+	keepSetting(&ignored, "tls.cert_file", running.TLS.CertFile, &cfg.TLS.CertFile)
+	keepSetting(&ignored, "tls.key_file", running.TLS.KeyFile, &cfg.TLS.KeyFile)
+	keepSetting(&ignored, "tls.acme.enabled", running.TLS.ACME.Enabled, &cfg.TLS.ACME.Enabled)
+	keepSetting(&ignored, "database.url", running.Database.URL, &cfg.Database.URL)
+	keepSetting(&ignored, "spool.dir", running.Spool.Dir, &cfg.Spool.Dir)
+	return ignored
+}
+
+func keepSetting[T comparable](ignored *[]string, key string, running T, loaded *T) {
+	if *loaded != running {
+		*ignored = append(*ignored, key)
+		*loaded = running
 	}
 }
 
@@ -31,104 +160,508 @@ func NewBackend(cfg *Config, db *DB, validator *Validator) *Backend {
 func (bkd *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
 	remoteAddr := c.Conn().RemoteAddr().String()
 	hostname := c.Hostname()
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+
+	bkd.mu.RLock()
+	cfg, domains := bkd.cfg, bkd.domains
+	validator, ipFilter, tlsPolicy := bkd.validator, bkd.ipFilter, bkd.tlsPolicy
+	bkd.mu.RUnlock()
+
+	if ipFilter != nil {
+		if rule, blocked := ipFilter.Blocked(ip); blocked {
+			slog.Info("REJECTED: Client IP matches blocklist", "remote_addr", remoteAddr, "rule", rule)
+			return nil, customResponse(cfg, ResponseBlocklisted, errSMTPBlocklisted)
+		}
+	}
 
-	// Check if TLS is enabled
-	tlsInfo := ""
-	if tlsConn, ok := c.Conn().(*tls.Conn); ok {
-		state := tlsConn.ConnectionState()
-		tlsInfo = fmt.Sprintf(" [TLS %s]", tlsVersionString(state.Version))
+	// Checked before PTR: it is cheap and keeps a flood off DNS
+	if bkd.connLimit != nil {
+		if !bkd.connLimit.Acquire(ip, c) {
+			slog.Info("DEFERRED: Too many sessions", "remote_addr", remoteAddr, "sessions", bkd.connLimit.Active(ip))
+			return nil, customResponse(cfg, ResponseTooManyConnections, errSMTPTooManyConnections)
+		}
+	}
+
+	if bkd.ptr != nil {
+		if err := bkd.ptr.Check(ip); err != nil {
+			slog.Info("REJECTED: Reverse DNS policy", "remote_addr", remoteAddr)
+			if bkd.connLimit != nil {
+				bkd.connLimit.Release(ip, c)
+			}
+			return nil, customResponse(cfg, ResponseReverseDNS, err)
+		}
 	}
 
-	log.Printf("[%s] New connection from: %s%s", remoteAddr, hostname, tlsInfo)
+	state, isTLS := c.TLSConnectionState()
+	session := NewSession(remoteAddr, hostname, cfg, bkd.db, validator, domains)
+	session.storage = bkd.storage
+	session.notifier = bkd.notifier
+	session.ratelimit = bkd.ratelimit
+	session.reputation = bkd.reputation
+	session.tlsPolicy = tlsPolicy
+	session.dnsbl = bkd.dnsbl
+	session.tls = isTLS
+	session.spool = bkd.spool
+	session.greylist = bkd.greylist
+	if bkd.db != nil {
+		session.addresses = bkd.db
+	}
+	bkd.trackConn(c)
+	// Per-command timeouts reset on every line, so a client trickling bytes
+	// could otherwise hold the connection forever. Runs on the timer's
+	// goroutine, so it logs without the session's lazily built logger
+	var deadline *time.Timer
+	if maxSession := cfg.MaxSession(); maxSession > 0 {
+		deadline = time.AfterFunc(maxSession, func() {
+			slog.Info("CLOSED: Session exceeded max_session_seconds", "session_id", session.id,
+				"remote_addr", remoteAddr, "max_session", maxSession)
+			// Abort a store in progress; the client never sees its reply
+			session.cancel()
+			c.Conn().Close()
+		})
+	}
+	session.releaseConn = func() {
+		if deadline != nil {
+			deadline.Stop()
+		}
+		bkd.untrackConn(c)
+		if bkd.connLimit != nil {
+			bkd.connLimit.Release(ip, c)
+		}
+	}
+	session.geo = bkd.geoip.Lookup(session.getClientIP())
 
-	return NewSession(remoteAddr, hostname, bkd.cfg, bkd.db, bkd.validator, bkd.domains), nil
+	attrs := []any{"helo", hostname}
+	if isTLS {
+		attrs = append(attrs, "tls", tlsVersionString(state.Version))
+	}
+	if geo := session.geo.String(); geo != "" {
+		attrs = append(attrs, "geo", geo)
+	}
+	session.info("New connection", attrs...)
+	return session, nil
 }
 
 // SMTPServer wraps the SMTP server
 type SMTPServer struct {
-	server *smtp.Server
-	cfg    *Config
+	server  *smtp.Server
+	backend *Backend
+	cfg     *Config
+	storage *StorageMonitor
+	batcher *batchingNotifier
+	webhook *webhookNotifier
+	queue   *queueNotifier
+	stop    context.CancelFunc
+
+	acme         *autocert.Manager // nil unless tls.acme.enabled
+	acmeListener net.Listener
+	certs        *certReloader // nil unless TLS uses cert_file/key_file
+
+	listening atomic.Bool // listener bound and accepting, for /readyz
+}
+
+// newValidatorFor returns a validator for cfg, nil when every check is off
+func newValidatorFor(cfg *Config) *Validator {
+	if cfg.Validation.CheckDKIM || cfg.Validation.CheckSPF || cfg.Validation.CheckDMARC || cfg.Validation.TrustExistingAuthResults {
+		return NewValidator(cfg)
+	}
+	return nil
 }
 
 // NewSMTPServer creates a new SMTP server
 func NewSMTPServer(cfg *Config, db *DB) (*SMTPServer, error) {
+	// Without domains every RCPT would be rejected; refuse to start instead
+	if len(cfg.GetDomainMap()) == 0 {
+		return nil, configErrorf("domains", "must list at least one domain")
+	}
+
 	// Create validator (if validation is enabled)
-	var validator *Validator
-	if cfg.Validation.CheckDKIM || cfg.Validation.CheckSPF || cfg.Validation.CheckDMARC {
-		validator = NewValidator(cfg)
-		log.Printf("Email validation enabled - DKIM: %v, SPF: %v, DMARC: %v",
-			cfg.Validation.CheckDKIM, cfg.Validation.CheckSPF, cfg.Validation.CheckDMARC)
+	validator := newValidatorFor(cfg)
+	if validator != nil {
+		slog.Info("Email validation enabled",
+			"dkim", cfg.Validation.CheckDKIM, "spf", cfg.Validation.CheckSPF, "dmarc", cfg.Validation.CheckDMARC)
 	} else {
-		log.Println("Email validation disabled")
+		slog.Info("Email validation disabled")
 	}
 
 	// Create backend
 	backend := NewBackend(cfg, db, validator)
 
+	// Track total storage when a global cap is configured
+	if cfg.Storage.GlobalMaxGB > 0 {
+		backend.storage = NewStorageMonitor(cfg, db, db)
+		slog.Info("Storage cap enabled", "max_gb", cfg.Storage.GlobalMaxGB, "over_cap_action", cfg.Storage.OverCapAction)
+	}
+
+	// Refuse clients with generic/dynamic reverse DNS
+	if cfg.Validation.RejectPTRPattern != "" {
+		ptr, err := NewPTRChecker(cfg, defaultResolver())
+		if err != nil {
+			return nil, err
+		}
+		backend.ptr = ptr
+		slog.Info("PTR check enabled", "pattern", cfg.Validation.RejectPTRPattern, "fail_closed", cfg.Validation.PTRFailClosed)
+	}
+
+	// Refuse clients listed on DNS blocklists
+	if len(cfg.DNSBL.Zones) > 0 {
+		backend.dnsbl = NewDNSBLChecker(cfg, defaultResolver())
+		slog.Info("DNSBL checks enabled", "zones", cfg.DNSBL.Zones)
+	}
+
+	// Require STARTTLS from selected client networks
+	if len(cfg.TLS.RequireByNetwork) > 0 {
+		policy, err := NewTLSPolicy(cfg.TLS.RequireByNetwork)
+		if err != nil {
+			return nil, err
+		}
+		backend.tlsPolicy = policy
+		slog.Info("Per-network TLS requirement enabled", "networks", len(cfg.TLS.RequireByNetwork))
+	}
+
+	// Tag connections and stored mail with the client's country and ASN
+	// A missing or unreadable database only disables the enrichment
+	if cfg.GeoIP.DBPath != "" || cfg.GeoIP.ASNDBPath != "" {
+		geoip, err := NewGeoIP(cfg.GeoIP.DBPath, cfg.GeoIP.ASNDBPath)
+		if err != nil {
+			slog.Warn("GeoIP disabled", "error", err)
+		} else {
+			backend.geoip = geoip
+			slog.Info("GeoIP enrichment enabled")
+		}
+	}
+
+	// Tempfail the first attempt of each (IP, sender, recipient) triple
+	if cfg.Greylist.Enabled && db != nil {
+		backend.greylist = NewGreylister(cfg, db)
+		slog.Info("Greylisting enabled", "delay_minutes", cfg.Greylist.DelayMinutes, "expiry_hours", cfg.Greylist.ExpiryHours)
+	}
+
+	// Refuse blocklisted client networks
+	if len(cfg.Blocklist.IPs) > 0 {
+		backend.ipFilter = NewIPFilter(cfg)
+		slog.Info("IP blocklist enabled", "entries", len(cfg.Blocklist.IPs), "allowlisted", len(cfg.Blocklist.AllowIPs))
+	}
+
+	// Per-IP concurrent sessions
+	if cfg.RateLimit.MaxConnectionsPerIP > 0 {
+		backend.connLimit = NewConnLimiter(cfg.RateLimit.MaxConnectionsPerIP)
+		slog.Info("Connection limit enabled", "sessions_per_ip", cfg.RateLimit.MaxConnectionsPerIP)
+	}
+
+	// Per-IP message rate, scaled by reputation built from each client's behavior
+	if cfg.RateLimit.MessagesPerMinute > 0 {
+		backend.reputation = NewReputationStore()
+		backend.ratelimit = NewRateLimiter(cfg, backend.reputation)
+		slog.Info("Rate limit enabled", "per_minute", cfg.RateLimit.MessagesPerMinute,
+			"min_per_minute", cfg.RateLimit.MinMessagesPerMinute, "max_per_minute", cfg.RateLimit.MaxMessagesPerMinute)
+	}
+
+	// Push events to external services instead of only logging them
+	var sinks multiNotifier
+	var webhook *webhookNotifier
+	if cfg.Webhooks.URL != "" {
+		webhook = newWebhookNotifier(cfg.Webhooks.URL, cfg.Webhooks.Secret,
+			time.Duration(cfg.Webhooks.TimeoutSeconds)*time.Second)
+		sinks = append(sinks, webhook)
+		slog.Info("Webhook delivery enabled", "url", cfg.Webhooks.URL, "timeout_seconds", cfg.Webhooks.TimeoutSeconds)
+	}
+	var queue *queueNotifier
+	pub, err := NewPublisher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if pub != nil {
+		queue = newQueueNotifier(pub, cfg.Notify.Channel)
+		sinks = append(sinks, queue)
+		slog.Info("Queue notifications enabled", "backend", cfg.Notify.Backend, "channel", cfg.Notify.Channel)
+	}
+	switch len(sinks) {
+	case 0:
+	case 1:
+		backend.notifier = sinks[0]
+	default:
+		backend.notifier = sinks
+	}
+
+	// Coalesce bursts of mail to one recipient into batched events
+	var batcher *batchingNotifier
+	if cfg.Webhooks.BatchWindowSeconds > 0 {
+		batcher = newBatchingNotifier(backend.notifier, time.Duration(cfg.Webhooks.BatchWindowSeconds)*time.Second)
+		backend.notifier = batcher
+		slog.Info("Event batching enabled", "window_seconds", cfg.Webhooks.BatchWindowSeconds)
+	}
+
+	// Silent domains store mail without emitting events
+	backend.notifier = newSilentNotifier(backend.notifier, backend.config)
+
+	// Acknowledge mail once it is on disk; a worker commits it to the database
+	if cfg.Spool.Dir != "" {
+		spool, err := NewSpool(cfg.Spool.Dir, db, time.Duration(cfg.Spool.RetryIntervalSeconds)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		spool.notifier = backend.notifier
+		backend.spool = spool
+		slog.Info("Write-ahead spool enabled", "dir", cfg.Spool.Dir, "retry_seconds", cfg.Spool.RetryIntervalSeconds)
+	}
+
 	// Create SMTP server
 	s := smtp.NewServer(backend)
 
 	// Configure server
 	s.Addr = fmt.Sprintf("0.0.0.0:%d", cfg.Server.MXPort)
 	s.Domain = cfg.Server.Hostname
-	s.ReadTimeout = 30 * time.Second
-	s.WriteTimeout = 30 * time.Second
-	s.MaxMessageBytes = cfg.GetMaxMessageSize()
-	s.MaxRecipients = 50 // Reasonable limit for tempmail
+	s.ReadTimeout = cfg.ReadTimeout()
+	s.WriteTimeout = cfg.WriteTimeout()
+	s.MaxMessageBytes = cfg.LargestMessageSize() // Per-sender limits are enforced by the session
+	s.MaxRecipients = cfg.MaxRecipients()
+	if cfg.Recipients.Overflow == OverflowDrop {
+		// go-smtp would answer 452 first; the session drops the overflow instead
+		s.MaxRecipients = 0
+	}
 	s.AllowInsecureAuth = false
 	s.AuthDisabled = true // MX servers don't require authentication
 
 	// Configure TLS if enabled
+	var acmeManager *autocert.Manager
+	var certs *certReloader
 	if cfg.TLS.Enabled {
-		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
-		}
-
-		s.TLSConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			MinVersion:   tls.VersionTLS12, // Require TLS 1.2 or higher
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			},
-			PreferServerCipherSuites: true,
+		if cfg.TLS.ACME.Enabled {
+			acmeManager = newACMEManager(cfg)
+			s.TLSConfig = buildTLSConfig(cfg, acmeGetCertificate(acmeManager, cfg.TLS.ACME.Domains[0]))
+			slog.Info("TLS/STARTTLS enabled with ACME certificates", "domains", cfg.TLS.ACME.Domains, "cache_dir", cfg.TLS.ACME.CacheDir)
+		} else {
+			var err error
+			if certs, err = newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+				return nil, err
+			}
+			s.TLSConfig = buildTLSConfig(cfg, certs.GetCertificate)
+			slog.Info("TLS/STARTTLS enabled", "cert", cfg.TLS.CertFile)
 		}
-		log.Printf("✓ TLS/STARTTLS enabled (cert: %s)", cfg.TLS.CertFile)
 	} else {
-		log.Printf("⚠ TLS/STARTTLS disabled - connections will be unencrypted")
+		slog.Warn("TLS/STARTTLS disabled - connections will be unencrypted")
 	}
 
-	log.Printf("SMTP MX Server configured:")
-	log.Printf("  Listen address: %s", s.Addr)
-	log.Printf("  Server domain: %s", s.Domain)
-	log.Printf("  Max message size: %d MB", cfg.Server.MaxMsgSizeMB)
-	log.Printf("  Max recipients: %d", s.MaxRecipients)
-	log.Printf("  Accepted domains: %v", cfg.Domains)
+	slog.Info("SMTP MX Server configured",
+		"addr", s.Addr,
+		"domain", s.Domain,
+		"max_msg_size_mb", cfg.Server.MaxMsgSizeMB,
+		"max_recipients", s.MaxRecipients,
+		"read_timeout", s.ReadTimeout,
+		"write_timeout", s.WriteTimeout,
+		"max_session", cfg.MaxSession(),
+		"domains", cfg.Domains)
 
-	return &SMTPServer{
-		server: s,
-		cfg:    cfg,
-	}, nil
+	server := &SMTPServer{
+		server:  s,
+		backend: backend,
+		cfg:     cfg,
+		storage: backend.storage,
+		batcher: batcher,
+		webhook: webhook,
+		queue:   queue,
+		acme:    acmeManager,
+		certs:   certs,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	server.stop = cancel
+	if server.storage != nil {
+		server.storage.Start(ctx)
+	}
+	if backend.spool != nil {
+		backend.spool.Start(ctx)
+	}
+	if db != nil && cfg.hasAddressCaps() {
+		startAddressMetrics(ctx, db)
+	}
+	if backend.greylist != nil {
+		startGreylistCleanup(ctx, db)
+	}
+	if certs != nil {
+		certs.watch(ctx, certCheckInterval)
+	}
+
+	return server, nil
+}
+
+// buildTLSConfig builds the STARTTLS configuration around getCertificate
+func buildTLSConfig(cfg *Config, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	tlsConfig := &tls.Config{
+		GetCertificate: getCertificate,
+		MinVersion:     tls.VersionTLS12, // Require TLS 1.2 or higher
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		PreferServerCipherSuites: true,
+	}
+
+	// Session tickets are left at Go's default unless explicitly configured
+	if cfg.TLS.SessionTickets != nil {
+		tlsConfig.SessionTicketsDisabled = !*cfg.TLS.SessionTickets
+		slog.Info("TLS session tickets configured", "enabled", *cfg.TLS.SessionTickets)
+	}
+
+	return tlsConfig
+}
+
+// ReloadCertificate re-reads tls.cert_file and tls.key_file; an invalid pair
+// is refused and the current certificate kept. ACME certificates renew themselves
+func (s *SMTPServer) ReloadCertificate() error {
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.Reload()
 }
 
 // Start starts the SMTP server
 func (s *SMTPServer) Start() error {
-	log.Printf("🚀 Starting SMTP MX server on %s", s.server.Addr)
-	log.Printf("✉️  Ready to receive emails for domains: %v", s.cfg.Domains)
+	slog.Info("Starting SMTP MX server", "addr", s.server.Addr, "domains", s.cfg.Domains)
+
+	ln, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return newBindError(s.server.Addr, err)
+	}
+	if s.cfg.Server.ProxyProtocol {
+		ln = newProxyListener(ln, proxyHeaderTimeout, s.cfg.Server.ProxyTrustedCIDRs)
+		slog.Info("PROXY protocol enabled: client addresses come from the load balancer header",
+			"trusted_peers", s.cfg.Server.ProxyTrustedCIDRs)
+	}
+	if s.acme != nil {
+		addr := s.cfg.TLS.ACME.ChallengeAddr
+		challengeLn, err := listenACMEChallenges(addr, s.acme)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("cannot open ACME challenge listener on %s (tls.acme.challenge_addr): %w", addr, err)
+		}
+		s.acmeListener = challengeLn
+		slog.Info("ACME TLS-ALPN challenges served", "addr", addr)
+	}
 
-	if err := s.server.ListenAndServe(); err != nil {
+	s.listening.Store(true)
+	defer s.listening.Store(false)
+	if err := s.server.Serve(ln); err != nil {
 		return fmt.Errorf("SMTP server error: %w", err)
 	}
 	return nil
 }
 
-// Close shuts down the SMTP server
+// Listening reports whether the SMTP listener is bound and accepting
+func (s *SMTPServer) Listening() bool {
+	return s.listening.Load()
+}
+
+// Reload re-reads the config file and applies it to new sessions
+// An invalid file, or one with no domains, is rejected and the running config kept
+func (s *SMTPServer) Reload(configPath string) error {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("reload refused, keeping current configuration: %w", err)
+	}
+	return s.backend.Reload(cfg)
+}
+
+// bindError reports that the MX listener could not be opened, with operator guidance
+type bindError struct {
+	Addr string
+	Hint string
+	Err  error
+}
+
+func (e *bindError) Error() string {
+	msg := fmt.Sprintf("cannot listen on %s: %v", e.Addr, e.Err)
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+func (e *bindError) Unwrap() error {
+	return e.Err
+}
+
+// newBindError classifies a listen failure and attaches a hint for the common causes
+func newBindError(addr string, err error) *bindError {
+	be := &bindError{Addr: addr, Err: err}
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		be.Hint = "port already in use; stop the other MTA (postfix, exim, sendmail) or change server.mx_port"
+	case errors.Is(err, syscall.EACCES), errors.Is(err, os.ErrPermission):
+		be.Hint = "ports below 1024 need root or CAP_NET_BIND_SERVICE " +
+			"(setcap 'cap_net_bind_service=+ep' on the binary), or use a higher server.mx_port behind a port forward"
+	}
+	return be
+}
+
+// Defaults for unset server limits
+const (
+	defaultMaxRecipients        = 50
+	defaultShutdownGraceSeconds = 30
+	defaultIOTimeoutSeconds     = 30  // read_timeout_seconds, write_timeout_seconds
+	defaultMaxSessionSeconds    = 600 // long enough for a large DATA over a slow link
+)
+
+// Shutdown stops accepting connections and waits for active sessions to
+// finish; sessions still open when ctx is done are closed
+func (s *SMTPServer) Shutdown(ctx context.Context) error {
+	slog.Info("Shutting down SMTP server, draining sessions")
+	s.listening.Store(false)
+	err := s.server.Shutdown(ctx)
+	if ctx.Err() != nil {
+		slog.Warn("Shutdown grace period elapsed, closing remaining sessions", "sessions", s.backend.closeConns())
+	}
+	if s.stop != nil {
+		s.stop()
+	}
+	if s.acmeListener != nil {
+		s.acmeListener.Close()
+	}
+
+	// Deliver batched events still waiting for their window, then any
+	// webhook deliveries and queue publishes still pending
+	if s.batcher != nil {
+		s.batcher.Close()
+	}
+	if s.webhook != nil {
+		s.webhook.Close()
+	}
+	if s.queue != nil {
+		s.queue.Close()
+	}
+	return err
+}
+
+// Close shuts down the SMTP server, dropping active sessions
 func (s *SMTPServer) Close() error {
-	log.Println("Shutting down SMTP server...")
-	return s.server.Close()
+	slog.Info("Shutting down SMTP server")
+	s.listening.Store(false)
+	if s.stop != nil {
+		s.stop()
+	}
+	if s.acmeListener != nil {
+		s.acmeListener.Close()
+	}
+	err := s.server.Close()
+
+	// Deliver batched events still waiting for their window, then any
+	// webhook deliveries and queue publishes still pending
+	if s.batcher != nil {
+		s.batcher.Close()
+	}
+	if s.webhook != nil {
+		s.webhook.Close()
+	}
+	if s.queue != nil {
+		s.queue.Close()
+	}
+	return err
 }
 
 // tlsVersionString returns a human-readable TLS version string
@@ -1,8 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/emersion/go-smtp"
 )
 
 func TestNewBackend(t *testing.T) {
@@ -24,11 +43,11 @@ func TestNewBackend(t *testing.T) {
 		t.Errorf("NewBackend() domains count = %v, want 2", len(backend.domains))
 	}
 
-	if !backend.domains["tempmail.example.com"] {
+	if _, ok := backend.domains["tempmail.example.com"]; !ok {
 		t.Error("NewBackend() missing domain tempmail.example.com")
 	}
 
-	if !backend.domains["temp.test"] {
+	if _, ok := backend.domains["temp.test"]; !ok {
 		t.Error("NewBackend() missing domain temp.test")
 	}
 }
@@ -36,17 +55,10 @@ func TestNewBackend(t *testing.T) {
 func TestBackendNewSession(t *testing.T) {
 	cfg := &Config{
 		Domains: []string{"tempmail.example.com"},
-		Server: struct {
-			APIPort        int    `yaml:"api_port"`
-			MXPort         int    `yaml:"mx_port"`
-			MaxMsgSizeMB   int    `yaml:"max_message_size_mb"`
-			Hostname       string `yaml:"hostname"`
-		}{
-			MXPort:       25,
-			MaxMsgSizeMB: 10,
-			Hostname:     "mail.test.com",
-		},
 	}
+	cfg.Server.MXPort = 25
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Server.Hostname = "mail.test.com"
 
 	backend := NewBackend(cfg, nil, nil)
 
@@ -111,34 +123,13 @@ func TestTLSVersionString(t *testing.T) {
 func TestNewSMTPServerConfig(t *testing.T) {
 	cfg := &Config{
 		Domains: []string{"tempmail.example.com"},
-		Server: struct {
-			APIPort        int    `yaml:"api_port"`
-			MXPort         int    `yaml:"mx_port"`
-			MaxMsgSizeMB   int    `yaml:"max_message_size_mb"`
-			Hostname       string `yaml:"hostname"`
-		}{
-			MXPort:       2525,
-			MaxMsgSizeMB: 10,
-			Hostname:     "mail.tempmail.test",
-		},
-		Validation: struct {
-			CheckDKIM    bool `yaml:"check_dkim"`
-			CheckSPF     bool `yaml:"check_spf"`
-			CheckDMARC   bool `yaml:"check_dmarc"`
-			StoreResults bool `yaml:"store_results"`
-		}{
-			CheckDKIM:  false,
-			CheckSPF:   false,
-			CheckDMARC: false,
-		},
-		TLS: struct {
-			Enabled  bool   `yaml:"enabled"`
-			CertFile string `yaml:"cert_file"`
-			KeyFile  string `yaml:"key_file"`
-		}{
-			Enabled: false,
-		},
 	}
+	cfg.Server.MXPort = 2525
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Server.Hostname = "mail.tempmail.test"
+	cfg.Validation.CheckDKIM = false
+	cfg.Validation.CheckSPF = false
+	cfg.Validation.CheckDMARC = false
 
 	server, err := NewSMTPServer(cfg, nil)
 
@@ -183,6 +174,89 @@ func TestNewSMTPServerConfig(t *testing.T) {
 	}
 }
 
+func TestNewSMTPServerMaxRecipients(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Server.MaxRecipients = 5
+
+	server, err := NewSMTPServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewSMTPServer() error = %v", err)
+	}
+	if server.server.MaxRecipients != 5 {
+		t.Errorf("SMTP server MaxRecipients = %v, want 5", server.server.MaxRecipients)
+	}
+
+	// With overflow: drop the session enforces the limit itself
+	cfg.Recipients.Overflow = OverflowDrop
+	server, err = NewSMTPServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewSMTPServer() error = %v", err)
+	}
+	if server.server.MaxRecipients != 0 {
+		t.Errorf("SMTP server MaxRecipients with drop = %v, want 0", server.server.MaxRecipients)
+	}
+}
+
+// TestSMTPServerSizeExtension drives MAIL FROM with a declared SIZE against
+// the configured server: limits are refused before any DATA is sent
+func TestSMTPServerSizeExtension(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 1
+	cfg.Server.SenderMaxMsgSizeMB = map[string]int{"partner.example": 5}
+
+	server, err := NewSMTPServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewSMTPServer() error = %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.server.Serve(l)
+	t.Cleanup(func() { server.server.Close() })
+
+	conn, code := dialEHLO(t, l.Addr().String())
+	if code != 250 {
+		t.Fatalf("EHLO code = %d, want 250", code)
+	}
+
+	// The advertised limit is the largest any sender may use
+	conn.PrintfLine("EHLO client.test")
+	_, msg, err := conn.ReadResponse(250)
+	if err != nil {
+		t.Fatalf("EHLO: %v", err)
+	}
+	if want := fmt.Sprintf("SIZE %d", 5*1024*1024); !strings.Contains(msg, want) {
+		t.Errorf("EHLO response %q does not advertise %q", msg, want)
+	}
+
+	tests := []struct {
+		name     string
+		from     string
+		size     int64
+		wantCode int
+	}{
+		{"over the advertised limit", "sender@partner.example", 6 * 1024 * 1024, 552},
+		{"over the sender's own limit", "sender@other.example", 2 * 1024 * 1024, 552},
+		{"within the sender's limit", "sender@partner.example", 3 * 1024 * 1024, 250},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn.PrintfLine("MAIL FROM:<%s> SIZE=%d", tt.from, tt.size)
+			code, msg, _ := conn.ReadResponse(0)
+			if code != tt.wantCode {
+				t.Fatalf("MAIL FROM code = %d (%s), want %d", code, msg, tt.wantCode)
+			}
+			if code == 250 {
+				conn.PrintfLine("RSET")
+				conn.ReadResponse(250)
+			}
+		})
+	}
+}
+
 func TestNewSMTPServerValidation(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -214,34 +288,13 @@ func TestNewSMTPServerValidation(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := &Config{
 				Domains: []string{"test.com"},
-				Server: struct {
-					APIPort        int    `yaml:"api_port"`
-					MXPort         int    `yaml:"mx_port"`
-					MaxMsgSizeMB   int    `yaml:"max_message_size_mb"`
-					Hostname       string `yaml:"hostname"`
-				}{
-					MXPort:       25,
-					MaxMsgSizeMB: 10,
-					Hostname:     "mail.test.com",
-				},
-				Validation: struct {
-					CheckDKIM    bool `yaml:"check_dkim"`
-					CheckSPF     bool `yaml:"check_spf"`
-					CheckDMARC   bool `yaml:"check_dmarc"`
-					StoreResults bool `yaml:"store_results"`
-				}{
-					CheckDKIM:  tt.checkDKIM,
-					CheckSPF:   tt.checkSPF,
-					CheckDMARC: tt.checkDMARC,
-				},
-				TLS: struct {
-					Enabled  bool   `yaml:"enabled"`
-					CertFile string `yaml:"cert_file"`
-					KeyFile  string `yaml:"key_file"`
-				}{
-					Enabled: false,
-				},
 			}
+			cfg.Server.MXPort = 25
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Server.Hostname = "mail.test.com"
+			cfg.Validation.CheckDKIM = tt.checkDKIM
+			cfg.Validation.CheckSPF = tt.checkSPF
+			cfg.Validation.CheckDMARC = tt.checkDMARC
 
 			server, err := NewSMTPServer(cfg, nil)
 			if err != nil {
@@ -257,3 +310,425 @@ func TestNewSMTPServerValidation(t *testing.T) {
 		})
 	}
 }
+
+// writeTestCert writes a self-signed certificate and key into a temp directory
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mail.tempmail.test"},
+		DNSNames:     []string{"mail.tempmail.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewSMTPServerSessionTickets(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	enabled := true
+	disabled := false
+
+	tests := []struct {
+		name         string
+		tickets      *bool
+		wantDisabled bool
+	}{
+		{
+			name:         "unset keeps Go default",
+			tickets:      nil,
+			wantDisabled: false,
+		},
+		{
+			name:         "explicitly enabled",
+			tickets:      &enabled,
+			wantDisabled: false,
+		},
+		{
+			name:         "explicitly disabled",
+			tickets:      &disabled,
+			wantDisabled: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.TLS.Enabled = true
+			cfg.TLS.CertFile = certFile
+			cfg.TLS.KeyFile = keyFile
+			cfg.TLS.SessionTickets = tt.tickets
+
+			server, err := NewSMTPServer(cfg, nil)
+			if err != nil {
+				t.Fatalf("NewSMTPServer() error = %v", err)
+			}
+
+			if server.server.TLSConfig == nil {
+				t.Fatal("NewSMTPServer() should configure TLS")
+			}
+
+			if got := server.server.TLSConfig.SessionTicketsDisabled; got != tt.wantDisabled {
+				t.Errorf("TLSConfig.SessionTicketsDisabled = %v, want %v", got, tt.wantDisabled)
+			}
+		})
+	}
+}
+
+func TestSMTPServerStartBindConflict(t *testing.T) {
+	// Hold the port so the MX listener can't bind it
+	occupied, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	defer occupied.Close()
+
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MXPort = occupied.Addr().(*net.TCPAddr).Port
+	cfg.Server.MaxMsgSizeMB = 10
+
+	server, err := NewSMTPServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewSMTPServer() error = %v", err)
+	}
+
+	err = server.Start()
+	var bindErr *bindError
+	if !errors.As(err, &bindErr) {
+		t.Fatalf("Start() error = %v, want bindError", err)
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("Start() error should wrap EADDRINUSE, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "server.mx_port") {
+		t.Errorf("Start() error = %q, want guidance mentioning server.mx_port", err)
+	}
+}
+
+func TestNewBindError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantHint string
+	}{
+		{"address in use", &net.OpError{Op: "listen", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}, "already in use"},
+		{"permission denied", &net.OpError{Op: "listen", Err: os.NewSyscallError("bind", syscall.EACCES)}, "CAP_NET_BIND_SERVICE"},
+		{"other", errors.New("no such host"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newBindError("0.0.0.0:25", tt.err)
+			if tt.wantHint == "" {
+				if got.Hint != "" {
+					t.Errorf("Hint = %q, want none", got.Hint)
+				}
+				return
+			}
+			if !strings.Contains(got.Hint, tt.wantHint) {
+				t.Errorf("Hint = %q, want it to mention %q", got.Hint, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestSMTPServerReloadEmptyDomains(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	server, err := NewSMTPServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewSMTPServer() error = %v", err)
+	}
+	defer server.Close()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"empty domain list", "domains: []\ndatabase:\n  url: postgresql://localhost/tempmail\n", true},
+		{"blank domain entry", "domains:\n  - \"  \"\ndatabase:\n  url: postgresql://localhost/tempmail\n", true},
+		{"valid reload", "domains:\n  - New.Example.com\ndatabase:\n  url: postgresql://localhost/tempmail\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(configPath, []byte(tt.config), 0644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			before := server.backend.config()
+
+			err := server.Reload(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrConfigInvalid) {
+					t.Errorf("Reload() error = %v, want ErrConfigInvalid", err)
+				}
+				if server.backend.config() != before {
+					t.Error("rejected reload replaced the running configuration")
+				}
+				return
+			}
+			if _, ok := server.backend.domains["new.example.com"]; !ok {
+				t.Errorf("domains after reload = %v, want new.example.com", server.backend.domains)
+			}
+		})
+	}
+
+	// A config built in code is checked directly as well
+	if err := server.backend.Reload(&Config{}); !errors.Is(err, ErrConfigInvalid) {
+		t.Errorf("Backend.Reload(no domains) error = %v, want ErrConfigInvalid", err)
+	}
+	if _, err := NewSMTPServer(&Config{}, nil); !errors.Is(err, ErrConfigInvalid) {
+		t.Errorf("NewSMTPServer(no domains) error = %v, want ErrConfigInvalid", err)
+	}
+}
+
+func TestBackendReloadRcptDomains(t *testing.T) {
+	cfg := &Config{Domains: []string{"old.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Server.MXPort = 25
+
+	// Only recipients in an accepted domain reach the address lookup
+	db, mock := newMockDB(t)
+	for range 2 {
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT COALESCE\\(blackhole").WillReturnRows(sqlmock.NewRows([]string{"blackhole"}).AddRow(false))
+	}
+	backend := NewBackend(cfg, db, nil)
+	addr := serveBackend(t, backend)
+
+	// mail starts a transaction on a new session and returns its RCPT step
+	mail := func(t *testing.T) func(to string) int {
+		conn, code := dialEHLO(t, addr)
+		if code != 250 {
+			t.Fatalf("EHLO = %d, want 250", code)
+		}
+		conn.PrintfLine("MAIL FROM:<sender@example.com>")
+		if _, _, err := conn.ReadResponse(250); err != nil {
+			t.Fatalf("MAIL FROM: %v", err)
+		}
+		return func(to string) int {
+			conn.PrintfLine("RCPT TO:<%s>", to)
+			code, _, _ := conn.ReadResponse(0)
+			return code
+		}
+	}
+
+	inFlight := mail(t)
+
+	reloaded := &Config{Domains: []string{"new.example.com"}}
+	reloaded.Server.MaxMsgSizeMB = 10
+	reloaded.Server.MXPort = 2525
+	reloaded.Blocklist.IPs = []string{"192.0.2.0/24"}
+	if err := backend.Reload(reloaded); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	rcpt := mail(t)
+	tests := []struct {
+		name string
+		rcpt func(string) int
+		to   string
+		want int
+	}{
+		{"new session, added domain", rcpt, "user@new.example.com", 250},
+		{"new session, removed domain", rcpt, "user@old.example.com", 451},
+		{"in-flight session keeps its domains", inFlight, "user@old.example.com", 250},
+		{"in-flight session, added domain", inFlight, "user@new.example.com", 451},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rcpt(tt.to); got != tt.want {
+				t.Errorf("RCPT TO:<%s> = %d, want %d", tt.to, got, tt.want)
+			}
+		})
+	}
+
+	// Config-derived settings follow; restart-only ones keep running values
+	if backend.ipFilter == nil {
+		t.Error("blocklist from the reloaded config was not applied")
+	}
+	if got := backend.config().Server.MXPort; got != 25 {
+		t.Errorf("server.mx_port after reload = %d, want running value 25", got)
+	}
+}
+
+// startDrainTestServer serves the real backend over a mock database that
+// knows one address and accepts one email for it
+func startDrainTestServer(t *testing.T) (*SMTPServer, string) {
+	t.Helper()
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT COALESCE\\(blackhole").WillReturnRows(sqlmock.NewRows([]string{"blackhole"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM addresses").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("addr-1"))
+	mock.ExpectQuery("INSERT INTO emails").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("email-1"))
+	mock.ExpectExec("UPDATE addresses SET first_email_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO email_recipients").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	backend := NewBackend(cfg, db, nil)
+	s := smtp.NewServer(backend)
+	s.Domain = "mx.test"
+	s.AuthDisabled = true
+	s.ReadTimeout = 5 * time.Second
+	s.WriteTimeout = 5 * time.Second
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l)
+	server := &SMTPServer{server: s, backend: backend, cfg: cfg}
+	t.Cleanup(func() { s.Close() })
+	return server, l.Addr().String()
+}
+
+func TestSMTPServerShutdownDrainsData(t *testing.T) {
+	server, addr := startDrainTestServer(t)
+	conn, _ := dialEHLO(t, addr)
+
+	for _, cmd := range []string{"MAIL FROM:<sender@example.com>", "RCPT TO:<user@tempmail.example.com>"} {
+		conn.PrintfLine("%s", cmd)
+		if _, _, err := conn.ReadResponse(250); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	conn.PrintfLine("DATA")
+	if _, _, err := conn.ReadResponse(354); err != nil {
+		t.Fatalf("DATA: %v", err)
+	}
+	conn.PrintfLine("From: sender@example.com\r\nTo: user@tempmail.example.com\r\nSubject: in flight\r\n")
+
+	// Shut down halfway through the body
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+
+	// New connections are refused while the session drains
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("listener still accepting after Shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn.PrintfLine("Body still arriving.\r\n.")
+	if _, msg, err := conn.ReadResponse(250); err != nil {
+		t.Fatalf("end of DATA: %v (%s)", err, msg)
+	}
+	conn.PrintfLine("QUIT")
+	conn.ReadResponse(221)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown() error = %v, want nil once the session ended", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() did not return after the session ended")
+	}
+}
+
+func TestSMTPServerShutdownGraceElapsed(t *testing.T) {
+	server, addr := startDrainTestServer(t)
+	conn, _ := dialEHLO(t, addr)
+
+	// An idle session that never quits is closed once the grace period ends
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	conn.PrintfLine("NOOP")
+	if _, _, err := conn.ReadResponse(250); err == nil {
+		t.Error("session still open after the grace period")
+	}
+}
+
+func TestBackendMaxSessionClosesConnection(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.Server.MaxSessionSeconds = 1
+
+	backend := NewBackend(cfg, nil, nil)
+	addr := serveBackend(t, backend)
+	conn, code := dialEHLO(t, addr)
+	if code != 250 {
+		t.Fatalf("EHLO = %d, want 250", code)
+	}
+
+	// An active client is still cut off once the session deadline passes
+	start := time.Now()
+	for time.Since(start) < 5*time.Second {
+		if err := conn.PrintfLine("NOOP"); err != nil {
+			break
+		}
+		if _, _, err := conn.ReadResponse(250); err != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Fatal("connection still open after max_session_seconds")
+	}
+
+	// Logout untracks the session once go-smtp notices the closed connection
+	deadline := time.Now().Add(2 * time.Second)
+	for trackedConns(backend) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := trackedConns(backend); n != 0 {
+		t.Errorf("tracked sessions after deadline = %d, want 0", n)
+	}
+}
+
+func trackedConns(bkd *Backend) int {
+	bkd.connsMu.Lock()
+	defer bkd.connsMu.Unlock()
+	return len(bkd.conns)
+}
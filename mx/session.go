@@ -2,177 +2,543 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/mail"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/abadojack/whatlanggo"
 	"github.com/emersion/go-smtp"
 	"github.com/jhillyerd/enmime"
 )
 
+// minLanguageSampleRunes is the shortest body language detection is attempted on
+const minLanguageSampleRunes = 40
+
 // SessionDB defines the database operations needed by Session
 type SessionDB interface {
-	AddressExists(email string) (bool, error)
-	StoreEmail(email *EmailData, attachments []AttachmentData) error
+	AddressExistsContext(ctx context.Context, email string) (bool, error)
+	IsBlackholeContext(ctx context.Context, email string) (bool, error)
+	StoreEmailContext(ctx context.Context, email *EmailData, attachments []AttachmentData) error
 }
 
 // Session represents an SMTP session
 type Session struct {
-	from       string
-	to         []string
-	remoteAddr string
-	hostname   string
-	cfg        *Config
-	db         SessionDB
-	validator  *Validator
-	domains    map[string]bool
+	from         string
+	to           []string
+	remoteAddr   string
+	hostname     string
+	cfg          *Config
+	db           SessionDB
+	validator    *Validator
+	domains      map[string]DomainConfig // accepted domains, from Config.GetDomainMap
+	notifier     Notifier
+	smtpEnvelope *Envelope
+	storage      *StorageMonitor  // nil when no global storage cap is configured
+	greylist     *Greylister      // nil when greylisting is off
+	addresses    AddressCounter   // max_addresses check for auto-created addresses, nil skips it
+	blackholed   map[string]bool  // accepted recipients whose mail is discarded
+	ratelimit    *RateLimiter     // nil when rate limiting is off
+	releaseConn  func()           // frees the session's connection slot, nil outside a server
+	reputation   *ReputationStore // nil when rate limiting is off
+	tlsPolicy    *TLSPolicy       // nil when no per-network TLS requirement is configured
+	dnsbl        *DNSBLChecker    // nil when no dnsbl.zones are configured
+	tls          bool             // connection is using TLS
+	spool        *Spool           // nil when mail is stored directly
+	sampled      bool             // routine logs are kept for this connection
+	log          *slog.Logger     // set on first use, see logger
+	geo          GeoInfo          // client country/ASN, empty when GeoIP is off
+	ctx          context.Context  // cancelled by Logout or when max_session_seconds closes the connection
+	id           string           // short random ID in every log line and stored email
+	cancel       context.CancelFunc
 }
 
 // NewSession creates a new SMTP session
-func NewSession(remoteAddr, hostname string, cfg *Config, db SessionDB, validator *Validator, domains map[string]bool) *Session {
+func NewSession(remoteAddr, hostname string, cfg *Config, db SessionDB, validator *Validator, domains map[string]DomainConfig) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Session{
+		ctx:        ctx,
+		cancel:     cancel,
+		id:         newSessionID(),
 		remoteAddr: remoteAddr,
 		hostname:   hostname,
 		cfg:        cfg,
 		db:         db,
 		validator:  validator,
 		domains:    domains,
+		notifier:   logNotifier{},
+		sampled:    cfg.sampleConnection(),
 	}
 }
 
+// newSessionID returns a short random ID that tells concurrent sessions apart
+func newSessionID() string {
+	var b [6]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// queryContext derives a context for one database operation, bounded by
+// database.query_timeout_seconds and cancelled along with s.ctx
+func (s *Session) queryContext() (context.Context, context.CancelFunc) {
+	parent := s.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	if s.cfg == nil || s.cfg.QueryTimeout() <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, s.cfg.QueryTimeout())
+}
+
 // Mail is called when the client sends MAIL FROM
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
-	log.Printf("[%s] MAIL FROM: <%s>", s.remoteAddr, from)
+	s.info("MAIL FROM", "from", from)
+
+	mode := MailFromSyntaxBasic
+	if s.cfg != nil && s.cfg.Validation.MailFromSyntax != "" {
+		mode = s.cfg.Validation.MailFromSyntax
+	}
+	if err := validateMailFrom(from, mode, opts != nil && opts.UTF8); err != nil {
+		s.logger().Info("REJECTED: Malformed MAIL FROM", "from", from, "error", err)
+		return customResponse(s.cfg, ResponseMalformedSender, &smtp.SMTPError{
+			Code:         501,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 7},
+			Message:      "Malformed sender address",
+		})
+	}
+
+	from = normalizeMailFrom(from)
+
+	if err := s.checkSenderDomain(from); err != nil {
+		return customResponse(s.cfg, ResponseSenderBlocked, err)
+	}
+
+	if err := s.checkTLSRequired(); err != nil {
+		return customResponse(s.cfg, ResponseTLSRequired, err)
+	}
+
+	if err := s.checkDNSBL(); err != nil {
+		return customResponse(s.cfg, ResponseDNSBLListed, err)
+	}
+
+	if err := s.checkRateLimit(); err != nil {
+		return customResponse(s.cfg, ResponseRateLimited, err)
+	}
+
+	// Tempfail while the global storage cap is exceeded
+	if s.storage != nil && s.storage.OverCap() {
+		s.logger().Warn("DEFERRED: Storage over cap", "used_bytes", s.storage.UsedBytes())
+		return customResponse(s.cfg, ResponseStorageFull, &smtp.SMTPError{
+			Code:         452,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 1},
+			Message:      "Insufficient system storage",
+		})
+	}
+
+	// Refuse early when the declared SIZE exceeds what this sender may send
+	if opts != nil && s.cfg != nil && opts.Size > s.cfg.MaxMessageSizeFor(addressDomain(from)) {
+		s.logger().Info("REJECTED: Declared SIZE exceeds limit", "from", from, "size", opts.Size)
+		return customResponse(s.cfg, ResponseMessageTooLarge, errSMTPMessageTooLarge)
+	}
+
 	s.from = from
 	s.to = nil
+	s.blackholed = nil
+	s.smtpEnvelope = newEnvelope(from, s.remoteAddr, s.hostname, opts)
 	return nil
 }
 
 // Rcpt is called when the client sends RCPT TO
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
-	log.Printf("[%s] RCPT TO: <%s>", s.remoteAddr, to)
+	// Fail fast on oversized input before it reaches the address parser; the
+	// forward-path limit counts the angle brackets, as for MAIL FROM
+	if len(to)+2 > maxPathLen {
+		s.logger().Info("REJECTED: RCPT TO address too long", "length", len(to))
+		return customResponse(s.cfg, ResponseInvalidAddress, &smtp.SMTPError{
+			Code:         501,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 3},
+			Message:      "Recipient address too long",
+		})
+	}
+
+	s.info("RCPT TO", "to", to)
 
 	// Validate recipient address format
 	addr, err := mail.ParseAddress(to)
 	if err != nil {
-		log.Printf("[%s] REJECTED: Invalid address format: %v", s.remoteAddr, err)
-		return fmt.Errorf("invalid recipient address")
+		s.logger().Info("REJECTED: Invalid address format", "to", to, "error", err)
+		return customResponse(s.cfg, ResponseInvalidAddress, ErrInvalidAddress)
 	}
 
 	// Extract domain
 	parts := strings.Split(addr.Address, "@")
 	if len(parts) != 2 {
-		log.Printf("[%s] REJECTED: Invalid email format: %s", s.remoteAddr, addr.Address)
-		return fmt.Errorf("invalid email format")
+		s.logger().Info("REJECTED: Invalid email format", "to", addr.Address)
+		return customResponse(s.cfg, ResponseInvalidAddress, fmt.Errorf("%w: invalid email format", ErrInvalidAddress))
 	}
 	domain := strings.ToLower(parts[1])
 
 	// Check if domain is in our allowed list
-	if !s.domains[domain] {
-		log.Printf("[%s] REJECTED: Domain not accepted: %s (allowed: %v)", s.remoteAddr, domain, s.cfg.Domains)
-		return fmt.Errorf("relay access denied for domain %s", domain)
+	domainCfg, accepted := s.domains[domain]
+	if !accepted {
+		s.logger().Info("REJECTED: Domain not accepted", "domain", domain, "allowed", s.cfg.Domains)
+		return customResponse(s.cfg, ResponseDomainNotAccepted, fmt.Errorf("%w for domain %s", ErrDomainNotAccepted, domain))
+	}
+
+	// Retired domains keep their data but no longer accept new mail
+	if !domainCfg.IsAccepting() {
+		s.logger().Info("REJECTED: Domain no longer accepting mail", "domain", domain)
+		return customResponse(s.cfg, ResponseDomainNotAccepting, &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 2},
+			Message:      fmt.Sprintf("domain %s is not accepting new mail", domain),
+		})
 	}
 
 	// Normalize email address to lowercase for consistent storage
 	normalizedEmail := strings.ToLower(addr.Address)
 
+	// A repeated RCPT is already accepted; acknowledge without a second copy
+	if s.hasRecipient(normalizedEmail) {
+		s.info("DUPLICATE: Recipient already accepted in this transaction", "to", normalizedEmail)
+		return nil
+	}
+
+	mailbox := s.mailboxFor(normalizedEmail)
+
 	// Check if address exists in database
-	exists, err := s.db.AddressExists(normalizedEmail)
+	ctx, cancel := s.queryContext()
+	exists, err := s.db.AddressExistsContext(ctx, mailbox)
+	cancel()
+	if err != nil && s.spool != nil {
+		// The spool worker drops entries whose address turns out not to exist
+		s.logger().Warn("Address check failed, spooling unverified", "to", mailbox, "error", err)
+		exists, err = true, nil
+	}
 	if err != nil {
-		log.Printf("[%s] ERROR: Failed to check address existence for %s: %v", s.remoteAddr, normalizedEmail, err)
+		s.logger().Error("Failed to check address existence", "to", mailbox, "error", err)
 		return fmt.Errorf("temporary server error")
 	}
 
+	// Unknown addresses are created when their first email is stored
+	if !exists && s.autoCreates(mailbox) {
+		if err := s.checkAutoCreate(mailbox); err != nil {
+			return err
+		}
+		exists = true
+	}
+
+	// postmaster must always be reachable; it is created when first stored
+	if !exists && isPostmaster(mailbox) {
+		s.info("POSTMASTER: Accepting for address not yet created", "to", mailbox)
+		exists = true
+	}
+
 	if !exists {
-		log.Printf("[%s] REJECTED: Address does not exist: %s", s.remoteAddr, normalizedEmail)
-		return fmt.Errorf("mailbox unavailable")
+		s.logger().Info("REJECTED: Address does not exist", "to", mailbox, "mode", domainCfg.Mode)
+		s.adjustReputation(reputationUnknownRcpt)
+		if domainCfg.Mode == DomainModeExplicit {
+			return customResponse(s.cfg, ResponseUnknownRecipient, errSMTPUnknownRecipient)
+		}
+		return customResponse(s.cfg, ResponseUnknownRecipient, ErrAddressNotFound)
+	}
+
+	if s.greylist != nil {
+		triple := GreylistTriple{IP: s.getClientIP(), Sender: s.from, Recipient: normalizedEmail}
+		if err := s.greylist.Check(triple); err != nil {
+			s.logger().Info("GREYLISTED", "from", s.from, "to", normalizedEmail)
+			return err
+		}
+	}
+
+	// Blackhole addresses accept mail but never store it
+	ctx, cancel = s.queryContext()
+	blackhole, err := s.db.IsBlackholeContext(ctx, mailbox)
+	cancel()
+	if err != nil && s.spool != nil {
+		s.logger().Warn("Blackhole check failed, spooling", "to", mailbox, "error", err)
+		blackhole, err = false, nil
+	}
+	if err != nil {
+		s.logger().Error("Failed to check blackhole flag", "to", mailbox, "error", err)
+		return fmt.Errorf("temporary server error")
+	}
+	if blackhole {
+		if s.blackholed == nil {
+			s.blackholed = make(map[string]bool)
+		}
+		s.blackholed[normalizedEmail] = true
+	}
+
+	// Enforce max_recipients; the drop action acknowledges without delivering
+	ok, err := newRecipientPolicy(s.cfg).allowRcpt(len(s.to))
+	if err != nil {
+		s.logger().Info("REJECTED: max_recipients reached", "to", normalizedEmail)
+		return customResponse(s.cfg, ResponseTooManyRecipients, err)
+	}
+	if !ok {
+		s.logger().Info("POLICY: max_recipients reached, dropping recipient", "to", normalizedEmail)
+		return nil
 	}
 
 	// Accept the recipient
 	s.to = append(s.to, normalizedEmail)
-	log.Printf("[%s] ACCEPTED: <%s> -> normalized as <%s> (total recipients: %d)", s.remoteAddr, addr.Address, normalizedEmail, len(s.to))
+	if s.smtpEnvelope != nil {
+		s.smtpEnvelope.addRecipient(normalizedEmail, opts)
+	}
+	s.info("ACCEPTED", "to", addr.Address, "normalized", normalizedEmail, "recipients", len(s.to))
+	return nil
+}
+
+// autoCreates reports whether mailbox is created on first mail, decided by
+// its own domain, which differs from the RCPT domain for a catch-all or
+// bounce address elsewhere
+func (s *Session) autoCreates(mailbox string) bool {
+	return s.domains[addressDomain(mailbox)].CreatesAddresses(s.cfg.Tempmail.AutoCreateAddresses)
+}
+
+// checkAutoCreate refuses a recipient that would be auto-created once its
+// domain has reached max_addresses
+func (s *Session) checkAutoCreate(mailbox string) error {
+	if s.addresses == nil {
+		return nil
+	}
+	err := checkAddressCap(s.cfg, s.addresses, addressDomain(mailbox))
+	if errors.Is(err, ErrDomainAddressLimit) {
+		s.logger().Info("REJECTED: Address does not exist and domain is at max_addresses", "to", mailbox)
+		return customResponse(s.cfg, ResponseUnknownRecipient, ErrAddressNotFound)
+	}
+	if err != nil {
+		s.logger().Error("Failed to count addresses", "to", mailbox, "error", err)
+		return fmt.Errorf("temporary server error")
+	}
 	return nil
 }
 
 // Data is called when the client sends DATA
 func (s *Session) Data(r io.Reader) error {
-	log.Printf("[%s] DATA: %s -> %v", s.remoteAddr, s.from, s.to)
+	s.info("DATA", "from", s.from, "to", s.to)
+
+	// go-smtp sequences commands, but never store a message nobody accepted
+	if len(s.to) == 0 && len(s.blackholed) == 0 {
+		s.logger().Info("REJECTED: DATA without accepted recipients")
+		return customResponse(s.cfg, ResponseNoValidRecipients, &smtp.SMTPError{
+			Code:         503,
+			EnhancedCode: smtp.EnhancedCode{5, 5, 1},
+			Message:      "Bad sequence of commands: no valid recipients",
+		})
+	}
 
-	// Read the message
+	// Read the message; a sender override may raise the limit, confirmed after validation
+	// The whole message stays in memory: DKIM and the raw_message column need its bytes
+	maxSize := s.cfg.MaxMessageSizeFor(addressDomain(s.from))
 	buf := new(bytes.Buffer)
-	size, err := buf.ReadFrom(io.LimitReader(r, s.cfg.GetMaxMessageSize()))
+	size, err := buf.ReadFrom(io.LimitReader(r, maxSize))
 	if err != nil {
-		log.Printf("[%s] ERROR: Failed to read message: %v", s.remoteAddr, err)
+		s.logger().Error("Failed to read message", "error", err)
 		return fmt.Errorf("error reading message")
 	}
 
-	if size >= s.cfg.GetMaxMessageSize() {
-		log.Printf("[%s] REJECTED: Message too large (%d bytes, max %d)", s.remoteAddr, size, s.cfg.GetMaxMessageSize())
-		return fmt.Errorf("message too large (max %d MB)", s.cfg.Server.MaxMsgSizeMB)
+	if size >= maxSize {
+		s.logger().Info("REJECTED: Message too large", "size", size, "max_size", maxSize)
+		return customResponse(s.cfg, ResponseMessageTooLarge, fmt.Errorf("%w (max %d MB)", ErrMessageTooLarge, maxSize/(1024*1024)))
 	}
 
 	rawMessage := buf.Bytes()
-	log.Printf("[%s] Received message (%d bytes)", s.remoteAddr, size)
+	s.info("Received message", "size", size)
 
 	// Parse the email with MIME support
 	envelope, err := enmime.ReadEnvelope(bytes.NewReader(rawMessage))
 	if err != nil {
-		log.Printf("[%s] ERROR: Failed to parse email: %v", s.remoteAddr, err)
+		s.logger().Error("Failed to parse email", "error", err)
 		return fmt.Errorf("error processing message")
 	}
 
 	// Extract email data
 	emailData := s.extractEmailData(envelope, rawMessage, size)
 
+	// A raised size limit cannot be confirmed without validation
+	if s.validator == nil && size > s.cfg.GetMaxMessageSize() {
+		s.logger().Info("REJECTED: Size override needs SPF or DMARC pass", "from", s.from, "size", size)
+		return customResponse(s.cfg, ResponseMessageTooLarge, errSMTPMessageTooLarge)
+	}
+
 	// Perform validation if enabled
 	if s.validator != nil {
 		clientIP := s.getClientIP()
-		validationResult := s.validator.ValidateEmail(rawMessage, s.from, clientIP, s.hostname)
+		validationResult := s.validator.withLogger(s.logger()).ValidateEmail(rawMessage, s.from, clientIP, s.hostname)
 
 		emailData.DKIMValid = validationResult.DKIMValid
+		emailData.DKIMAlgorithm = validationResult.DKIMAlgorithm
+		emailData.DKIMDetails = validationResult.DKIMDetails
 		emailData.SPFResult = validationResult.SPFResult
+		emailData.SPFIdentity = validationResult.SPFIdentity
 		emailData.DMARCResult = validationResult.DMARCResult
+		emailData.ARCResult = validationResult.ARCResult
+
+		s.info("Validation",
+			"message_id", emailData.MessageID,
+			"dkim_valid", formatBoolPtr(validationResult.DKIMValid),
+			"spf_result", validationResult.SPFResult,
+			"dmarc_result", validationResult.DMARCResult,
+			"dmarc_policy", validationResult.DMARCPolicy.EffectivePolicy(validationResult.DMARCDomain))
+
+		// A raised size limit only holds if the sender domain authenticated
+		if size > s.cfg.GetMaxMessageSize() && !senderAuthenticated(validationResult, s.from) {
+			s.logger().Info("REJECTED: Size override needs SPF or DMARC pass", "from", s.from, "size", size)
+			return customResponse(s.cfg, ResponseMessageTooLarge, errSMTPMessageTooLarge)
+		}
 
-		log.Printf("[%s] Validation - DKIM: %v, SPF: %s, DMARC: %s",
-			s.remoteAddr, formatBoolPtr(validationResult.DKIMValid), validationResult.SPFResult, validationResult.DMARCResult)
+		unauthenticated, err := s.checkUnauthenticated(validationResult)
+		if err != nil {
+			return customResponse(s.cfg, ResponseUnauthenticated, err)
+		}
+		emailData.Unauthenticated = unauthenticated
+
+		dkimMisaligned, err := s.checkDKIMAlignment(validationResult)
+		if err != nil {
+			return customResponse(s.cfg, ResponseDKIMMisaligned, err)
+		}
+		emailData.DKIMMisaligned = dkimMisaligned
+
+		quarantined, err := s.checkDMARCPolicy(validationResult)
+		if err != nil {
+			return customResponse(s.cfg, ResponseDMARCReject, err)
+		}
+		emailData.Quarantined = quarantined
+
+		s.addAuthResults(emailData, validationResult)
 	}
 
 	// Extract attachments
-	attachments := s.extractAttachments(envelope)
+	attachments, err := s.extractAttachments(envelope)
+	if err != nil {
+		return customResponse(s.cfg, ResponseAttachmentsTooLarge, err)
+	}
 	emailData.HasAttachments = len(attachments) > 0
 
-	log.Printf("[%s] Parsed - Subject: '%s', Attachments: %d", s.remoteAddr, emailData.Subject, len(attachments))
+	if err := s.applyAttachmentPolicy(attachments); err != nil {
+		return customResponse(s.cfg, ResponseSuspiciousAttachment, err)
+	}
 
-	// Store email for each recipient
+	emailData.ImageSpamCandidate = s.detectImageSpam(envelope, attachments)
+	emailData.BCCOnly = s.detectBCCOnly(envelope)
+
+	emailData.RecipientMismatch, err = s.checkHeaderRecipient(envelope)
+	if err != nil {
+		return customResponse(s.cfg, ResponseRecipientMismatch, err)
+	}
+
+	// Content scoring is stored separately from the authentication results above
+	emailData.Spam = s.scoreMessage(emailData, attachments)
+
+	s.info("Parsed", "message_id", emailData.MessageID, "subject", emailData.Subject, "attachments", len(attachments))
+
+	// Blackhole recipients are acknowledged but never stored
+	var recipients []string
 	for _, recipient := range s.to {
-		emailData.ToAddr = recipient
+		if s.blackholed[recipient] {
+			s.logger().Info("DISCARDED: Blackhole address", "to", recipient, "message_id", emailData.MessageID)
+			continue
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	recipients, err = newRecipientPolicy(s.cfg).applyStorage(s.logger(), recipients, size)
+	if err != nil {
+		return customResponse(s.cfg, ResponseFanOutExceeded, err)
+	}
+
+	// Store email for each recipient
+	for _, recipient := range recipients {
+		emailData.ToAddr = s.mailboxFor(recipient)
+		emailData.DeliveredTo = recipient
+		emailData.CreateAddress = isPostmaster(emailData.ToAddr) || s.autoCreates(emailData.ToAddr)
+
+		// Keep the SMTP envelope alongside the DATA message, limited to this
+		// recipient so other copies' addresses are not disclosed
+		if s.smtpEnvelope != nil {
+			envelopeJSON, err := s.smtpEnvelope.forRecipient(recipient).marshal(emailData.ReceivedAt)
+			if err != nil {
+				s.logger().Error("Failed to encode envelope", "error", err)
+				return fmt.Errorf("error processing message")
+			}
+			emailData.Envelope = envelopeJSON
+		}
+
+		// The spool commits to the database and emits events in the background
+		if s.spool != nil {
+			if err := s.spool.Enqueue(emailData, attachments); err != nil {
+				s.logger().Error("Failed to spool email", "to", recipient, "message_id", emailData.MessageID, "error", err)
+				return fmt.Errorf("error storing message")
+			}
+			s.info("Spooled email", "to", recipient, "message_id", emailData.MessageID)
+			continue
+		}
 
-		if err := s.db.StoreEmail(emailData, attachments); err != nil {
-			log.Printf("[%s] ERROR: Failed to store email for %s: %v", s.remoteAddr, recipient, err)
+		ctx, cancel := s.queryContext()
+		err := s.db.StoreEmailContext(ctx, emailData, attachments)
+		cancel()
+		if errors.Is(err, ErrStorageUnavailable) {
+			s.logger().Warn("DEFERRED: Failed to store email", "to", recipient, "message_id", emailData.MessageID, "error", err)
+			return customResponse(s.cfg, ResponseStorageUnavailable, errSMTPStorageUnavailable)
+		}
+		if err != nil {
+			s.logger().Error("Failed to store email", "to", recipient, "message_id", emailData.MessageID, "error", err)
 			return fmt.Errorf("error storing message")
 		}
+		if emailData.Duplicate {
+			s.info("Duplicate not stored", "to", recipient, "message_id", emailData.MessageID)
+			continue
+		}
+
+		if emailData.ToAddr != recipient {
+			s.info("Stored email", "to", recipient, "mailbox", emailData.ToAddr, "message_id", emailData.MessageID)
+		} else {
+			s.info("Stored email", "to", recipient, "message_id", emailData.MessageID)
+		}
 
-		log.Printf("[%s] ✓ Stored email for %s", s.remoteAddr, recipient)
+		s.notify(EventEmailReceived, emailData)
+		if emailData.FirstEmail {
+			s.notify(EventAddressFirstEmail, emailData)
+		}
 	}
 
-	log.Printf("[%s] ✓ SUCCESS: Email delivered to %d recipients", s.remoteAddr, len(s.to))
+	s.adjustReputation(reputationDelivered)
+	s.info("SUCCESS: Email delivered", "message_id", emailData.MessageID, "recipients", len(s.to))
 	return nil
 }
 
 // Reset is called when the client sends RSET
 func (s *Session) Reset() {
-	log.Printf("[%s] RSET: Transaction reset", s.remoteAddr)
+	s.info("RSET: Transaction reset")
 	s.from = ""
 	s.to = nil
+	s.blackholed = nil
+	s.smtpEnvelope = nil
 }
 
 // Logout is called when the client disconnects
 func (s *Session) Logout() error {
-	log.Printf("[%s] QUIT: Connection closed", s.remoteAddr)
+	s.info("QUIT: Connection closed")
+	// go-smtp also calls Logout when the connection drops, but only once the
+	// command in progress has returned, so this does not abort a running Data
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.releaseConn != nil {
+		s.releaseConn()
+	}
 	return nil
 }
 
@@ -182,54 +548,233 @@ func (s *Session) AuthPlain(username, password string) error {
 	return fmt.Errorf("authentication not supported on MX server")
 }
 
+// notify emits an event for a stored email
+func (s *Session) notify(eventType string, email *EmailData) {
+	if s.notifier == nil {
+		return
+	}
+	s.notifier.Notify(newEvent(eventType, email))
+}
+
 // extractEmailData extracts structured data from email envelope
 func (s *Session) extractEmailData(envelope *enmime.Envelope, rawMessage []byte, size int64) *EmailData {
 	// Extract headers
 	messageID := envelope.GetHeader("Message-ID")
 	subject := envelope.GetHeader("Subject")
+
+	// Keep the original only when normalizing actually changed it
+	var rawSubject string
+	if s.cfg != nil && s.cfg.Tempmail.NormalizeSubjects {
+		if normalized := normalizeSubject(subject); normalized != subject {
+			rawSubject, subject = subject, normalized
+		}
+	}
 	dateStr := envelope.GetHeader("Date")
+	receivedAt := time.Now()
 
-	// Parse date
-	var dateSent time.Time
+	// A missing or unparseable Date is malformed (RFC 5322 3.6 requires one)
+	dateMissing := true
 	if dateStr != "" {
-		dateSent, _ = mail.ParseDate(dateStr)
+		_, err := mail.ParseDate(dateStr)
+		dateMissing = err != nil
 	}
-	if dateSent.IsZero() {
-		dateSent = time.Now()
+
+	var redactMode string
+	if s.cfg != nil {
+		redactMode = s.cfg.Privacy.RedactReceivedIPs
 	}
 
 	// Collect all headers as raw text
 	rawHeaders := new(bytes.Buffer)
 	for key, values := range envelope.Root.Header {
+		if key == "Return-Path" && s.smtpEnvelope != nil {
+			continue // replaced below
+		}
 		for _, val := range values {
+			if key == "Received" {
+				val = redactIPs(val, redactMode)
+			}
 			fmt.Fprintf(rawHeaders, "%s: %s\n", key, val)
 		}
 	}
+	if s.smtpEnvelope != nil {
+		fmt.Fprintf(rawHeaders, "Return-Path: <%s>\n", s.from)
+	}
+
+	// Hash and keep the message exactly as received, before we add or redact any headers
+	rawSum := sha256.Sum256(rawMessage)
+	originalMessage := rawMessage
+
+	// Hide sender IPs from readers; the envelope record keeps the client address
+	rawMessage = redactReceivedIPs(rawMessage, redactMode)
+
+	// Give downstream tools a Date to work with; an unparseable one is left alone
+	// rather than adding a second Date header
+	if dateStr == "" && s.cfg != nil && s.cfg.Tempmail.AddMissingDate {
+		rawMessage = prependHeader(rawMessage, "Date", receivedAt.Format(time.RFC1123Z))
+	}
+
+	// As the delivering MTA we set Return-Path from MAIL FROM (RFC 5321 4.4);
+	// one sent by the client is replaced, never trusted
+	var returnPath string
+	if s.smtpEnvelope != nil {
+		returnPath = s.from
+		rawMessage = prependReturnPath(rawMessage, s.from)
+	}
 
 	// Get body content
 	bodyPlain := envelope.Text
 	bodyHTML := envelope.HTML
 
+	// Detect body language before any placeholder text is substituted
+	var bodyLanguage string
+	if s.cfg != nil && s.cfg.Tempmail.DetectLanguage {
+		bodyLanguage = detectLanguage(bodyPlain)
+	}
+
+	// Neutralize open-tracking pixels before the HTML is stored
+	if s.cfg != nil && s.cfg.Privacy.StripTrackers && bodyHTML != "" {
+		var removed int
+		bodyHTML, removed = stripTrackers(bodyHTML, s.cfg.trackerDomains())
+		if removed > 0 {
+			s.info("PRIVACY: Removed tracking images", "count", removed)
+		}
+	}
+
+	// Route remaining remote images through the proxy so viewing mail doesn't leak the reader's IP
+	if s.cfg != nil && s.cfg.Privacy.ImageProxyBase != "" && bodyHTML != "" {
+		bodyHTML, _ = proxyRemoteImages(bodyHTML, s.cfg.Privacy.ImageProxyBase)
+	}
+
 	// If no plain text but have HTML, note it
 	if bodyPlain == "" && bodyHTML != "" {
 		bodyPlain = "[HTML email - plain text not provided]"
 	}
 
 	return &EmailData{
-		MessageID:  messageID,
-		Subject:    subject,
-		FromAddr:   s.from,
-		RawHeaders: rawHeaders.String(),
-		BodyPlain:  bodyPlain,
-		BodyHTML:   bodyHTML,
-		RawMessage: rawMessage,
-		SizeBytes:  size,
-		ReceivedAt: time.Now(),
+		MessageID:       messageID,
+		Subject:         subject,
+		RawSubject:      rawSubject,
+		FromAddr:        s.from,
+		ReturnPath:      returnPath,
+		RawHeaders:      rawHeaders.String(),
+		BodyPlain:       bodyPlain,
+		BodyHTML:        bodyHTML,
+		BodyLanguage:    bodyLanguage,
+		RawMessage:      rawMessage,
+		OriginalMessage: originalMessage,
+		RawSHA256:       hex.EncodeToString(rawSum[:]),
+		SessionID:       s.id,
+		SizeBytes:       size,
+		ClientCountry:   s.geo.Country,
+		ClientASN:       s.geo.ASN,
+		DateMissing:     dateMissing,
+		IsBounce:        s.isBounce(),
+		ReceivedAt:      receivedAt,
 	}
 }
 
+// isBounce reports whether the transaction has the null sender (MAIL FROM:<>)
+func (s *Session) isBounce() bool {
+	return s.smtpEnvelope != nil && s.from == ""
+}
+
+// mailboxFor returns the address mail for recipient is stored under: the
+// bounce address for null-sender mail when tempmail.bounce_address is set,
+// postmaster itself, or else the domain's catch-all address if it has one
+func (s *Session) mailboxFor(recipient string) string {
+	if s.isBounce() && s.cfg.Tempmail.BounceAddress != "" {
+		return s.cfg.Tempmail.BounceAddress
+	}
+	if isPostmaster(recipient) {
+		return recipient
+	}
+	if catchAll := s.domains[addressDomain(recipient)].CatchAllAddress; catchAll != "" {
+		return catchAll
+	}
+	return recipient
+}
+
+// normalizeSubject collapses every run of whitespace to one space and trims the ends
+func normalizeSubject(subject string) string {
+	return strings.Join(strings.Fields(subject), " ")
+}
+
+// prependReturnPath sets the Return-Path header to the envelope sender,
+// replacing any the client sent
+func prependReturnPath(rawMessage []byte, from string) []byte {
+	rawMessage = removeHeaderFields(rawMessage, func(name, value string) bool {
+		return strings.EqualFold(name, "Return-Path")
+	})
+	return prependHeader(rawMessage, "Return-Path", "<"+from+">")
+}
+
+// removeHeaderFields drops the header fields (with their folded lines) for
+// which drop returns true; the body is left untouched
+func removeHeaderFields(rawMessage []byte, drop func(name, value string) bool) []byte {
+	var out bytes.Buffer
+	out.Grow(len(rawMessage))
+	var field []byte
+	flush := func() {
+		name, value, _ := strings.Cut(string(field), ":")
+		if len(field) > 0 && !drop(strings.TrimSpace(name), value) {
+			out.Write(field)
+		}
+		field = field[:0]
+	}
+
+	rest := rawMessage
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break // the blank line ends the header
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			flush()
+		}
+		field = append(field, line...)
+		rest = rest[end:]
+	}
+	flush()
+	out.Write(rest)
+	return out.Bytes()
+}
+
+// prependHeader adds a header field at the top of the message
+func prependHeader(rawMessage []byte, name, value string) []byte {
+	header := fmt.Sprintf("%s: %s\r\n", name, value)
+	return append([]byte(header), rawMessage...)
+}
+
+// addAuthResults records our verdict in an Authentication-Results header for mail
+// clients, replacing any header that claims to come from us
+func (s *Session) addAuthResults(email *EmailData, result *ValidationResult) {
+	authservID := s.cfg.Server.Hostname
+	if authservID == "" {
+		return
+	}
+	value := formatAuthResults(authservID, result, s.from, s.hostname, s.getClientIP())
+	email.RawMessage = prependHeader(removeAuthResults(email.RawMessage, authservID), "Authentication-Results", value)
+
+	var rawHeaders strings.Builder
+	fmt.Fprintf(&rawHeaders, "Authentication-Results: %s\n", strings.ReplaceAll(value, "\r\n\t", " "))
+	for _, line := range strings.SplitAfter(email.RawHeaders, "\n") {
+		name, value, _ := strings.Cut(line, ":")
+		if name == "Authentication-Results" && strings.EqualFold(authservIDOf(value), authservID) {
+			continue
+		}
+		rawHeaders.WriteString(line)
+	}
+	email.RawHeaders = rawHeaders.String()
+}
+
 // extractAttachments extracts attachment data from email envelope
-func (s *Session) extractAttachments(envelope *enmime.Envelope) []AttachmentData {
+// The decoded total is checked against tempmail.max_total_attachment_size_mb
+func (s *Session) extractAttachments(envelope *enmime.Envelope) ([]AttachmentData, error) {
 	var attachments []AttachmentData
 
 	// Process regular attachments
@@ -252,7 +797,35 @@ func (s *Session) extractAttachments(envelope *enmime.Envelope) []AttachmentData
 		})
 	}
 
-	return attachments
+	if err := s.checkAttachmentTotal(attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// detectLanguage returns the ISO 639-1 code of the body's primary language
+// Returns empty when the text is too short or detection isn't reliable
+func detectLanguage(text string) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) < minLanguageSampleRunes {
+		return ""
+	}
+
+	info := whatlanggo.Detect(text)
+	if !info.IsReliable() {
+		return ""
+	}
+	return whatlanggo.LangToStringShort(info.Lang)
+}
+
+// hasRecipient reports whether a normalized recipient was already accepted
+func (s *Session) hasRecipient(recipient string) bool {
+	for _, accepted := range s.to {
+		if accepted == recipient {
+			return true
+		}
+	}
+	return false
 }
 
 // getClientIP extracts the client IP from remote address
@@ -264,6 +837,40 @@ func (s *Session) getClientIP() string {
 	return host
 }
 
+// errSMTPUnknownRecipient refuses an address that does not exist in a
+// domain with mode explicit; other domains tempfail with ErrAddressNotFound
+var errSMTPUnknownRecipient = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+	Message:      "No such user here",
+}
+
+// errSMTPMessageTooLarge rejects messages over the sender's size limit with a 552
+var errSMTPMessageTooLarge = &smtp.SMTPError{
+	Code:         552,
+	EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+	Message:      "Message size exceeds fixed maximum message size",
+}
+
+// addressDomain returns the lowercased domain of an address, empty for the null sender <>
+func addressDomain(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(addr[at+1:])
+}
+
+// senderAuthenticated reports whether the MAIL FROM domain passed SPF, or the
+// message passed DMARC for a From: domain aligned with the MAIL FROM domain
+// A DMARC pass for an unrelated From: says nothing about the envelope sender
+func senderAuthenticated(result *ValidationResult, from string) bool {
+	if result.SPFResult == "pass" && result.SPFIdentity == SPFIdentityMailFrom {
+		return true
+	}
+	return result.DMARCResult == "pass" && dmarcAligned(result.DMARCDomain, addressDomain(from), DMARCAlignmentRelaxed)
+}
+
 // formatBoolPtr formats a nullable bool pointer for logging
 func formatBoolPtr(b *bool) string {
 	if b == nil {
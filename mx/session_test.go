@@ -2,25 +2,76 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/emersion/go-smtp"
 	"github.com/jhillyerd/enmime"
 )
 
 // mockSessionDB implements SessionDB interface for testing
 type mockSessionDB struct {
-	addresses map[string]bool
+	addresses   map[string]bool
+	blackholes  map[string]bool
+	stored      []EmailData
+	attachments [][]AttachmentData
 }
 
-func (m *mockSessionDB) AddressExists(email string) (bool, error) {
+func (m *mockSessionDB) AddressExistsContext(ctx context.Context, email string) (bool, error) {
 	return m.addresses[strings.ToLower(email)], nil
 }
 
-func (m *mockSessionDB) StoreEmail(email *EmailData, attachments []AttachmentData) error {
-	return nil // Not used in these tests
+func (m *mockSessionDB) IsBlackholeContext(ctx context.Context, email string) (bool, error) {
+	return m.blackholes[strings.ToLower(email)], nil
 }
 
+func (m *mockSessionDB) StoreEmailContext(ctx context.Context, email *EmailData, attachments []AttachmentData) error {
+	email.FirstEmail = true
+	for _, prev := range m.stored {
+		if prev.ToAddr == email.ToAddr {
+			email.FirstEmail = false
+			break
+		}
+	}
+	m.stored = append(m.stored, *email)
+	m.attachments = append(m.attachments, attachments)
+	return nil
+}
+
+// recordingNotifier captures emitted events for assertions
+type recordingNotifier struct {
+	events []*Event
+}
+
+func (n *recordingNotifier) Notify(event *Event) {
+	n.events = append(n.events, event)
+}
+
+// countEvents returns the number of recorded events of the given type
+func (n *recordingNotifier) countEvents(eventType string) int {
+	count := 0
+	for _, e := range n.events {
+		if e.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+const testMessage = `From: sender@example.com
+To: user@tempmail.example.com
+Subject: Hello
+Message-ID: <hello@example.com>
+
+Hello there.
+`
+
 func TestGetClientIP(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -201,7 +252,10 @@ JVBERi0xLjQKJeLjz9MKCg==
 			}
 
 			s := &Session{}
-			attachments := s.extractAttachments(envelope)
+			attachments, err := s.extractAttachments(envelope)
+			if err != nil {
+				t.Fatalf("extractAttachments() error = %v", err)
+			}
 
 			if len(attachments) != tt.wantAttachments {
 				t.Errorf("extractAttachments() returned %v attachments, want %v", len(attachments), tt.wantAttachments)
@@ -404,3 +458,1022 @@ func TestFormatBoolPtr(t *testing.T) {
 		})
 	}
 }
+
+func TestSessionDataFirstEmailEvent(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	mockDB := &mockSessionDB{
+		addresses: map[string]bool{"user@tempmail.example.com": true},
+	}
+	notifier := &recordingNotifier{}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.notifier = notifier
+
+	// First delivery emits both the receipt and first-email events
+	if err := s.Mail("sender@example.com", nil); err != nil {
+		t.Fatalf("Mail() error = %v", err)
+	}
+	if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	if got := notifier.countEvents(EventEmailReceived); got != 1 {
+		t.Errorf("after first email: %s events = %d, want 1", EventEmailReceived, got)
+	}
+	if got := notifier.countEvents(EventAddressFirstEmail); got != 1 {
+		t.Errorf("after first email: %s events = %d, want 1", EventAddressFirstEmail, got)
+	}
+
+	// Subsequent delivery only emits the receipt event
+	if err := s.Mail("sender@example.com", nil); err != nil {
+		t.Fatalf("Mail() error = %v", err)
+	}
+	if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	if got := notifier.countEvents(EventEmailReceived); got != 2 {
+		t.Errorf("after second email: %s events = %d, want 2", EventEmailReceived, got)
+	}
+	if got := notifier.countEvents(EventAddressFirstEmail); got != 1 {
+		t.Errorf("after second email: %s events = %d, want 1", EventAddressFirstEmail, got)
+	}
+
+	first := notifier.events[1]
+	if first.Recipient != "user@tempmail.example.com" || first.MessageID != "<hello@example.com>" {
+		t.Errorf("first email event = %+v", first)
+	}
+}
+
+func TestSessionRcptNonAcceptingDomain(t *testing.T) {
+	notAccepting := false
+	cfg := &Config{
+		Domains: []string{"tempmail.example.com", "retired.example.com"},
+		DomainsConfig: map[string]DomainConfig{
+			"retired.example.com": {Accepting: &notAccepting},
+		},
+	}
+
+	mockDB := &mockSessionDB{
+		addresses: map[string]bool{
+			"user@tempmail.example.com": true,
+			"user@retired.example.com":  true,
+		},
+	}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+
+	if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+		t.Errorf("Rcpt() for accepting domain error = %v", err)
+	}
+
+	err := s.Rcpt("user@retired.example.com", nil)
+	if err == nil {
+		t.Fatal("Rcpt() for non-accepting domain should fail")
+	}
+
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+		t.Errorf("Rcpt() error = %v, want 550 SMTP error", err)
+	}
+
+	if len(s.to) != 1 || s.to[0] != "user@tempmail.example.com" {
+		t.Errorf("Rcpt() recipients = %v, want only the accepting domain", s.to)
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "English body",
+			text: "Thank you for signing up. Please confirm your email address by clicking the link below to activate your account.",
+			want: "en",
+		},
+		{
+			name: "German body",
+			text: "Vielen Dank für Ihre Anmeldung bei unserem Dienst. Bitte bestätigen Sie jetzt Ihre neue Adresse, indem Sie auf den folgenden Link klicken und die Schritte auf der Seite befolgen.",
+			want: "de",
+		},
+		{
+			name: "Spanish body",
+			text: "Gracias por registrarte. Por favor confirma tu dirección de correo electrónico haciendo clic en el enlace de abajo.",
+			want: "es",
+		},
+		{
+			name: "too short",
+			text: "Hi there",
+			want: "",
+		},
+		{
+			name: "empty",
+			text: "",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectLanguage(tt.text); got != tt.want {
+				t.Errorf("detectLanguage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractEmailDataLanguage(t *testing.T) {
+	rawMessage := `From: sender@example.com
+To: recipient@tempmail.example.com
+Subject: Bienvenue
+
+Merci de votre inscription. Veuillez confirmer votre adresse électronique en cliquant sur le lien ci-dessous.
+`
+
+	envelope, err := enmime.ReadEnvelope(strings.NewReader(rawMessage))
+	if err != nil {
+		t.Fatalf("Failed to parse email: %v", err)
+	}
+
+	// Disabled by default
+	s := &Session{from: "sender@example.com", cfg: &Config{}}
+	if got := s.extractEmailData(envelope, []byte(rawMessage), int64(len(rawMessage))).BodyLanguage; got != "" {
+		t.Errorf("BodyLanguage with detection disabled = %q, want empty", got)
+	}
+
+	s.cfg.Tempmail.DetectLanguage = true
+	if got := s.extractEmailData(envelope, []byte(rawMessage), int64(len(rawMessage))).BodyLanguage; got != "fr" {
+		t.Errorf("BodyLanguage = %q, want fr", got)
+	}
+}
+
+func TestSessionEnvelopeRecorded(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	mockDB := &mockSessionDB{
+		addresses: map[string]bool{
+			"one@tempmail.example.com": true,
+			"two@tempmail.example.com": true,
+		},
+	}
+
+	s := NewSession("192.0.2.10:2525", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+
+	mailOpts := &smtp.MailOptions{Size: 1234, Body: smtp.Body8BitMIME, EnvelopeID: "env-1"}
+	if err := s.Mail("sender@example.com", mailOpts); err != nil {
+		t.Fatalf("Mail() error = %v", err)
+	}
+	rcptOpts := &smtp.RcptOptions{Notify: []smtp.DSNNotify{smtp.DSNNotifyFailure}}
+	if err := s.Rcpt("One@tempmail.example.com", rcptOpts); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	if err := s.Rcpt("two@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	if len(mockDB.stored) != 2 {
+		t.Fatalf("stored %d emails, want 2", len(mockDB.stored))
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(mockDB.stored[0].Envelope, &env); err != nil {
+		t.Fatalf("Envelope is not valid JSON: %v", err)
+	}
+
+	// Each copy lists only its own recipient so Bcc addresses stay hidden
+	if bytes.Contains(mockDB.stored[0].Envelope, []byte("two@tempmail.example.com")) {
+		t.Errorf("first copy's envelope discloses the other recipient: %s", mockDB.stored[0].Envelope)
+	}
+	if bytes.Contains(mockDB.stored[1].Envelope, []byte("one@tempmail.example.com")) {
+		t.Errorf("second copy's envelope discloses the other recipient: %s", mockDB.stored[1].Envelope)
+	}
+
+	if env.MailFrom != "sender@example.com" {
+		t.Errorf("Envelope.MailFrom = %q, want sender@example.com", env.MailFrom)
+	}
+	if env.Size != 1234 || env.Body != "8BITMIME" || env.EnvelopeID != "env-1" {
+		t.Errorf("Envelope MAIL params = size %d, body %q, envid %q", env.Size, env.Body, env.EnvelopeID)
+	}
+	if env.RemoteAddr != "192.0.2.10:2525" || env.Helo != "client.example.com" {
+		t.Errorf("Envelope connection = %q / %q", env.RemoteAddr, env.Helo)
+	}
+	if len(env.Recipients) != 1 || env.Recipients[0].Address != "one@tempmail.example.com" {
+		t.Fatalf("Envelope.Recipients = %+v, want only the normalized recipient", env.Recipients)
+	}
+	if len(env.Recipients[0].Notify) != 1 || env.Recipients[0].Notify[0] != "FAILURE" {
+		t.Errorf("Envelope.Recipients[0].Notify = %v, want [FAILURE]", env.Recipients[0].Notify)
+	}
+	if env.MailAt.IsZero() || env.DataAt == nil {
+		t.Error("Envelope timestamps should be set")
+	}
+
+	// Reset discards the envelope
+	s.Reset()
+	if s.smtpEnvelope != nil {
+		t.Error("Reset() should clear the envelope")
+	}
+}
+
+func TestExtractEmailDataReturnPath(t *testing.T) {
+	tests := []struct {
+		name           string
+		message        string
+		from           string
+		wantReturnPath string
+		wantPrepended  string
+	}{
+		{
+			name:           "client header replaced",
+			message:        "Return-Path: <bounce+123@esp.example>\r\n" + testMessage,
+			from:           "sender@example.com",
+			wantReturnPath: "sender@example.com",
+			wantPrepended:  "Return-Path: <sender@example.com>\r\n",
+		},
+		{
+			name:           "folded client header replaced",
+			message:        "Return-Path:\r\n <bounce+123@esp.example>\r\n" + testMessage,
+			from:           "sender@example.com",
+			wantReturnPath: "sender@example.com",
+			wantPrepended:  "Return-Path: <sender@example.com>\r\n",
+		},
+		{
+			name:           "synthesized from MAIL FROM",
+			message:        testMessage,
+			from:           "sender@example.com",
+			wantReturnPath: "sender@example.com",
+			wantPrepended:  "Return-Path: <sender@example.com>\r\n",
+		},
+		{
+			name:           "synthesized null sender",
+			message:        testMessage,
+			from:           "",
+			wantReturnPath: "",
+			wantPrepended:  "Return-Path: <>\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSession("127.0.0.1:12345", "client.example.com", &Config{}, nil, nil, nil)
+			if err := s.Mail(tt.from, nil); err != nil {
+				t.Fatalf("Mail() error = %v", err)
+			}
+
+			raw := []byte(tt.message)
+			envelope, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("Failed to parse message: %v", err)
+			}
+
+			data := s.extractEmailData(envelope, raw, int64(len(raw)))
+			if data.ReturnPath != tt.wantReturnPath {
+				t.Errorf("ReturnPath = %q, want %q", data.ReturnPath, tt.wantReturnPath)
+			}
+
+			if !bytes.HasPrefix(data.RawMessage, []byte(tt.wantPrepended+"From: sender@example.com")) {
+				t.Errorf("RawMessage should start with %q", tt.wantPrepended)
+			}
+			if bytes.Contains(data.RawMessage, []byte("bounce+123")) || strings.Contains(data.RawHeaders, "bounce+123") {
+				t.Error("the client's Return-Path should be removed")
+			}
+		})
+	}
+}
+
+func TestExtractEmailDataDate(t *testing.T) {
+	tests := []struct {
+		name        string
+		date        string // Date header value, empty for none
+		addDate     bool
+		wantMissing bool
+		wantAdded   bool
+	}{
+		{"present", "Mon, 02 Jan 2006 15:04:05 -0700", true, false, false},
+		{"missing", "", false, true, false},
+		{"missing with add_missing_date", "", true, true, true},
+		{"unparseable", "yesterday-ish", false, true, false},
+		{"unparseable left alone", "yesterday-ish", true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Tempmail.AddMissingDate = tt.addDate
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, nil, nil, nil)
+
+			message := "Return-Path: <sender@example.com>\r\n"
+			if tt.date != "" {
+				message += "Date: " + tt.date + "\r\n"
+			}
+			raw := []byte(message + testMessage)
+			envelope, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("Failed to parse message: %v", err)
+			}
+
+			data := s.extractEmailData(envelope, raw, int64(len(raw)))
+			if data.DateMissing != tt.wantMissing {
+				t.Errorf("DateMissing = %v, want %v", data.DateMissing, tt.wantMissing)
+			}
+
+			wantHeader := "Date: " + data.ReceivedAt.Format(time.RFC1123Z) + "\r\n"
+			if added := bytes.HasPrefix(data.RawMessage, []byte(wantHeader)); added != tt.wantAdded {
+				t.Errorf("Date header added = %v, want %v", added, tt.wantAdded)
+			}
+			if !tt.wantAdded && !bytes.Equal(data.RawMessage, raw) {
+				t.Error("RawMessage should be unchanged")
+			}
+		})
+	}
+}
+
+func TestSessionDataBlackhole(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	mockDB := &mockSessionDB{
+		addresses: map[string]bool{
+			"sink@tempmail.example.com": true,
+			"user@tempmail.example.com": true,
+		},
+		blackholes: map[string]bool{"sink@tempmail.example.com": true},
+	}
+	notifier := &recordingNotifier{}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.notifier = notifier
+	s.Mail("sender@example.com", nil)
+	if err := s.Rcpt("sink@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() blackhole error = %v, want accepted", err)
+	}
+	if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v, want accepted", err)
+	}
+
+	if len(mockDB.stored) != 1 || mockDB.stored[0].ToAddr != "user@tempmail.example.com" {
+		t.Fatalf("stored %+v, want only user@tempmail.example.com", mockDB.stored)
+	}
+	if got := notifier.countEvents(EventEmailReceived); got != 1 {
+		t.Errorf("%d email.received events, want 1", got)
+	}
+
+	// A new transaction starts without the previous blackhole state
+	s.Reset()
+	if s.blackholed != nil {
+		t.Error("Reset() should clear blackholed recipients")
+	}
+}
+
+func TestSessionDataRawSHA256(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	notifier := &recordingNotifier{}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.notifier = notifier
+	s.Mail("sender@example.com", nil)
+	if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	if len(mockDB.stored) != 1 {
+		t.Fatalf("stored %d emails, want 1", len(mockDB.stored))
+	}
+	stored := mockDB.stored[0]
+
+	// The hash covers the bytes as received, not the stored copy with Return-Path prepended
+	sum := sha256.Sum256([]byte(testMessage))
+	want := hex.EncodeToString(sum[:])
+	if stored.RawSHA256 != want {
+		t.Errorf("RawSHA256 = %s, want %s", stored.RawSHA256, want)
+	}
+	if bytes.Equal(stored.RawMessage, []byte(testMessage)) {
+		t.Error("RawMessage should include the synthesized Return-Path")
+	}
+	for _, event := range notifier.events {
+		if event.Type == EventEmailReceived && event.RawSHA256 != want {
+			t.Errorf("event raw_message_sha256 = %q, want %s", event.RawSHA256, want)
+		}
+	}
+}
+
+func TestSessionSenderSizeOverride(t *testing.T) {
+	// Just over the 1 MB global limit
+	body := strings.Repeat("x", 1024*1024+1)
+	message := "From: sender@partner.example\r\nTo: user@tempmail.example.com\r\nSubject: Big\r\n\r\n" + body
+
+	tests := []struct {
+		name     string
+		from     string
+		spf      string // SPF record for partner.example, empty disables validation
+		wantCode int    // 0 = accepted
+	}{
+		{"override domain without validation", "sender@partner.example", "", 552},
+		{"override domain passing SPF", "sender@partner.example", "v=spf1 ip4:127.0.0.1 -all", 0},
+		{"override domain failing SPF", "sender@partner.example", "v=spf1 -all", 552},
+		{"other domain", "sender@other.example", "", 554},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 1
+			cfg.Server.SenderMaxMsgSizeMB = map[string]int{"partner.example": 5}
+
+			var validator *Validator
+			if tt.spf != "" {
+				cfg.Validation.CheckSPF = true
+				validator = NewValidator(cfg)
+				validator.resolver = &fakeResolver{txt: map[string][]string{"partner.example": {tt.spf}}}
+			}
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, validator, cfg.GetDomainMap())
+			if err := s.Mail(tt.from, nil); err != nil {
+				t.Fatalf("Mail() error = %v", err)
+			}
+			s.Rcpt("user@tempmail.example.com", nil)
+
+			err := s.Data(strings.NewReader(message))
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("Data() error = %v, want accepted", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Data() accepted, want rejection")
+			}
+			var smtpErr *smtp.SMTPError
+			if errors.As(err, &smtpErr) && smtpErr.Code != tt.wantCode {
+				t.Errorf("Data() code = %d, want %d", smtpErr.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSenderAuthenticated(t *testing.T) {
+	tests := []struct {
+		name   string
+		result ValidationResult
+		from   string
+		want   bool
+	}{
+		{"SPF pass for MAIL FROM", ValidationResult{SPFResult: "pass", SPFIdentity: SPFIdentityMailFrom}, "a@partner.example", true},
+		{"SPF pass for HELO only", ValidationResult{SPFResult: "pass", SPFIdentity: SPFIdentityHelo}, "a@partner.example", false},
+		{"DMARC pass, same domain", ValidationResult{DMARCResult: "pass", DMARCDomain: "partner.example"}, "a@partner.example", true},
+		{"DMARC pass, relaxed alignment", ValidationResult{DMARCResult: "pass", DMARCDomain: "partner.example"}, "a@mail.partner.example", true},
+		{"DMARC pass for another From domain", ValidationResult{DMARCResult: "pass", DMARCDomain: "attacker.example"}, "a@partner.example", false},
+		{"DMARC fail", ValidationResult{DMARCResult: "fail", DMARCDomain: "partner.example"}, "a@partner.example", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := senderAuthenticated(&tt.result, tt.from); got != tt.want {
+				t.Errorf("senderAuthenticated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionMailSizeOverride(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 1
+	cfg.Server.SenderMaxMsgSizeMB = map[string]int{"partner.example": 5}
+
+	tests := []struct {
+		name    string
+		from    string
+		size    int64
+		wantErr bool
+	}{
+		{"override domain under its limit", "sender@partner.example", 3 * 1024 * 1024, false},
+		{"override domain over its limit", "sender@partner.example", 6 * 1024 * 1024, true},
+		{"other domain over global limit", "sender@other.example", 3 * 1024 * 1024, true},
+		{"no SIZE declared", "sender@other.example", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+			err := s.Mail(tt.from, &smtp.MailOptions{Size: tt.size})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Mail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSessionCatchAllAddress(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com", "other.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.DomainsConfig = map[string]DomainConfig{
+		"tempmail.example.com": {CatchAllAddress: "inbox@tempmail.example.com"},
+	}
+
+	mockDB := &mockSessionDB{addresses: map[string]bool{
+		"inbox@tempmail.example.com": true,
+		"user@other.example.com":     true,
+	}}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.Mail("sender@example.com", nil)
+	for _, rcpt := range []string{"alice@tempmail.example.com", "Shop-Signup@tempmail.example.com", "user@other.example.com"} {
+		if err := s.Rcpt(rcpt, nil); err != nil {
+			t.Fatalf("Rcpt(%s) error = %v", rcpt, err)
+		}
+	}
+	// Without a catch-all, unknown local parts are still rejected
+	if err := s.Rcpt("nobody@other.example.com", nil); err == nil {
+		t.Error("Rcpt(nobody@other.example.com) accepted, want rejection")
+	}
+
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+
+	want := []struct{ to, deliveredTo string }{
+		{"inbox@tempmail.example.com", "alice@tempmail.example.com"},
+		{"inbox@tempmail.example.com", "shop-signup@tempmail.example.com"},
+		{"user@other.example.com", "user@other.example.com"},
+	}
+	if len(mockDB.stored) != len(want) {
+		t.Fatalf("stored %d emails, want %d", len(mockDB.stored), len(want))
+	}
+	for i, w := range want {
+		if got := mockDB.stored[i]; got.ToAddr != w.to || got.DeliveredTo != w.deliveredTo {
+			t.Errorf("stored[%d] = (%s, %s), want (%s, %s)", i, got.ToAddr, got.DeliveredTo, w.to, w.deliveredTo)
+		}
+	}
+}
+
+func TestSessionDuplicateRecipients(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	mockDB := &mockSessionDB{addresses: map[string]bool{
+		"user@tempmail.example.com":  true,
+		"other@tempmail.example.com": true,
+	}}
+
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.Mail("sender@example.com", nil)
+	for _, rcpt := range []string{
+		"user@tempmail.example.com",
+		"user@tempmail.example.com",
+		"User@TempMail.Example.COM",
+		"other@tempmail.example.com",
+	} {
+		if err := s.Rcpt(rcpt, nil); err != nil {
+			t.Fatalf("Rcpt(%s) error = %v, want accepted", rcpt, err)
+		}
+	}
+
+	if len(s.to) != 2 {
+		t.Fatalf("accepted recipients = %v, want 2 unique", s.to)
+	}
+	if len(s.smtpEnvelope.Recipients) != 2 {
+		t.Errorf("envelope recipients = %d, want 2", len(s.smtpEnvelope.Recipients))
+	}
+
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	if len(mockDB.stored) != 2 {
+		t.Errorf("stored %d copies, want one per unique recipient", len(mockDB.stored))
+	}
+}
+
+func TestSessionRcptAddressTooLong(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.Mail("sender@example.com", nil)
+
+	tests := []struct {
+		name     string
+		to       string
+		wantCode int // 0 = accepted
+	}{
+		{"multi-kilobyte local part", strings.Repeat("a", 8192) + "@tempmail.example.com", 501},
+		{"nested comments", strings.Repeat("(", 4096) + "user@tempmail.example.com", 501},
+		{"at the limit", strings.Repeat("a", maxPathLen-2-len("@tempmail.example.com")) + "@tempmail.example.com", 451},
+		{"one over the limit", strings.Repeat("a", maxPathLen-1-len("@tempmail.example.com")) + "@tempmail.example.com", 501},
+		{"normal address", "user@tempmail.example.com", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Rcpt(tt.to, nil)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("Rcpt() error = %v, want accepted", err)
+				}
+				return
+			}
+
+			var smtpErr *smtp.SMTPError
+			if tt.wantCode == 501 {
+				if !errors.As(err, &smtpErr) || smtpErr.Code != 501 {
+					t.Errorf("Rcpt() error = %v, want 501", err)
+				}
+				return
+			}
+			// Within the cap the address is parsed and looked up as usual
+			if errors.As(err, &smtpErr) || !errors.Is(err, ErrAddressNotFound) {
+				t.Errorf("Rcpt() error = %v, want ErrAddressNotFound", err)
+			}
+		})
+	}
+}
+
+func TestExtractEmailDataSubject(t *testing.T) {
+	tests := []struct {
+		name           string
+		header         string // raw Subject header line(s), without the trailing CRLF
+		normalize      bool
+		wantSubject    string
+		wantRawSubject string
+	}{
+		{"clean", "Subject: Hello world", true, "Hello world", ""},
+		{"folded header", "Subject: Hello\r\n   world,\r\n\tagain", true, "Hello world, again", ""},
+		{"encoded newlines", "Subject: =?utf-8?Q?Hello=0D=0A=0D=0Aworld?=", true, "Hello world", "Hello\r\n\r\nworld"},
+		{"tabs and spaces", "Subject: \tWin\t\ta   prize  \t", true, "Win a prize", "Win\t\ta   prize"},
+		{"off", "Subject: Win\t\ta   prize", false, "Win\t\ta   prize", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Tempmail.NormalizeSubjects = tt.normalize
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, nil, nil, nil)
+
+			raw := []byte("From: sender@example.com\r\nTo: user@tempmail.example.com\r\n" + tt.header + "\r\n\r\nBody\r\n")
+			envelope, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("Failed to parse message: %v", err)
+			}
+
+			data := s.extractEmailData(envelope, raw, int64(len(raw)))
+			if data.Subject != tt.wantSubject {
+				t.Errorf("Subject = %q, want %q", data.Subject, tt.wantSubject)
+			}
+			if data.RawSubject != tt.wantRawSubject {
+				t.Errorf("RawSubject = %q, want %q", data.RawSubject, tt.wantRawSubject)
+			}
+		})
+	}
+}
+
+func TestSessionDataRedactReceivedIPs(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 1
+	cfg.Privacy.RedactReceivedIPs = RedactIPsRemove
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	s := NewSession("203.0.113.7:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+
+	raw := "Received: from client.example.com ([203.0.113.7])\r\n\tby relay.example.net; Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+		"From: sender@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+	s.Mail("sender@example.com", nil)
+	s.Rcpt("user@tempmail.example.com", nil)
+	if err := s.Data(strings.NewReader(raw)); err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	if len(mockDB.stored) != 1 {
+		t.Fatalf("stored %d emails, want 1", len(mockDB.stored))
+	}
+	got := mockDB.stored[0]
+
+	if strings.Contains(string(got.RawMessage), "203.0.113.7") || !strings.Contains(string(got.RawMessage), "([redacted])") {
+		t.Errorf("RawMessage not redacted:\n%s", got.RawMessage)
+	}
+	if strings.Contains(got.RawHeaders, "203.0.113.7") {
+		t.Errorf("RawHeaders not redacted:\n%s", got.RawHeaders)
+	}
+
+	// The client address and a hash of the original stay available internally
+	if !strings.Contains(string(got.Envelope), `"remote_addr":"203.0.113.7:12345"`) {
+		t.Errorf("Envelope lost the client address: %s", got.Envelope)
+	}
+	sum := sha256.Sum256([]byte(raw))
+	if got.RawSHA256 != hex.EncodeToString(sum[:]) {
+		t.Error("RawSHA256 should hash the message as received")
+	}
+}
+
+// stallingDB blocks StoreEmailContext until its context ends
+type stallingDB struct {
+	mockSessionDB
+	started  chan struct{}
+	deadline time.Time
+}
+
+func (d *stallingDB) StoreEmailContext(ctx context.Context, email *EmailData, attachments []AttachmentData) error {
+	d.deadline, _ = ctx.Deadline()
+	close(d.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSessionDataQueryContext(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 1
+	cfg.Database.QueryTimeoutSeconds = 20
+	db := &stallingDB{
+		mockSessionDB: mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}},
+		started:       make(chan struct{}),
+	}
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, db, nil, cfg.GetDomainMap())
+	s.Mail("sender@example.com", nil)
+	if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- s.Data(strings.NewReader("From: sender@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"))
+	}()
+
+	select {
+	case <-db.started:
+	case err := <-result:
+		t.Fatalf("Data() returned %v before storing", err)
+	}
+	if remaining := time.Until(db.deadline); remaining <= 0 || remaining > 20*time.Second {
+		t.Errorf("store deadline in %s, want within query_timeout_seconds", remaining)
+	}
+
+	// The connection dropping aborts the stalled store
+	s.Logout()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Data() succeeded although the store was cancelled")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Data() still blocked after Logout")
+	}
+}
+
+func TestSessionRcptAutoCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoCreate bool
+		count      int64 // addresses already under the domain, capped at 5
+		wantErr    bool
+	}{
+		{"unknown address rejected by default", false, 0, true},
+		{"unknown address accepted", true, 0, false},
+		{"domain at max_addresses", true, 5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Tempmail.AutoCreateAddresses = tt.autoCreate
+			cfg.DomainsConfig = map[string]DomainConfig{"tempmail.example.com": {MaxAddresses: 5}}
+
+			mockDB := &mockSessionDB{}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			s.addresses = fakeAddressCounter{"tempmail.example.com": tt.count}
+			s.Mail("sender@example.com", nil)
+
+			err := s.Rcpt("new@tempmail.example.com", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rcpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := s.Data(strings.NewReader(testMessage)); err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+			if len(mockDB.stored) != 1 || mockDB.stored[0].ToAddr != "new@tempmail.example.com" {
+				t.Errorf("stored %+v, want one email for new@tempmail.example.com", mockDB.stored)
+			}
+		})
+	}
+}
+
+func TestSessionRcptPostmaster(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+	cfg.DomainsConfig = map[string]DomainConfig{
+		"tempmail.example.com": {CatchAllAddress: "inbox@tempmail.example.com"},
+	}
+
+	tests := []struct {
+		name        string
+		to          string
+		wantErr     bool
+		wantMailbox string
+	}{
+		{"postmaster never created", "postmaster@tempmail.example.com", false, "postmaster@tempmail.example.com"},
+		{"postmaster any case", "PostMaster@tempmail.example.com", false, "postmaster@tempmail.example.com"},
+		{"postmaster of another domain", "postmaster@other.example", true, ""},
+		{"other role address", "abuse@tempmail.example.com", false, "inbox@tempmail.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the catch-all exists; postmaster bypasses it
+			mockDB := &mockSessionDB{addresses: map[string]bool{"inbox@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			s.Mail("sender@example.com", nil)
+
+			err := s.Rcpt(tt.to, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rcpt(%s) error = %v, wantErr %v", tt.to, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := s.Data(strings.NewReader(testMessage)); err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+			if len(mockDB.stored) != 1 || mockDB.stored[0].ToAddr != tt.wantMailbox {
+				t.Fatalf("stored %+v, want one copy for %s", mockDB.stored, tt.wantMailbox)
+			}
+			// The store creates postmaster on first use
+			if got, want := mockDB.stored[0].CreateAddress, isPostmaster(tt.wantMailbox); got != want {
+				t.Errorf("CreateAddress = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSessionRcptDomainMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		autoCreate bool
+		to         string
+		wantCode   int   // SMTP status for a rejection, 0 = accepted
+		wantErr    error // plain error for a rejection go-smtp answers with 451
+		wantCreate bool
+	}{
+		{"default unknown tempfails", "", false, "new@tempmail.example.com", 0, ErrAddressNotFound, false},
+		{"default follows auto_create", "", true, "new@tempmail.example.com", 0, nil, true},
+		{"explicit unknown refused", DomainModeExplicit, true, "new@tempmail.example.com", 550, nil, false},
+		{"explicit existing accepted", DomainModeExplicit, false, "user@tempmail.example.com", 0, nil, false},
+		{"catch_all unknown created", DomainModeCatchAll, false, "new@tempmail.example.com", 0, nil, true},
+		{"catch_all existing accepted", DomainModeCatchAll, false, "user@tempmail.example.com", 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Tempmail.AutoCreateAddresses = tt.autoCreate
+			cfg.DomainsConfig = map[string]DomainConfig{"tempmail.example.com": {Mode: tt.mode}}
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			s.Mail("sender@example.com", nil)
+
+			err := s.Rcpt(tt.to, nil)
+			var smtpErr *smtp.SMTPError
+			switch {
+			case tt.wantCode != 0:
+				if !errors.As(err, &smtpErr) || smtpErr.Code != tt.wantCode {
+					t.Fatalf("Rcpt(%s) error = %v, want %d", tt.to, err, tt.wantCode)
+				}
+				return
+			case tt.wantErr != nil:
+				if errors.As(err, &smtpErr) || !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Rcpt(%s) error = %v, want %v", tt.to, err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Rcpt(%s) error = %v, want accepted", tt.to, err)
+			}
+
+			if err := s.Data(strings.NewReader(testMessage)); err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+			if len(mockDB.stored) != 1 || mockDB.stored[0].CreateAddress != tt.wantCreate {
+				t.Errorf("stored %+v, want one email with CreateAddress %v", mockDB.stored, tt.wantCreate)
+			}
+		})
+	}
+}
+
+func TestSessionCrossDomainCatchAll(t *testing.T) {
+	tests := []struct {
+		name       string
+		sinkMode   string
+		wantCreate bool
+	}{
+		{"sink domain catch_all", DomainModeCatchAll, true},
+		{"sink domain explicit", DomainModeExplicit, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com", "sink.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.DomainsConfig = map[string]DomainConfig{
+				"tempmail.example.com": {Mode: DomainModeCatchAll, CatchAllAddress: "all@sink.example.com"},
+				"sink.example.com":     {Mode: tt.sinkMode},
+			}
+
+			// The catch-all mailbox does not exist yet; its own domain decides
+			mockDB := &mockSessionDB{addresses: map[string]bool{}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			s.Mail("sender@example.com", nil)
+
+			err := s.Rcpt("anyone@tempmail.example.com", nil)
+			if !tt.wantCreate {
+				if err == nil {
+					t.Fatal("Rcpt() accepted mail for a missing catch-all in an explicit domain")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Rcpt() error = %v", err)
+			}
+			if err := s.Data(strings.NewReader(testMessage)); err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+			if len(mockDB.stored) != 1 || mockDB.stored[0].ToAddr != "all@sink.example.com" || !mockDB.stored[0].CreateAddress {
+				t.Errorf("stored %+v, want one email for all@sink.example.com with CreateAddress", mockDB.stored)
+			}
+		})
+	}
+}
+
+func TestSessionDataBounce(t *testing.T) {
+	tests := []struct {
+		name          string
+		from          string
+		bounceAddress string
+		wantBounce    bool
+		wantMailbox   string
+	}{
+		{"regular sender", "sender@example.com", "bounces@tempmail.example.com", false, "user@tempmail.example.com"},
+		{"null sender stored normally", "", "", true, "user@tempmail.example.com"},
+		{"null sender routed", "", "bounces@tempmail.example.com", true, "bounces@tempmail.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Tempmail.BounceAddress = tt.bounceAddress
+
+			// The recipient need not exist when its bounces are routed elsewhere
+			addresses := map[string]bool{"bounces@tempmail.example.com": true}
+			if tt.wantMailbox == "user@tempmail.example.com" {
+				addresses["user@tempmail.example.com"] = true
+			}
+			mockDB := &mockSessionDB{addresses: addresses}
+
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			if err := s.Mail(tt.from, nil); err != nil {
+				t.Fatalf("Mail() error = %v", err)
+			}
+			if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+				t.Fatalf("Rcpt() error = %v", err)
+			}
+			if err := s.Data(strings.NewReader(testMessage)); err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+
+			if len(mockDB.stored) != 1 {
+				t.Fatalf("stored %d copies, want 1", len(mockDB.stored))
+			}
+			stored := mockDB.stored[0]
+			if stored.IsBounce != tt.wantBounce {
+				t.Errorf("IsBounce = %v, want %v", stored.IsBounce, tt.wantBounce)
+			}
+			if stored.ToAddr != tt.wantMailbox || stored.DeliveredTo != "user@tempmail.example.com" {
+				t.Errorf("stored under %s (delivered_to %s), want %s (user@tempmail.example.com)",
+					stored.ToAddr, stored.DeliveredTo, tt.wantMailbox)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-smtp"
+	"github.com/jhillyerd/enmime"
+)
+
+// Actions for an envelope recipient missing from the To/Cc headers
+const (
+	RecipientMatchAllow  = "allow"
+	RecipientMatchFlag   = "flag"
+	RecipientMatchReject = "reject"
+)
+
+// Spam dispositions stored alongside the score
+const (
+	SpamDispositionAccept = "accept"
+	SpamDispositionFlag   = "flag"
+)
+
+// defaultSpamFlagThreshold is the score at which a message is flagged
+const defaultSpamFlagThreshold = 5.0
+
+// spamRules weights each content signal the scorer knows about
+var spamRules = []struct {
+	name   string
+	weight float64
+	match  func(email *EmailData, attachments []AttachmentData) bool
+}{
+	{"IMAGE_ONLY", 3.0, func(e *EmailData, _ []AttachmentData) bool { return e.ImageSpamCandidate }},
+	{"BCC_ONLY", 1.0, func(e *EmailData, _ []AttachmentData) bool { return e.BCCOnly }},
+	{"RCPT_NOT_IN_HEADERS", 1.0, func(e *EmailData, _ []AttachmentData) bool { return e.RecipientMismatch }},
+	{"UNAUTHENTICATED", 3.0, func(e *EmailData, _ []AttachmentData) bool { return e.Unauthenticated }},
+	{"DKIM_MISALIGNED", 1.5, func(e *EmailData, _ []AttachmentData) bool { return e.DKIMMisaligned }},
+	{"MISSING_DATE", 1.0, func(e *EmailData, _ []AttachmentData) bool { return e.DateMissing }},
+	{"SUSPICIOUS_ATTACHMENT", 2.5, func(_ *EmailData, atts []AttachmentData) bool {
+		for _, att := range atts {
+			if att.Suspicious {
+				return true
+			}
+		}
+		return false
+	}},
+}
+
+// SpamVerdict is the content filter's result, kept apart from SPF/DKIM/DMARC
+type SpamVerdict struct {
+	Score       float64  `json:"score"`
+	Rules       []string `json:"rules"`       // names of the rules that matched
+	Disposition string   `json:"disposition"` // accept or flag
+}
+
+// scoreSpam sums the weights of the matching rules
+func scoreSpam(email *EmailData, attachments []AttachmentData, flagThreshold float64) *SpamVerdict {
+	verdict := &SpamVerdict{Rules: []string{}, Disposition: SpamDispositionAccept}
+	for _, rule := range spamRules {
+		if rule.match(email, attachments) {
+			verdict.Score += rule.weight
+			verdict.Rules = append(verdict.Rules, rule.name)
+		}
+	}
+	if verdict.Score >= flagThreshold {
+		verdict.Disposition = SpamDispositionFlag
+	}
+	return verdict
+}
+
+// defaultImageSpamMaxTextChars is the body length below which text counts as negligible
+const defaultImageSpamMaxTextChars = 20
+
+// isImageSpamCandidate reports whether a message is an image attachment with
+// little or no accompanying text, a common way to slip past text classifiers
+func isImageSpamCandidate(envelope *enmime.Envelope, attachments []AttachmentData, maxTextChars int) bool {
+	hasImage := false
+	for _, att := range attachments {
+		if strings.HasPrefix(strings.ToLower(att.ContentType), "image/") {
+			hasImage = true
+			break
+		}
+	}
+	if !hasImage {
+		return false
+	}
+
+	text := strings.TrimSpace(envelope.Text)
+	if text == "" {
+		text = strings.TrimSpace(stripHTMLTags(envelope.HTML))
+	}
+	return utf8.RuneCountInString(strings.Join(strings.Fields(text), " ")) < maxTextChars
+}
+
+// isBCCOnly reports whether a message carries no To or Cc header, meaning
+// every envelope recipient was BCC'd (typical of bulk mail)
+func isBCCOnly(envelope *enmime.Envelope) bool {
+	for _, name := range []string{"To", "Cc"} {
+		if strings.TrimSpace(envelope.GetHeader(name)) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// headerHasRecipient reports whether recipient is listed in the To or Cc header
+func headerHasRecipient(envelope *enmime.Envelope, recipient string) bool {
+	for _, name := range []string{"To", "Cc"} {
+		addrs, _ := envelope.AddressList(name)
+		for _, addr := range addrs {
+			if strings.EqualFold(addr.Address, recipient) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stripHTMLTags drops markup, keeping only text content
+func stripHTMLTags(html string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range html {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+			b.WriteRune(' ')
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return strings.ReplaceAll(b.String(), "&nbsp;", " ")
+}
+
+// detectImageSpam flags image-only messages when spam.detect_image_spam is set
+func (s *Session) detectImageSpam(envelope *enmime.Envelope, attachments []AttachmentData) bool {
+	if s.cfg == nil || !s.cfg.Spam.DetectImageSpam {
+		return false
+	}
+
+	maxTextChars := s.cfg.Spam.ImageSpamMaxTextChars
+	if maxTextChars <= 0 {
+		maxTextChars = defaultImageSpamMaxTextChars
+	}
+	return isImageSpamCandidate(envelope, attachments, maxTextChars)
+}
+
+// detectBCCOnly flags messages without recipient headers when spam.detect_bcc_only is set
+func (s *Session) detectBCCOnly(envelope *enmime.Envelope) bool {
+	if s.cfg == nil || !s.cfg.Spam.DetectBCCOnly || len(s.to) == 0 {
+		return false
+	}
+	return isBCCOnly(envelope)
+}
+
+// checkHeaderRecipient compares a single envelope recipient with the To/Cc headers
+// per spam.header_recipient_match; returns whether it was missing, or an error to reject
+func (s *Session) checkHeaderRecipient(envelope *enmime.Envelope) (bool, error) {
+	action := RecipientMatchFlag
+	if s.cfg != nil && s.cfg.Spam.HeaderRecipientMatch != "" {
+		action = s.cfg.Spam.HeaderRecipientMatch
+	}
+	// Multi-recipient deliveries legitimately list only some recipients
+	if action == RecipientMatchAllow || len(s.to) != 1 || headerHasRecipient(envelope, s.to[0]) {
+		return false, nil
+	}
+
+	if action == RecipientMatchReject {
+		s.logger().Info("REJECTED: Recipient not listed in To/Cc", "to", s.to[0])
+		return true, &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Envelope recipient does not appear in message headers",
+		}
+	}
+	s.logger().Info("FLAGGED: Recipient not listed in To/Cc", "to", s.to[0])
+	return true, nil
+}
+
+// scoreMessage returns the spam verdict when spam.score_messages is set, nil otherwise
+func (s *Session) scoreMessage(email *EmailData, attachments []AttachmentData) *SpamVerdict {
+	if s.cfg == nil || !s.cfg.Spam.ScoreMessages {
+		return nil
+	}
+
+	threshold := s.cfg.Spam.FlagThreshold
+	if threshold <= 0 {
+		threshold = defaultSpamFlagThreshold
+	}
+	verdict := scoreSpam(email, attachments, threshold)
+	s.info("Spam", "score", verdict.Score, "rules", verdict.Rules, "disposition", verdict.Disposition)
+	return verdict
+}
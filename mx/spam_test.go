@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/jhillyerd/enmime"
+)
+
+const imageOnlyMessage = `From: sender@example.com
+To: user@tempmail.example.com
+Subject: Special offer
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+ 
+--b
+Content-Type: image/png
+Content-Disposition: attachment; filename="offer.png"
+
+PNGDATA
+--b--
+`
+
+const imageWithTextMessage = `From: sender@example.com
+To: user@tempmail.example.com
+Subject: Holiday photos
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain
+
+Hi! Here is the photo from the beach trip last weekend, hope you like it.
+--b
+Content-Type: image/jpeg
+Content-Disposition: attachment; filename="beach.jpg"
+
+JPEGDATA
+--b--
+`
+
+const htmlImageOnlyMessage = `From: sender@example.com
+To: user@tempmail.example.com
+Subject: Offer
+Content-Type: multipart/related; boundary="b"
+
+--b
+Content-Type: text/html
+
+<html><body><img src="cid:offer">&nbsp;</body></html>
+--b
+Content-Type: image/gif
+Content-Disposition: inline; filename="offer.gif"
+Content-ID: <offer>
+
+GIFDATA
+--b--
+`
+
+func TestIsImageSpamCandidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    bool
+	}{
+		{"image only", imageOnlyMessage, true},
+		{"image with text", imageWithTextMessage, false},
+		{"html wrapper around inline image", htmlImageOnlyMessage, true},
+		{"text only", testMessage, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, err := enmime.ReadEnvelope(strings.NewReader(tt.message))
+			if err != nil {
+				t.Fatalf("Failed to parse message: %v", err)
+			}
+
+			s := &Session{}
+			attachments, err := s.extractAttachments(envelope)
+			if err != nil {
+				t.Fatalf("extractAttachments() error = %v", err)
+			}
+			got := isImageSpamCandidate(envelope, attachments, defaultImageSpamMaxTextChars)
+			if got != tt.want {
+				t.Errorf("isImageSpamCandidate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionDataImageSpamFlag(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		cfg := &Config{Domains: []string{"tempmail.example.com"}}
+		cfg.Server.MaxMsgSizeMB = 10
+		cfg.Spam.DetectImageSpam = enabled
+
+		mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+		s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+		s.Mail("sender@example.com", nil)
+		s.Rcpt("user@tempmail.example.com", nil)
+
+		if err := s.Data(strings.NewReader(imageOnlyMessage)); err != nil {
+			t.Fatalf("Data() error = %v", err)
+		}
+		if got := mockDB.stored[0].ImageSpamCandidate; got != enabled {
+			t.Errorf("detect_image_spam=%v: ImageSpamCandidate = %v", enabled, got)
+		}
+	}
+}
+
+const bccOnlyMessage = `From: news@example.com
+Subject: Weekly digest
+Message-ID: <digest@example.com>
+
+This week's news.
+`
+
+func TestIsBCCOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    bool
+	}{
+		{"no recipient headers", bccOnlyMessage, true},
+		{"to header", testMessage, false},
+		{"cc only", "From: a@example.com\nCc: b@example.com\nSubject: x\n\nBody\n", false},
+		{"empty to header", "From: a@example.com\nTo: \nSubject: x\n\nBody\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, err := enmime.ReadEnvelope(strings.NewReader(tt.message))
+			if err != nil {
+				t.Fatalf("Failed to parse message: %v", err)
+			}
+			if got := isBCCOnly(envelope); got != tt.want {
+				t.Errorf("isBCCOnly() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionDataBCCOnlyFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		enabled bool
+		want    bool
+	}{
+		{"bcc only", bccOnlyMessage, true, true},
+		{"normal message", testMessage, true, false},
+		{"detection disabled", bccOnlyMessage, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Spam.DetectBCCOnly = tt.enabled
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			s.Mail("news@example.com", nil)
+			s.Rcpt("user@tempmail.example.com", nil)
+
+			if err := s.Data(strings.NewReader(tt.message)); err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+			if got := mockDB.stored[0].BCCOnly; got != tt.want {
+				t.Errorf("BCCOnly = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionHeaderRecipientMatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		message      string
+		recipients   []string
+		action       string
+		wantMismatch bool
+		wantReject   bool
+	}{
+		{"listed in To", testMessage, []string{"user@tempmail.example.com"}, RecipientMatchReject, false, false},
+		{"listed in Cc", "From: a@example.com\nTo: other@example.com\nCc: User@Tempmail.Example.com\nSubject: x\n\nBody\n",
+			[]string{"user@tempmail.example.com"}, RecipientMatchReject, false, false},
+		{"mismatch flagged by default", bccOnlyMessage, []string{"user@tempmail.example.com"}, "", true, false},
+		{"mismatch rejected", bccOnlyMessage, []string{"user@tempmail.example.com"}, RecipientMatchReject, true, true},
+		{"mismatch allowed", bccOnlyMessage, []string{"user@tempmail.example.com"}, RecipientMatchAllow, false, false},
+		{"multiple recipients skipped", bccOnlyMessage, []string{"user@tempmail.example.com", "other@tempmail.example.com"}, RecipientMatchReject, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Spam.HeaderRecipientMatch = tt.action
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{
+				"user@tempmail.example.com":  true,
+				"other@tempmail.example.com": true,
+			}}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+			s.Mail("news@example.com", nil)
+			for _, rcpt := range tt.recipients {
+				s.Rcpt(rcpt, nil)
+			}
+
+			err := s.Data(strings.NewReader(tt.message))
+			if tt.wantReject {
+				var smtpErr *smtp.SMTPError
+				if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+					t.Fatalf("Data() error = %v, want 550", err)
+				}
+				if len(mockDB.stored) != 0 {
+					t.Error("rejected message should not be stored")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+			if got := mockDB.stored[0].RecipientMismatch; got != tt.wantMismatch {
+				t.Errorf("RecipientMismatch = %v, want %v", got, tt.wantMismatch)
+			}
+		})
+	}
+}
+
+func TestScoreSpam(t *testing.T) {
+	tests := []struct {
+		name            string
+		email           EmailData
+		attachments     []AttachmentData
+		wantScore       float64
+		wantRules       []string
+		wantDisposition string
+	}{
+		{"clean", EmailData{}, nil, 0, []string{}, SpamDispositionAccept},
+		{"bcc only", EmailData{BCCOnly: true, RecipientMismatch: true}, nil, 2.0, []string{"BCC_ONLY", "RCPT_NOT_IN_HEADERS"}, SpamDispositionAccept},
+		{"image spam with suspicious attachment", EmailData{ImageSpamCandidate: true}, []AttachmentData{{Suspicious: true}},
+			5.5, []string{"IMAGE_ONLY", "SUSPICIOUS_ATTACHMENT"}, SpamDispositionFlag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scoreSpam(&tt.email, tt.attachments, defaultSpamFlagThreshold)
+			if got.Score != tt.wantScore {
+				t.Errorf("Score = %v, want %v", got.Score, tt.wantScore)
+			}
+			if strings.Join(got.Rules, ",") != strings.Join(tt.wantRules, ",") {
+				t.Errorf("Rules = %v, want %v", got.Rules, tt.wantRules)
+			}
+			if got.Disposition != tt.wantDisposition {
+				t.Errorf("Disposition = %q, want %q", got.Disposition, tt.wantDisposition)
+			}
+		})
+	}
+}
+
+func TestSessionDataSpamVerdict(t *testing.T) {
+	tests := []struct {
+		name      string
+		spf       string // SPF record for example.com, empty disables validation
+		score     bool
+		wantSPF   string
+		wantScore bool
+	}{
+		{"authenticated and scored", "v=spf1 ip4:127.0.0.1 -all", true, "pass", true},
+		{"authenticated only", "v=spf1 ip4:127.0.0.1 -all", false, "pass", false},
+		{"scored only", "", true, "", true},
+		{"failing SPF still scored", "v=spf1 -all", true, "fail", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.Server.MaxMsgSizeMB = 10
+			cfg.Spam.DetectImageSpam = true
+			cfg.Spam.ScoreMessages = tt.score
+			cfg.Spam.FlagThreshold = 3
+
+			var validator *Validator
+			if tt.spf != "" {
+				cfg.Validation.CheckSPF = true
+				validator = NewValidator(cfg)
+				validator.resolver = &fakeResolver{txt: map[string][]string{"example.com": {tt.spf}}}
+			}
+
+			mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+			notifier := &recordingNotifier{}
+			s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, validator, cfg.GetDomainMap())
+			s.notifier = notifier
+			s.Mail("sender@example.com", nil)
+			s.Rcpt("user@tempmail.example.com", nil)
+
+			if err := s.Data(strings.NewReader(imageOnlyMessage)); err != nil {
+				t.Fatalf("Data() error = %v", err)
+			}
+
+			stored := mockDB.stored[0]
+			if stored.SPFResult != tt.wantSPF {
+				t.Errorf("SPFResult = %q, want %q", stored.SPFResult, tt.wantSPF)
+			}
+			if !tt.wantScore {
+				if stored.Spam != nil {
+					t.Errorf("Spam = %+v, want nil", stored.Spam)
+				}
+				return
+			}
+			if stored.Spam == nil {
+				t.Fatal("Spam = nil, want verdict")
+			}
+			if stored.Spam.Disposition != SpamDispositionFlag || stored.Spam.Rules[0] != "IMAGE_ONLY" {
+				t.Errorf("Spam = %+v, want flagged IMAGE_ONLY", stored.Spam)
+			}
+			for _, event := range notifier.events {
+				if event.Spam != stored.Spam {
+					t.Errorf("event %s spam = %+v, want stored verdict", event.Type, event.Spam)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// errSPFMacroSyntax marks a macro that cannot be expanded; the check is a permerror
+var errSPFMacroSyntax = errors.New("invalid SPF macro")
+
+// expandSPFMacros expands the RFC 7208 section 7 macros in an SPF term
+// Supported letters: s (sender), l (local part), o (sender domain), d (current
+// domain), i (client IP) and v ("in-addr" or "ip6"), each with optional digit
+// and r transformers and delimiters, e.g. %{ir}.%{v}._spf.%{d2}
+// An uppercase letter URL-escapes the value; %%, %_ and %- are literals
+func expandSPFMacros(record string, ip net.IP, sender, domain string) (string, error) {
+	if !strings.Contains(record, "%") {
+		return record, nil
+	}
+
+	local, senderDomain := sender, domain
+	if at := strings.LastIndex(sender, "@"); at >= 0 {
+		local, senderDomain = sender[:at], sender[at+1:]
+	}
+	if local == "" {
+		local = "postmaster"
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(record); i++ {
+		if record[i] != '%' {
+			out.WriteByte(record[i])
+			continue
+		}
+		if i+1 >= len(record) {
+			return "", fmt.Errorf("%w: trailing %%", errSPFMacroSyntax)
+		}
+		i++
+		switch record[i] {
+		case '%':
+			out.WriteByte('%')
+			continue
+		case '_':
+			out.WriteByte(' ')
+			continue
+		case '-':
+			out.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", fmt.Errorf("%w: %%%c", errSPFMacroSyntax, record[i])
+		}
+
+		end := strings.IndexByte(record[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("%w: unclosed %%{", errSPFMacroSyntax)
+		}
+		macro := record[i+1 : i+end]
+		i += end
+		if macro == "" {
+			return "", fmt.Errorf("%w: empty %%{}", errSPFMacroSyntax)
+		}
+
+		letter := macro[0]
+		var value string
+		switch letter | 0x20 { // lowercase
+		case 's':
+			value = local + "@" + senderDomain
+		case 'l':
+			value = local
+		case 'o':
+			value = senderDomain
+		case 'd':
+			value = domain
+		case 'i':
+			value = spfMacroIP(ip)
+		case 'v':
+			value = "in-addr"
+			if ip.To4() == nil {
+				value = "ip6"
+			}
+		default:
+			return "", fmt.Errorf("%w: unsupported letter %q", errSPFMacroSyntax, letter)
+		}
+
+		value, err := transformSPFMacro(value, macro[1:])
+		if err != nil {
+			return "", err
+		}
+		if letter >= 'A' && letter <= 'Z' {
+			value = url.PathEscape(value)
+		}
+		out.WriteString(value)
+	}
+	return out.String(), nil
+}
+
+// transformSPFMacro applies the [digits][r][delimiters] part of a macro:
+// split on the delimiters (default "."), optionally reverse, keep the
+// rightmost digits parts and join them with dots
+func transformSPFMacro(value, spec string) (string, error) {
+	digits := 0
+	for digits < len(spec) && spec[digits] >= '0' && spec[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		n, err := strconv.Atoi(spec[:digits])
+		if err != nil || n == 0 {
+			return "", fmt.Errorf("%w: bad part count %q", errSPFMacroSyntax, spec[:digits])
+		}
+		keep = n
+	}
+	spec = spec[digits:]
+
+	reverse := false
+	if spec != "" && (spec[0] == 'r' || spec[0] == 'R') {
+		reverse = true
+		spec = spec[1:]
+	}
+
+	delimiters := "."
+	if spec != "" {
+		if strings.Trim(spec, ".-+,/_=") != "" {
+			return "", fmt.Errorf("%w: bad delimiters %q", errSPFMacroSyntax, spec)
+		}
+		delimiters = spec
+	}
+
+	if keep == 0 && !reverse && delimiters == "." {
+		return value, nil
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// spfMacroIP formats the client IP for %{i}: dotted quad for IPv4, dot-separated
+// nibbles for IPv6 (so %{ir} gives the reverse-lookup form)
+func spfMacroIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ""
+	}
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip16 {
+		nibbles = append(nibbles, strconv.FormatUint(uint64(b>>4), 16), strconv.FormatUint(uint64(b&0x0f), 16))
+	}
+	return strings.Join(nibbles, ".")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestExpandSPFMacros(t *testing.T) {
+	ip4 := net.ParseIP("192.0.2.3")
+	ip6 := net.ParseIP("2001:db8::cb01")
+
+	tests := []struct {
+		name   string
+		record string
+		ip     net.IP
+		sender string
+		want   string
+	}{
+		{"no macros", "ip4:192.0.2.0/24", ip4, "strong-bad@email.example.com", "ip4:192.0.2.0/24"},
+		{"sender", "%{s}", ip4, "strong-bad@email.example.com", "strong-bad@email.example.com"},
+		{"local part", "%{l}", ip4, "strong-bad@email.example.com", "strong-bad"},
+		{"sender domain", "%{o}", ip4, "strong-bad@email.example.com", "email.example.com"},
+		{"current domain", "%{d}", ip4, "strong-bad@email.example.com", "example.org"},
+		{"rightmost parts", "%{d2}", ip4, "", "example.org"},
+		{"reversed domain", "%{dr}", ip4, "", "org.example"},
+		{"ip", "%{i}", ip4, "", "192.0.2.3"},
+		{"reversed ip", "%{ir}.%{v}._spf.%{d2}", ip4, "", "3.2.0.192.in-addr._spf.example.org"},
+		{"exists form", "exists:%{i}.%{d}.spf.example.com", ip4, "", "exists:192.0.2.3.example.org.spf.example.com"},
+		{"local part delimiters", "%{l-}", ip4, "strong-bad@email.example.com", "strong.bad"},
+		{"reversed local part", "%{lr-}", ip4, "strong-bad@email.example.com", "bad.strong"},
+		{"one part reversed", "%{l1r-}", ip4, "strong-bad@email.example.com", "strong"},
+		{"ipv6 nibbles", "%{ir}.%{v}", ip6, "",
+			"1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6"},
+		{"empty local part", "%{l}", ip4, "@email.example.com", "postmaster"},
+		{"url escaped", "%{S}", ip4, "a b@example.com", "a%20b@example.com"},
+		{"literals", "%%%_%-", ip4, "", "% %20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandSPFMacros(tt.record, tt.ip, tt.sender, "example.org")
+			if err != nil {
+				t.Fatalf("expandSPFMacros() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expandSPFMacros() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandSPFMacrosMalformed(t *testing.T) {
+	for _, record := range []string{"%{i", "%{}", "%{x}", "%{d0}", "%{d2q}", "%x", "trailing%"} {
+		if _, err := expandSPFMacros(record, net.ParseIP("192.0.2.3"), "a@example.com", "example.org"); !errors.Is(err, errSPFMacroSyntax) {
+			t.Errorf("expandSPFMacros(%q) error = %v, want errSPFMacroSyntax", record, err)
+		}
+	}
+}
+
+func TestEvaluateSPFMacros(t *testing.T) {
+	resolver := &fakeResolver{
+		txt: map[string][]string{"_spf.example.com": {"v=spf1 exists:%{i}._ip.%{d} -all"}},
+		ip: map[string][]string{
+			"192.0.2.3.example.com.spf.example.net": {"127.0.0.2"},
+			"192.0.2.3._ip._spf.example.com":        {"127.0.0.2"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		ip        string
+		spfRecord string
+		want      string
+	}{
+		{"exists matches", "192.0.2.3", "v=spf1 exists:%{i}.%{d}.spf.example.net -all", "pass"},
+		{"exists no match", "192.0.2.4", "v=spf1 exists:%{i}.%{d}.spf.example.net -all", "fail"},
+		{"macro in include uses included domain", "192.0.2.3", "v=spf1 include:_spf.example.com -all", "pass"},
+		{"malformed macro", "192.0.2.3", "v=spf1 exists:%{i.%{d} -all", "permerror"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{cfg: &Config{}, resolver: resolver}
+			check := &spfCheck{ip: net.ParseIP(tt.ip), sender: "sender@example.com"}
+			if got := v.evaluateSPF(check, tt.spfRecord, "example.com"); got != tt.want {
+				t.Errorf("evaluateSPF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSpoolRetrySeconds is how often pending spool entries are retried
+const defaultSpoolRetrySeconds = 30
+
+// spoolSuffix marks committed-to-disk spool entries; temp files are ignored on replay
+const spoolSuffix = ".json"
+
+// EmailStore persists one stored copy of a message
+type EmailStore interface {
+	StoreEmail(email *EmailData, attachments []AttachmentData) error
+}
+
+// spoolEntry is one accepted message for one recipient, as written to disk
+type spoolEntry struct {
+	Email       EmailData        `json:"email"`
+	Attachments []AttachmentData `json:"attachments"`
+}
+
+// Spool is an on-disk write-ahead queue between SMTP acceptance and the database
+// Entries are fsynced before the sender is acknowledged and removed once stored,
+// so delivery is at-least-once: a crash between commit and removal replays the entry
+type Spool struct {
+	dir           string
+	store         EmailStore
+	notifier      Notifier
+	retryInterval time.Duration
+
+	mu   sync.Mutex // serializes commit passes
+	seq  uint64
+	wake chan struct{}
+}
+
+// NewSpool creates the spool directory if needed
+func NewSpool(dir string, store EmailStore, retryInterval time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	if retryInterval <= 0 {
+		retryInterval = defaultSpoolRetrySeconds * time.Second
+	}
+	return &Spool{
+		dir:           dir,
+		store:         store,
+		notifier:      logNotifier{},
+		retryInterval: retryInterval,
+		wake:          make(chan struct{}, 1),
+	}, nil
+}
+
+// Enqueue durably writes a message for one recipient and wakes the worker
+func (sp *Spool) Enqueue(email *EmailData, attachments []AttachmentData) error {
+	data, err := json.Marshal(spoolEntry{Email: *email, Attachments: attachments})
+	if err != nil {
+		return fmt.Errorf("failed to encode spool entry: %w", err)
+	}
+
+	sp.mu.Lock()
+	sp.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), sp.seq, spoolSuffix)
+	sp.mu.Unlock()
+
+	// Write to a temp file and rename so replay never sees a partial entry
+	tmp, err := os.CreateTemp(sp.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create spool entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync spool entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close spool entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(sp.dir, name)); err != nil {
+		return fmt.Errorf("failed to commit spool entry: %w", err)
+	}
+	syncDir(sp.dir)
+
+	select {
+	case sp.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start replays entries left from a previous run, then commits new ones
+// in the background until ctx is cancelled
+func (sp *Spool) Start(ctx context.Context) {
+	if pending := sp.Pending(); pending > 0 {
+		slog.Info("Spool: replaying entries", "pending", pending, "dir", sp.dir)
+	}
+
+	go func() {
+		ticker := time.NewTicker(sp.retryInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := sp.Flush(); err != nil {
+				slog.Warn("Spool commit paused", "retry_in", sp.retryInterval, "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-sp.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Pending returns the number of entries waiting for the database
+func (sp *Spool) Pending() int {
+	names, err := sp.entries()
+	if err != nil {
+		return 0
+	}
+	return len(names)
+}
+
+// Flush commits pending entries in arrival order, stopping at the first
+// database failure so nothing is reordered; returns how many were stored
+func (sp *Spool) Flush() (int, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	names, err := sp.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	for _, name := range names {
+		path := filepath.Join(sp.dir, name)
+		entry, err := readSpoolEntry(path)
+		if err != nil {
+			// An unreadable entry would block the queue forever; set it aside
+			slog.Error("Spool entry is corrupt, moving aside", "entry", name, "error", err)
+			os.Rename(path, path+".corrupt")
+			continue
+		}
+
+		err = sp.store.StoreEmail(&entry.Email, entry.Attachments)
+		if errors.Is(err, ErrAddressNotFound) {
+			// The address expired or was never valid (accepted during an outage)
+			slog.Warn("Spool: dropping entry", "message_id", entry.Email.MessageID, "to", entry.Email.ToAddr, "error", err)
+			os.Remove(path)
+			continue
+		}
+		if err != nil {
+			return stored, err
+		}
+
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Failed to remove committed spool entry", "entry", name, "error", err)
+		}
+		stored++
+		if entry.Email.Duplicate {
+			slog.Info("Spool: Duplicate not stored", "message_id", entry.Email.MessageID, "to", entry.Email.ToAddr)
+			continue
+		}
+		slog.Info("Spool: Stored email", "message_id", entry.Email.MessageID, "to", entry.Email.ToAddr)
+
+		sp.notifier.Notify(newEvent(EventEmailReceived, &entry.Email))
+		if entry.Email.FirstEmail {
+			sp.notifier.Notify(newEvent(EventAddressFirstEmail, &entry.Email))
+		}
+	}
+	return stored, nil
+}
+
+// entries lists committed spool files, oldest first
+func (sp *Spool) entries() ([]string, error) {
+	dirEntries, err := os.ReadDir(sp.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var names []string
+	for _, e := range dirEntries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), spoolSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readSpoolEntry decodes one spool file
+func readSpoolEntry(path string) (*spoolEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry spoolEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// syncDir fsyncs a directory so a rename survives a crash (best effort)
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
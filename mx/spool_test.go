@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// outageStore simulates a database that can be taken down and brought back
+type outageStore struct {
+	down    bool
+	missing map[string]bool // addresses that no longer exist
+	stored  []EmailData
+}
+
+func (o *outageStore) StoreEmail(email *EmailData, attachments []AttachmentData) error {
+	if o.down {
+		return errors.New("connection refused")
+	}
+	if o.missing[email.ToAddr] {
+		return ErrAddressNotFound
+	}
+	o.stored = append(o.stored, *email)
+	return nil
+}
+
+func newTestSpool(t *testing.T, dir string, store EmailStore) *Spool {
+	t.Helper()
+	sp, err := NewSpool(dir, store, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+	return sp
+}
+
+func TestSpoolSurvivesOutageAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := &outageStore{down: true}
+	sp := newTestSpool(t, dir, store)
+
+	for _, id := range []string{"<1@example.com>", "<2@example.com>"} {
+		email := &EmailData{MessageID: id, ToAddr: "user@tempmail.example.com", RawMessage: []byte("raw " + id)}
+		atts := []AttachmentData{{Filename: "a.txt", Data: []byte("attachment")}}
+		if err := sp.Enqueue(email, atts); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	// Database down: nothing is committed and the entries stay on disk
+	if n, err := sp.Flush(); err == nil || n != 0 {
+		t.Fatalf("Flush() during outage = %d, %v; want 0 and an error", n, err)
+	}
+	if got := sp.Pending(); got != 2 {
+		t.Fatalf("Pending() = %d, want 2", got)
+	}
+
+	// Simulated restart: a new spool on the same directory replays in order
+	store.down = false
+	restarted := newTestSpool(t, dir, store)
+	notifier := &recordingNotifier{}
+	restarted.notifier = notifier
+
+	if n, err := restarted.Flush(); err != nil || n != 2 {
+		t.Fatalf("Flush() after recovery = %d, %v; want 2, nil", n, err)
+	}
+	if restarted.Pending() != 0 {
+		t.Errorf("Pending() after flush = %d, want 0", restarted.Pending())
+	}
+	if store.stored[0].MessageID != "<1@example.com>" || store.stored[1].MessageID != "<2@example.com>" {
+		t.Errorf("stored out of order: %s, %s", store.stored[0].MessageID, store.stored[1].MessageID)
+	}
+	if string(store.stored[0].RawMessage) != "raw <1@example.com>" {
+		t.Errorf("RawMessage = %q, not preserved through the spool", store.stored[0].RawMessage)
+	}
+	if got := notifier.countEvents(EventEmailReceived); got != 2 {
+		t.Errorf("email.received events = %d, want 2 (emitted after commit)", got)
+	}
+}
+
+func TestSpoolDropsUnknownAddresses(t *testing.T) {
+	store := &outageStore{missing: map[string]bool{"gone@tempmail.example.com": true}}
+	sp := newTestSpool(t, t.TempDir(), store)
+
+	sp.Enqueue(&EmailData{MessageID: "<1@example.com>", ToAddr: "gone@tempmail.example.com"}, nil)
+	sp.Enqueue(&EmailData{MessageID: "<2@example.com>", ToAddr: "user@tempmail.example.com"}, nil)
+
+	if n, err := sp.Flush(); err != nil || n != 1 {
+		t.Fatalf("Flush() = %d, %v; want 1, nil", n, err)
+	}
+	if sp.Pending() != 0 {
+		t.Errorf("Pending() = %d, want 0 (unknown address dropped)", sp.Pending())
+	}
+}
+
+func TestSpoolIgnoresPartialWrites(t *testing.T) {
+	dir := t.TempDir()
+	store := &outageStore{}
+	sp := newTestSpool(t, dir, store)
+
+	// A crash mid-write leaves only a temp file behind
+	if err := os.WriteFile(filepath.Join(dir, ".tmp-123"), []byte(`{"email":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A corrupt committed entry is set aside rather than blocking the queue
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001-000001.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sp.Enqueue(&EmailData{MessageID: "<ok@example.com>", ToAddr: "user@tempmail.example.com"}, nil)
+
+	if n, err := sp.Flush(); err != nil || n != 1 {
+		t.Fatalf("Flush() = %d, %v; want 1, nil", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000001-000001.json.corrupt")); err != nil {
+		t.Errorf("corrupt entry not moved aside: %v", err)
+	}
+}
+
+func TestSessionDataSpooledDuringOutage(t *testing.T) {
+	cfg := &Config{Domains: []string{"tempmail.example.com"}}
+	cfg.Server.MaxMsgSizeMB = 10
+
+	store := &outageStore{down: true}
+	sp := newTestSpool(t, t.TempDir(), store)
+
+	mockDB := &mockSessionDB{addresses: map[string]bool{"user@tempmail.example.com": true}}
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, mockDB, nil, cfg.GetDomainMap())
+	s.spool = sp
+	s.Mail("sender@example.com", nil)
+	if err := s.Rcpt("user@tempmail.example.com", nil); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+
+	if err := s.Data(strings.NewReader(testMessage)); err != nil {
+		t.Fatalf("Data() error = %v, want accepted while the database is down", err)
+	}
+	if len(mockDB.stored) != 0 {
+		t.Error("message stored directly, want it spooled")
+	}
+	if sp.Pending() != 1 {
+		t.Fatalf("Pending() = %d, want 1", sp.Pending())
+	}
+
+	store.down = false
+	if n, _ := sp.Flush(); n != 1 || store.stored[0].ToAddr != "user@tempmail.example.com" {
+		t.Errorf("Flush() stored %d, want the spooled message", n)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Over-cap actions (storage.over_cap_action)
+const (
+	StorageActionTempfail = "tempfail"
+	StorageActionCleanup  = "cleanup"
+)
+
+// Attachment insert failure handling (storage.attachment_failure)
+const (
+	AttachmentFailureRollback = "rollback" // drop the whole message
+	AttachmentFailureSkip     = "skip"     // store the message without the failing attachment
+)
+
+// cleanupBatchSize is how many of the oldest emails are deleted per cleanup pass
+const cleanupBatchSize = 100
+
+// StorageSizer reports the total bytes stored (emails + attachments)
+type StorageSizer interface {
+	TotalStoredBytes() (int64, error)
+}
+
+// StoragePruner frees space when the storage cap is exceeded
+type StoragePruner interface {
+	CleanupExpired(lifetime time.Duration) (int64, int64, error)
+	DeleteOldestEmails(limit int) (deleted int64, freedBytes int64, err error)
+}
+
+// StorageMonitor tracks total stored bytes against storage.global_max_gb
+type StorageMonitor struct {
+	sizer    StorageSizer
+	pruner   StoragePruner
+	maxBytes int64
+	action   string
+	interval time.Duration
+	lifetime time.Duration // tempmail.address_lifetime_hours, for CleanupExpired
+
+	used atomic.Int64
+	over atomic.Bool
+}
+
+// NewStorageMonitor creates a monitor for the configured cap
+// pruner may be nil, in which case cleanup falls back to tempfailing
+func NewStorageMonitor(cfg *Config, sizer StorageSizer, pruner StoragePruner) *StorageMonitor {
+	return &StorageMonitor{
+		sizer:    sizer,
+		pruner:   pruner,
+		maxBytes: int64(cfg.Storage.GlobalMaxGB * 1024 * 1024 * 1024),
+		action:   cfg.Storage.OverCapAction,
+		interval: time.Duration(cfg.Storage.CheckIntervalMinutes) * time.Minute,
+		lifetime: time.Duration(cfg.Tempmail.AddressLifetimeHours) * time.Hour,
+	}
+}
+
+// UsedBytes returns the total stored bytes from the last refresh
+func (m *StorageMonitor) UsedBytes() int64 {
+	return m.used.Load()
+}
+
+// OverCap reports whether new mail should be tempfailed
+func (m *StorageMonitor) OverCap() bool {
+	return m.over.Load()
+}
+
+// Refresh recomputes usage and, with the cleanup action, deletes expired
+// addresses and then the oldest emails until usage drops under the cap
+func (m *StorageMonitor) Refresh() error {
+	used, err := m.sizer.TotalStoredBytes()
+	if err != nil {
+		return err
+	}
+
+	if used > m.maxBytes && m.action == StorageActionCleanup && m.pruner != nil {
+		used, err = m.cleanup(used)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.used.Store(used)
+	metricStorageUsedBytes.Set(used)
+	over := used > m.maxBytes
+	if over != m.over.Swap(over) {
+		if over {
+			slog.Warn("Storage over cap, tempfailing new mail", "used_bytes", used, "max_bytes", m.maxBytes)
+		} else {
+			slog.Info("Storage back under cap", "used_bytes", used, "max_bytes", m.maxBytes)
+		}
+	}
+	return nil
+}
+
+// cleanup prunes data until usage is under the cap or nothing is left to delete
+func (m *StorageMonitor) cleanup(used int64) (int64, error) {
+	emails, addresses, err := m.pruner.CleanupExpired(m.lifetime)
+	if err != nil {
+		return used, err
+	}
+	if emails > 0 || addresses > 0 {
+		slog.Info("Storage cleanup: deleted expired addresses and emails", "addresses", addresses, "emails", emails)
+	}
+
+	// Expired addresses free an unknown amount, so measure once; after that
+	// each batch reports the bytes it freed
+	used, err = m.sizer.TotalStoredBytes()
+	if err != nil {
+		return used, err
+	}
+
+	for used > m.maxBytes {
+		deleted, freed, err := m.pruner.DeleteOldestEmails(cleanupBatchSize)
+		if err != nil {
+			return used, err
+		}
+		if deleted == 0 {
+			break
+		}
+		used -= freed
+		slog.Info("Storage cleanup: deleted oldest emails", "deleted", deleted, "used_bytes", used, "max_bytes", m.maxBytes)
+	}
+	return used, nil
+}
+
+// Start refreshes usage now and then every check interval until ctx is done
+func (m *StorageMonitor) Start(ctx context.Context) {
+	if err := m.Refresh(); err != nil {
+		slog.Warn("Failed to compute storage usage", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.Refresh(); err != nil {
+					slog.Warn("Failed to compute storage usage", "error", err)
+				}
+			}
+		}
+	}()
+}
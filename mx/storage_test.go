@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// fakeStorage is an in-memory size source and pruner
+type fakeStorage struct {
+	emails  []int64 // sizes, oldest first
+	expired int64   // bytes held by expired addresses
+
+	sizeQueries int
+}
+
+func (f *fakeStorage) TotalStoredBytes() (int64, error) {
+	f.sizeQueries++
+	total := f.expired
+	for _, size := range f.emails {
+		total += size
+	}
+	return total, nil
+}
+
+func (f *fakeStorage) CleanupExpired(lifetime time.Duration) (int64, int64, error) {
+	if f.expired == 0 {
+		return 0, 0, nil
+	}
+	f.expired = 0
+	return 0, 1, nil
+}
+
+func (f *fakeStorage) DeleteOldestEmails(limit int) (int64, int64, error) {
+	n := min(limit, len(f.emails))
+	var freed int64
+	for _, size := range f.emails[:n] {
+		freed += size
+	}
+	f.emails = f.emails[n:]
+	return int64(n), freed, nil
+}
+
+// newTestStorageMonitor builds a monitor with a byte-sized cap
+func newTestStorageMonitor(maxBytes int64, action string, sizer StorageSizer, pruner StoragePruner) *StorageMonitor {
+	cfg := &Config{}
+	cfg.Storage.OverCapAction = action
+	m := NewStorageMonitor(cfg, sizer, pruner)
+	m.maxBytes = maxBytes
+	return m
+}
+
+func TestStorageMonitorTempfail(t *testing.T) {
+	fake := &fakeStorage{emails: []int64{600, 600}}
+	m := newTestStorageMonitor(1000, StorageActionTempfail, fake, fake)
+
+	if err := m.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if !m.OverCap() || m.UsedBytes() != 1200 {
+		t.Fatalf("OverCap() = %v, UsedBytes() = %d, want over cap at 1200", m.OverCap(), m.UsedBytes())
+	}
+	if len(fake.emails) != 2 {
+		t.Error("tempfail action should not delete data")
+	}
+
+	cfg := &Config{}
+	s := NewSession("127.0.0.1:12345", "client.example.com", cfg, nil, nil, nil)
+	s.storage = m
+
+	err := s.Mail("sender@example.com", nil)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 452 {
+		t.Fatalf("Mail() error = %v, want 452", err)
+	}
+
+	// Usage dropping under the cap lifts the tempfail
+	fake.emails = fake.emails[1:]
+	if err := m.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if err := s.Mail("sender@example.com", nil); err != nil {
+		t.Errorf("Mail() under cap error = %v", err)
+	}
+}
+
+func TestStorageMonitorCleanup(t *testing.T) {
+	fake := &fakeStorage{expired: 300, emails: make([]int64, 250)}
+	for i := range fake.emails {
+		fake.emails[i] = 10
+	}
+	// 300 expired + 2500 in emails; cap leaves room for 50 emails
+	m := newTestStorageMonitor(500, StorageActionCleanup, fake, fake)
+
+	if err := m.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if m.OverCap() {
+		t.Errorf("OverCap() = true after cleanup, used %d", m.UsedBytes())
+	}
+	if fake.expired != 0 {
+		t.Error("cleanup should remove expired addresses first")
+	}
+	// Deletes in batches of 100 until under the cap
+	if len(fake.emails) != 50 {
+		t.Errorf("%d emails left after cleanup, want 50", len(fake.emails))
+	}
+	// Measured before and after the expired cleanup, then tracked from the freed bytes
+	if fake.sizeQueries != 2 {
+		t.Errorf("TotalStoredBytes called %d times, want 2", fake.sizeQueries)
+	}
+	if m.UsedBytes() != 500 || metricStorageUsedBytes.Value() != 500 {
+		t.Errorf("UsedBytes() = %d, storage_used_bytes = %d, want 500", m.UsedBytes(), metricStorageUsedBytes.Value())
+	}
+}
+
+func TestStorageMonitorCleanupWithoutPruner(t *testing.T) {
+	fake := &fakeStorage{emails: []int64{2000}}
+	m := newTestStorageMonitor(1000, StorageActionCleanup, fake, nil)
+
+	if err := m.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if !m.OverCap() {
+		t.Error("OverCap() = false, want true when nothing can be pruned")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/emersion/go-smtp"
+)
+
+// tlsNetwork is one CIDR entry of the per-network TLS policy
+type tlsNetwork struct {
+	network *net.IPNet
+	require bool
+}
+
+// TLSPolicy decides per client network whether STARTTLS is required before MAIL FROM
+// The most specific matching CIDR wins; unmatched clients may use cleartext
+type TLSPolicy struct {
+	networks []tlsNetwork
+}
+
+// NewTLSPolicy parses a CIDR -> required map (bare IPs are treated as /32 or /128)
+func NewTLSPolicy(networks map[string]bool) (*TLSPolicy, error) {
+	p := &TLSPolicy{}
+	for entry, require := range networks {
+		network, err := parseCIDROrIP(entry)
+		if err != nil {
+			return nil, err
+		}
+		p.networks = append(p.networks, tlsNetwork{network: network, require: require})
+	}
+
+	sort.Slice(p.networks, func(i, j int) bool {
+		a, _ := p.networks[i].network.Mask.Size()
+		b, _ := p.networks[j].network.Mask.Size()
+		return a > b
+	})
+	return p, nil
+}
+
+// Requires reports whether a client at ip must use TLS
+func (p *TLSPolicy) Requires(ip string) bool {
+	require, _ := p.lookup(ip)
+	return require
+}
+
+// lookup returns the most specific network's setting for ip, ok false when none matches
+func (p *TLSPolicy) lookup(ip string) (require, ok bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false, false
+	}
+	for _, n := range p.networks {
+		if n.network.Contains(parsed) {
+			return n.require, true
+		}
+	}
+	return false, false
+}
+
+// parseCIDROrIP parses a CIDR, or a single address as a host network
+func parseCIDROrIP(entry string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP or CIDR %q", entry)
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// checkTLSRequired refuses cleartext transactions when tls.require is set or
+// the client's network must use TLS; a matching require_by_network entry
+// overrides tls.require either way
+func (s *Session) checkTLSRequired() error {
+	if s.tls {
+		return nil
+	}
+
+	ip := s.getClientIP()
+	required := s.cfg != nil && s.cfg.TLS.Require
+	if s.tlsPolicy != nil {
+		if require, ok := s.tlsPolicy.lookup(ip); ok {
+			required = require
+		}
+	}
+	if !required {
+		return nil
+	}
+
+	s.logger().Info("REJECTED: TLS required", "client_ip", ip)
+	return &smtp.SMTPError{
+		Code:         530,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+		Message:      "Must issue a STARTTLS command first",
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestTLSPolicyRequires(t *testing.T) {
+	policy, err := NewTLSPolicy(map[string]bool{
+		"0.0.0.0/0":     true,
+		"10.0.0.0/8":    false,
+		"10.9.9.9":      true,
+		"2001:db8::/32": false,
+	})
+	if err != nil {
+		t.Fatalf("NewTLSPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.5", true},
+		{"10.1.2.3", false},
+		{"10.9.9.9", true},      // host entry beats the internal /8
+		{"2001:db8::1", false},  // listed IPv6 network
+		{"2001:4860::1", false}, // unlisted clients may use cleartext
+		{"not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Requires(tt.ip); got != tt.want {
+			t.Errorf("Requires(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if _, err := NewTLSPolicy(map[string]bool{"10.0.0.0/33": true}); err == nil {
+		t.Error("NewTLSPolicy() accepted invalid CIDR")
+	}
+}
+
+func TestSessionTLSRequired(t *testing.T) {
+	policy, err := NewTLSPolicy(map[string]bool{"0.0.0.0/0": true, "10.0.0.0/8": false})
+	if err != nil {
+		t.Fatalf("NewTLSPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		wantErr    bool
+	}{
+		{"public cleartext refused", "203.0.113.5:40000", false, true},
+		{"public over TLS", "203.0.113.5:40000", true, false},
+		{"internal cleartext allowed", "10.0.0.25:40000", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			s := NewSession(tt.remoteAddr, "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+			s.tlsPolicy = policy
+			s.tls = tt.tls
+
+			err := s.Mail("sender@example.com", nil)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Mail() error = %v, want nil", err)
+				}
+				return
+			}
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+				t.Errorf("Mail() error = %v, want 530", err)
+			}
+		})
+	}
+}
+
+func TestSessionTLSRequireGlobal(t *testing.T) {
+	exempt, err := NewTLSPolicy(map[string]bool{"10.0.0.0/8": false})
+	if err != nil {
+		t.Fatalf("NewTLSPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		require    bool
+		policy     *TLSPolicy
+		remoteAddr string
+		tls        bool
+		wantErr    bool
+	}{
+		{"permissive cleartext", false, nil, "203.0.113.5:40000", false, false},
+		{"enforced cleartext refused", true, nil, "203.0.113.5:40000", false, true},
+		{"enforced after STARTTLS", true, nil, "203.0.113.5:40000", true, false},
+		{"enforced, exempt network", true, exempt, "10.0.0.25:40000", false, false},
+		{"enforced, unlisted network", true, exempt, "203.0.113.5:40000", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Domains: []string{"tempmail.example.com"}}
+			cfg.TLS.Require = tt.require
+			s := NewSession(tt.remoteAddr, "client.example.com", cfg, &mockSessionDB{}, nil, cfg.GetDomainMap())
+			s.tlsPolicy = tt.policy
+			s.tls = tt.tls
+
+			err := s.Mail("sender@example.com", nil)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Mail() error = %v, want nil", err)
+				}
+				return
+			}
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+				t.Errorf("Mail() error = %v, want 530", err)
+			}
+		})
+	}
+}
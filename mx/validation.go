@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/emersion/go-msgauth/dkim"
 	"golang.org/x/net/publicsuffix"
@@ -13,19 +23,43 @@ import (
 
 // Validator handles email validation (DKIM, SPF, DMARC)
 type Validator struct {
-	cfg *Config
+	cfg      *Config
+	resolver Resolver
+	log      *slog.Logger // nil logs through slog.Default
 }
 
 // ValidationResult holds the results of email validation
 type ValidationResult struct {
-	DKIMValid   *bool  // nullable - true/false if checked, nil if not checked
-	SPFResult   string // pass, fail, softfail, neutral, none, temperror, permerror
-	DMARCResult string // pass, fail, none
+	DKIMValid     *bool                 // nullable - true/false if checked, nil if not checked
+	DKIMAlgorithm string                // a= tag of the accepted signature (or first signature if none accepted)
+	DKIMDetails   []DKIMSignatureResult // one entry per signature (local checks only)
+	SPFResult     string                // pass, fail, softfail, neutral, none, temperror, permerror
+	SPFIdentity   string                // mailfrom or helo (null sender)
+	DMARCResult   string                // pass, fail, none
+	DMARCDomain   string                // From: domain DMARC was evaluated for (local checks only)
+	ARCResult     string                // none, pass, fail; empty when not checked
+	DMARCPolicy   *DMARCPolicy          // published policy, nil if none was found or DMARC came from upstream
+	DKIMDomains   []string              // d= of each signature that verified (local checks only)
 }
 
 // NewValidator creates a new validator
 func NewValidator(cfg *Config) *Validator {
-	return &Validator{cfg: cfg}
+	return &Validator{cfg: cfg, resolver: defaultResolver()}
+}
+
+// withLogger returns a copy of v that logs through l, so a session's checks
+// carry its session_id
+func (v *Validator) withLogger(l *slog.Logger) *Validator {
+	scoped := *v
+	scoped.log = l
+	return &scoped
+}
+
+func (v *Validator) logger() *slog.Logger {
+	if v.log == nil {
+		return slog.Default()
+	}
+	return v.log
 }
 
 // ValidateEmail performs configured validation checks on an email
@@ -35,159 +69,786 @@ func (v *Validator) ValidateEmail(rawMessage []byte, from string, clientIP strin
 		DMARCResult: "none",
 	}
 
+	// Results from a trusted upstream MTA replace the matching local checks
+	upstream := v.trustedAuthResults(rawMessage, clientIP)
+	if upstream == nil {
+		upstream = &upstreamAuthResults{}
+	}
+
 	// DKIM validation
-	if v.cfg.Validation.CheckDKIM {
-		dkimValid := v.validateDKIM(rawMessage)
+	if upstream.DKIMValid != nil {
+		result.DKIMValid = upstream.DKIMValid
+	} else if v.cfg.Validation.CheckDKIM {
+		signatures := v.validateDKIM(rawMessage)
+		if signatures == nil {
+			signatures = []DKIMSignatureResult{} // checked, but unsigned
+		}
+		dkimValid, algorithm := summarizeDKIM(signatures)
 		result.DKIMValid = &dkimValid
+		result.DKIMAlgorithm = algorithm
+		result.DKIMDetails = signatures
+		for _, signature := range signatures {
+			if signature.Valid {
+				result.DKIMDomains = append(result.DKIMDomains, strings.ToLower(signature.Domain))
+			}
+		}
 	}
 
 	// SPF validation
-	if v.cfg.Validation.CheckSPF {
-		result.SPFResult = v.validateSPF(clientIP, heloName, from)
+	if upstream.SPFResult != "" {
+		result.SPFResult, result.SPFIdentity = upstream.SPFResult, upstream.SPFIdentity
+	} else if v.cfg.Validation.CheckSPF {
+		result.SPFResult, result.SPFIdentity = v.checkSPF(clientIP, heloName, from)
+	}
+
+	// ARC validation; a passing chain from a trusted sealer can stand in for SPF
+	// and DKIM results broken by forwarding
+	var arc *arcAuthResults
+	if v.cfg.Validation.CheckARC {
+		var preserved *arcAuthResults
+		result.ARCResult, preserved = v.validateARC(rawMessage)
+		arc = v.trustedARCResults(result.ARCResult, preserved)
 	}
 
 	// DMARC validation (requires SPF and DKIM results)
-	if v.cfg.Validation.CheckDMARC {
-		fromDomain := extractDomain(from)
-		result.DMARCResult = v.validateDMARC(fromDomain, result.SPFResult, result.DKIMValid)
+	if upstream.DMARCResult != "" {
+		result.DMARCResult = upstream.DMARCResult
+	} else if v.cfg.Validation.CheckDMARC {
+		// DMARC protects the From: header domain; the envelope sender is only a fallback
+		fromDomain := headerFromDomain(rawMessage)
+		if fromDomain == "" {
+			fromDomain = extractDomain(from)
+		}
+		spfDomain := extractDomain(from)
+		if result.SPFIdentity == SPFIdentityHelo {
+			spfDomain = heloDomain(heloName)
+		}
+		result.DMARCDomain = fromDomain
+		result.DMARCResult, result.DMARCPolicy = v.validateDMARC(fromDomain, result.SPFResult, spfDomain, result.DKIMDomains, arc)
 	}
 
 	return result
 }
 
-// validateDKIM checks DKIM signatures
-func (v *Validator) validateDKIM(rawMessage []byte) bool {
-	verifications, err := dkim.Verify(bytes.NewReader(rawMessage))
+// DKIMSignatureResult is the outcome of checking one DKIM-Signature header,
+// stored as JSON for abuse investigations
+type DKIMSignatureResult struct {
+	Domain    string `json:"domain"`          // d= tag
+	Selector  string `json:"selector"`        // s= tag
+	Algorithm string `json:"algorithm"`       // a= tag
+	Valid     bool   `json:"valid"`           // verified and passed the key policy
+	Error     string `json:"error,omitempty"` // why the signature was not accepted
+}
+
+// summarizeDKIM reports whether any signature was accepted, with the algorithm of
+// the first accepted signature (or of the first signature if none was)
+func summarizeDKIM(results []DKIMSignatureResult) (bool, string) {
+	for _, result := range results {
+		if result.Valid {
+			return true, result.Algorithm
+		}
+	}
+	if len(results) > 0 {
+		return false, results[0].Algorithm
+	}
+	return false, ""
+}
+
+// validateDKIM checks every DKIM signature, in header order
+func (v *Validator) validateDKIM(rawMessage []byte) []DKIMSignatureResult {
+	// Record key sizes as keys are fetched so the key-size policy can be applied
+	var mu sync.Mutex
+	keyBits := make(map[string]int)
+	options := &dkim.VerifyOptions{
+		LookupTXT: func(name string) ([]string, error) {
+			records, err := v.lookupDKIMKey(name)
+			if err == nil && len(records) == 1 {
+				mu.Lock()
+				keyBits[strings.ToLower(name)] = dkimKeyBits(records[0])
+				mu.Unlock()
+			}
+			return records, err
+		},
+	}
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(rawMessage), options)
 	if err != nil {
-		log.Printf("DKIM: No signatures found - %v", err)
-		return false
+		v.logger().Debug("DKIM: No signatures found", "error", err)
+		return nil
 	}
 
 	if len(verifications) == 0 {
-		log.Printf("DKIM: No signatures present")
-		return false
+		v.logger().Debug("DKIM: No signatures present")
+		return nil
 	}
 
-	// Check if at least one signature is valid
+	// Verifications are returned in the same order as the signature headers
+	signatures := parseDKIMSignatures(rawMessage)
+	results := make([]DKIMSignatureResult, 0, len(verifications))
 	for i, verification := range verifications {
-		if verification.Err == nil {
-			log.Printf("DKIM: Signature %d VALID (domain=%s)", i+1, verification.Domain)
-			return true
+		var sig dkimSignature
+		if i < len(signatures) {
+			sig = signatures[i]
+		}
+
+		err := verification.Err
+		if err == nil {
+			err = v.checkDKIMKeyPolicy(keyBits[sig.keyName()])
+		}
+
+		result := DKIMSignatureResult{
+			Domain:    verification.Domain,
+			Selector:  sig.Selector,
+			Algorithm: sig.Algorithm,
+			Valid:     err == nil,
+		}
+		if err == nil {
+			v.logger().Info("DKIM: Signature valid", "signature", i+1, "dkim_domain", verification.Domain, "algorithm", sig.Algorithm)
 		} else {
-			log.Printf("DKIM: Signature %d INVALID - %v", i+1, verification.Err)
+			v.logger().Info("DKIM: Signature invalid", "signature", i+1, "algorithm", sig.Algorithm, "error", err)
+			result.Error = err.Error()
 		}
+		results = append(results, result)
 	}
 
-	return false
+	return results
+}
+
+// lookupDKIMKey fetches a DKIM key record, preferring validation.dkim_key_overrides
+// (keyed by selector._domainkey.domain) over DNS
+func (v *Validator) lookupDKIMKey(name string) ([]string, error) {
+	if record, ok := v.cfg.Validation.DKIMKeyOverrides[strings.TrimSuffix(strings.ToLower(name), ".")]; ok {
+		return []string{record}, nil
+	}
+	return v.resolver.LookupTXT(context.Background(), name)
+}
+
+// checkDKIMKeyPolicy applies validation.min_dkim_key_bits to a verified signature's key
+// rsa-sha1 and keys under 1024 bits are always rejected by the verifier (RFC 8301)
+func (v *Validator) checkDKIMKeyPolicy(bits int) error {
+	minBits := v.cfg.Validation.MinDKIMKeyBits
+	if minBits > 0 && bits > 0 && bits < minBits {
+		return fmt.Errorf("key too short: %d bits, require %d", bits, minBits)
+	}
+	return nil
+}
+
+// dkimSignature holds the DKIM-Signature tags used for policy and reporting
+type dkimSignature struct {
+	Domain    string
+	Selector  string
+	Algorithm string
+}
+
+// keyName returns the DNS name the signature's public key is published under
+func (sig dkimSignature) keyName() string {
+	return strings.ToLower(sig.Selector + "._domainkey." + sig.Domain)
+}
+
+// parseDKIMSignatures extracts DKIM-Signature headers in message order
+func parseDKIMSignatures(rawMessage []byte) []dkimSignature {
+	// A partially parsed header is still useful, so the error is ignored
+	header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(rawMessage))).ReadMIMEHeader()
+
+	var signatures []dkimSignature
+	for _, value := range header.Values("DKIM-Signature") {
+		tags := parseTagList(value)
+		signatures = append(signatures, dkimSignature{
+			Domain:    tags["d"],
+			Selector:  tags["s"],
+			Algorithm: strings.ToLower(tags["a"]),
+		})
+	}
+	return signatures
+}
+
+// parseTagList parses a DKIM-style "tag=value; tag=value" list
+// Whitespace inside values is removed
+func parseTagList(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(key))] = strings.Join(strings.Fields(value), "")
+	}
+	return tags
+}
+
+// dkimKeyBits returns the RSA modulus size of a DKIM key record, or 0 if it
+// isn't an RSA key or can't be parsed
+func dkimKeyBits(record string) int {
+	key, err := parseDKIMPublicKey(record)
+	if err != nil {
+		return 0
+	}
+	return key.N.BitLen()
+}
+
+// parseDKIMPublicKey parses the RSA key of a DKIM key record (v=DKIM1; k=rsa; p=...)
+func parseDKIMPublicKey(record string) (*rsa.PublicKey, error) {
+	tags := parseTagList(record)
+	if k := tags["k"]; k != "" && k != "rsa" {
+		return nil, fmt.Errorf("unsupported key type %q", k)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil || len(der) == 0 {
+		return nil, errors.New("missing or invalid p=")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		pub, err = x509.ParsePKCS1PublicKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+	}
+
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return rsaPub, nil
 }
 
+// SPF identities (RFC 7208 section 2.2-2.4)
+const (
+	SPFIdentityMailFrom = "mailfrom"
+	SPFIdentityHelo     = "helo"
+)
+
 // validateSPF performs basic SPF validation
 func (v *Validator) validateSPF(clientIP, heloName, from string) string {
-	// Extract domain from sender
+	result, _ := v.checkSPF(clientIP, heloName, from)
+	return result
+}
+
+// checkSPF evaluates SPF and reports which identity was checked
+// The null sender has no MAIL FROM domain, so the HELO name is used instead
+func (v *Validator) checkSPF(clientIP, heloName, from string) (string, string) {
+	identity := SPFIdentityMailFrom
 	domain := extractDomain(from)
+	if from == "" {
+		identity = SPFIdentityHelo
+		domain = heloDomain(heloName)
+	}
 	if domain == "" {
-		return "none"
+		return "none", identity
 	}
 
 	// Parse client IP
 	ip := net.ParseIP(clientIP)
 	if ip == nil {
-		log.Printf("SPF: Invalid client IP: %s", clientIP)
-		return "none"
+		v.logger().Warn("SPF: Invalid client IP", "client_ip", clientIP)
+		return "none", identity
 	}
 
 	// Look up SPF record
-	spfRecord, err := lookupSPFRecord(domain)
+	spfRecord, err := v.lookupSPF(domain)
 	if err != nil {
-		log.Printf("SPF: No record found for %s - %v", domain, err)
-		return "none"
+		result := spfLookupResult(err)
+		if result == "none" {
+			v.logger().Debug("SPF: No record found", "spf_domain", domain, "error", err)
+		} else {
+			v.logger().Info("SPF: Lookup failed", "spf_result", result, "spf_domain", domain, "error", err)
+		}
+		return result, identity
 	}
 
 	// Basic SPF evaluation
 	// For tempmail, we just check if the IP is authorized
 	// We don't do full SPF evaluation since it's complex
-	result := evaluateBasicSPF(ip, spfRecord, domain)
-	log.Printf("SPF: %s (domain=%s, ip=%s)", result, domain, clientIP)
+	sender := from
+	if sender == "" {
+		sender = "postmaster@" + domain
+	}
+	result := v.evaluateSPF(&spfCheck{ip: ip, sender: sender, log: v.log}, spfRecord, domain)
+	v.logger().Info("SPF", "spf_result", result, "spf_identity", identity, "spf_domain", domain, "client_ip", clientIP)
 
-	return result
+	return result, identity
 }
 
-// validateDMARC performs basic DMARC validation
-func (v *Validator) validateDMARC(domain string, spfResult string, dkimValid *bool) string {
+// heloDomain returns the HELO name if it can carry an SPF record
+// Address literals like [192.0.2.1] and bare IPs have none
+func heloDomain(heloName string) string {
+	heloName = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(heloName)), ".")
+	if heloName == "" || strings.HasPrefix(heloName, "[") || net.ParseIP(heloName) != nil || !strings.Contains(heloName, ".") {
+		return ""
+	}
+	return heloName
+}
+
+// validateDMARC evaluates DMARC for the From: domain
+// It passes only when SPF passed for a domain aligned with it (spfDomain is the
+// MAIL FROM or HELO domain SPF checked) or a valid DKIM signature's d= is aligned,
+// in the strict or relaxed mode the policy's aspf= and adkim= tags select
+// When local checks fail, results preserved by a passing ARC chain from a trusted
+// sealer (arc, nil otherwise) are checked the same way
+// Returns the result along with the parsed policy (nil when the result is none)
+func (v *Validator) validateDMARC(domain string, spfResult string, spfDomain string, dkimDomains []string, arc *arcAuthResults) (string, *DMARCPolicy) {
 	if domain == "" {
-		return "none"
+		return "none", nil
 	}
 
 	// Look up DMARC policy
-	dmarcRecord, err := lookupDMARCRecord(domain)
+	dmarcRecord, policyDomain, err := v.lookupDMARC(domain)
+	if err != nil {
+		v.logger().Debug("DMARC: No policy found", "dmarc_domain", domain)
+		return "none", nil
+	}
+	policy, err := parseDMARCRecord(dmarcRecord)
 	if err != nil {
-		log.Printf("DMARC: No policy found for %s", domain)
-		return "none"
+		v.logger().Warn("DMARC: Ignoring invalid policy", "dmarc_domain", domain, "error", err)
+		return "none", nil
 	}
+	policy.Domain = policyDomain
 
-	// Basic DMARC evaluation
-	// DMARC passes if either SPF or DKIM passes
-	spfPass := (spfResult == "pass")
-	dkimPass := (dkimValid != nil && *dkimValid)
+	spfAligned, dkimAligned := policy.authAligned(domain, spfResult, spfDomain, dkimDomains)
 
-	var result string
-	if spfPass || dkimPass {
+	result := "fail"
+	if spfAligned || dkimAligned {
 		result = "pass"
-	} else {
-		result = "fail"
 	}
 
-	log.Printf("DMARC: %s (policy=%s, spf=%s, dkim=%v)", result, dmarcRecord, spfResult, dkimPass)
-	return result
+	v.logger().Info("DMARC", "dmarc_result", result, "dmarc_record", dmarcRecord,
+		"spf_result", spfResult, "spf_domain", spfDomain, "spf_aligned", spfAligned,
+		"dkim_domains", dkimDomains, "dkim_aligned", dkimAligned)
+
+	if result == "fail" && arc != nil {
+		spfAligned, dkimAligned = policy.authAligned(domain, arc.SPFResult, arc.SPFDomain, arc.DKIMDomains)
+		if spfAligned || dkimAligned {
+			v.logger().Info("DMARC: pass via ARC", "arc_sealer", arc.Sealer,
+				"spf_result", arc.SPFResult, "spf_domain", arc.SPFDomain, "dkim_domains", arc.DKIMDomains)
+			result = "pass"
+		}
+	}
+	return result, policy
 }
 
 // lookupSPFRecord retrieves SPF record from DNS
 func lookupSPFRecord(domain string) (string, error) {
-	txtRecords, err := net.LookupTXT(domain)
+	return (&Validator{resolver: defaultResolver()}).lookupSPF(domain)
+}
+
+// lookupSPF retrieves a domain's SPF record through the validator's resolver
+func (v *Validator) lookupSPF(domain string) (string, error) {
+	txtRecords, err := v.resolver.LookupTXT(context.Background(), domain)
 	if err != nil {
 		return "", fmt.Errorf("DNS lookup failed: %w", err)
 	}
 
-	// Find SPF record (starts with "v=spf1")
+	// Find SPF record ("v=spf1" alone or followed by a space); more than one is an error
+	var found []string
 	for _, record := range txtRecords {
-		if strings.HasPrefix(record, "v=spf1") {
-			return record, nil
+		if record == "v=spf1" || strings.HasPrefix(record, "v=spf1 ") {
+			found = append(found, record)
 		}
 	}
 
-	return "", fmt.Errorf("no SPF record found")
+	switch len(found) {
+	case 0:
+		return "", errSPFNoRecord
+	case 1:
+		if err := checkSPFSyntax(found[0]); err != nil {
+			return "", err
+		}
+		return found[0], nil
+	}
+	return "", errSPFMultipleRecords
 }
 
-// evaluateBasicSPF performs simplified SPF evaluation
-// Full SPF is complex - this is a basic implementation
+var (
+	errSPFNoRecord        = errors.New("no SPF record found")
+	errSPFMultipleRecords = errors.New("multiple SPF records published")
+	errSPFSyntax          = errors.New("malformed SPF record")
+)
+
+// checkSPFSyntax rejects a record that does not follow the RFC 7208 12 grammar:
+// an unknown mechanism, a mechanism with an invalid argument, a bad modifier
+// name, or redirect= or exp= given twice. Macros are left unexpanded
+func checkSPFSyntax(record string) error {
+	seen := map[string]bool{}
+	for _, term := range strings.Fields(record)[1:] {
+		// Modifiers are name=value; a mechanism never contains "=" before ":" or "/"
+		if i := strings.IndexAny(term, "=:/"); i > 0 && term[i] == '=' {
+			name := strings.ToLower(term[:i])
+			if !spfModifierName.MatchString(name) {
+				return fmt.Errorf("%w: invalid modifier %q", errSPFSyntax, term)
+			}
+			if (name == "redirect" || name == "exp") && seen[name] {
+				return fmt.Errorf("%w: %s= given more than once", errSPFSyntax, name)
+			}
+			if (name == "redirect" || name == "exp") && term[i+1:] == "" {
+				return fmt.Errorf("%w: empty %s=", errSPFSyntax, name)
+			}
+			seen[name] = true
+			continue
+		}
+
+		_, mech := spfQualifier(term)
+		name := strings.ToLower(mech)
+		switch {
+		case name == "all":
+		case name == "ptr" || strings.HasPrefix(name, "ptr:"):
+		case strings.HasPrefix(name, "include:") || strings.HasPrefix(name, "exists:"):
+			if mech[strings.Index(mech, ":")+1:] == "" {
+				return fmt.Errorf("%w: %q lacks a domain", errSPFSyntax, term)
+			}
+		case strings.HasPrefix(name, "ip4:") || strings.HasPrefix(name, "ip6:"):
+			if !validSPFNetwork(name[:3], mech[len("ip4:"):]) {
+				return fmt.Errorf("%w: invalid network in %q", errSPFSyntax, term)
+			}
+		default:
+			kind, spec, ok := spfHostMechanism(mech)
+			if !ok {
+				return fmt.Errorf("%w: unknown mechanism %q", errSPFSyntax, term)
+			}
+			if _, _, _, ok := parseSPFDualCIDR(spec, ""); !ok {
+				return fmt.Errorf("%w: invalid %s mechanism %q", errSPFSyntax, kind, term)
+			}
+		}
+	}
+	return nil
+}
+
+// spfModifierName is the RFC 7208 name production for modifiers
+var spfModifierName = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
+
+// validSPFNetwork reports whether value is an address or CIDR of the
+// family an ip4 or ip6 mechanism names
+func validSPFNetwork(kind, value string) bool {
+	addr := value
+	if i := strings.Index(value, "/"); i >= 0 {
+		addr = value[:i]
+		bits, err := strconv.Atoi(value[i+1:])
+		max := 32
+		if kind == "ip6" {
+			max = 128
+		}
+		if err != nil || bits < 0 || bits > max {
+			return false
+		}
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if kind == "ip4" {
+		return ip.To4() != nil && !strings.Contains(addr, ":")
+	}
+	return strings.Contains(addr, ":")
+}
+
+// spfLookupResult maps a failed SPF record lookup to an SPF result (RFC 7208 4.4):
+// no record or NXDOMAIN is "none", several records or a malformed one
+// "permerror" (4.5, 4.6), and any other DNS failure (SERVFAIL, timeout)
+// "temperror" since a retry may succeed
+func spfLookupResult(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, errSPFMultipleRecords), errors.Is(err, errSPFSyntax):
+		return "permerror"
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsNotFound {
+			return "none"
+		}
+		return "temperror"
+	}
+	return "none"
+}
+
+// spfMaxLookups is the RFC 7208 cap on DNS-querying mechanisms per check
+const spfMaxLookups = 10
+
+// spfCheck carries the state of one SPF check through include: and redirect=
+type spfCheck struct {
+	ip      net.IP
+	sender  string       // MAIL FROM, or postmaster@<helo> for the null sender
+	lookups int          // DNS-querying mechanisms evaluated so far
+	log     *slog.Logger // nil logs through slog.Default
+}
+
+func (c *spfCheck) logger() *slog.Logger {
+	if c.log == nil {
+		return slog.Default()
+	}
+	return c.log
+}
+
+// countLookup records a DNS-querying term, reporting false once the limit is passed
+func (c *spfCheck) countLookup(kind, domain string) bool {
+	c.lookups++
+	if c.lookups > spfMaxLookups {
+		c.logger().Info("SPF: Too many DNS lookups", "limit", spfMaxLookups, "mechanism", kind, "spf_domain", domain)
+		return false
+	}
+	return true
+}
+
+// evaluateBasicSPF performs simplified SPF evaluation against live DNS
 func evaluateBasicSPF(ip net.IP, spfRecord, domain string) string {
+	check := &spfCheck{ip: ip, sender: "postmaster@" + domain}
+	return (&Validator{resolver: defaultResolver()}).evaluateSPF(check, spfRecord, domain)
+}
+
+// evaluateSPF performs simplified SPF evaluation of domain's record, following
+// include: and redirect= through the validator's resolver
+func (v *Validator) evaluateSPF(check *spfCheck, spfRecord, domain string) string {
 	// Parse SPF mechanisms
 	mechanisms := strings.Fields(spfRecord)
 
-	for _, mech := range mechanisms[1:] { // Skip "v=spf1"
-		// Check for common mechanisms
-		if strings.HasPrefix(mech, "ip4:") || strings.HasPrefix(mech, "ip6:") {
-			// IP match
-			ipRange := strings.TrimPrefix(mech, "ip4:")
-			ipRange = strings.TrimPrefix(ipRange, "ip6:")
-			if matchIP(ip, ipRange) {
-				return "pass"
+	redirect := ""
+	hasAll := false
+	for _, term := range mechanisms[1:] { // Skip "v=spf1"
+		term, err := expandSPFMacros(term, check.ip, check.sender, domain)
+		if err != nil {
+			v.logger().Info("SPF: Invalid macro", "spf_domain", domain, "error", err)
+			return "permerror"
+		}
+
+		if strings.HasPrefix(strings.ToLower(term), "redirect=") {
+			// Only used once every mechanism has failed to match
+			redirect = term[len("redirect="):]
+			continue
+		}
+
+		// The qualifier decides the result when the mechanism matches
+		qualifier, mech := spfQualifier(term)
+		name := strings.ToLower(mech)
+
+		matched := false
+		if strings.HasPrefix(name, "include:") {
+			switch result := v.evaluateSPFReference(check, "include", mech[len("include:"):]); result {
+			case "pass":
+				matched = true
+			case "temperror", "permerror":
+				return result
 			}
-		} else if mech == "a" || mech == "+a" {
-			// A record match (simplified)
-			return "neutral"
-		} else if mech == "-all" {
-			return "fail"
-		} else if mech == "~all" {
-			return "softfail"
-		} else if mech == "?all" {
-			return "neutral"
+			// fail, softfail and neutral inside an include are simply not a match
+		} else if kind, spec, ok := spfHostMechanism(mech); ok {
+			switch result := v.evaluateSPFHosts(check, kind, spec, domain); result {
+			case "pass":
+				matched = true
+			case "temperror", "permerror":
+				return result
+			}
+		} else if strings.HasPrefix(name, "exists:") {
+			switch result := v.evaluateSPFExists(check, mech[len("exists:"):]); result {
+			case "pass":
+				matched = true
+			case "temperror", "permerror":
+				return result
+			}
+		} else if strings.HasPrefix(name, "ip4:") || strings.HasPrefix(name, "ip6:") {
+			matched = matchIP(check.ip, mech[len("ip4:"):])
+		} else if name == "all" {
+			hasAll = true
+			matched = true
 		}
+
+		if matched {
+			return qualifier
+		}
+	}
+
+	// An all mechanism takes precedence over redirect= (RFC 7208 6.1)
+	if redirect != "" && !hasAll {
+		return v.evaluateSPFReference(check, "redirect", redirect)
 	}
 
 	return "neutral"
 }
 
+// spfQualifier splits the qualifier off an SPF mechanism and returns the
+// result it gives on a match; no qualifier means "+" (pass)
+func spfQualifier(term string) (string, string) {
+	if term != "" {
+		switch term[0] {
+		case '+':
+			return "pass", term[1:]
+		case '-':
+			return "fail", term[1:]
+		case '~':
+			return "softfail", term[1:]
+		case '?':
+			return "neutral", term[1:]
+		}
+	}
+	return "pass", term
+}
+
+// evaluateSPFReference evaluates the record an include: mechanism or redirect=
+// modifier (kind) points at
+// Per RFC 7208 5.2 and 6.1 a missing record there is a permerror, not "none"
+func (v *Validator) evaluateSPFReference(check *spfCheck, kind, domain string) string {
+	if !check.countLookup(kind, domain) {
+		return "permerror"
+	}
+	if domain == "" {
+		return "permerror"
+	}
+
+	record, err := v.lookupSPF(domain)
+	if err != nil {
+		// Unlike the top-level check, a missing record here is a permerror
+		if spfLookupResult(err) == "temperror" {
+			return "temperror"
+		}
+		return "permerror"
+	}
+	return v.evaluateSPF(check, record, domain)
+}
+
+// evaluateSPFExists matches when domain has any IPv4 address, whatever the
+// client's address family (RFC 7208 5.7); domain is usually built from macros
+func (v *Validator) evaluateSPFExists(check *spfCheck, domain string) string {
+	if domain == "" {
+		return "permerror"
+	}
+	if !check.countLookup("exists", domain) {
+		return "permerror"
+	}
+
+	addrs, err := v.resolver.LookupIPAddr(context.Background(), domain)
+	if err != nil {
+		return spfLookupError(err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return "pass"
+		}
+	}
+	return ""
+}
+
+// spfMaxMXHosts is the RFC 7208 cap on MX hosts examined by one mx mechanism
+const spfMaxMXHosts = 10
+
+// spfHostMechanism splits an unqualified a or mx mechanism into its kind and
+// the rest, e.g. "mx:example.com/24" -> ("mx", ":example.com/24")
+func spfHostMechanism(mech string) (string, string, bool) {
+	mech = strings.ToLower(mech)
+	for _, kind := range []string{"mx", "a"} {
+		rest, found := strings.CutPrefix(mech, kind)
+		if found && (rest == "" || rest[0] == ':' || rest[0] == '/') {
+			return kind, rest, true
+		}
+	}
+	return "", "", false
+}
+
+// parseSPFDualCIDR parses the [":" domain] [ip4-cidr] ["//" ip6-cidr] tail of an
+// a or mx mechanism; the domain defaults to the one being evaluated
+func parseSPFDualCIDR(spec, domain string) (string, int, int, bool) {
+	v4Prefix, v6Prefix := 32, 128
+	target := spec
+	cidr := ""
+	if i := strings.Index(spec, "/"); i >= 0 {
+		target, cidr = spec[:i], spec[i:]
+	}
+	if target != "" {
+		if target[0] != ':' || len(target) == 1 {
+			return "", 0, 0, false
+		}
+		domain = target[1:]
+	}
+
+	v4Part, v6Part := cidr, ""
+	if i := strings.Index(cidr, "//"); i >= 0 {
+		v4Part, v6Part = cidr[:i], cidr[i+2:]
+		if v6Part == "" {
+			return "", 0, 0, false
+		}
+	}
+	if v4Part != "" {
+		n, err := strconv.Atoi(v4Part[1:])
+		if err != nil || n < 0 || n > 32 {
+			return "", 0, 0, false
+		}
+		v4Prefix = n
+	}
+	if v6Part != "" {
+		n, err := strconv.Atoi(v6Part)
+		if err != nil || n < 0 || n > 128 {
+			return "", 0, 0, false
+		}
+		v6Prefix = n
+	}
+	return domain, v4Prefix, v6Prefix, true
+}
+
+// evaluateSPFHosts resolves an a or mx mechanism and compares the client IP
+// with each address; returns "pass", "" for no match, or an error result
+func (v *Validator) evaluateSPFHosts(check *spfCheck, kind, spec, domain string) string {
+	target, v4Prefix, v6Prefix, ok := parseSPFDualCIDR(spec, domain)
+	if !ok {
+		v.logger().Info("SPF: Invalid mechanism", "mechanism", kind+spec)
+		return "permerror"
+	}
+
+	if !check.countLookup(kind, target) {
+		return "permerror"
+	}
+
+	hosts := []string{target}
+	if kind == "mx" {
+		records, err := v.resolver.LookupMX(context.Background(), target)
+		if err != nil {
+			return spfLookupError(err)
+		}
+		if len(records) > spfMaxMXHosts {
+			v.logger().Info("SPF: Too many MX hosts", "spf_domain", target, "limit", spfMaxMXHosts)
+			return "permerror"
+		}
+		hosts = hosts[:0]
+		for _, mx := range records {
+			hosts = append(hosts, mx.Host)
+		}
+	}
+
+	for _, host := range hosts {
+		addrs, err := v.resolver.LookupIPAddr(context.Background(), host)
+		if err != nil {
+			if result := spfLookupError(err); result != "" {
+				return result
+			}
+			continue
+		}
+		for _, addr := range addrs {
+			if matchIPPrefix(check.ip, addr.IP, v4Prefix, v6Prefix) {
+				return "pass"
+			}
+		}
+	}
+	return ""
+}
+
+// spfLookupError maps a DNS error to an SPF result: a missing name is simply
+// no match, anything else a temperror
+func spfLookupError(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return ""
+	}
+	return "temperror"
+}
+
+// matchIPPrefix reports whether ip and addr share a prefix of the length for
+// their address family; addresses of different families never match
+func matchIPPrefix(ip, addr net.IP, v4Prefix, v6Prefix int) bool {
+	if ip4, addr4 := ip.To4(), addr.To4(); ip4 != nil || addr4 != nil {
+		if ip4 == nil || addr4 == nil {
+			return false
+		}
+		mask := net.CIDRMask(v4Prefix, 32)
+		return ip4.Mask(mask).Equal(addr4.Mask(mask))
+	}
+	mask := net.CIDRMask(v6Prefix, 128)
+	return ip.To16().Mask(mask).Equal(addr.To16().Mask(mask))
+}
+
 // matchIP checks if IP matches range (simplified)
 func matchIP(ip net.IP, ipRange string) bool {
 	// Simple exact match or CIDR
@@ -209,16 +870,23 @@ func matchIP(ip net.IP, ipRange string) bool {
 // Per RFC 7489, if no DMARC record exists for a subdomain,
 // fall back to the organizational domain
 func lookupDMARCRecord(domain string) (string, error) {
+	record, _, err := (&Validator{resolver: defaultResolver()}).lookupDMARC(domain)
+	return record, err
+}
+
+// lookupDMARC retrieves a domain's DMARC policy through the validator's resolver
+// Also returns the domain the record was found at
+func (v *Validator) lookupDMARC(domain string) (string, string, error) {
 	// Try exact domain first
 	dmarcDomain := "_dmarc." + domain
 
-	txtRecords, err := net.LookupTXT(dmarcDomain)
+	txtRecords, err := v.resolver.LookupTXT(context.Background(), dmarcDomain)
 	if err == nil {
 		// Find DMARC record (starts with "v=DMARC1")
 		for _, record := range txtRecords {
 			if strings.HasPrefix(record, "v=DMARC1") {
-				log.Printf("DMARC: Found policy for %s", domain)
-				return record, nil
+				v.logger().Debug("DMARC: Found policy", "dmarc_domain", domain)
+				return record, domain, nil
 			}
 		}
 	}
@@ -227,21 +895,21 @@ func lookupDMARCRecord(domain string) (string, error) {
 	// Try organizational domain if this is a subdomain
 	orgDomain := getOrganizationalDomain(domain)
 	if orgDomain != "" && orgDomain != domain {
-		log.Printf("DMARC: No policy for %s, checking organizational domain %s", domain, orgDomain)
+		v.logger().Debug("DMARC: No policy, checking organizational domain", "dmarc_domain", domain, "org_domain", orgDomain)
 
 		orgDmarcDomain := "_dmarc." + orgDomain
-		txtRecords, err := net.LookupTXT(orgDmarcDomain)
+		txtRecords, err := v.resolver.LookupTXT(context.Background(), orgDmarcDomain)
 		if err == nil {
 			for _, record := range txtRecords {
 				if strings.HasPrefix(record, "v=DMARC1") {
-					log.Printf("DMARC: Found organizational domain policy for %s", orgDomain)
-					return record, nil
+					v.logger().Debug("DMARC: Found organizational domain policy", "dmarc_domain", orgDomain)
+					return record, orgDomain, nil
 				}
 			}
 		}
 	}
 
-	return "", fmt.Errorf("no DMARC record found for %s or organizational domain", domain)
+	return "", "", fmt.Errorf("no DMARC record found for %s or organizational domain", domain)
 }
 
 // getOrganizationalDomain extracts the organizational domain from a fully qualified domain
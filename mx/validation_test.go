@@ -1,8 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
 )
 
 func TestExtractDomain(t *testing.T) {
@@ -153,13 +164,6 @@ func TestEvaluateBasicSPF(t *testing.T) {
 			domain:    "example.com",
 			want:      "neutral",
 		},
-		{
-			name:      "neutral - a mechanism",
-			ip:        "10.0.0.1",
-			spfRecord: "v=spf1 a -all",
-			domain:    "example.com",
-			want:      "neutral",
-		},
 	}
 
 	for _, tt := range tests {
@@ -176,19 +180,250 @@ func TestEvaluateBasicSPF(t *testing.T) {
 	}
 }
 
-func TestNewValidator(t *testing.T) {
-	cfg := &Config{
-		Validation: struct {
-			CheckDKIM    bool `yaml:"check_dkim"`
-			CheckSPF     bool `yaml:"check_spf"`
-			CheckDMARC   bool `yaml:"check_dmarc"`
-			StoreResults bool `yaml:"store_results"`
-		}{
-			CheckDKIM:  true,
-			CheckSPF:   true,
-			CheckDMARC: true,
+func TestEvaluateSPFInclude(t *testing.T) {
+	resolver := &fakeResolver{txt: map[string][]string{
+		"_spf.google.com":       {"v=spf1 include:_netblocks.google.com ~all"},
+		"_netblocks.google.com": {"v=spf1 ip4:209.85.128.0/17 -all"},
+		"strict.example":        {"v=spf1 ip4:198.51.100.1 -all"},
+		"loop.example":          {"v=spf1 include:loop.example -all"},
+	}}
+	// A chain of 11 includes, one more than the lookup limit allows
+	for i := 0; i < 11; i++ {
+		resolver.txt[fmt.Sprintf("chain%d.example", i)] = []string{fmt.Sprintf("v=spf1 include:chain%d.example -all", i+1)}
+	}
+	resolver.txt["chain11.example"] = []string{"v=spf1 ip4:192.0.2.1 -all"}
+
+	tests := []struct {
+		name      string
+		ip        string
+		spfRecord string
+		want      string
+	}{
+		{"match in nested include", "209.85.220.41", "v=spf1 include:_spf.google.com -all", "pass"},
+		{"no match falls through to parent all", "10.0.0.1", "v=spf1 include:_spf.google.com ~all", "softfail"},
+		{"nested -all does not end parent", "192.0.2.1", "v=spf1 include:strict.example ip4:192.0.2.1 -all", "pass"},
+		{"missing included record", "192.0.2.1", "v=spf1 include:missing.example -all", "permerror"},
+		{"include loop", "192.0.2.1", "v=spf1 include:loop.example -all", "permerror"},
+		{"too many lookups", "192.0.2.1", "v=spf1 include:chain0.example -all", "permerror"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{cfg: &Config{}, resolver: resolver}
+			check := &spfCheck{ip: net.ParseIP(tt.ip), sender: "sender@example.com"}
+			if got := v.evaluateSPF(check, tt.spfRecord, "example.com"); got != tt.want {
+				t.Errorf("evaluateSPF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSPFHostMechanisms(t *testing.T) {
+	resolver := &fakeResolver{
+		mx: map[string][]*net.MX{
+			"example.com":   {{Host: "mx1.example.com.", Pref: 10}, {Host: "mx2.example.com.", Pref: 20}},
+			"other.example": {{Host: "mail.other.example.", Pref: 10}},
+		},
+		ip: map[string][]string{
+			"example.com":        {"192.0.2.10", "2001:db8::10"},
+			"mx1.example.com":    {"198.51.100.1"},
+			"mx2.example.com":    {"198.51.100.20", "198.51.100.21"},
+			"mail.other.example": {"203.0.113.5"},
+			"www.example.com":    {"192.0.2.80"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		ip        string
+		spfRecord string
+		want      string
+	}{
+		{"a matches", "192.0.2.10", "v=spf1 a -all", "pass"},
+		{"a matches ipv6", "2001:db8::10", "v=spf1 a -all", "pass"},
+		{"a no match continues", "192.0.2.11", "v=spf1 a ip4:192.0.2.11 -all", "pass"},
+		{"a no match falls to all", "192.0.2.11", "v=spf1 a ~all", "softfail"},
+		{"a with cidr", "192.0.2.200", "v=spf1 a/24 -all", "pass"},
+		{"a with domain", "192.0.2.80", "v=spf1 a:www.example.com -all", "pass"},
+		{"a with domain and cidr", "192.0.2.1", "v=spf1 a:www.example.com/24 -all", "pass"},
+		{"dual cidr ipv6", "2001:db8::ffff", "v=spf1 a/32//64 -all", "pass"},
+		{"dual cidr ipv6 outside", "2001:db8:1::1", "v=spf1 a//64 -all", "fail"},
+		{"missing domain is no match", "192.0.2.10", "v=spf1 a:missing.example -all", "fail"},
+		{"mx second host", "198.51.100.21", "v=spf1 mx -all", "pass"},
+		{"mx first host", "198.51.100.1", "v=spf1 +mx -all", "pass"},
+		{"mx with domain", "203.0.113.5", "v=spf1 mx:other.example -all", "pass"},
+		{"mx with cidr", "198.51.100.99", "v=spf1 mx/24 -all", "pass"},
+		{"mx no match", "192.0.2.10", "v=spf1 mx -all", "fail"},
+		{"invalid cidr", "192.0.2.10", "v=spf1 a/33 -all", "permerror"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{cfg: &Config{}, resolver: resolver}
+			check := &spfCheck{ip: net.ParseIP(tt.ip), sender: "sender@example.com"}
+			if got := v.evaluateSPF(check, tt.spfRecord, "example.com"); got != tt.want {
+				t.Errorf("evaluateSPF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSPFQualifiers(t *testing.T) {
+	resolver := &fakeResolver{
+		txt: map[string][]string{"partner.example": {"v=spf1 ip4:203.0.113.0/24 -all"}},
+		ip:  map[string][]string{"example.com": {"192.0.2.10"}},
+	}
+
+	tests := []struct {
+		name      string
+		ip        string
+		spfRecord string
+		want      string
+	}{
+		{"excluded range fails", "1.2.3.4", "v=spf1 -ip4:1.2.3.0/24 ?ip4:5.6.7.0/24 ~all", "fail"},
+		{"neutral range", "5.6.7.8", "v=spf1 -ip4:1.2.3.0/24 ?ip4:5.6.7.0/24 ~all", "neutral"},
+		{"no match falls to all", "9.9.9.9", "v=spf1 -ip4:1.2.3.0/24 ?ip4:5.6.7.0/24 ~all", "softfail"},
+		{"narrow exclusion before wide pass", "1.2.3.4", "v=spf1 -ip4:1.2.3.4 ip4:1.2.3.0/24 -all", "fail"},
+		{"wide pass outside exclusion", "1.2.3.5", "v=spf1 -ip4:1.2.3.4 ip4:1.2.3.0/24 -all", "pass"},
+		{"explicit plus", "1.2.3.4", "v=spf1 +ip4:1.2.3.4 -all", "pass"},
+		{"softfail ip6", "2001:db8::1", "v=spf1 ~ip6:2001:db8::/32 -all", "softfail"},
+		{"qualified a", "192.0.2.10", "v=spf1 ~a -all", "softfail"},
+		{"qualified include", "203.0.113.9", "v=spf1 ?include:partner.example -all", "neutral"},
+		{"bare all passes", "9.9.9.9", "v=spf1 all", "pass"},
+		{"plus all passes", "9.9.9.9", "v=spf1 +all", "pass"},
+		{"uppercase mechanism", "1.2.3.4", "v=spf1 -IP4:1.2.3.4 +all", "fail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{cfg: &Config{}, resolver: resolver}
+			check := &spfCheck{ip: net.ParseIP(tt.ip), sender: "sender@example.com"}
+			if got := v.evaluateSPF(check, tt.spfRecord, "example.com"); got != tt.want {
+				t.Errorf("evaluateSPF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSPFRedirect(t *testing.T) {
+	resolver := &fakeResolver{txt: map[string][]string{
+		"_spf.example.net":  {"v=spf1 ip4:198.51.100.0/24 -all"},
+		"chained.example":   {"v=spf1 redirect=_spf.example.net"},
+		"loop.example":      {"v=spf1 redirect=loop.example"},
+		"no-record.example": {"not spf"},
+	}}
+
+	tests := []struct {
+		name      string
+		ip        string
+		spfRecord string
+		want      string
+	}{
+		{"target ip4 matches", "198.51.100.7", "v=spf1 redirect=_spf.example.net", "pass"},
+		{"target result is final", "192.0.2.1", "v=spf1 redirect=_spf.example.net", "fail"},
+		{"mechanism match before redirect", "192.0.2.1", "v=spf1 ip4:192.0.2.1 redirect=_spf.example.net", "pass"},
+		{"redirect listed first", "192.0.2.1", "v=spf1 redirect=_spf.example.net ip4:192.0.2.1", "pass"},
+		{"all takes precedence", "198.51.100.7", "v=spf1 ~all redirect=_spf.example.net", "softfail"},
+		{"chained redirect", "198.51.100.7", "v=spf1 redirect=chained.example", "pass"},
+		{"target without record", "198.51.100.7", "v=spf1 redirect=no-record.example", "permerror"},
+		{"redirect loop", "198.51.100.7", "v=spf1 redirect=loop.example", "permerror"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{cfg: &Config{}, resolver: resolver}
+			check := &spfCheck{ip: net.ParseIP(tt.ip), sender: "sender@example.com"}
+			if got := v.evaluateSPF(check, tt.spfRecord, "example.com"); got != tt.want {
+				t.Errorf("evaluateSPF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSPFLookupErrors(t *testing.T) {
+	resolver := &fakeResolver{
+		txt: map[string][]string{
+			"nospf.example":   {"google-site-verification=abc"},
+			"twice.example":   {"v=spf1 -all", "v=spf1 ip4:192.0.2.1 -all"},
+			"spf1x.example":   {"v=spf10 -all"},
+			"include.example": {"v=spf1 include:servfail.example -all"},
+			"typo.example":    {"v=spf1 ip4:192.0.2.1 inclde:spf.example -all"},
+			"badinc.example":  {"v=spf1 include:typo.example -all"},
 		},
+		err: map[string]error{
+			"servfail.example": &net.DNSError{Err: "server misbehaving", Name: "servfail.example", IsTemporary: true},
+			"timeout.example":  &net.DNSError{Err: "i/o timeout", Name: "timeout.example", IsTimeout: true},
+		},
+	}
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"nxdomain.example", "none"},
+		{"nospf.example", "none"},
+		{"spf1x.example", "none"},
+		{"servfail.example", "temperror"},
+		{"timeout.example", "temperror"},
+		{"twice.example", "permerror"},
+		{"include.example", "temperror"},
+		{"typo.example", "permerror"},
+		{"badinc.example", "permerror"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			v := &Validator{cfg: &Config{}, resolver: resolver}
+			if got, _ := v.checkSPF("192.0.2.1", "client.example", "sender@"+tt.domain); got != tt.want {
+				t.Errorf("checkSPF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSPFSyntax(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  string
+		wantErr bool
+	}{
+		{"bare version", "v=spf1", false},
+		{"common record", "v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 a mx include:_spf.example.com ~all", false},
+		{"qualifiers and dual cidr", "v=spf1 +a:mail.example.com/24//64 -mx/28 ?ptr exists:%{i}.rbl.example -all", false},
+		{"modifiers", "v=spf1 redirect=_spf.example.com exp=explain.example.com unknown-mod=x", false},
+		{"unknown mechanism", "v=spf1 inclde:_spf.example.com -all", true},
+		{"all with argument", "v=spf1 all:example.com", true},
+		{"include without domain", "v=spf1 include: -all", true},
+		{"exists without domain", "v=spf1 exists: -all", true},
+		{"ip4 not an address", "v=spf1 ip4:mail.example.com -all", true},
+		{"ip4 given ipv6", "v=spf1 ip4:2001:db8::1 -all", true},
+		{"ip6 given ipv4", "v=spf1 ip6:192.0.2.1 -all", true},
+		{"ip4 prefix too long", "v=spf1 ip4:192.0.2.0/33 -all", true},
+		{"a prefix too long", "v=spf1 a/33 -all", true},
+		{"duplicate redirect", "v=spf1 redirect=a.example redirect=b.example", true},
+		{"duplicate exp", "v=spf1 exp=a.example exp=b.example -all", true},
+		{"empty redirect", "v=spf1 redirect=", true},
+		{"invalid modifier name", "v=spf1 1bad=x -all", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSPFSyntax(tt.record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkSPFSyntax(%q) error = %v, wantErr %v", tt.record, err, tt.wantErr)
+			}
+			if err != nil && spfLookupResult(err) != "permerror" {
+				t.Errorf("spfLookupResult(%v) = %s, want permerror", err, spfLookupResult(err))
+			}
+		})
 	}
+}
+
+func TestNewValidator(t *testing.T) {
+	cfg := &Config{}
+	cfg.Validation.CheckDKIM = true
+	cfg.Validation.CheckSPF = true
+	cfg.Validation.CheckDMARC = true
 
 	validator := NewValidator(cfg)
 
@@ -242,18 +477,10 @@ func TestValidateEmail(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cfg := &Config{
-				Validation: struct {
-					CheckDKIM    bool `yaml:"check_dkim"`
-					CheckSPF     bool `yaml:"check_spf"`
-					CheckDMARC   bool `yaml:"check_dmarc"`
-					StoreResults bool `yaml:"store_results"`
-				}{
-					CheckDKIM:  tt.checkDKIM,
-					CheckSPF:   tt.checkSPF,
-					CheckDMARC: tt.checkDMARC,
-				},
-			}
+			cfg := &Config{}
+			cfg.Validation.CheckDKIM = tt.checkDKIM
+			cfg.Validation.CheckSPF = tt.checkSPF
+			cfg.Validation.CheckDMARC = tt.checkDMARC
 
 			validator := NewValidator(cfg)
 
@@ -296,68 +523,40 @@ Test body.
 }
 
 func TestValidateDMARC(t *testing.T) {
-	cfg := &Config{}
-	validator := NewValidator(cfg)
+	resolver := &fakeResolver{txt: map[string][]string{
+		"_dmarc.example.com": {"v=DMARC1; p=reject"},
+		"_dmarc.strict.com":  {"v=DMARC1; p=reject; adkim=s; aspf=s"},
+	}}
+	validator := &Validator{cfg: &Config{}, resolver: resolver}
 
 	tests := []struct {
-		name       string
-		domain     string
-		spfResult  string
-		dkimValid  *bool
-		wantResult string
+		name        string
+		domain      string
+		spfResult   string
+		spfDomain   string
+		dkimDomains []string
+		wantResult  string
 	}{
-		{
-			name:       "pass - SPF passes",
-			domain:     "example.com",
-			spfResult:  "pass",
-			dkimValid:  nil,
-			wantResult: "pass",
-		},
-		{
-			name:       "pass - DKIM passes",
-			domain:     "example.com",
-			spfResult:  "fail",
-			dkimValid:  func() *bool { v := true; return &v }(),
-			wantResult: "pass",
-		},
-		{
-			name:       "pass - both pass",
-			domain:     "example.com",
-			spfResult:  "pass",
-			dkimValid:  func() *bool { v := true; return &v }(),
-			wantResult: "pass",
-		},
-		{
-			name:       "fail - both fail",
-			domain:     "example.com",
-			spfResult:  "fail",
-			dkimValid:  func() *bool { v := false; return &v }(),
-			wantResult: "fail",
-		},
-		{
-			name:       "none - empty domain",
-			domain:     "",
-			spfResult:  "pass",
-			dkimValid:  nil,
-			wantResult: "none",
-		},
+		{"SPF passes aligned", "example.com", "pass", "example.com", nil, "pass"},
+		{"SPF passes for subdomain, relaxed", "example.com", "pass", "bounce.example.com", nil, "pass"},
+		{"SPF passes for unrelated bounce domain", "example.com", "pass", "esp.example.net", nil, "fail"},
+		{"SPF fails aligned", "example.com", "fail", "example.com", nil, "fail"},
+		{"DKIM aligned", "example.com", "fail", "esp.example.net", []string{"example.com"}, "pass"},
+		{"DKIM subdomain, relaxed", "news.example.com", "none", "", []string{"example.com"}, "pass"},
+		{"DKIM unaligned", "example.com", "fail", "", []string{"esp.example.net"}, "fail"},
+		{"one of several signatures aligned", "example.com", "none", "", []string{"esp.example.net", "example.com"}, "pass"},
+		{"strict SPF subdomain", "strict.com", "pass", "bounce.strict.com", nil, "fail"},
+		{"strict DKIM subdomain", "strict.com", "none", "", []string{"mail.strict.com"}, "fail"},
+		{"strict exact match", "strict.com", "pass", "strict.com", []string{"mail.strict.com"}, "pass"},
+		{"no policy", "unpublished.com", "pass", "unpublished.com", nil, "none"},
+		{"empty domain", "", "pass", "example.com", nil, "none"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := validator.validateDMARC(tt.domain, tt.spfResult, tt.dkimValid)
-
-			// For domains that exist, we expect a result (pass/fail)
-			// For domains that don't exist or DNS fails, we might get "none"
-			if tt.domain == "" {
-				if got != "none" {
-					t.Errorf("validateDMARC() = %v, want none for empty domain", got)
-				}
-			} else {
-				// Can be pass, fail, or none (if DNS lookup fails)
-				if got != tt.wantResult && got != "none" {
-					t.Errorf("validateDMARC() = %v, want %v or none", got, tt.wantResult)
-				}
+			got, _ := validator.validateDMARC(tt.domain, tt.spfResult, tt.spfDomain, tt.dkimDomains, nil)
+			if got != tt.wantResult {
+				t.Errorf("validateDMARC() = %v, want %v", got, tt.wantResult)
 			}
 		})
 	}
@@ -368,32 +567,32 @@ func TestValidateSPF(t *testing.T) {
 	validator := NewValidator(cfg)
 
 	tests := []struct {
-		name      string
-		clientIP  string
-		heloName  string
-		from      string
-		wantNone  bool // DNS lookups might fail in test environment
+		name     string
+		clientIP string
+		heloName string
+		from     string
+		wantNone bool // DNS lookups might fail in test environment
 	}{
 		{
-			name:      "valid inputs",
-			clientIP:  "192.168.1.100",
-			heloName:  "client.example.com",
-			from:      "sender@example.com",
-			wantNone:  false, // example.com has SPF record
+			name:     "valid inputs",
+			clientIP: "192.168.1.100",
+			heloName: "client.example.com",
+			from:     "sender@example.com",
+			wantNone: false, // example.com has SPF record
 		},
 		{
-			name:      "invalid IP",
-			clientIP:  "invalid",
-			heloName:  "client.example.com",
-			from:      "sender@example.com",
-			wantNone:  true,
+			name:     "invalid IP",
+			clientIP: "invalid",
+			heloName: "client.example.com",
+			from:     "sender@example.com",
+			wantNone: true,
 		},
 		{
-			name:      "no domain",
-			clientIP:  "192.168.1.100",
-			heloName:  "client.example.com",
-			from:      "invalid",
-			wantNone:  true,
+			name:     "no domain",
+			clientIP: "192.168.1.100",
+			heloName: "client.example.com",
+			from:     "invalid",
+			wantNone: true,
 		},
 	}
 
@@ -459,7 +658,7 @@ Test body.
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := validator.validateDKIM([]byte(tt.rawMessage))
+			got, _ := summarizeDKIM(validator.validateDKIM([]byte(tt.rawMessage)))
 
 			// Since we're using test messages without valid signatures,
 			// we expect false
@@ -613,3 +812,299 @@ func TestLookupDMARCRecord(t *testing.T) {
 		})
 	}
 }
+
+// fakeResolver serves DNS answers from in-memory maps
+type fakeResolver struct {
+	txt map[string][]string
+	mx  map[string][]*net.MX
+	ptr map[string][]string
+	ip  map[string][]string
+	err map[string]error // returned by LookupTXT instead of an answer
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if err, ok := r.err[strings.ToLower(name)]; ok {
+		return nil, err
+	}
+	if records, ok := r.txt[strings.ToLower(name)]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if records, ok := r.mx[strings.ToLower(name)]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := r.ptr[addr]; ok {
+		return names, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.ip[strings.ToLower(strings.TrimSuffix(host, "."))]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+// signTestMessage DKIM-signs a message with a fresh RSA key and returns the
+// signed message and the matching DNS key record
+func signTestMessage(t *testing.T, bits int, domain, selector string) ([]byte, string) {
+	t.Helper()
+	return signTestMessageFrom(t, bits, domain, selector, domain)
+}
+
+// signTestMessageFrom is signTestMessage with a From: header in fromDomain
+func signTestMessageFrom(t *testing.T, bits int, domain, selector, fromDomain string) ([]byte, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	message := "From: sender@" + fromDomain + "\r\n" +
+		"To: recipient@tempmail.example.com\r\n" +
+		"Subject: Signed\r\n" +
+		"\r\n" +
+		"Signed body.\r\n"
+
+	var signed bytes.Buffer
+	options := &dkim.SignOptions{
+		Domain:   domain,
+		Selector: selector,
+		Signer:   key,
+	}
+	if err := dkim.Sign(&signed, strings.NewReader(message), options); err != nil {
+		t.Fatalf("Failed to sign message: %v", err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	record := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)
+
+	return signed.Bytes(), record
+}
+
+func TestValidateDKIMKeyPolicy(t *testing.T) {
+	signed, record := signTestMessage(t, 1024, "example.com", "sel")
+
+	tests := []struct {
+		name      string
+		minBits   int
+		wantValid bool
+	}{
+		{
+			name:      "no minimum configured",
+			minBits:   0,
+			wantValid: true,
+		},
+		{
+			name:      "key meets minimum",
+			minBits:   1024,
+			wantValid: true,
+		},
+		{
+			name:      "short key rejected",
+			minBits:   2048,
+			wantValid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Validation.MinDKIMKeyBits = tt.minBits
+
+			validator := NewValidator(cfg)
+			validator.resolver = &fakeResolver{txt: map[string][]string{
+				"sel._domainkey.example.com": {record},
+			}}
+
+			valid, algorithm := summarizeDKIM(validator.validateDKIM(signed))
+			if valid != tt.wantValid {
+				t.Errorf("validateDKIM() valid = %v, want %v", valid, tt.wantValid)
+			}
+			if algorithm != "rsa-sha256" {
+				t.Errorf("validateDKIM() algorithm = %q, want rsa-sha256", algorithm)
+			}
+		})
+	}
+}
+
+func TestValidateDKIMDetails(t *testing.T) {
+	signed, record := signTestMessage(t, 1024, "example.com", "sel")
+	otherSigned, _ := signTestMessage(t, 1024, "other.example", "gone")
+
+	validator := NewValidator(&Config{})
+	validator.resolver = &fakeResolver{txt: map[string][]string{
+		"sel._domainkey.example.com": {record},
+	}}
+
+	got := validator.validateDKIM(signed)
+	want := []DKIMSignatureResult{{Domain: "example.com", Selector: "sel", Algorithm: "rsa-sha256", Valid: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("validateDKIM(signed) = %+v, want %+v", got, want)
+	}
+
+	// Both signatures are reported in header order; the unpublished key explains the failure
+	otherHeader := otherSigned[:bytes.Index(otherSigned, []byte("\r\nFrom:"))+2]
+	got = validator.validateDKIM(append(bytes.Clone(otherHeader), signed...))
+	if len(got) != 2 {
+		t.Fatalf("validateDKIM(two signatures) returned %d results, want 2", len(got))
+	}
+	if got[0].Domain != "other.example" || got[0].Selector != "gone" || got[0].Valid || got[0].Error == "" {
+		t.Errorf("first signature = %+v, want invalid other.example/gone with an error", got[0])
+	}
+	if !got[1].Valid || got[1].Error != "" {
+		t.Errorf("second signature = %+v, want valid", got[1])
+	}
+
+	if got := validator.validateDKIM([]byte(testMessage)); len(got) != 0 {
+		t.Errorf("validateDKIM(unsigned) = %+v, want none", got)
+	}
+
+	// ValidateEmail distinguishes "checked, unsigned" from "not checked"
+	cfg := &Config{}
+	cfg.Validation.CheckDKIM = true
+	validator.cfg = cfg
+	if result := validator.ValidateEmail([]byte(testMessage), "", "127.0.0.1", "client.example.com"); result.DKIMDetails == nil || len(result.DKIMDetails) != 0 {
+		t.Errorf("DKIMDetails = %#v, want empty for an unsigned message", result.DKIMDetails)
+	}
+	cfg.Validation.CheckDKIM = false
+	if result := validator.ValidateEmail(signed, "", "127.0.0.1", "client.example.com"); result.DKIMDetails != nil {
+		t.Errorf("DKIMDetails = %#v, want nil when DKIM is not checked", result.DKIMDetails)
+	}
+}
+
+func TestValidateDKIMRejectsSHA1(t *testing.T) {
+	_, record := signTestMessage(t, 1024, "example.com", "sel")
+
+	rawMessage := []byte("DKIM-Signature: v=1; a=rsa-sha1; d=example.com; s=sel;\r\n" +
+		" c=relaxed/relaxed; h=from:to:subject;\r\n" +
+		" bh=2jmj7l5rSw0yVb/vlWAYkK/YBwk=; b=c2lnbmF0dXJl\r\n" +
+		"From: sender@example.com\r\n" +
+		"To: recipient@tempmail.example.com\r\n" +
+		"Subject: Weak\r\n" +
+		"\r\n" +
+		"Body.\r\n")
+
+	validator := NewValidator(&Config{})
+	validator.resolver = &fakeResolver{txt: map[string][]string{
+		"sel._domainkey.example.com": {record},
+	}}
+
+	valid, algorithm := summarizeDKIM(validator.validateDKIM(rawMessage))
+	if valid {
+		t.Error("validateDKIM() should reject rsa-sha1 signatures")
+	}
+	if algorithm != "rsa-sha1" {
+		t.Errorf("validateDKIM() algorithm = %q, want rsa-sha1", algorithm)
+	}
+}
+
+func TestDKIMKeyBits(t *testing.T) {
+	_, record := signTestMessage(t, 1024, "example.com", "sel")
+
+	if got := dkimKeyBits(record); got != 1024 {
+		t.Errorf("dkimKeyBits() = %d, want 1024", got)
+	}
+	if got := dkimKeyBits("v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="); got != 0 {
+		t.Errorf("dkimKeyBits(ed25519) = %d, want 0", got)
+	}
+	if got := dkimKeyBits("v=DKIM1; p=not-base64!"); got != 0 {
+		t.Errorf("dkimKeyBits(invalid) = %d, want 0", got)
+	}
+}
+
+func TestCheckSPFHeloFallback(t *testing.T) {
+	validator := NewValidator(&Config{})
+	validator.resolver = &fakeResolver{txt: map[string][]string{
+		"mail.sender.example": {"v=spf1 ip4:192.0.2.0/24 -all"},
+		"sender.example":      {"v=spf1 -all"},
+	}}
+
+	tests := []struct {
+		name         string
+		clientIP     string
+		heloName     string
+		from         string
+		wantResult   string
+		wantIdentity string
+	}{
+		{"null sender uses HELO", "192.0.2.10", "mail.sender.example", "", "pass", SPFIdentityHelo},
+		{"null sender HELO fail", "198.51.100.1", "mail.sender.example", "", "fail", SPFIdentityHelo},
+		{"MAIL FROM takes precedence", "192.0.2.10", "mail.sender.example", "user@sender.example", "fail", SPFIdentityMailFrom},
+		{"HELO address literal", "192.0.2.10", "[192.0.2.10]", "", "none", SPFIdentityHelo},
+		{"HELO without record", "192.0.2.10", "unknown.example", "", "none", SPFIdentityHelo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, identity := validator.checkSPF(tt.clientIP, tt.heloName, tt.from)
+			if result != tt.wantResult || identity != tt.wantIdentity {
+				t.Errorf("checkSPF() = (%s, %s), want (%s, %s)", result, identity, tt.wantResult, tt.wantIdentity)
+			}
+		})
+	}
+}
+
+func TestValidateDKIMKeyOverride(t *testing.T) {
+	signed, record := signTestMessage(t, 2048, "example.com", "sel")
+	_, otherRecord := signTestMessage(t, 2048, "example.com", "sel")
+
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		dns       map[string][]string
+		wantValid bool
+	}{
+		{
+			name:      "override without DNS",
+			overrides: map[string]string{"sel._domainkey.example.com": record},
+			wantValid: true,
+		},
+		{
+			name:      "override wins over DNS",
+			overrides: map[string]string{"sel._domainkey.example.com": record},
+			dns:       map[string][]string{"sel._domainkey.example.com": {otherRecord}},
+			wantValid: true,
+		},
+		{
+			name:      "wrong override key",
+			overrides: map[string]string{"sel._domainkey.example.com": otherRecord},
+			wantValid: false,
+		},
+		{
+			name:      "no override or DNS",
+			wantValid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Validation.DKIMKeyOverrides = tt.overrides
+
+			validator := NewValidator(cfg)
+			validator.resolver = &fakeResolver{txt: tt.dns}
+
+			if valid, _ := summarizeDKIM(validator.validateDKIM(signed)); valid != tt.wantValid {
+				t.Errorf("validateDKIM() valid = %v, want %v", valid, tt.wantValid)
+			}
+		})
+	}
+}